@@ -10,6 +10,7 @@ import (
 
 	"tt-stock-api/internal/app"
 	"tt-stock-api/internal/app/routes"
+	"tt-stock-api/internal/auth"
 	"tt-stock-api/internal/config"
 	"tt-stock-api/internal/db"
 )
@@ -41,17 +42,24 @@ func main() {
 		log.Fatalf("Failed to create database tables: %v", err)
 	}
 
+	// Start the background purge of expired blacklist entries
+	reaper := auth.NewBlacklistReaper(auth.NewBlacklistRepository(database), cfg.BlacklistPurgeInterval, cfg.BlacklistPurgeBatchSize)
+	reaper.Start()
+
 	// Create Fiber server with configuration
 	server := app.NewServer(cfg)
 
 	// Set up dependency injection for all layers
 	deps := &routes.Dependencies{
-		DB:     database,
-		Config: cfg,
+		DB:              database,
+		Config:          cfg,
+		BlacklistReaper: reaper,
 	}
 
 	// Register all routes with dependency injection
-	routes.RegisterRoutes(server.GetApp(), deps)
+	if err := routes.RegisterRoutes(server.GetApp(), deps); err != nil {
+		log.Fatalf("Failed to register routes: %v", err)
+	}
 
 	// Channel to listen for interrupt signals
 	quit := make(chan os.Signal, 1)
@@ -78,6 +86,7 @@ func main() {
 	// Graceful shutdown with timeout
 	shutdownComplete := make(chan error, 1)
 	go func() {
+		reaper.Stop()
 		shutdownComplete <- server.Shutdown()
 	}()
 
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+
+	"tt-stock-api/internal/auth"
+	"tt-stock-api/internal/config"
+	"tt-stock-api/internal/db"
+)
+
+// purge-blacklist deletes expired token_blacklist rows once and exits
+// It runs the same batched purge as the reaper in the API server
+func main() {
+	// Load configuration from environment variables
+	cfg := config.Load()
+
+	// Initialize database connection
+	database, err := db.Connect(cfg.DBUrl)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer func() {
+		if err := database.Close(); err != nil {
+			log.Printf("Error closing database connection: %v", err)
+		}
+	}()
+
+	reaper := auth.NewBlacklistReaper(auth.NewBlacklistRepository(database), 0, cfg.BlacklistPurgeBatchSize)
+	deleted, err := reaper.RunOnce()
+	if err != nil {
+		log.Fatalf("Purge stopped after deleting %d expired blacklist entries: %v", deleted, err)
+	}
+
+	log.Printf("Deleted %d expired blacklist entries", deleted)
+}
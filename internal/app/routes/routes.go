@@ -1,38 +1,116 @@
 package routes
 
 import (
+	"fmt"
+
 	"github.com/gofiber/fiber/v2"
 	"tt-stock-api/internal/auth"
 	"tt-stock-api/internal/config"
 	"tt-stock-api/internal/db"
 	"tt-stock-api/internal/health"
+	"tt-stock-api/internal/notify"
+	"tt-stock-api/internal/phone"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/sms"
 	"tt-stock-api/internal/user"
 )
 
 // Dependencies holds all the dependencies needed for route handlers
 type Dependencies struct {
-	DB     *db.DB
-	Config *config.Config
+	DB              *db.DB
+	Config          *config.Config
+	BlacklistReaper *auth.BlacklistReaper // Optional; its status is reported by /health
 }
 
 // RegisterRoutes sets up all application routes with dependency injection
-func RegisterRoutes(app *fiber.App, deps *Dependencies) {
+func RegisterRoutes(app *fiber.App, deps *Dependencies) error {
 	// Initialize repositories
 	userRepo := user.NewRepository(deps.DB)
-	blacklistRepo := auth.NewBlacklistRepository(deps.DB)
+	blacklistRepo := auth.NewCachedBlacklistRepository(auth.NewBlacklistRepository(deps.DB), deps.Config.BlacklistCacheTTL)
+	attemptRepo := auth.NewLoginAttemptRepository(deps.DB)
+	familyRepo := auth.NewCachedTokenFamilyRepository(auth.NewTokenFamilyRepository(deps.DB), deps.Config.BlacklistCacheTTL)
+	securityEventRepo := auth.NewSecurityEventRepository(deps.DB)
+	sessionRepo := auth.NewSessionRepository(deps.DB)
+	signingKeyRepo := auth.NewSigningKeyRepository(deps.DB)
+	otpRepo := auth.NewOTPRepository(deps.DB)
+	deviceRepo := auth.NewDeviceRepository(deps.DB)
+	deviceKeyRepo := auth.NewDeviceKeyRepository(deps.DB)
+	authEventRepo := auth.NewAuthEventRepository(deps.DB)
+	loginAlertRepo := auth.NewLoginAlertRepository(deps.DB)
+	serviceAccountRepo := auth.NewServiceAccountRepository(deps.DB)
+	policyRepo := policy.NewRepository(deps.DB)
+
+	// Initialize JWT signing keys
+	keyManager, err := auth.NewKeyManager(deps.Config, signingKeyRepo)
+	if err != nil {
+		return fmt.Errorf("failed to initialize JWT signing keys: %w", err)
+	}
+
+	// Initialize the SMS sender for one-time codes
+	smsSender, err := sms.NewSender(deps.Config)
+	if err != nil {
+		return fmt.Errorf("failed to initialize SMS sender: %w", err)
+	}
+
+	// Initialize the notifier for login alerts
+	notifier, err := notify.NewNotifier(deps.Config, smsSender)
+	if err != nil {
+		return fmt.Errorf("failed to initialize notifier: %w", err)
+	}
+
+	// Initialize the permission policy engine
+	authorizer := policy.NewEngine(policyRepo, deps.Config.PolicyCacheTTL)
+
+	// Initialize the phone number validators of the shop's countries
+	phones, err := phone.NewRegistryFromConfig(deps.Config)
+	if err != nil {
+		return fmt.Errorf("failed to initialize phone number validation: %w", err)
+	}
 
 	// Initialize services
-	authService := auth.NewService(userRepo, blacklistRepo, deps.Config)
+	authService := auth.NewService(auth.Repositories{
+		Users:           userRepo,
+		Blacklist:       blacklistRepo,
+		LoginAttempts:   attemptRepo,
+		TokenFamilies:   familyRepo,
+		SecurityEvents:  securityEventRepo,
+		Sessions:        sessionRepo,
+		OTPs:            otpRepo,
+		Devices:         deviceRepo,
+		DeviceKeys:      deviceKeyRepo,
+		AuthEvents:      authEventRepo,
+		LoginAlerts:     loginAlertRepo,
+		ServiceAccounts: serviceAccountRepo,
+	}, keyManager, smsSender, notifier, authorizer, phones, deps.Config)
 
 	// Initialize handlers
 	authHandler := auth.NewHandler(authService)
 	healthHandler := health.NewHandler(deps.DB.DB, deps.Config)
+	if deps.BlacklistReaper != nil {
+		healthHandler.RegisterJob("blacklist_reaper", func() interface{} {
+			return deps.BlacklistReaper.Status()
+		})
+	}
 
 	// Health check routes (no authentication required)
 	app.Get("/health", healthHandler.Health)
 	app.Get("/ready", healthHandler.Readiness)
 	app.Get("/live", healthHandler.Liveness)
 
+	// Public keys for verifying our tokens (no authentication required)
+	app.Get("/.well-known/jwks.json", authHandler.JWKS)
+
+	// OAuth token introspection and revocation for other services (require a service account's API key;
+	// errors are answered in the RFC 6749 format)
+	oauth := app.Group("/oauth")
+	{
+		// POST /oauth/introspect - Check a token and read its claims (RFC 7662; requires the token.introspect permission)
+		oauth.Post("/introspect", auth.OAuthClientProtected(authService, authorizer, policy.PermissionTokenIntrospect), authHandler.IntrospectToken)
+
+		// POST /oauth/revoke - Revoke a token (RFC 7009; requires the token.revoke permission)
+		oauth.Post("/revoke", auth.OAuthClientProtected(authService, authorizer, policy.PermissionTokenRevoke), authHandler.RevokeToken)
+	}
+
 	// Create API v1 group
 	api := app.Group("/api/v1")
 
@@ -42,15 +120,132 @@ func RegisterRoutes(app *fiber.App, deps *Dependencies) {
 		// POST /api/v1/auth/login - User login
 		authGroup.Post("/login", authHandler.Login)
 
+		// POST /api/v1/auth/login/otp/request - Send a login code by SMS
+		authGroup.Post("/login/otp/request", authHandler.RequestLoginOTP)
+
+		// POST /api/v1/auth/login/otp - User login with an SMS code
+		authGroup.Post("/login/otp", authHandler.LoginWithOTP)
+
+		// POST /api/v1/auth/login/device-key/challenge - Issue a nonce for a device key to sign
+		authGroup.Post("/login/device-key/challenge", authHandler.CreateDeviceKeyChallenge)
+
+		// POST /api/v1/auth/login/device-key - User login with a signed device key challenge
+		authGroup.Post("/login/device-key", authHandler.LoginWithDeviceKey)
+
 		// POST /api/v1/auth/refresh - Refresh access token
 		authGroup.Post("/refresh", authHandler.Refresh)
 
-		// POST /api/v1/auth/logout - User logout (requires authentication)
-		authGroup.Post("/logout", auth.JWTProtected(authService), authHandler.Logout)
+		// POST /api/v1/auth/logout - User logout (requires authentication; allowed before a required PIN change)
+		authGroup.Post("/logout", auth.PinChangeProtected(authService), authHandler.Logout)
+
+		// POST /api/v1/auth/logout-all - Log out on every device (requires authentication; allowed before a required PIN change)
+		authGroup.Post("/logout-all", auth.PinChangeProtected(authService), authHandler.LogoutAll)
+
+		// GET /api/v1/auth/sessions - List active sessions (requires authentication)
+		authGroup.Get("/sessions", auth.JWTProtected(authService), authHandler.ListSessions)
+
+		// DELETE /api/v1/auth/sessions/:id - Revoke a session (requires authentication)
+		authGroup.Delete("/sessions/:id", auth.JWTProtected(authService), authHandler.RevokeSession)
+
+		// GET /api/v1/auth/devices - List the user's devices (requires authentication)
+		authGroup.Get("/devices", auth.JWTProtected(authService), authHandler.ListDevices)
+
+		// DELETE /api/v1/auth/devices/:id - Remove a device and sign it out (requires authentication)
+		authGroup.Delete("/devices/:id", auth.JWTProtected(authService), authHandler.RemoveDevice)
+
+		// Shop device routes (require the shop_device.manage permission)
+		shopDevices := authGroup.Group("/shop-devices", auth.JWTProtected(authService), auth.RequirePermission(authorizer, policy.PermissionShopDeviceManage))
+		{
+			// GET /api/v1/auth/shop-devices - List shop devices
+			shopDevices.Get("/", authHandler.ListShopDevices)
+
+			// POST /api/v1/auth/shop-devices - Register a shop device
+			shopDevices.Post("/", authHandler.RegisterShopDevice)
+
+			// DELETE /api/v1/auth/shop-devices/:device_id - Deregister a shop device
+			shopDevices.Delete("/:device_id", authHandler.RemoveShopDevice)
+		}
+
+		// POST /api/v1/auth/device-keys - Register a device key (requires authentication)
+		authGroup.Post("/device-keys", auth.JWTProtected(authService), authHandler.RegisterDeviceKey)
+
+		// GET /api/v1/auth/device-keys - List the user's device keys (requires authentication)
+		authGroup.Get("/device-keys", auth.JWTProtected(authService), authHandler.ListDeviceKeys)
+
+		// DELETE /api/v1/auth/device-keys/:id - Revoke a device key (requires authentication)
+		authGroup.Delete("/device-keys/:id", auth.JWTProtected(authService), authHandler.RevokeDeviceKey)
+
+		// POST /api/v1/auth/pin - Change PIN and sign out other sessions (requires authentication; allowed before a required PIN change)
+		authGroup.Post("/pin", auth.PinChangeProtected(authService), authHandler.ChangePin)
+
+		// POST /api/v1/auth/pin/reset/request - Send a PIN reset code by SMS
+		authGroup.Post("/pin/reset/request", authHandler.RequestPinReset)
+
+		// POST /api/v1/auth/pin/reset/confirm - Set a new PIN with a PIN reset code
+		authGroup.Post("/pin/reset/confirm", authHandler.ConfirmPinReset)
+
+		// GET /api/v1/auth/events - Query the authentication audit log (requires the audit.view permission)
+		authGroup.Get("/events", auth.JWTProtected(authService), auth.RequirePermission(authorizer, policy.PermissionAuditView), authHandler.ListAuthEvents)
+
+		// GET /api/v1/auth/alerts - List login alerts; own alerts only without the login_alert.review permission (requires authentication)
+		authGroup.Get("/alerts", auth.JWTProtected(authService), authHandler.ListLoginAlerts)
+
+		// POST /api/v1/auth/alerts/:id/review - Mark a login alert as reviewed (requires authentication)
+		authGroup.Post("/alerts/:id/review", auth.JWTProtected(authService), authHandler.ReviewLoginAlert)
+	}
+
+	// User administration routes (require the user.manage permission)
+	adminUsers := api.Group("/admin/users", auth.JWTProtected(authService), auth.RequirePermission(authorizer, policy.PermissionUserManage))
+	{
+		// POST /api/v1/admin/users - Create an employee account with an initial PIN
+		adminUsers.Post("/", authHandler.CreateUser)
+
+		// GET /api/v1/admin/users - List users
+		adminUsers.Get("/", authHandler.ListUsers)
+
+		// GET /api/v1/admin/users/:id - Get a user
+		adminUsers.Get("/:id", authHandler.GetUser)
+
+		// PATCH /api/v1/admin/users/:id - Edit a user's profile
+		adminUsers.Patch("/:id", authHandler.UpdateUser)
+
+		// PUT /api/v1/admin/users/:id/status - Suspend, lock or reactivate an account
+		adminUsers.Put("/:id/status", authHandler.SetUserStatus)
+
+		// POST /api/v1/admin/users/:id/logout - Log a user out on every device
+		adminUsers.Post("/:id/logout", authHandler.ForceLogout)
+
+		// POST /api/v1/admin/users/:id/unlock - Lift a lock after too many failed PIN attempts
+		adminUsers.Post("/:id/unlock", authHandler.UnlockUser)
+
+		// DELETE /api/v1/admin/users/:id - Delete an account
+		adminUsers.Delete("/:id", authHandler.DeleteUser)
 	}
 
-	// Protected routes group (for future endpoints)
-	protected := api.Group("/protected", auth.JWTProtected(authService))
+	// Service account administration routes (require the service_account.manage permission)
+	adminServiceAccounts := api.Group("/admin/service-accounts", auth.JWTProtected(authService), auth.RequirePermission(authorizer, policy.PermissionServiceAccountManage))
+	{
+		// POST /api/v1/admin/service-accounts - Create a service account
+		adminServiceAccounts.Post("/", authHandler.CreateServiceAccount)
+
+		// GET /api/v1/admin/service-accounts - List service accounts
+		adminServiceAccounts.Get("/", authHandler.ListServiceAccounts)
+
+		// DELETE /api/v1/admin/service-accounts/:id - Delete a service account and its API keys
+		adminServiceAccounts.Delete("/:id", authHandler.DeleteServiceAccount)
+
+		// POST /api/v1/admin/service-accounts/:id/keys - Create an API key; the key is only shown in the response
+		adminServiceAccounts.Post("/:id/keys", authHandler.CreateAPIKey)
+
+		// GET /api/v1/admin/service-accounts/:id/keys - List a service account's API keys
+		adminServiceAccounts.Get("/:id/keys", authHandler.ListAPIKeys)
+
+		// DELETE /api/v1/admin/service-accounts/:id/keys/:key_id - Revoke an API key
+		adminServiceAccounts.Delete("/:id/keys/:key_id", authHandler.RevokeAPIKey)
+	}
+
+	// Protected routes group (for future endpoints), open to users and to service accounts with an API key
+	protected := api.Group("/protected", auth.JWTOrAPIKeyProtected(authService))
 	{
 		// Example protected endpoint for testing
 		protected.Get("/profile", func(c *fiber.Ctx) error {
@@ -70,7 +265,7 @@ func RegisterRoutes(app *fiber.App, deps *Dependencies) {
 				"message": "Profile retrieved successfully",
 				"data": fiber.Map{
 					"user_id":      userID,
-					"phone_number": phoneNumber,
+					"phone_number": phone.FormatLocal(phoneNumber),
 				},
 			})
 		})
@@ -85,15 +280,61 @@ func RegisterRoutes(app *fiber.App, deps *Dependencies) {
 				"version": "1.0.0",
 				"endpoints": fiber.Map{
 					"auth": fiber.Map{
-						"login":   "POST /api/v1/auth/login",
-						"refresh": "POST /api/v1/auth/refresh",
-						"logout":  "POST /api/v1/auth/logout",
+						"login":                "POST /api/v1/auth/login",
+						"login_otp_request":    "POST /api/v1/auth/login/otp/request",
+						"login_otp":            "POST /api/v1/auth/login/otp",
+						"device_key_challenge": "POST /api/v1/auth/login/device-key/challenge",
+						"login_device_key":     "POST /api/v1/auth/login/device-key",
+						"refresh":              "POST /api/v1/auth/refresh",
+						"logout":               "POST /api/v1/auth/logout",
+						"logout_all":           "POST /api/v1/auth/logout-all",
+						"sessions":             "GET /api/v1/auth/sessions",
+						"revoke_session":       "DELETE /api/v1/auth/sessions/:id",
+						"devices":              "GET /api/v1/auth/devices",
+						"remove_device":        "DELETE /api/v1/auth/devices/:id",
+						"shop_devices":         "GET /api/v1/auth/shop-devices",
+						"register_shop_device": "POST /api/v1/auth/shop-devices",
+						"remove_shop_device":   "DELETE /api/v1/auth/shop-devices/:device_id",
+						"register_device_key":  "POST /api/v1/auth/device-keys",
+						"device_keys":          "GET /api/v1/auth/device-keys",
+						"revoke_device_key":    "DELETE /api/v1/auth/device-keys/:id",
+						"change_pin":           "POST /api/v1/auth/pin",
+						"pin_reset_request":    "POST /api/v1/auth/pin/reset/request",
+						"pin_reset_confirm":    "POST /api/v1/auth/pin/reset/confirm",
+						"events":               "GET /api/v1/auth/events",
+						"alerts":               "GET /api/v1/auth/alerts",
+						"review_alert":         "POST /api/v1/auth/alerts/:id/review",
+					},
+					"admin": fiber.Map{
+						"users":                  "GET /api/v1/admin/users",
+						"create_user":            "POST /api/v1/admin/users",
+						"user":                   "GET /api/v1/admin/users/:id",
+						"update_user":            "PATCH /api/v1/admin/users/:id",
+						"user_status":            "PUT /api/v1/admin/users/:id/status",
+						"delete_user":            "DELETE /api/v1/admin/users/:id",
+						"logout_user":            "POST /api/v1/admin/users/:id/logout",
+						"unlock_user":            "POST /api/v1/admin/users/:id/unlock",
+						"service_accounts":       "GET /api/v1/admin/service-accounts",
+						"create_service_account": "POST /api/v1/admin/service-accounts",
+						"delete_service_account": "DELETE /api/v1/admin/service-accounts/:id",
+						"api_keys":               "GET /api/v1/admin/service-accounts/:id/keys",
+						"create_api_key":         "POST /api/v1/admin/service-accounts/:id/keys",
+						"revoke_api_key":         "DELETE /api/v1/admin/service-accounts/:id/keys/:key_id",
 					},
 					"protected": fiber.Map{
 						"profile": "GET /api/v1/protected/profile",
 					},
+					"oauth": fiber.Map{
+						"introspect": "POST /oauth/introspect",
+						"revoke":     "POST /oauth/revoke",
+					},
+					"well_known": fiber.Map{
+						"jwks": "GET /.well-known/jwks.json",
+					},
 				},
 			},
 		})
 	})
+
+	return nil
 }
\ No newline at end of file
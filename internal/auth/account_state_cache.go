@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/user"
+)
+
+// accountStateCacheMaxEntries bounds the memory used by the account state cache
+// When the cache is full, expired entries are dropped; if that is not enough it starts over empty
+const accountStateCacheMaxEntries = 100000
+
+// accountStateCacheEntry is a cached account state lookup result
+type accountStateCacheEntry struct {
+	state     user.AccountState
+	expiresAt time.Time
+}
+
+// accountStateCache keeps recent account state lookups in memory so that checking the status
+// and token cutoff on every protected request does not need a database round trip
+// A state is reused for at most ttl, which bounds how long a user suspended or logged out
+// everywhere through another instance can still use their tokens here. Changes made through
+// this instance forget the cached state, so they take effect immediately.
+type accountStateCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[uuid.UUID]accountStateCacheEntry
+}
+
+// newAccountStateCache creates an account state cache; a ttl of zero or less disables caching
+func newAccountStateCache(ttl time.Duration) *accountStateCache {
+	return &accountStateCache{
+		ttl:     ttl,
+		entries: map[uuid.UUID]accountStateCacheEntry{},
+	}
+}
+
+// get returns the cached state of a user's account, if there is one that has not expired
+func (c *accountStateCache) get(userID uuid.UUID) (user.AccountState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return user.AccountState{}, false
+	}
+	return entry.state, true
+}
+
+// store caches the state of a user's account, making room first if the cache is full
+func (c *accountStateCache) store(userID uuid.UUID, state user.AccountState) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= accountStateCacheMaxEntries {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= accountStateCacheMaxEntries {
+			// Every entry can be reloaded from the database, so dropping them is always safe
+			c.entries = map[uuid.UUID]accountStateCacheEntry{}
+		}
+	}
+
+	c.entries[userID] = accountStateCacheEntry{
+		state:     state,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// forget drops the cached state of a user's account after it has changed
+func (c *accountStateCache) forget(userID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
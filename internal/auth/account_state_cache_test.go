@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"tt-stock-api/internal/user"
+)
+
+func TestAccountStateCache(t *testing.T) {
+	cache := newAccountStateCache(time.Minute)
+	userID := uuid.New()
+	tokensValidAfter := time.Now()
+
+	_, ok := cache.get(userID)
+	assert.False(t, ok)
+
+	cache.store(userID, user.AccountState{Status: user.StatusActive, TokensValidAfter: &tokensValidAfter})
+	state, ok := cache.get(userID)
+	assert.True(t, ok)
+	assert.Equal(t, user.StatusActive, state.Status)
+	assert.Equal(t, &tokensValidAfter, state.TokensValidAfter)
+
+	// A change to the account forgets the cached state
+	cache.forget(userID)
+	_, ok = cache.get(userID)
+	assert.False(t, ok)
+
+	// Expired entries are not used
+	cache.store(userID, user.AccountState{Status: user.StatusActive})
+	entry := cache.entries[userID]
+	entry.expiresAt = time.Now().Add(-time.Second)
+	cache.entries[userID] = entry
+	_, ok = cache.get(userID)
+	assert.False(t, ok)
+}
+
+func TestAccountStateCache_Disabled(t *testing.T) {
+	cache := newAccountStateCache(0)
+	userID := uuid.New()
+
+	cache.store(userID, user.AccountState{Status: user.StatusActive})
+	_, ok := cache.get(userID)
+	assert.False(t, ok)
+	assert.Empty(t, cache.entries)
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/user"
+)
+
+// recordAuthEvent appends an event to the audit log with the client's IP, user agent and device
+// The audit log never fails the operation being audited
+func (s *service) recordAuthEvent(event AuthEvent, client ClientInfo) {
+	event.IPAddress = client.IPAddress
+	event.UserAgent = client.UserAgent
+	if event.DeviceID == "" {
+		event.DeviceID = client.DeviceID
+	}
+
+	if err := s.authEventRepo.RecordAuthEvent(&event); err != nil {
+		// Log error but don't fail the audited operation
+		// In a real application, you'd use a proper logger here
+	}
+}
+
+// recordLoginFailed records a failed login attempt
+// u is nil when the attempt could not be attributed to a user
+func (s *service) recordLoginFailed(method, phoneNumber string, u *user.User, client ClientInfo, reason string) {
+	event := AuthEvent{
+		EventType:   AuthEventLoginFailed,
+		PhoneNumber: phoneNumber,
+		Method:      method,
+		Reason:      reason,
+	}
+	if u != nil {
+		event.UserID = &u.ID
+		event.PhoneNumber = u.PhoneNumber
+	}
+
+	s.recordAuthEvent(event, client)
+}
+
+// failLogin counts a failed login attempt, records it in the audit log and returns the error to
+// report to the caller. An attempt that locks the account is also recorded as a lockout.
+func (s *service) failLogin(method, phoneNumber string, u *user.User, client ClientInfo, reason string) error {
+	return s.failAttempt(AuthEventLoginFailed, method, phoneNumber, u, client, reason)
+}
+
+// failAttempt counts a failed PIN, code or key check against the login throttle and records
+// it as an event of the given type, followed by a lockout event if the account has just been locked
+func (s *service) failAttempt(eventType, method, phoneNumber string, u *user.User, client ClientInfo, reason string) error {
+	err := s.recordLoginFailure(phoneNumber, client.IPAddress)
+
+	event := AuthEvent{
+		EventType:   eventType,
+		PhoneNumber: phoneNumber,
+		Method:      method,
+		Reason:      reason,
+	}
+	if u != nil {
+		event.UserID = &u.ID
+	}
+	s.recordAuthEvent(event, client)
+
+	var lockedErr *AccountLockedError
+	if errors.As(err, &lockedErr) {
+		event.EventType = AuthEventAccountLocked
+		event.Reason = ""
+		s.recordAuthEvent(event, client)
+	}
+
+	return err
+}
+
+// recordSessionEvent records an event concerning a session, such as a refresh or logout
+// A failed event carries the reason derived from err
+func (s *service) recordSessionEvent(eventType string, claims *Claims, client ClientInfo, err error) {
+	event := AuthEvent{
+		EventType:   eventType,
+		UserID:      &claims.UserID,
+		PhoneNumber: claims.PhoneNumber,
+	}
+	if sessionID, parseErr := uuid.Parse(claims.FamilyID); parseErr == nil {
+		event.SessionID = &sessionID
+	}
+	if err != nil {
+		event.Reason = authFailureReason(err)
+	}
+
+	s.recordAuthEvent(event, client)
+}
+
+// authFailureReason returns the audit log reason for an error returned by the service
+func authFailureReason(err error) string {
+	var lockedErr *AccountLockedError
+	var attemptsErr *TooManyAttemptsError
+
+	switch {
+	case errors.As(err, &lockedErr):
+		return AuthReasonAccountLocked
+	case errors.As(err, &attemptsErr):
+		return AuthReasonRateLimited
+	case errors.Is(err, ErrValidation):
+		return AuthReasonValidation
+	case errors.Is(err, ErrInvalidCredentials):
+		return AuthReasonInvalidCredentials
+	case errors.Is(err, ErrInvalidOTP):
+		return AuthReasonInvalidOTP
+	case errors.Is(err, ErrOTPAttemptsExceeded):
+		return AuthReasonOTPAttemptsExceeded
+	case errors.Is(err, ErrInvalidDeviceKey):
+		return AuthReasonInvalidDeviceKey
+	case errors.Is(err, ErrAccountSuspended):
+		return AuthReasonAccountSuspended
+	case errors.Is(err, ErrAccountLocked):
+		return AuthReasonAccountLocked
+	case errors.Is(err, ErrDeviceNotAllowed):
+		return AuthReasonDeviceNotAllowed
+	case errors.Is(err, ErrDeviceMismatch):
+		return AuthReasonDeviceMismatch
+	case errors.Is(err, ErrWrongTokenType):
+		return AuthReasonWrongTokenType
+	case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrInvalidToken):
+		return AuthReasonInvalidToken
+	case errors.Is(err, ErrRefreshTokenReused):
+		return AuthReasonTokenReused
+	case errors.Is(err, ErrSessionExpired):
+		return AuthReasonSessionExpired
+	default:
+		return AuthReasonError
+	}
+}
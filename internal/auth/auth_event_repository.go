@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// Authentication audit log event types
+const (
+	AuthEventLoginSucceeded  = "login_succeeded"
+	AuthEventLoginFailed     = "login_failed"
+	AuthEventAccountLocked   = "account_locked"
+	AuthEventTokenRefreshed  = "token_refreshed"
+	AuthEventRefreshFailed   = "refresh_failed"
+	AuthEventLogout          = "logout"
+	AuthEventLogoutAll       = "logout_all"
+	AuthEventPinChanged      = "pin_changed"
+	AuthEventPinChangeFailed = "pin_change_failed"
+	AuthEventPinReset        = "pin_reset"
+	AuthEventSessionRevoked  = "session_revoked"
+
+	AuthEventDeviceKeyRegisterFailed = "device_key_register_failed" // A wrong PIN was given to register a device key
+
+	// User management by an administrator; the actor is recorded with the event
+	AuthEventUserCreated       = "user_created"
+	AuthEventUserUpdated       = "user_updated"
+	AuthEventUserStatusChanged = "user_status_changed" // The new status is recorded as the reason
+	AuthEventUserDeleted       = "user_deleted"
+	AuthEventUserLoggedOut     = "user_logged_out"
+	AuthEventUserUnlocked      = "user_unlocked"
+
+	// Service account management by an administrator; the service account is recorded as the user
+	AuthEventServiceAccountCreated = "service_account_created"
+	AuthEventServiceAccountDeleted = "service_account_deleted"
+	AuthEventAPIKeyCreated         = "api_key_created" // The key prefix is recorded as the reason
+	AuthEventAPIKeyRevoked         = "api_key_revoked" // The key prefix is recorded as the reason
+
+	// A token revoked by a service account through POST /oauth/revoke; the service account is
+	// recorded as the actor and the token type as the reason
+	AuthEventTokenRevoked = "token_revoked"
+)
+
+// Login methods recorded with login events
+const (
+	LoginMethodPin       = "pin"
+	LoginMethodOTP       = "otp"
+	LoginMethodDeviceKey = "device_key"
+)
+
+// Reasons recorded with failed events
+const (
+	AuthReasonUnknownUser         = "unknown_user"
+	AuthReasonInvalidPin          = "invalid_pin"
+	AuthReasonInvalidOTP          = "invalid_otp"
+	AuthReasonOTPAttemptsExceeded = "otp_attempts_exceeded"
+	AuthReasonOTPLoginDisabled    = "otp_login_disabled"
+	AuthReasonInvalidDeviceKey    = "invalid_device_key"
+	AuthReasonInvalidCredentials  = "invalid_credentials"
+	AuthReasonAccountLocked       = "account_locked"
+	AuthReasonAccountSuspended    = "account_suspended"
+	AuthReasonRateLimited         = "rate_limited"
+	AuthReasonDeviceNotAllowed    = "device_not_allowed"
+	AuthReasonDeviceMismatch      = "device_mismatch"
+	AuthReasonWrongTokenType      = "wrong_token_type"
+	AuthReasonInvalidToken        = "invalid_token"
+	AuthReasonTokenReused         = "token_reused"
+	AuthReasonSessionExpired      = "session_expired"
+	AuthReasonValidation          = "validation_failed"
+	AuthReasonError               = "error"
+)
+
+// AuthEventRepository defines the interface for the append-only authentication audit log
+type AuthEventRepository interface {
+	RecordAuthEvent(event *AuthEvent) error
+	ListAuthEvents(filter AuthEventFilter) ([]AuthEvent, int, error)
+	LoginHistory(userID uuid.UUID, ipAddress, deviceID string) (*LoginHistory, error)
+}
+
+// authEventRepository implements the AuthEventRepository interface
+type authEventRepository struct {
+	db *db.DB
+}
+
+// NewAuthEventRepository creates a new authentication audit log repository instance
+func NewAuthEventRepository(database *db.DB) AuthEventRepository {
+	return &authEventRepository{
+		db: database,
+	}
+}
+
+// RecordAuthEvent appends an event to the audit log
+func (r *authEventRepository) RecordAuthEvent(event *AuthEvent) error {
+	if event == nil || event.EventType == "" {
+		return errors.New("event type cannot be empty")
+	}
+
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO auth_events (id, event_type, user_id, phone_number, method, reason, session_id, ip_address, user_agent, device_id, actor_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	_, err := r.db.Exec(query,
+		event.ID,
+		event.EventType,
+		event.UserID,
+		event.PhoneNumber,
+		event.Method,
+		event.Reason,
+		event.SessionID,
+		event.IPAddress,
+		event.UserAgent,
+		event.DeviceID,
+		event.ActorID,
+		event.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record auth event: %w", err)
+	}
+
+	return nil
+}
+
+// ListAuthEvents returns the events matching the filter, newest first, together with the
+// number of matching events across all pages
+func (r *authEventRepository) ListAuthEvents(filter AuthEventFilter) ([]AuthEvent, int, error) {
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != uuid.Nil {
+		where("user_id = $%d", filter.UserID)
+	}
+	if filter.PhoneNumber != "" {
+		where("phone_number = $%d", filter.PhoneNumber)
+	}
+	if filter.EventType != "" {
+		where("event_type = $%d", filter.EventType)
+	}
+	if filter.IPAddress != "" {
+		where("ip_address = $%d", filter.IPAddress)
+	}
+	if filter.DeviceID != "" {
+		where("device_id = $%d", filter.DeviceID)
+	}
+	if filter.ActorID != uuid.Nil {
+		where("actor_id = $%d", filter.ActorID)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM auth_events " + whereClause
+	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count auth events: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, event_type, user_id, phone_number, method, reason, session_id, ip_address, user_agent, device_id, actor_id, created_at
+		FROM auth_events
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, whereClause, len(args)+1, len(args)+2)
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list auth events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AuthEvent{}
+	for rows.Next() {
+		event, err := scanAuthEvent(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan auth event: %w", err)
+		}
+		events = append(events, *event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list auth events: %w", err)
+	}
+
+	return events, total, nil
+}
+
+// LoginHistory reports whether the user has logged in before, and whether from the IP address
+// and device. Empty IP addresses and device IDs are never known.
+func (r *authEventRepository) LoginHistory(userID uuid.UUID, ipAddress, deviceID string) (*LoginHistory, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		SELECT COUNT(*) > 0,
+			COALESCE(BOOL_OR(ip_address = $2 AND ip_address <> ''), false),
+			COALESCE(BOOL_OR(device_id = $3 AND device_id <> ''), false)
+		FROM auth_events
+		WHERE user_id = $1 AND event_type = $4
+	`
+
+	var history LoginHistory
+	err := r.db.QueryRow(query, userID, ipAddress, deviceID, AuthEventLoginSucceeded).
+		Scan(&history.HasLogins, &history.KnownIP, &history.KnownDevice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login history: %w", err)
+	}
+
+	return &history, nil
+}
+
+// scanAuthEvent scans an audit log row produced by ListAuthEvents
+func scanAuthEvent(row interface{ Scan(dest ...any) error }) (*AuthEvent, error) {
+	var event AuthEvent
+	var userID, sessionID, actorID uuid.NullUUID
+
+	err := row.Scan(
+		&event.ID,
+		&event.EventType,
+		&userID,
+		&event.PhoneNumber,
+		&event.Method,
+		&event.Reason,
+		&sessionID,
+		&event.IPAddress,
+		&event.UserAgent,
+		&event.DeviceID,
+		&actorID,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		event.UserID = &userID.UUID
+	}
+	if sessionID.Valid {
+		event.SessionID = &sessionID.UUID
+	}
+	if actorID.Valid {
+		event.ActorID = &actorID.UUID
+	}
+
+	return &event, nil
+}
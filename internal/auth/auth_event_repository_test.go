@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tt-stock-api/internal/db"
+)
+
+var authEventColumns = []string{"id", "event_type", "user_id", "phone_number", "method", "reason", "session_id", "ip_address", "user_agent", "device_id", "actor_id", "created_at"}
+
+func TestAuthEventRepository_RecordAuthEvent(t *testing.T) {
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	actorID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440001")
+
+	tests := []struct {
+		name        string
+		event       *AuthEvent
+		setupMock   func(mock sqlmock.Sqlmock)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "failed login without a known user",
+			event: &AuthEvent{
+				EventType:   AuthEventLoginFailed,
+				PhoneNumber: "0812345678",
+				Method:      LoginMethodPin,
+				Reason:      AuthReasonUnknownUser,
+				IPAddress:   "192.168.1.1",
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO auth_events`).
+					WithArgs(sqlmock.AnyArg(), AuthEventLoginFailed, nil, "0812345678", LoginMethodPin, AuthReasonUnknownUser, nil, "192.168.1.1", "", "", nil, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:  "event of a user",
+			event: &AuthEvent{EventType: AuthEventLogout, UserID: &userID},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO auth_events`).
+					WithArgs(sqlmock.AnyArg(), AuthEventLogout, &userID, "", "", "", nil, "", "", "", nil, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:  "event performed by an administrator",
+			event: &AuthEvent{EventType: AuthEventUserStatusChanged, UserID: &userID, Reason: "suspended", ActorID: &actorID},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO auth_events \(id, event_type, user_id, phone_number, method, reason, session_id, ip_address, user_agent, device_id, actor_id, created_at\)`).
+					WithArgs(sqlmock.AnyArg(), AuthEventUserStatusChanged, &userID, "", "", "suspended", nil, "", "", "", &actorID, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:  "database error",
+			event: &AuthEvent{EventType: AuthEventLogout},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO auth_events`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			expectError: true,
+			errorMsg:    "failed to record auth event",
+		},
+		{
+			name:  "missing event type",
+			event: &AuthEvent{},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				// No mock setup needed as validation happens before query
+			},
+			expectError: true,
+			errorMsg:    "event type cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+
+			repo := NewAuthEventRepository(&db.DB{DB: mockDB})
+			err = repo.RecordAuthEvent(tt.event)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEqual(t, uuid.Nil, tt.event.ID)
+				assert.False(t, tt.event.CreatedAt.IsZero())
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthEventRepository_ListAuthEvents(t *testing.T) {
+	eventID := uuid.New()
+	userID := uuid.New()
+	sessionID := uuid.New()
+	actorID := uuid.New()
+	createdAt := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		filter        AuthEventFilter
+		setupMock     func(mock sqlmock.Sqlmock)
+		expected      []AuthEvent
+		expectedTotal int
+		expectError   bool
+		errorMsg      string
+	}{
+		{
+			name:   "filters by user, type and time",
+			filter: AuthEventFilter{UserID: userID, EventType: AuthEventTokenRefreshed, From: from, Limit: 50},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_events WHERE user_id = \$1 AND event_type = \$2 AND created_at >= \$3`).
+					WithArgs(userID, AuthEventTokenRefreshed, from).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(51))
+				rows := sqlmock.NewRows(authEventColumns).
+					AddRow(eventID, AuthEventTokenRefreshed, userID, "0812345678", "", "", sessionID, "192.168.1.1", "tt-stock-app/1.0", "device-1", nil, createdAt)
+				mock.ExpectQuery(`FROM auth_events\s+WHERE user_id = \$1 AND event_type = \$2 AND created_at >= \$3\s+ORDER BY created_at DESC, id DESC\s+LIMIT \$4 OFFSET \$5`).
+					WithArgs(userID, AuthEventTokenRefreshed, from, 50, 0).
+					WillReturnRows(rows)
+			},
+			expected: []AuthEvent{
+				{
+					ID:          eventID,
+					EventType:   AuthEventTokenRefreshed,
+					UserID:      &userID,
+					PhoneNumber: "0812345678",
+					SessionID:   &sessionID,
+					IPAddress:   "192.168.1.1",
+					UserAgent:   "tt-stock-app/1.0",
+					DeviceID:    "device-1",
+					CreatedAt:   createdAt,
+				},
+			},
+			expectedTotal: 51,
+		},
+		{
+			name:   "no filter and no matches",
+			filter: AuthEventFilter{Limit: 50, Offset: 50},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_events\s*$`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`FROM auth_events\s+ORDER BY created_at DESC, id DESC\s+LIMIT \$1 OFFSET \$2`).
+					WithArgs(50, 50).
+					WillReturnRows(sqlmock.NewRows(authEventColumns))
+			},
+			expected: []AuthEvent{},
+		},
+		{
+			name:   "failed login without a user",
+			filter: AuthEventFilter{PhoneNumber: "0812345678", Limit: 50},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_events WHERE phone_number = \$1`).
+					WithArgs("0812345678").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				rows := sqlmock.NewRows(authEventColumns).
+					AddRow(eventID, AuthEventLoginFailed, nil, "0812345678", LoginMethodPin, AuthReasonUnknownUser, nil, "192.168.1.1", "", "", nil, createdAt)
+				mock.ExpectQuery(`FROM auth_events\s+WHERE phone_number = \$1`).
+					WithArgs("0812345678", 50, 0).
+					WillReturnRows(rows)
+			},
+			expected: []AuthEvent{
+				{
+					ID:          eventID,
+					EventType:   AuthEventLoginFailed,
+					PhoneNumber: "0812345678",
+					Method:      LoginMethodPin,
+					Reason:      AuthReasonUnknownUser,
+					IPAddress:   "192.168.1.1",
+					CreatedAt:   createdAt,
+				},
+			},
+			expectedTotal: 1,
+		},
+		{
+			name:   "filters by actor",
+			filter: AuthEventFilter{ActorID: actorID, Limit: 50},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_events WHERE actor_id = \$1`).
+					WithArgs(actorID).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				rows := sqlmock.NewRows(authEventColumns).
+					AddRow(eventID, AuthEventUserCreated, userID, "+66812345678", "", "", nil, "192.168.1.1", "", "", actorID, createdAt)
+				mock.ExpectQuery(`FROM auth_events\s+WHERE actor_id = \$1`).
+					WithArgs(actorID, 50, 0).
+					WillReturnRows(rows)
+			},
+			expected: []AuthEvent{
+				{
+					ID:          eventID,
+					EventType:   AuthEventUserCreated,
+					UserID:      &userID,
+					PhoneNumber: "+66812345678",
+					IPAddress:   "192.168.1.1",
+					ActorID:     &actorID,
+					CreatedAt:   createdAt,
+				},
+			},
+			expectedTotal: 1,
+		},
+		{
+			name:   "database error",
+			filter: AuthEventFilter{Limit: 50},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_events`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			expectError: true,
+			errorMsg:    "failed to count auth events",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+
+			repo := NewAuthEventRepository(&db.DB{DB: mockDB})
+			events, total, err := repo.ListAuthEvents(tt.filter)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				assert.Nil(t, events)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, events)
+				assert.Equal(t, tt.expectedTotal, total)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthEventRepository_LoginHistory(t *testing.T) {
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) > 0,\s+COALESCE\(BOOL_OR\(ip_address = \$2 AND ip_address <> ''\), false\),\s+COALESCE\(BOOL_OR\(device_id = \$3 AND device_id <> ''\), false\)\s+FROM auth_events\s+WHERE user_id = \$1 AND event_type = \$4`).
+		WithArgs(userID, "203.0.113.7", "phone-1", AuthEventLoginSucceeded).
+		WillReturnRows(sqlmock.NewRows([]string{"has_logins", "known_ip", "known_device"}).AddRow(true, true, false))
+	mock.ExpectQuery(`FROM auth_events`).
+		WithArgs(userID, "203.0.113.7", "", AuthEventLoginSucceeded).
+		WillReturnError(errors.New("database connection error"))
+
+	repo := NewAuthEventRepository(&db.DB{DB: mockDB})
+
+	history, err := repo.LoginHistory(userID, "203.0.113.7", "phone-1")
+	require.NoError(t, err)
+	assert.Equal(t, &LoginHistory{HasLogins: true, KnownIP: true}, history)
+
+	history, err = repo.LoginHistory(userID, "203.0.113.7", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to query login history")
+	assert.Nil(t, history)
+
+	// An empty user ID never queries the database
+	_, err = repo.LoginHistory(uuid.Nil, "203.0.113.7", "phone-1")
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
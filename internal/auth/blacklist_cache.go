@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"tt-stock-api/pkg/utils"
+)
+
+// blacklistCacheMaxEntries bounds the memory used by the blacklist cache
+// When the cache is full, expired entries are dropped; if that is not enough it starts over empty
+const blacklistCacheMaxEntries = 100000
+
+// blacklistCacheEntry is a cached blacklist lookup result
+type blacklistCacheEntry struct {
+	blacklisted bool
+	expiresAt   time.Time
+}
+
+// cachedBlacklistRepository keeps recent blacklist lookups in memory so that validating a token
+// on every protected request does not need a database round trip
+type cachedBlacklistRepository struct {
+	repo BlacklistRepository
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]blacklistCacheEntry // Keyed by token hash
+}
+
+// NewCachedBlacklistRepository wraps a blacklist repository with an in-memory lookup cache
+// A "not blacklisted" answer is reused for at most ttl, which bounds how long a token revoked by
+// another instance is still accepted here. Tokens blacklisted through this instance are cached
+// as blacklisted right away, so its own revocations take effect immediately.
+// A ttl of zero or less disables caching.
+func NewCachedBlacklistRepository(repo BlacklistRepository, ttl time.Duration) BlacklistRepository {
+	if ttl <= 0 {
+		return repo
+	}
+
+	return &cachedBlacklistRepository{
+		repo:    repo,
+		ttl:     ttl,
+		entries: map[string]blacklistCacheEntry{},
+	}
+}
+
+// BlacklistToken adds a token to the blacklist and caches it as blacklisted until it expires
+func (r *cachedBlacklistRepository) BlacklistToken(token, userID, tokenType string, expiresAt time.Time) error {
+	if err := r.repo.BlacklistToken(token, userID, tokenType, expiresAt); err != nil {
+		return err
+	}
+
+	r.store(utils.HashToken(token), true, expiresAt)
+	return nil
+}
+
+// IsTokenBlacklisted answers from the cache when possible and caches the database answer otherwise
+func (r *cachedBlacklistRepository) IsTokenBlacklisted(token string) (bool, error) {
+	tokenHash := utils.HashToken(token)
+	now := time.Now()
+
+	r.mu.Lock()
+	entry, ok := r.entries[tokenHash]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.blacklisted, nil
+	}
+
+	blacklisted, err := r.repo.IsTokenBlacklisted(token)
+	if err != nil {
+		return false, err
+	}
+
+	r.store(tokenHash, blacklisted, now.Add(r.ttl))
+	return blacklisted, nil
+}
+
+// ConsumeRefreshToken consumes a refresh token and caches it as blacklisted until it expires
+func (r *cachedBlacklistRepository) ConsumeRefreshToken(token, userID string, expiresAt time.Time, rotate func() ([]byte, error)) (*RefreshTokenUse, error) {
+	use, err := r.repo.ConsumeRefreshToken(token, userID, expiresAt, rotate)
+	if err != nil {
+		return nil, err
+	}
+
+	r.store(utils.HashToken(token), true, expiresAt)
+	return use, nil
+}
+
+// PurgeExpired deletes expired blacklist entries; cached entries expire on their own
+func (r *cachedBlacklistRepository) PurgeExpired(batchSize int) (int64, error) {
+	return r.repo.PurgeExpired(batchSize)
+}
+
+// store caches a lookup result, making room first if the cache is full
+func (r *cachedBlacklistRepository) store(tokenHash string, blacklisted bool, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	// A lookup that started before a local revocation must not overwrite it
+	if existing, ok := r.entries[tokenHash]; ok && existing.blacklisted && !blacklisted && now.Before(existing.expiresAt) {
+		return
+	}
+
+	if len(r.entries) >= blacklistCacheMaxEntries {
+		for key, entry := range r.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(r.entries, key)
+			}
+		}
+		if len(r.entries) >= blacklistCacheMaxEntries {
+			// Every entry can be reloaded from the database, so dropping them is always safe
+			r.entries = map[string]blacklistCacheEntry{}
+		}
+	}
+
+	r.entries[tokenHash] = blacklistCacheEntry{
+		blacklisted: blacklisted,
+		expiresAt:   expiresAt,
+	}
+}
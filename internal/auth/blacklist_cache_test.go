@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCachedBlacklistRepository_Disabled(t *testing.T) {
+	repo := &MockBlacklistRepository{}
+
+	// Without a TTL the repository is used as is
+	assert.Same(t, repo, NewCachedBlacklistRepository(repo, 0))
+}
+
+func TestCachedBlacklistRepository_IsTokenBlacklisted(t *testing.T) {
+	repo := &MockBlacklistRepository{}
+	cached := NewCachedBlacklistRepository(repo, time.Minute).(*cachedBlacklistRepository)
+
+	// Only the first lookup reaches the database
+	repo.On("IsTokenBlacklisted", "token").Return(false, nil).Once()
+	for i := 0; i < 3; i++ {
+		blacklisted, err := cached.IsTokenBlacklisted("token")
+		require.NoError(t, err)
+		assert.False(t, blacklisted)
+	}
+	repo.AssertExpectations(t)
+
+	// Once the entry expires, a revocation made by another instance is seen
+	for key, entry := range cached.entries {
+		entry.expiresAt = time.Now().Add(-time.Second)
+		cached.entries[key] = entry
+	}
+	repo.On("IsTokenBlacklisted", "token").Return(true, nil).Once()
+	blacklisted, err := cached.IsTokenBlacklisted("token")
+	require.NoError(t, err)
+	assert.True(t, blacklisted)
+	repo.AssertExpectations(t)
+
+	// Errors are not cached
+	repo.On("IsTokenBlacklisted", "other-token").Return(false, errors.New("db error")).Once()
+	_, err = cached.IsTokenBlacklisted("other-token")
+	assert.Error(t, err)
+	repo.On("IsTokenBlacklisted", "other-token").Return(false, nil).Once()
+	blacklisted, err = cached.IsTokenBlacklisted("other-token")
+	require.NoError(t, err)
+	assert.False(t, blacklisted)
+	repo.AssertExpectations(t)
+}
+
+func TestCachedBlacklistRepository_LocalRevocationIsImmediate(t *testing.T) {
+	repo := &MockBlacklistRepository{}
+	cached := NewCachedBlacklistRepository(repo, time.Minute)
+	expiresAt := time.Now().Add(15 * time.Minute)
+
+	// The token is cached as valid, then revoked through this instance
+	repo.On("IsTokenBlacklisted", "access-token").Return(false, nil).Once()
+	blacklisted, err := cached.IsTokenBlacklisted("access-token")
+	require.NoError(t, err)
+	assert.False(t, blacklisted)
+
+	repo.On("BlacklistToken", "access-token", "user-id", "access", expiresAt).Return(nil).Once()
+	require.NoError(t, cached.BlacklistToken("access-token", "user-id", "access", expiresAt))
+
+	blacklisted, err = cached.IsTokenBlacklisted("access-token")
+	require.NoError(t, err)
+	assert.True(t, blacklisted)
+
+	// A consumed refresh token is rejected without a database lookup
+	repo.On("ConsumeRefreshToken", "refresh-token", "user-id", expiresAt).Return(&RefreshTokenUse{}, nil).Once()
+	_, err = cached.ConsumeRefreshToken("refresh-token", "user-id", expiresAt, func() ([]byte, error) { return nil, nil })
+	require.NoError(t, err)
+
+	blacklisted, err = cached.IsTokenBlacklisted("refresh-token")
+	require.NoError(t, err)
+	assert.True(t, blacklisted)
+
+	repo.AssertExpectations(t)
+	repo.AssertNumberOfCalls(t, "IsTokenBlacklisted", 1)
+}
+
+func TestCachedBlacklistRepository_FailedRevocationIsNotCached(t *testing.T) {
+	repo := &MockBlacklistRepository{}
+	cached := NewCachedBlacklistRepository(repo, time.Minute)
+	expiresAt := time.Now().Add(15 * time.Minute)
+
+	repo.On("BlacklistToken", "access-token", "user-id", "access", expiresAt).Return(errors.New("db error")).Once()
+	assert.Error(t, cached.BlacklistToken("access-token", "user-id", "access", expiresAt))
+
+	repo.On("IsTokenBlacklisted", "access-token").Return(false, nil).Once()
+	blacklisted, err := cached.IsTokenBlacklisted("access-token")
+	require.NoError(t, err)
+	assert.False(t, blacklisted)
+
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// ReaperStatus describes the most recent run of the blacklist reaper
+type ReaperStatus struct {
+	Running     bool       `json:"running"`
+	Interval    string     `json:"interval"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastDeleted int64      `json:"last_deleted"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// BlacklistReaper periodically deletes expired token_blacklist rows
+// Rows are deleted in bounded batches so a large backlog never holds long locks
+type BlacklistReaper struct {
+	repo      BlacklistRepository
+	interval  time.Duration
+	batchSize int
+
+	mu     sync.Mutex
+	status ReaperStatus
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewBlacklistReaper creates a reaper that purges expired entries every interval,
+// deleting at most batchSize rows per statement
+func NewBlacklistReaper(repo BlacklistRepository, interval time.Duration, batchSize int) *BlacklistReaper {
+	return &BlacklistReaper{
+		repo:      repo,
+		interval:  interval,
+		batchSize: batchSize,
+		status: ReaperStatus{
+			Interval: interval.String(),
+		},
+	}
+}
+
+// Start runs the reaper in the background until Stop is called
+// It does nothing if the interval is not positive or the reaper is already running
+func (r *BlacklistReaper) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.interval <= 0 || r.stop != nil {
+		return
+	}
+
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	r.status.Running = true
+
+	go r.loop(r.stop, r.done)
+}
+
+// Stop stops the background reaper and waits for a purge in progress to finish its current batch
+func (r *BlacklistReaper) Stop() {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.status.Running = false
+	r.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	<-done
+}
+
+// RunOnce deletes all expired entries, batch by batch, and returns how many were deleted
+func (r *BlacklistReaper) RunOnce() (int64, error) {
+	return r.purge(nil)
+}
+
+// Status returns the state of the reaper and the outcome of its last run
+func (r *BlacklistReaper) Status() ReaperStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// loop purges on every tick until stop is closed
+func (r *BlacklistReaper) loop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := r.purge(stop); err != nil {
+				// Log error but keep the reaper running; the status reports the failure
+				// In a real application, you'd use a proper logger here
+			}
+		}
+	}
+}
+
+// purge deletes expired entries until a batch comes back short or stop is closed,
+// and records the outcome in the status
+func (r *BlacklistReaper) purge(stop <-chan struct{}) (int64, error) {
+	var total int64
+	var err error
+
+	for {
+		var deleted int64
+		deleted, err = r.repo.PurgeExpired(r.batchSize)
+		total += deleted
+		if err != nil || deleted < int64(r.batchSize) {
+			break
+		}
+
+		select {
+		case <-stop:
+			// Shutting down; the remaining rows are picked up by the next run
+			return r.record(total, nil)
+		default:
+		}
+	}
+
+	return r.record(total, err)
+}
+
+// record stores the outcome of a run in the status
+func (r *BlacklistReaper) record(deleted int64, err error) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.status.LastRunAt = &now
+	r.status.LastDeleted = deleted
+	r.status.LastError = ""
+	if err != nil {
+		r.status.LastError = err.Error()
+	}
+
+	return deleted, err
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlacklistReaper_RunOnce(t *testing.T) {
+	t.Run("Deletes in batches until a batch comes back short", func(t *testing.T) {
+		repo := &MockBlacklistRepository{}
+		repo.On("PurgeExpired", 100).Return(int64(100), nil).Twice()
+		repo.On("PurgeExpired", 100).Return(int64(42), nil).Once()
+
+		reaper := NewBlacklistReaper(repo, time.Hour, 100)
+		deleted, err := reaper.RunOnce()
+
+		require.NoError(t, err)
+		assert.Equal(t, int64(242), deleted)
+		repo.AssertExpectations(t)
+
+		status := reaper.Status()
+		require.NotNil(t, status.LastRunAt)
+		assert.Equal(t, int64(242), status.LastDeleted)
+		assert.Empty(t, status.LastError)
+		assert.False(t, status.Running)
+	})
+
+	t.Run("Stops at the first failing batch and reports the error", func(t *testing.T) {
+		repo := &MockBlacklistRepository{}
+		repo.On("PurgeExpired", 100).Return(int64(100), nil).Once()
+		repo.On("PurgeExpired", 100).Return(int64(0), errors.New("db error")).Once()
+
+		reaper := NewBlacklistReaper(repo, time.Hour, 100)
+		deleted, err := reaper.RunOnce()
+
+		assert.Error(t, err)
+		assert.Equal(t, int64(100), deleted)
+		repo.AssertExpectations(t)
+
+		status := reaper.Status()
+		assert.Equal(t, int64(100), status.LastDeleted)
+		assert.Equal(t, "db error", status.LastError)
+	})
+}
+
+func TestBlacklistReaper_StartStop(t *testing.T) {
+	repo := &MockBlacklistRepository{}
+	repo.On("PurgeExpired", 100).Return(int64(0), nil)
+
+	reaper := NewBlacklistReaper(repo, 10*time.Millisecond, 100)
+	reaper.Start()
+	assert.True(t, reaper.Status().Running)
+
+	// The reaper runs on its interval
+	assert.Eventually(t, func() bool {
+		return reaper.Status().LastRunAt != nil
+	}, time.Second, 5*time.Millisecond)
+
+	reaper.Stop()
+	assert.False(t, reaper.Status().Running)
+
+	// No run happens after Stop returns, and stopping twice is harmless
+	calls := len(repo.Calls)
+	time.Sleep(30 * time.Millisecond)
+	assert.Len(t, repo.Calls, calls)
+	reaper.Stop()
+}
+
+func TestBlacklistReaper_DisabledWithoutInterval(t *testing.T) {
+	repo := &MockBlacklistRepository{}
+	reaper := NewBlacklistReaper(repo, 0, 100)
+
+	reaper.Start()
+	assert.False(t, reaper.Status().Running)
+	reaper.Stop()
+
+	repo.AssertNotCalled(t, "PurgeExpired", 100)
+}
@@ -1,17 +1,29 @@
 package auth
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
 	"tt-stock-api/internal/db"
+	"tt-stock-api/pkg/utils"
 )
 
 // BlacklistRepository defines the interface for token blacklist operations
+// Tokens are stored as SHA-256 digests, never in plain text
 type BlacklistRepository interface {
 	BlacklistToken(token, userID, tokenType string, expiresAt time.Time) error
 	IsTokenBlacklisted(token string) (bool, error)
+	ConsumeRefreshToken(token, userID string, expiresAt time.Time, rotate func() ([]byte, error)) (*RefreshTokenUse, error)
+	PurgeExpired(batchSize int) (int64, error)
+}
+
+// RefreshTokenUse describes the outcome of consuming a refresh token
+type RefreshTokenUse struct {
+	Consumed   bool      // True if this call consumed the token, false if it had already been used
+	Successor  []byte    // Sealed token pair issued by the call that consumed the token, if any
+	ConsumedAt time.Time // When the token was first consumed
 }
 
 // blacklistRepository implements the BlacklistRepository interface
@@ -39,12 +51,13 @@ func (r *blacklistRepository) BlacklistToken(token, userID, tokenType string, ex
 	}
 
 	query := `
-		INSERT INTO token_blacklist (token, user_id, token_type, expires_at, blacklisted_at)
+		INSERT INTO token_blacklist (token_hash, user_id, token_type, expires_at, blacklisted_at)
 		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (token_hash) DO NOTHING
 	`
 
 	now := time.Now()
-	_, err := r.db.Exec(query, token, userID, tokenType, expiresAt, now)
+	_, err := r.db.Exec(query, utils.HashToken(token), userID, tokenType, expiresAt, now)
 	if err != nil {
 		return fmt.Errorf("failed to blacklist token: %w", err)
 	}
@@ -61,15 +74,137 @@ func (r *blacklistRepository) IsTokenBlacklisted(token string) (bool, error) {
 	query := `
 		SELECT EXISTS(
 			SELECT 1 FROM token_blacklist 
-			WHERE token = $1 AND expires_at > NOW()
+			WHERE token_hash = $1 AND expires_at > NOW()
 		)
 	`
 
 	var exists bool
-	err := r.db.QueryRow(query, token).Scan(&exists)
+	err := r.db.QueryRow(query, utils.HashToken(token)).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check token blacklist status: %w", err)
 	}
 
 	return exists, nil
-}
\ No newline at end of file
+}
+
+// ConsumeRefreshToken atomically marks a refresh token as used
+// Only one caller can consume a given token: the insert relies on the unique token hash index,
+// so a concurrent caller blocks until the first transaction finishes and then sees the
+// token as already consumed. The consuming caller's rotate function runs inside the
+// transaction and its result is stored as the token's successor, so duplicates can be
+// answered with the same token pair.
+func (r *blacklistRepository) ConsumeRefreshToken(token, userID string, expiresAt time.Time, rotate func() ([]byte, error)) (*RefreshTokenUse, error) {
+	if token == "" {
+		return nil, errors.New("token cannot be empty")
+	}
+	if userID == "" {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+		INSERT INTO token_blacklist (token_hash, user_id, token_type, expires_at, blacklisted_at)
+		VALUES ($1, $2, 'refresh', $3, $4)
+		ON CONFLICT (token_hash) DO NOTHING
+	`
+
+	tokenHash := utils.HashToken(token)
+	now := time.Now()
+	result, err := tx.Exec(insertQuery, tokenHash, userID, expiresAt, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	// Someone else already consumed the token; report what they left behind
+	if rowsAffected == 0 {
+		if err := tx.Rollback(); err != nil {
+			return nil, fmt.Errorf("failed to roll back transaction: %w", err)
+		}
+		return r.findRefreshTokenUse(tokenHash)
+	}
+
+	successor, err := rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	updateQuery := `UPDATE token_blacklist SET successor = $1 WHERE token_hash = $2`
+	if _, err := tx.Exec(updateQuery, successor, tokenHash); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token successor: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &RefreshTokenUse{
+		Consumed:   true,
+		Successor:  successor,
+		ConsumedAt: now,
+	}, nil
+}
+
+// findRefreshTokenUse loads the consumption record of an already used token
+func (r *blacklistRepository) findRefreshTokenUse(tokenHash string) (*RefreshTokenUse, error) {
+	query := `
+		SELECT successor, blacklisted_at
+		FROM token_blacklist
+		WHERE token_hash = $1
+	`
+
+	var use RefreshTokenUse
+	var successor []byte
+	var blacklistedAt sql.NullTime
+
+	err := r.db.QueryRow(query, tokenHash).Scan(&successor, &blacklistedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load refresh token use: %w", err)
+	}
+
+	use.Successor = successor
+	if blacklistedAt.Valid {
+		use.ConsumedAt = blacklistedAt.Time
+	}
+
+	return &use, nil
+}
+
+// PurgeExpired deletes up to batchSize blacklist entries whose token has expired
+// Expired tokens are rejected on their own, so their entries are no longer needed
+// Returns the number of deleted rows; callers repeat until it is below batchSize
+func (r *blacklistRepository) PurgeExpired(batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		return 0, errors.New("batch size must be positive")
+	}
+
+	query := `
+		DELETE FROM token_blacklist
+		WHERE id IN (
+			SELECT id FROM token_blacklist
+			WHERE expires_at <= NOW()
+			LIMIT $1
+		)
+	`
+
+	result, err := r.db.Exec(query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired tokens: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tt-stock-api/internal/db"
+	"tt-stock-api/pkg/utils"
+)
+
+func TestBlacklistRepository_ConsumeRefreshToken(t *testing.T) {
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+	expiresAt := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	consumedAt := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tokenHash := utils.HashToken("refresh-token")
+
+	tests := []struct {
+		name          string
+		token         string
+		setupMock     func(mock sqlmock.Sqlmock)
+		rotate        func() ([]byte, error)
+		expected      *RefreshTokenUse
+		expectRotated bool
+		expectError   bool
+		errorMsg      string
+	}{
+		{
+			name:  "first use consumes the token and stores the successor",
+			token: "refresh-token",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO token_blacklist`).
+					WithArgs(tokenHash, userID, expiresAt, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE token_blacklist SET successor = \$1 WHERE token_hash = \$2`).
+					WithArgs([]byte("sealed-pair"), tokenHash).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			rotate:        func() ([]byte, error) { return []byte("sealed-pair"), nil },
+			expected:      &RefreshTokenUse{Consumed: true, Successor: []byte("sealed-pair")},
+			expectRotated: true,
+		},
+		{
+			name:  "already consumed token returns the stored successor",
+			token: "refresh-token",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO token_blacklist`).
+					WithArgs(tokenHash, userID, expiresAt, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+				rows := sqlmock.NewRows([]string{"successor", "blacklisted_at"}).
+					AddRow([]byte("sealed-pair"), consumedAt)
+				mock.ExpectQuery(`SELECT successor, blacklisted_at FROM token_blacklist WHERE token_hash = \$1`).
+					WithArgs(tokenHash).
+					WillReturnRows(rows)
+			},
+			rotate:   func() ([]byte, error) { return []byte("unexpected"), nil },
+			expected: &RefreshTokenUse{Successor: []byte("sealed-pair"), ConsumedAt: consumedAt},
+		},
+		{
+			name:  "rotation failure rolls back the consumption",
+			token: "refresh-token",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO token_blacklist`).
+					WithArgs(tokenHash, userID, expiresAt, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectRollback()
+			},
+			rotate:        func() ([]byte, error) { return nil, errors.New("rotation failed") },
+			expectRotated: true,
+			expectError:   true,
+			errorMsg:      "rotation failed",
+		},
+		{
+			name:  "database error",
+			token: "refresh-token",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO token_blacklist`).
+					WithArgs(tokenHash, userID, expiresAt, sqlmock.AnyArg()).
+					WillReturnError(errors.New("database connection error"))
+				mock.ExpectRollback()
+			},
+			rotate:      func() ([]byte, error) { return []byte("unexpected"), nil },
+			expectError: true,
+			errorMsg:    "failed to consume refresh token",
+		},
+		{
+			name:  "empty token",
+			token: "",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				// No mock setup needed as validation happens before query
+			},
+			rotate:      func() ([]byte, error) { return []byte("unexpected"), nil },
+			expectError: true,
+			errorMsg:    "token cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create mock database
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			// Setup mock expectations
+			tt.setupMock(mock)
+
+			// Create repository with mock database
+			repo := NewBlacklistRepository(&db.DB{DB: mockDB})
+
+			rotated := false
+			rotate := func() ([]byte, error) {
+				rotated = true
+				return tt.rotate()
+			}
+
+			// Execute the method
+			result, err := repo.ConsumeRefreshToken(tt.token, userID, expiresAt, rotate)
+
+			// Verify results
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				assert.Nil(t, result)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected.Consumed, result.Consumed)
+				assert.Equal(t, tt.expected.Successor, result.Successor)
+				if !tt.expected.Consumed {
+					assert.Equal(t, tt.expected.ConsumedAt, result.ConsumedAt)
+				}
+			}
+			assert.Equal(t, tt.expectRotated, rotated)
+
+			// Verify all expectations were met
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestBlacklistRepository_StoresTokenHash(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+	expiresAt := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tokenHash := utils.HashToken("access-token")
+
+	// Only the digest reaches the database, never the token itself
+	mock.ExpectExec(`INSERT INTO token_blacklist \(token_hash, user_id, token_type, expires_at, blacklisted_at\)`).
+		WithArgs(tokenHash, userID, "access", expiresAt, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs(tokenHash).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	repo := NewBlacklistRepository(&db.DB{DB: mockDB})
+
+	require.NoError(t, repo.BlacklistToken("access-token", userID, "access", expiresAt))
+	blacklisted, err := repo.IsTokenBlacklisted("access-token")
+	assert.NoError(t, err)
+	assert.True(t, blacklisted)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBlacklistRepository_PurgeExpired(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectExec(`DELETE FROM token_blacklist WHERE id IN \( SELECT id FROM token_blacklist WHERE expires_at <= NOW\(\) LIMIT \$1 \)`).
+		WithArgs(500).
+		WillReturnResult(sqlmock.NewResult(0, 37))
+
+	repo := NewBlacklistRepository(&db.DB{DB: mockDB})
+
+	deleted, err := repo.PurgeExpired(500)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(37), deleted)
+
+	// A batch size is required so a purge never deletes an unbounded number of rows
+	_, err = repo.PurgeExpired(0)
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
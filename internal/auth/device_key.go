@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Supported device key algorithms
+const (
+	DeviceKeyAlgorithmES256 = "ES256" // ECDSA on P-256 with SHA-256
+	DeviceKeyAlgorithmEdDSA = "EdDSA" // Ed25519
+)
+
+// deviceKeyNonceBytes is the number of random bytes in a device key login challenge
+const deviceKeyNonceBytes = 32
+
+// parseDevicePublicKey decodes a base64 PKIX public key and checks that it matches the algorithm
+// Returns the DER encoding to store
+func parseDevicePublicKey(algorithm, encoded string) ([]byte, error) {
+	der, err := decodeBase64(encoded)
+	if err != nil || len(der) == 0 {
+		return nil, &ValidationError{Field: "public_key", Message: "public key must be a base64 encoded PKIX (SubjectPublicKeyInfo) key"}
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, &ValidationError{Field: "public_key", Message: "public key must be a base64 encoded PKIX (SubjectPublicKeyInfo) key"}
+	}
+
+	switch algorithm {
+	case DeviceKeyAlgorithmES256:
+		ecKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return nil, &ValidationError{Field: "public_key", Message: "ES256 requires an ECDSA P-256 public key"}
+		}
+	case DeviceKeyAlgorithmEdDSA:
+		if _, ok := publicKey.(ed25519.PublicKey); !ok {
+			return nil, &ValidationError{Field: "public_key", Message: "EdDSA requires an Ed25519 public key"}
+		}
+	default:
+		return nil, &ValidationError{Field: "algorithm", Message: "algorithm must be ES256 or EdDSA"}
+	}
+
+	return der, nil
+}
+
+// verifyDeviceKeySignature reports whether signature is a valid signature of message by the device key
+// ES256 signatures may be ASN.1 DER encoded, as produced by iOS and Android, or raw r || s
+func verifyDeviceKeySignature(key *DeviceKey, message, signature []byte) bool {
+	publicKey, err := x509.ParsePKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return false
+	}
+
+	switch key.Algorithm {
+	case DeviceKeyAlgorithmES256:
+		ecKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(message)
+		if len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if ecdsa.Verify(ecKey, digest[:], r, s) {
+				return true
+			}
+		}
+		return ecdsa.VerifyASN1(ecKey, digest[:], signature)
+	case DeviceKeyAlgorithmEdDSA:
+		edKey, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(edKey, message, signature)
+	default:
+		return false
+	}
+}
+
+// newDeviceKeyNonce generates a random, URL-safe login challenge nonce
+func newDeviceKeyNonce() (string, error) {
+	nonce := make([]byte, deviceKeyNonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// decodeBase64 decodes standard or URL-safe base64, with or without padding
+func decodeBase64(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, errors.New("empty base64 value")
+	}
+
+	encoding := base64.RawStdEncoding
+	if strings.ContainsAny(encoded, "-_") {
+		encoding = base64.RawURLEncoding
+	}
+	return encoding.DecodeString(strings.TrimRight(encoded, "="))
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// DeviceKeyRepository defines the interface for device key and login challenge storage
+type DeviceKeyRepository interface {
+	CreateDeviceKey(key *DeviceKey) error
+	FindDeviceKey(id uuid.UUID) (*DeviceKey, error)
+	ListDeviceKeys(userID uuid.UUID) ([]DeviceKey, error)
+	RevokeDeviceKey(userID, id uuid.UUID) (bool, error)
+	RevokeDeviceKeysForDevice(userID uuid.UUID, deviceID string) error
+	TouchDeviceKey(id uuid.UUID) error
+	SaveChallenge(challenge *DeviceKeyChallenge) error
+	ConsumeChallenge(keyID uuid.UUID) (*DeviceKeyChallenge, error)
+}
+
+// deviceKeyRepository implements the DeviceKeyRepository interface
+type deviceKeyRepository struct {
+	db *db.DB
+}
+
+// NewDeviceKeyRepository creates a new device key repository instance
+func NewDeviceKeyRepository(database *db.DB) DeviceKeyRepository {
+	return &deviceKeyRepository{
+		db: database,
+	}
+}
+
+// CreateDeviceKey stores a newly registered device key
+func (r *deviceKeyRepository) CreateDeviceKey(key *DeviceKey) error {
+	if key == nil || key.UserID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if len(key.PublicKey) == 0 {
+		return errors.New("public key cannot be empty")
+	}
+
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO device_keys (id, user_id, device_id, name, algorithm, public_key, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := r.db.Exec(query, key.ID, key.UserID, key.DeviceID, key.Name, key.Algorithm, key.PublicKey, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create device key: %w", err)
+	}
+
+	return nil
+}
+
+// FindDeviceKey returns a device key by ID, or nil if there is none
+// Revoked keys are returned as well; callers decide whether a key is usable
+func (r *deviceKeyRepository) FindDeviceKey(id uuid.UUID) (*DeviceKey, error) {
+	if id == uuid.Nil {
+		return nil, errors.New("device key ID cannot be empty")
+	}
+
+	query := `
+		SELECT id, user_id, device_id, name, algorithm, public_key, created_at, last_used_at, revoked_at
+		FROM device_keys
+		WHERE id = $1
+	`
+
+	key, err := scanDeviceKey(r.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query device key: %w", err)
+	}
+
+	return key, nil
+}
+
+// ListDeviceKeys returns the unrevoked device keys of a user, newest first
+func (r *deviceKeyRepository) ListDeviceKeys(userID uuid.UUID) ([]DeviceKey, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		SELECT id, user_id, device_id, name, algorithm, public_key, created_at, last_used_at, revoked_at
+		FROM device_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list device keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []DeviceKey{}
+	for rows.Next() {
+		key, err := scanDeviceKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list device keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeDeviceKey revokes one of the user's device keys and reports whether it was active
+func (r *deviceKeyRepository) RevokeDeviceKey(userID, id uuid.UUID) (bool, error) {
+	if userID == uuid.Nil || id == uuid.Nil {
+		return false, errors.New("user ID and device key ID cannot be empty")
+	}
+
+	query := `
+		UPDATE device_keys
+		SET revoked_at = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke device key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// RevokeDeviceKeysForDevice revokes every active key the user registered from a device
+func (r *deviceKeyRepository) RevokeDeviceKeysForDevice(userID uuid.UUID, deviceID string) error {
+	if userID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		UPDATE device_keys
+		SET revoked_at = $1
+		WHERE user_id = $2 AND device_id = $3 AND revoked_at IS NULL
+	`
+
+	if _, err := r.db.Exec(query, time.Now(), userID, deviceID); err != nil {
+		return fmt.Errorf("failed to revoke device keys: %w", err)
+	}
+
+	return nil
+}
+
+// TouchDeviceKey records that a device key has just been used to log in
+func (r *deviceKeyRepository) TouchDeviceKey(id uuid.UUID) error {
+	if _, err := r.db.Exec(`UPDATE device_keys SET last_used_at = $1 WHERE id = $2`, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to update device key: %w", err)
+	}
+
+	return nil
+}
+
+// SaveChallenge stores a login challenge, replacing any earlier challenge for the same key
+func (r *deviceKeyRepository) SaveChallenge(challenge *DeviceKeyChallenge) error {
+	if challenge == nil || challenge.KeyID == uuid.Nil {
+		return errors.New("device key ID cannot be empty")
+	}
+	if challenge.Nonce == "" {
+		return errors.New("nonce cannot be empty")
+	}
+
+	query := `
+		INSERT INTO device_key_challenges (key_id, nonce, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key_id) DO UPDATE
+		SET nonce = EXCLUDED.nonce,
+			expires_at = EXCLUDED.expires_at,
+			created_at = EXCLUDED.created_at
+	`
+
+	_, err := r.db.Exec(query, challenge.KeyID, challenge.Nonce, challenge.ExpiresAt, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save device key challenge: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeChallenge removes and returns the challenge of a key, or nil if there is none
+// A challenge can only be consumed once, even by concurrent requests
+func (r *deviceKeyRepository) ConsumeChallenge(keyID uuid.UUID) (*DeviceKeyChallenge, error) {
+	if keyID == uuid.Nil {
+		return nil, errors.New("device key ID cannot be empty")
+	}
+
+	query := `
+		DELETE FROM device_key_challenges
+		WHERE key_id = $1
+		RETURNING key_id, nonce, expires_at
+	`
+
+	var challenge DeviceKeyChallenge
+	err := r.db.QueryRow(query, keyID).Scan(&challenge.KeyID, &challenge.Nonce, &challenge.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume device key challenge: %w", err)
+	}
+
+	return &challenge, nil
+}
+
+// scanDeviceKey scans a device key row produced by the device key queries
+func scanDeviceKey(row interface{ Scan(dest ...any) error }) (*DeviceKey, error) {
+	var key DeviceKey
+	var lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.DeviceID,
+		&key.Name,
+		&key.Algorithm,
+		&key.PublicKey,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodePublicKey returns the base64 PKIX encoding of a public key, as clients send it
+func encodePublicKey(t *testing.T, publicKey any) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestParseDevicePublicKey(t *testing.T) {
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		algorithm     string
+		publicKey     string
+		expectedField string
+	}{
+		{name: "ECDSA P-256", algorithm: DeviceKeyAlgorithmES256, publicKey: encodePublicKey(t, &p256Key.PublicKey)},
+		{name: "Ed25519", algorithm: DeviceKeyAlgorithmEdDSA, publicKey: encodePublicKey(t, edPublic)},
+		{name: "URL-safe base64", algorithm: DeviceKeyAlgorithmEdDSA, publicKey: base64.RawURLEncoding.EncodeToString(mustDecode(t, encodePublicKey(t, edPublic)))},
+		{name: "Other curve", algorithm: DeviceKeyAlgorithmES256, publicKey: encodePublicKey(t, &p384Key.PublicKey), expectedField: "public_key"},
+		{name: "Key does not match the algorithm", algorithm: DeviceKeyAlgorithmEdDSA, publicKey: encodePublicKey(t, &p256Key.PublicKey), expectedField: "public_key"},
+		{name: "Unsupported algorithm", algorithm: "RS256", publicKey: encodePublicKey(t, edPublic), expectedField: "algorithm"},
+		{name: "Not base64", algorithm: DeviceKeyAlgorithmEdDSA, publicKey: "not a key!", expectedField: "public_key"},
+		{name: "Not a PKIX key", algorithm: DeviceKeyAlgorithmEdDSA, publicKey: base64.StdEncoding.EncodeToString(edPublic), expectedField: "public_key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := parseDevicePublicKey(tt.algorithm, tt.publicKey)
+
+			if tt.expectedField != "" {
+				var validationErr *ValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, tt.expectedField, validationErr.Field)
+				assert.Nil(t, der)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, der)
+			}
+		})
+	}
+}
+
+func TestVerifyDeviceKeySignature(t *testing.T) {
+	message := []byte("nonce-to-sign")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+	ecDeviceKey := &DeviceKey{Algorithm: DeviceKeyAlgorithmES256, PublicKey: ecDER}
+
+	digest := sha256.Sum256(message)
+	asn1Signature, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	require.NoError(t, err)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	require.NoError(t, err)
+	rawSignature := make([]byte, 64)
+	r.FillBytes(rawSignature[:32])
+	s.FillBytes(rawSignature[32:])
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKIXPublicKey(edPublic)
+	require.NoError(t, err)
+	edDeviceKey := &DeviceKey{Algorithm: DeviceKeyAlgorithmEdDSA, PublicKey: edDER}
+	edSignature := ed25519.Sign(edPrivate, message)
+
+	assert.True(t, verifyDeviceKeySignature(ecDeviceKey, message, asn1Signature), "ASN.1 ECDSA signature")
+	assert.True(t, verifyDeviceKeySignature(ecDeviceKey, message, rawSignature), "raw ECDSA signature")
+	assert.True(t, verifyDeviceKeySignature(edDeviceKey, message, edSignature), "Ed25519 signature")
+
+	assert.False(t, verifyDeviceKeySignature(ecDeviceKey, []byte("other nonce"), asn1Signature), "ECDSA signature of another message")
+	assert.False(t, verifyDeviceKeySignature(edDeviceKey, []byte("other nonce"), edSignature), "Ed25519 signature of another message")
+	assert.False(t, verifyDeviceKeySignature(edDeviceKey, message, asn1Signature), "signature by another key")
+	assert.False(t, verifyDeviceKeySignature(&DeviceKey{Algorithm: DeviceKeyAlgorithmEdDSA, PublicKey: ecDER}, message, asn1Signature), "key of another algorithm")
+}
+
+func TestNewDeviceKeyNonce(t *testing.T) {
+	first, err := newDeviceKeyNonce()
+	require.NoError(t, err)
+	second, err := newDeviceKeyNonce()
+	require.NoError(t, err)
+
+	assert.Len(t, first, 43)
+	assert.NotEqual(t, first, second)
+}
+
+// mustDecode decodes standard base64 or fails the test
+func mustDecode(t *testing.T, encoded string) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	return decoded
+}
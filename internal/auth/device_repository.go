@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// DeviceRepository defines the interface for user and shop device storage
+// User devices are recorded on login; shop devices are registered by owners
+type DeviceRepository interface {
+	RecordDevice(device *Device) error
+	ListDevices(userID uuid.UUID) ([]Device, error)
+	DeleteDevice(userID, id uuid.UUID) (*Device, error)
+	RegisterShopDevice(device *ShopDevice) error
+	ListShopDevices() ([]ShopDevice, error)
+	DeleteShopDevice(deviceID string) (bool, error)
+	IsShopDevice(deviceID string) (bool, error)
+}
+
+// deviceRepository implements the DeviceRepository interface
+type deviceRepository struct {
+	db *db.DB
+}
+
+// NewDeviceRepository creates a new device repository instance
+func NewDeviceRepository(database *db.DB) DeviceRepository {
+	return &deviceRepository{
+		db: database,
+	}
+}
+
+// RecordDevice adds a device to the user's devices or, if it is already known, updates
+// its name, IP address and last use. The stored ID and creation time are set on device.
+func (r *deviceRepository) RecordDevice(device *Device) error {
+	if device == nil || device.UserID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if device.DeviceID == "" {
+		return errors.New("device ID cannot be empty")
+	}
+
+	now := time.Now()
+	query := `
+		INSERT INTO devices (id, user_id, device_id, name, last_ip, created_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (user_id, device_id) DO UPDATE
+		SET name = COALESCE(NULLIF(EXCLUDED.name, ''), devices.name),
+			last_ip = EXCLUDED.last_ip,
+			last_seen_at = EXCLUDED.last_seen_at
+		RETURNING id, name, created_at
+	`
+
+	err := r.db.QueryRow(query, uuid.New(), device.UserID, device.DeviceID, device.Name, device.LastIP, now).
+		Scan(&device.ID, &device.Name, &device.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record device: %w", err)
+	}
+
+	device.LastSeenAt = now
+	return nil
+}
+
+// ListDevices returns the devices of a user, most recently used first
+func (r *deviceRepository) ListDevices(userID uuid.UUID) ([]Device, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		SELECT id, user_id, device_id, name, last_ip, created_at, last_seen_at
+		FROM devices
+		WHERE user_id = $1
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		devices = append(devices, *device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// DeleteDevice removes one of the user's devices and returns it
+// Returns nil without an error if the user has no device with that ID
+func (r *deviceRepository) DeleteDevice(userID, id uuid.UUID) (*Device, error) {
+	if userID == uuid.Nil || id == uuid.Nil {
+		return nil, errors.New("user ID and device ID cannot be empty")
+	}
+
+	query := `
+		DELETE FROM devices
+		WHERE id = $1 AND user_id = $2
+		RETURNING id, user_id, device_id, name, last_ip, created_at, last_seen_at
+	`
+
+	device, err := scanDevice(r.db.QueryRow(query, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to delete device: %w", err)
+	}
+
+	return device, nil
+}
+
+// scanDevice scans a device row produced by the device queries
+func scanDevice(row interface{ Scan(dest ...any) error }) (*Device, error) {
+	var device Device
+
+	err := row.Scan(
+		&device.ID,
+		&device.UserID,
+		&device.DeviceID,
+		&device.Name,
+		&device.LastIP,
+		&device.CreatedAt,
+		&device.LastSeenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// RegisterShopDevice registers a device as belonging to the shop, or renames it if it already is
+func (r *deviceRepository) RegisterShopDevice(device *ShopDevice) error {
+	if device == nil || device.DeviceID == "" {
+		return errors.New("device ID cannot be empty")
+	}
+
+	query := `
+		INSERT INTO shop_devices (device_id, name, registered_by, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id) DO UPDATE
+		SET name = EXCLUDED.name
+		RETURNING registered_by, created_at
+	`
+
+	var registeredBy uuid.NullUUID
+	err := r.db.QueryRow(query, device.DeviceID, device.Name, device.RegisteredBy, time.Now()).
+		Scan(&registeredBy, &device.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to register shop device: %w", err)
+	}
+
+	device.RegisteredBy = registeredBy.UUID
+	return nil
+}
+
+// ListShopDevices returns all registered shop devices, most recently registered first
+func (r *deviceRepository) ListShopDevices() ([]ShopDevice, error) {
+	query := `
+		SELECT device_id, name, registered_by, created_at
+		FROM shop_devices
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shop devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []ShopDevice{}
+	for rows.Next() {
+		var device ShopDevice
+		var registeredBy uuid.NullUUID
+		if err := rows.Scan(&device.DeviceID, &device.Name, &registeredBy, &device.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan shop device: %w", err)
+		}
+		device.RegisteredBy = registeredBy.UUID
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list shop devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// DeleteShopDevice removes a shop device and reports whether it was registered
+func (r *deviceRepository) DeleteShopDevice(deviceID string) (bool, error) {
+	if deviceID == "" {
+		return false, errors.New("device ID cannot be empty")
+	}
+
+	result, err := r.db.Exec(`DELETE FROM shop_devices WHERE device_id = $1`, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete shop device: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// IsShopDevice reports whether a device is registered as a shop device
+func (r *deviceRepository) IsShopDevice(deviceID string) (bool, error) {
+	if deviceID == "" {
+		return false, nil
+	}
+
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM shop_devices WHERE device_id = $1)`, deviceID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check shop device: %w", err)
+	}
+
+	return exists, nil
+}
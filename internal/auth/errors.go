@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AccountLockedError is returned when a phone number has been locked after too many failed PIN attempts
+type AccountLockedError struct {
+	RetryAfter time.Duration // Time remaining until the lock is lifted
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("account is locked due to too many failed attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// TooManyAttemptsError is returned when a login attempt arrives before the progressive delay has passed
+// or when the client IP has been blocked
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration // Time remaining until the next attempt is accepted
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many login attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// Authentication errors
+// Handlers and middleware map these to stable response error codes with errors.Is,
+// so their messages can change without breaking clients
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrTokenExpired       = errors.New("token has expired")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrWrongTokenType     = errors.New("invalid token type")
+)
+
+// ErrValidation matches every *ValidationError with errors.Is
+var ErrValidation = errors.New("validation failed")
+
+// ValidationError is returned when a request field has an invalid value
+type ValidationError struct {
+	Field   string // JSON name of the offending field, e.g. "phone_number"
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is ErrValidation
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Refresh token rotation errors
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrSessionExpired      = errors.New("session has reached its maximum lifetime")
+)
+
+// Session registry errors
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Device errors
+var (
+	ErrDeviceNotAllowed = errors.New("device is not registered for this shop")
+	ErrDeviceMismatch   = errors.New("token is bound to another device")
+	ErrDeviceNotFound   = errors.New("device not found")
+)
+
+// Account status errors
+var (
+	ErrAccountSuspended = errors.New("account has been suspended")
+	ErrAccountLocked    = errors.New("account has been locked by an administrator")
+)
+
+// User administration errors
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrPhoneNumberTaken = errors.New("phone number is already registered")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrOwnAccount       = errors.New("you cannot change the status of or delete your own account")
+)
+
+// Login alert errors
+var (
+	ErrLoginAlertNotFound = errors.New("login alert not found")
+)
+
+// Device key errors
+var (
+	ErrInvalidDeviceKey  = errors.New("invalid device key or signature")
+	ErrDeviceKeyNotFound = errors.New("device key not found")
+)
+
+// One-time code errors
+var (
+	ErrInvalidOTP          = errors.New("invalid or expired verification code")
+	ErrOTPAttemptsExceeded = errors.New("too many attempts for this verification code")
+)
+
+// Service account errors
+var (
+	ErrServiceAccountNotFound  = errors.New("service account not found")
+	ErrServiceAccountNameTaken = errors.New("service account name is already taken")
+	ErrAPIKeyNotFound          = errors.New("API key not found")
+	ErrInvalidAPIKey           = errors.New("invalid API key")
+	ErrAPIKeyExpired           = errors.New("API key has expired")
+)
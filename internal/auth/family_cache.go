@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// familyCacheMaxEntries bounds the memory used by the token family cache
+// When the cache is full, expired entries are dropped; if that is not enough it starts over empty
+const familyCacheMaxEntries = 100000
+
+// revokedFamilyCacheTTL is how long a revoked family is cached; revocations are final, so this
+// only bounds how long unused entries are kept
+const revokedFamilyCacheTTL = time.Hour
+
+// familyCacheEntry is a cached family revocation lookup result
+type familyCacheEntry struct {
+	revoked   bool
+	expiresAt time.Time
+}
+
+// cachedTokenFamilyRepository keeps recent family revocation lookups in memory so that checking
+// the session of a token on every protected request does not need a database round trip
+type cachedTokenFamilyRepository struct {
+	repo TokenFamilyRepository
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[uuid.UUID]familyCacheEntry
+}
+
+// NewCachedTokenFamilyRepository wraps a token family repository with an in-memory lookup cache
+// A "not revoked" answer is reused for at most ttl, which bounds how long a session ended by
+// another instance is still accepted here. Families revoked through this instance are cached as
+// revoked right away, so its own revocations take effect immediately.
+// A ttl of zero or less disables caching.
+func NewCachedTokenFamilyRepository(repo TokenFamilyRepository, ttl time.Duration) TokenFamilyRepository {
+	if ttl <= 0 {
+		return repo
+	}
+
+	return &cachedTokenFamilyRepository{
+		repo:    repo,
+		ttl:     ttl,
+		entries: map[uuid.UUID]familyCacheEntry{},
+	}
+}
+
+// CreateFamily starts a new token family and caches it as not revoked
+func (r *cachedTokenFamilyRepository) CreateFamily(userID uuid.UUID) (uuid.UUID, error) {
+	familyID, err := r.repo.CreateFamily(userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	r.store(familyID, false, time.Now().Add(r.ttl))
+	return familyID, nil
+}
+
+// IsFamilyRevoked answers from the cache when possible and caches the database answer otherwise
+func (r *cachedTokenFamilyRepository) IsFamilyRevoked(familyID uuid.UUID) (bool, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	entry, ok := r.entries[familyID]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := r.repo.IsFamilyRevoked(familyID)
+	if err != nil {
+		return false, err
+	}
+
+	expiresAt := now.Add(r.ttl)
+	if revoked {
+		expiresAt = now.Add(revokedFamilyCacheTTL)
+	}
+	r.store(familyID, revoked, expiresAt)
+	return revoked, nil
+}
+
+// RevokeFamily revokes a token family and caches it as revoked
+func (r *cachedTokenFamilyRepository) RevokeFamily(familyID uuid.UUID, reason string) error {
+	if err := r.repo.RevokeFamily(familyID, reason); err != nil {
+		return err
+	}
+
+	r.store(familyID, true, time.Now().Add(revokedFamilyCacheTTL))
+	return nil
+}
+
+// store caches a lookup result, making room first if the cache is full
+func (r *cachedTokenFamilyRepository) store(familyID uuid.UUID, revoked bool, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	// A lookup that started before a local revocation must not overwrite it
+	if existing, ok := r.entries[familyID]; ok && existing.revoked && !revoked && now.Before(existing.expiresAt) {
+		return
+	}
+
+	if len(r.entries) >= familyCacheMaxEntries {
+		for key, entry := range r.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(r.entries, key)
+			}
+		}
+		if len(r.entries) >= familyCacheMaxEntries {
+			// Every entry can be reloaded from the database, so dropping them is always safe
+			r.entries = map[uuid.UUID]familyCacheEntry{}
+		}
+	}
+
+	r.entries[familyID] = familyCacheEntry{
+		revoked:   revoked,
+		expiresAt: expiresAt,
+	}
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCachedTokenFamilyRepository_Disabled(t *testing.T) {
+	repo := &MockTokenFamilyRepository{}
+
+	// Without a TTL the repository is used as is
+	assert.Same(t, repo, NewCachedTokenFamilyRepository(repo, 0))
+}
+
+func TestCachedTokenFamilyRepository_IsFamilyRevoked(t *testing.T) {
+	repo := &MockTokenFamilyRepository{}
+	cached := NewCachedTokenFamilyRepository(repo, time.Minute).(*cachedTokenFamilyRepository)
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	otherFamilyID := uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+
+	// Only the first lookup reaches the database
+	repo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+	for i := 0; i < 3; i++ {
+		revoked, err := cached.IsFamilyRevoked(familyID)
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	}
+	repo.AssertExpectations(t)
+
+	// Once the entry expires, a revocation made by another instance is seen
+	entry := cached.entries[familyID]
+	entry.expiresAt = time.Now().Add(-time.Second)
+	cached.entries[familyID] = entry
+	repo.On("IsFamilyRevoked", familyID).Return(true, nil).Once()
+	revoked, err := cached.IsFamilyRevoked(familyID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	repo.AssertExpectations(t)
+
+	// Errors are not cached
+	repo.On("IsFamilyRevoked", otherFamilyID).Return(false, errors.New("db error")).Once()
+	_, err = cached.IsFamilyRevoked(otherFamilyID)
+	assert.Error(t, err)
+	repo.On("IsFamilyRevoked", otherFamilyID).Return(false, nil).Once()
+	revoked, err = cached.IsFamilyRevoked(otherFamilyID)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+	repo.AssertExpectations(t)
+}
+
+func TestCachedTokenFamilyRepository_LocalRevocationIsImmediate(t *testing.T) {
+	repo := &MockTokenFamilyRepository{}
+	cached := NewCachedTokenFamilyRepository(repo, time.Minute)
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	// A new family is known to be active without a database lookup
+	repo.On("CreateFamily", userID).Return(familyID, nil).Once()
+	created, err := cached.CreateFamily(userID)
+	require.NoError(t, err)
+	assert.Equal(t, familyID, created)
+
+	revoked, err := cached.IsFamilyRevoked(familyID)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	// Revoking it through this instance takes effect at once
+	repo.On("RevokeFamily", familyID, RevokedReasonLogout).Return(nil).Once()
+	require.NoError(t, cached.RevokeFamily(familyID, RevokedReasonLogout))
+
+	revoked, err = cached.IsFamilyRevoked(familyID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	repo.AssertExpectations(t)
+	repo.AssertNotCalled(t, "IsFamilyRevoked", familyID)
+}
@@ -1,9 +1,16 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"tt-stock-api/internal/phone"
+	"tt-stock-api/internal/user"
 	"tt-stock-api/pkg/response"
 )
 
@@ -11,6 +18,47 @@ import (
 type LoginRequest struct {
 	PhoneNumber string `json:"phone_number" validate:"required"`
 	Pin         string `json:"pin" validate:"required"`
+	DeviceID    string `json:"device_id"`   // Optional stable device ID; defaults to the X-Device-ID header
+	DeviceName  string `json:"device_name"` // Optional label shown in the session list
+	ClientType  string `json:"client_type"` // Optional client kind, e.g. "pos" or "mobile"
+}
+
+// clientTypePattern restricts client types to short lowercase identifiers
+var clientTypePattern = regexp.MustCompile(`^[a-z0-9_-]{1,20}$`)
+
+// SendLoginOTPRequest represents the request body for the login code request endpoint
+type SendLoginOTPRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+}
+
+// OTPLoginRequest represents the request body for the SMS code login endpoint
+type OTPLoginRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	Code        string `json:"code" validate:"required"`
+	DeviceID    string `json:"device_id"`   // Optional stable device ID; defaults to the X-Device-ID header
+	DeviceName  string `json:"device_name"` // Optional label shown in the session list
+	ClientType  string `json:"client_type"` // Optional client kind, e.g. "pos" or "mobile"
+}
+
+// DeviceKeyChallengeRequest represents the request body for the device key challenge endpoint
+type DeviceKeyChallengeRequest struct {
+	KeyID string `json:"key_id" validate:"required"`
+}
+
+// DeviceKeyChallengeResponse is returned by the device key challenge endpoint
+type DeviceKeyChallengeResponse struct {
+	KeyID     string `json:"key_id"`
+	Nonce     string `json:"nonce"`      // Value the device signs, as UTF-8 bytes
+	ExpiresIn int64  `json:"expires_in"` // Seconds until the challenge expires
+}
+
+// DeviceKeyLoginRequest represents the request body for the device key login endpoint
+type DeviceKeyLoginRequest struct {
+	KeyID      string `json:"key_id" validate:"required"`
+	Signature  string `json:"signature" validate:"required"` // Base64 signature of the challenge nonce
+	DeviceID   string `json:"device_id"`                     // Defaults to the X-Device-ID header
+	DeviceName string `json:"device_name"`                   // Optional label shown in the session list
+	ClientType string `json:"client_type"`                   // Optional client kind, e.g. "pos" or "mobile"
 }
 
 // RefreshRequest represents the request body for refresh token endpoint
@@ -18,11 +66,123 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
+// ChangePinRequest represents the request body for the PIN change endpoint
+type ChangePinRequest struct {
+	CurrentPin string `json:"current_pin" validate:"required"`
+	NewPin     string `json:"new_pin" validate:"required"`
+}
+
+// PinResetRequest represents the request body for the PIN reset request endpoint
+type PinResetRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+}
+
+// PinResetConfirmRequest represents the request body for the PIN reset confirmation endpoint
+type PinResetConfirmRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	Code        string `json:"code" validate:"required"`
+	NewPin      string `json:"new_pin" validate:"required"`
+}
+
+// RegisterShopDeviceRequest represents the request body for the shop device registration endpoint
+type RegisterShopDeviceRequest struct {
+	DeviceID string `json:"device_id" validate:"required"`
+	Name     string `json:"name"`
+}
+
+// RegisterDeviceKeyRequest represents the request body for the device key registration endpoint
+type RegisterDeviceKeyRequest struct {
+	Pin       string `json:"pin" validate:"required"`
+	Algorithm string `json:"algorithm" validate:"required"`  // "ES256" or "EdDSA"
+	PublicKey string `json:"public_key" validate:"required"` // Base64 PKIX (SubjectPublicKeyInfo) DER
+	Name      string `json:"name"`
+}
+
+// CreateUserRequest represents the request body for the user creation endpoint
+type CreateUserRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	Pin         string `json:"pin" validate:"required"` // Initial PIN; the user must change it at first login
+	Role        string `json:"role"`                    // Defaults to "staff"
+	Branch      string `json:"branch"`
+	BirthDate   string `json:"birth_date"` // Optional, YYYY-MM-DD
+	OTPLogin    bool   `json:"otp_login"`
+}
+
+// UpdateUserRequest represents the request body for the user update endpoint
+// Omitted fields are left unchanged
+type UpdateUserRequest struct {
+	PhoneNumber *string `json:"phone_number"`
+	Role        *string `json:"role"`
+	Branch      *string `json:"branch"`
+	BirthDate   *string `json:"birth_date"` // YYYY-MM-DD
+	OTPLogin    *bool   `json:"otp_login"`
+}
+
+// SetUserStatusRequest represents the request body for the user status endpoint
+type SetUserStatusRequest struct {
+	Status string `json:"status"` // active, suspended, locked or pending_pin_change
+}
+
+// CreateServiceAccountRequest represents the request body for the service account creation endpoint
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+	Branch      string `json:"branch"` // Limits the account to one branch; empty for every branch
+}
+
+// CreateAPIKeyRequest represents the request body for the API key creation endpoint
+type CreateAPIKeyRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions" validate:"required"`
+	ExpiresAt   string   `json:"expires_at"` // Optional, RFC 3339
+}
+
+// birthDateLayout is the format of birth dates in requests
+const birthDateLayout = "2006-01-02"
+
 // Handler defines the interface for authentication HTTP handlers
 type Handler interface {
 	Login(c *fiber.Ctx) error
+	RequestLoginOTP(c *fiber.Ctx) error
+	LoginWithOTP(c *fiber.Ctx) error
+	CreateDeviceKeyChallenge(c *fiber.Ctx) error
+	LoginWithDeviceKey(c *fiber.Ctx) error
 	Refresh(c *fiber.Ctx) error
 	Logout(c *fiber.Ctx) error
+	LogoutAll(c *fiber.Ctx) error
+	ListSessions(c *fiber.Ctx) error
+	RevokeSession(c *fiber.Ctx) error
+	ListDevices(c *fiber.Ctx) error
+	RemoveDevice(c *fiber.Ctx) error
+	ListShopDevices(c *fiber.Ctx) error
+	RegisterShopDevice(c *fiber.Ctx) error
+	RemoveShopDevice(c *fiber.Ctx) error
+	RegisterDeviceKey(c *fiber.Ctx) error
+	ListDeviceKeys(c *fiber.Ctx) error
+	RevokeDeviceKey(c *fiber.Ctx) error
+	ChangePin(c *fiber.Ctx) error
+	RequestPinReset(c *fiber.Ctx) error
+	ConfirmPinReset(c *fiber.Ctx) error
+	ListAuthEvents(c *fiber.Ctx) error
+	ListLoginAlerts(c *fiber.Ctx) error
+	ReviewLoginAlert(c *fiber.Ctx) error
+	CreateUser(c *fiber.Ctx) error
+	ListUsers(c *fiber.Ctx) error
+	GetUser(c *fiber.Ctx) error
+	UpdateUser(c *fiber.Ctx) error
+	SetUserStatus(c *fiber.Ctx) error
+	DeleteUser(c *fiber.Ctx) error
+	ForceLogout(c *fiber.Ctx) error
+	UnlockUser(c *fiber.Ctx) error
+	CreateServiceAccount(c *fiber.Ctx) error
+	ListServiceAccounts(c *fiber.Ctx) error
+	DeleteServiceAccount(c *fiber.Ctx) error
+	CreateAPIKey(c *fiber.Ctx) error
+	ListAPIKeys(c *fiber.Ctx) error
+	RevokeAPIKey(c *fiber.Ctx) error
+	IntrospectToken(c *fiber.Ctx) error
+	RevokeToken(c *fiber.Ctx) error
+	JWKS(c *fiber.Ctx) error
 }
 
 // handler implements the Handler interface
@@ -49,37 +209,212 @@ func (h *handler) Login(c *fiber.Ctx) error {
 
 	// Validate required fields
 	if req.PhoneNumber == "" {
-		return response.SendValidationError(c, "Phone number is required")
+		return response.SendFieldValidationError(c, "phone_number", "Phone number is required")
 	}
 	if req.Pin == "" {
-		return response.SendValidationError(c, "PIN is required")
+		return response.SendFieldValidationError(c, "pin", "PIN is required")
+	}
+
+	// Client type is optional and selects token lifetime overrides
+	clientType, ok := normalizeClientType(req.ClientType)
+	if !ok {
+		return response.SendFieldValidationError(c, "client_type", "Invalid client type")
+	}
+
+	// Authenticate user
+	client := clientInfo(c, req.DeviceName)
+	client.ClientType = clientType
+	client.LoginMethod = LoginMethodPin
+	if req.DeviceID != "" {
+		client.DeviceID = req.DeviceID
+	}
+	if client.DeviceID != "" {
+		if err := ValidateDeviceID(client.DeviceID); err != nil {
+			return sendAuthError(c, err, "Invalid device ID")
+		}
+	}
+	user, err := h.authService.AuthenticateUser(req.PhoneNumber, req.Pin, client)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to authenticate user")
+	}
+
+	return h.sendLoginTokens(c, user, client)
+}
+
+// RequestLoginOTP handles POST /auth/login/otp/request endpoint
+// Sends a login code by SMS to users allowed to log in without a PIN; the response is the
+// same whether or not the number is registered or allowed to use SMS code login
+func (h *handler) RequestLoginOTP(c *fiber.Ctx) error {
+	var req SendLoginOTPRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.PhoneNumber == "" {
+		return response.SendFieldValidationError(c, "phone_number", "Phone number is required")
+	}
+
+	if err := h.authService.RequestLoginOTP(req.PhoneNumber, clientInfo(c, "")); err != nil {
+		var attemptsErr *TooManyAttemptsError
+		if errors.As(err, &attemptsErr) {
+			return response.SendTooManyAttemptsError(c, attemptsErr.RetryAfter, "Please wait before requesting another verification code")
+		}
+		return sendAuthError(c, err, "Failed to send verification code")
+	}
+
+	return response.SendSuccess(c, nil, "If SMS code login is available for this phone number, a verification code has been sent")
+}
+
+// LoginWithOTP handles POST /auth/login/otp endpoint
+// Authenticates user with phone number and SMS code, returns access and refresh tokens
+func (h *handler) LoginWithOTP(c *fiber.Ctx) error {
+	var req OTPLoginRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.PhoneNumber == "" {
+		return response.SendFieldValidationError(c, "phone_number", "Phone number is required")
+	}
+	if req.Code == "" {
+		return response.SendFieldValidationError(c, "code", "Verification code is required")
+	}
+
+	// Client type is optional and selects token lifetime overrides
+	clientType, ok := normalizeClientType(req.ClientType)
+	if !ok {
+		return response.SendFieldValidationError(c, "client_type", "Invalid client type")
 	}
 
 	// Authenticate user
-	user, err := h.authService.AuthenticateUser(req.PhoneNumber, req.Pin)
+	client := clientInfo(c, req.DeviceName)
+	client.ClientType = clientType
+	client.LoginMethod = LoginMethodOTP
+	if req.DeviceID != "" {
+		client.DeviceID = req.DeviceID
+	}
+	if client.DeviceID != "" {
+		if err := ValidateDeviceID(client.DeviceID); err != nil {
+			return sendAuthError(c, err, "Invalid device ID")
+		}
+	}
+	user, err := h.authService.AuthenticateUserWithOTP(req.PhoneNumber, req.Code, client)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to authenticate user")
+	}
+
+	return h.sendLoginTokens(c, user, client)
+}
+
+// CreateDeviceKeyChallenge handles POST /auth/login/device-key/challenge endpoint
+// Issues a nonce for a registered device key to sign
+func (h *handler) CreateDeviceKeyChallenge(c *fiber.Ctx) error {
+	var req DeviceKeyChallengeRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	keyID, err := uuid.Parse(req.KeyID)
+	if err != nil {
+		return response.SendFieldValidationError(c, "key_id", "Valid device key ID is required")
+	}
+
+	challenge, err := h.authService.CreateDeviceKeyChallenge(keyID, clientInfo(c, ""))
+	if err != nil {
+		return sendAuthError(c, err, "Failed to create challenge")
+	}
+
+	return response.SendSuccess(c, DeviceKeyChallengeResponse{
+		KeyID:     challenge.KeyID.String(),
+		Nonce:     challenge.Nonce,
+		ExpiresIn: int64(time.Until(challenge.ExpiresAt).Seconds()),
+	}, "Challenge created successfully")
+}
+
+// LoginWithDeviceKey handles POST /auth/login/device-key endpoint
+// Authenticates user with a device key signature of the challenge, returns access and refresh tokens
+func (h *handler) LoginWithDeviceKey(c *fiber.Ctx) error {
+	var req DeviceKeyLoginRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	keyID, err := uuid.Parse(req.KeyID)
+	if err != nil {
+		return response.SendFieldValidationError(c, "key_id", "Valid device key ID is required")
+	}
+	if req.Signature == "" {
+		return response.SendFieldValidationError(c, "signature", "Signature is required")
+	}
+
+	// Client type is optional and selects token lifetime overrides
+	clientType, ok := normalizeClientType(req.ClientType)
+	if !ok {
+		return response.SendFieldValidationError(c, "client_type", "Invalid client type")
+	}
+
+	// Device keys only work from the device they were registered on
+	client := clientInfo(c, req.DeviceName)
+	client.ClientType = clientType
+	client.LoginMethod = LoginMethodDeviceKey
+	if req.DeviceID != "" {
+		client.DeviceID = req.DeviceID
+	}
+	if err := ValidateDeviceID(client.DeviceID); err != nil {
+		return sendAuthError(c, err, "Invalid device ID")
+	}
+
+	user, err := h.authService.AuthenticateUserWithDeviceKey(keyID, req.Signature, client)
 	if err != nil {
-		return response.SendAuthenticationError(c, err.Error())
+		return sendAuthError(c, err, "Failed to authenticate user")
 	}
 
+	return h.sendLoginTokens(c, user, client)
+}
+
+// sendLoginTokens starts a session for an authenticated user and sends its tokens
+func (h *handler) sendLoginTokens(c *fiber.Ctx, u *user.User, client ClientInfo) error {
 	// Generate tokens
-	tokens, err := h.authService.GenerateTokens(user.ID, user.PhoneNumber)
+	tokens, err := h.authService.GenerateTokens(u, client)
 	if err != nil {
-		return response.SendInternalServerError(c, "Failed to generate authentication tokens")
+		return sendAuthError(c, err, "Failed to generate authentication tokens")
 	}
 
-	// Return successful login response
+	// Return successful login response; phone numbers are stored in E.164 but shown in the local format
 	return response.SendLoginSuccess(
 		c,
 		tokens.AccessToken,
 		tokens.RefreshToken,
 		tokens.ExpiresIn,
-		user.ID.String(),
-		user.PhoneNumber,
+		tokens.PinChangeRequired,
+		u.ID.String(),
+		phone.FormatLocal(u.PhoneNumber),
 	)
 }
 
+// normalizeClientType lowercases an optional client type and reports whether it is valid
+func normalizeClientType(raw string) (string, bool) {
+	clientType := strings.ToLower(strings.TrimSpace(raw))
+	if clientType != "" && !clientTypePattern.MatchString(clientType) {
+		return "", false
+	}
+	return clientType, true
+}
+
 // Refresh handles POST /auth/refresh endpoint
-// Validates refresh token and issues new access and refresh tokens
+// Rotates the refresh token and issues new access and refresh tokens in the same token family
 func (h *handler) Refresh(c *fiber.Ctx) error {
 	var req RefreshRequest
 	
@@ -90,29 +425,13 @@ func (h *handler) Refresh(c *fiber.Ctx) error {
 
 	// Validate required fields
 	if req.RefreshToken == "" {
-		return response.SendValidationError(c, "Refresh token is required")
+		return response.SendFieldValidationError(c, "refresh_token", "Refresh token is required")
 	}
 
-	// Validate the refresh token
-	claims, err := h.authService.ValidateToken(req.RefreshToken)
+	// Rotate the refresh token; reuse of a rotated-out token revokes its whole family
+	tokens, claims, err := h.authService.RefreshTokens(req.RefreshToken, clientInfo(c, ""))
 	if err != nil {
-		return response.SendAuthenticationError(c, "Invalid or expired refresh token")
-	}
-
-	// Ensure this is actually a refresh token
-	if claims.TokenType != "refresh" {
-		return response.SendAuthenticationError(c, "Invalid token type")
-	}
-
-	// Blacklist the old refresh token
-	if err := h.authService.BlacklistToken(req.RefreshToken); err != nil {
-		return response.SendInternalServerError(c, "Failed to invalidate old refresh token")
-	}
-
-	// Generate new tokens
-	tokens, err := h.authService.GenerateTokens(claims.UserID, claims.PhoneNumber)
-	if err != nil {
-		return response.SendInternalServerError(c, "Failed to generate new authentication tokens")
+		return sendAuthError(c, err, "Failed to refresh authentication tokens")
 	}
 
 	// Return new tokens
@@ -121,8 +440,9 @@ func (h *handler) Refresh(c *fiber.Ctx) error {
 		tokens.AccessToken,
 		tokens.RefreshToken,
 		tokens.ExpiresIn,
+		tokens.PinChangeRequired,
 		claims.UserID.String(),
-		claims.PhoneNumber,
+		phone.FormatLocal(claims.PhoneNumber),
 	)
 }
 
@@ -149,12 +469,12 @@ func (h *handler) Logout(c *fiber.Ctx) error {
 	// Validate the access token
 	claims, err := h.authService.ValidateToken(accessToken)
 	if err != nil {
-		return response.SendAuthenticationError(c, "Invalid or expired access token")
+		return sendAuthError(c, err, "Failed to validate access token")
 	}
 
 	// Ensure this is an access token
 	if claims.TokenType != "access" {
-		return response.SendAuthenticationError(c, "Invalid token type")
+		return sendAuthError(c, ErrWrongTokenType, "")
 	}
 
 	// Blacklist the access token
@@ -172,6 +492,996 @@ func (h *handler) Logout(c *fiber.Ctx) error {
 		}
 	}
 
+	// End the session so it no longer shows up in the session list
+	if err := h.authService.Logout(claims, clientInfo(c, "")); err != nil {
+		// Log error but don't fail the logout process
+		// In a real application, you'd use a proper logger here
+	}
+
 	// Return success response
 	return response.SendSuccess(c, nil, "Logout successful")
-}
\ No newline at end of file
+}
+
+// LogoutAll handles POST /auth/logout-all endpoint
+// Logs the authenticated user out on every device, including this one
+func (h *handler) LogoutAll(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	if err := h.authService.LogoutAll(claims, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to log out of all devices")
+	}
+
+	return response.SendSuccess(c, nil, "Logged out of all devices")
+}
+
+// ListSessions handles GET /auth/sessions endpoint
+// Returns the active sessions of the authenticated user, marking the one making the request
+func (h *handler) ListSessions(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	sessions, err := h.authService.ListSessions(claims.UserID)
+	if err != nil {
+		return response.SendInternalServerError(c, "Failed to list sessions")
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.String() == claims.FamilyID
+	}
+
+	return response.SendSuccess(c, sessions, "Sessions retrieved successfully")
+}
+
+// RevokeSession handles DELETE /auth/sessions/:id endpoint
+// Ends one of the authenticated user's sessions; its tokens are rejected from then on
+func (h *handler) RevokeSession(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid session ID")
+	}
+
+	if err := h.authService.RevokeSession(claims.UserID, sessionID, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to revoke session")
+	}
+
+	return response.SendSuccess(c, nil, "Session revoked successfully")
+}
+
+// ListDevices handles GET /auth/devices endpoint
+// Returns the devices the authenticated user has logged in from, marking the one making the request
+func (h *handler) ListDevices(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	devices, err := h.authService.ListDevices(claims.UserID)
+	if err != nil {
+		return response.SendInternalServerError(c, "Failed to list devices")
+	}
+
+	for i := range devices {
+		devices[i].Current = claims.DeviceID != "" && devices[i].DeviceID == claims.DeviceID
+	}
+
+	return response.SendSuccess(c, devices, "Devices retrieved successfully")
+}
+
+// RemoveDevice handles DELETE /auth/devices/:id endpoint
+// Removes one of the authenticated user's devices and signs out its sessions
+func (h *handler) RemoveDevice(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid device ID")
+	}
+
+	if err := h.authService.RemoveDevice(claims.UserID, id); err != nil {
+		return sendAuthError(c, err, "Failed to remove device")
+	}
+
+	return response.SendSuccess(c, nil, "Device removed successfully")
+}
+
+// ListShopDevices handles GET /auth/shop-devices endpoint
+// Returns the devices registered as belonging to the shop
+func (h *handler) ListShopDevices(c *fiber.Ctx) error {
+	devices, err := h.authService.ListShopDevices()
+	if err != nil {
+		return response.SendInternalServerError(c, "Failed to list shop devices")
+	}
+
+	return response.SendSuccess(c, devices, "Shop devices retrieved successfully")
+}
+
+// RegisterShopDevice handles POST /auth/shop-devices endpoint
+// Registers a device as belonging to the shop
+func (h *handler) RegisterShopDevice(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	var req RegisterShopDeviceRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.DeviceID == "" {
+		return response.SendFieldValidationError(c, "device_id", "Device ID is required")
+	}
+
+	device, err := h.authService.RegisterShopDevice(claims.UserID, req.DeviceID, req.Name)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to register shop device")
+	}
+
+	return response.SendSuccess(c, device, "Shop device registered successfully")
+}
+
+// RemoveShopDevice handles DELETE /auth/shop-devices/:device_id endpoint
+// Deregisters a shop device
+func (h *handler) RemoveShopDevice(c *fiber.Ctx) error {
+	if err := h.authService.RemoveShopDevice(c.Params("device_id")); err != nil {
+		return sendAuthError(c, err, "Failed to remove shop device")
+	}
+
+	return response.SendSuccess(c, nil, "Shop device removed successfully")
+}
+
+// RegisterDeviceKey handles POST /auth/device-keys endpoint
+// Registers a public key of the requesting device for PIN-less login; the PIN must be confirmed
+func (h *handler) RegisterDeviceKey(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	var req RegisterDeviceKeyRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.Pin == "" {
+		return response.SendFieldValidationError(c, "pin", "PIN is required")
+	}
+	if req.Algorithm == "" {
+		return response.SendFieldValidationError(c, "algorithm", "Algorithm is required")
+	}
+	if req.PublicKey == "" {
+		return response.SendFieldValidationError(c, "public_key", "Public key is required")
+	}
+
+	key, err := h.authService.RegisterDeviceKey(claims, req.Pin, req.Algorithm, req.PublicKey, req.Name, clientInfo(c, ""))
+	if err != nil {
+		return sendAuthError(c, err, "Failed to register device key")
+	}
+
+	return response.SendSuccess(c, key, "Device key registered successfully")
+}
+
+// ListDeviceKeys handles GET /auth/device-keys endpoint
+// Returns the authenticated user's active device keys
+func (h *handler) ListDeviceKeys(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	keys, err := h.authService.ListDeviceKeys(claims.UserID)
+	if err != nil {
+		return response.SendInternalServerError(c, "Failed to list device keys")
+	}
+
+	return response.SendSuccess(c, keys, "Device keys retrieved successfully")
+}
+
+// RevokeDeviceKey handles DELETE /auth/device-keys/:id endpoint
+// Revokes one of the authenticated user's device keys
+func (h *handler) RevokeDeviceKey(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid device key ID")
+	}
+
+	if err := h.authService.RevokeDeviceKey(claims.UserID, id); err != nil {
+		return sendAuthError(c, err, "Failed to revoke device key")
+	}
+
+	return response.SendSuccess(c, nil, "Device key revoked successfully")
+}
+
+// ChangePin handles POST /auth/pin endpoint
+// Changes the authenticated user's PIN and signs out all of their other sessions
+func (h *handler) ChangePin(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	var req ChangePinRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.CurrentPin == "" {
+		return response.SendFieldValidationError(c, "current_pin", "Current PIN is required")
+	}
+	if req.NewPin == "" {
+		return response.SendFieldValidationError(c, "new_pin", "New PIN is required")
+	}
+
+	if err := h.authService.ChangePin(claims, req.CurrentPin, req.NewPin, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to change PIN")
+	}
+
+	return response.SendSuccess(c, nil, "PIN changed successfully")
+}
+
+// RequestPinReset handles POST /auth/pin/reset/request endpoint
+// Sends a PIN reset code by SMS; the response is the same whether or not the number is registered
+func (h *handler) RequestPinReset(c *fiber.Ctx) error {
+	var req PinResetRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.PhoneNumber == "" {
+		return response.SendFieldValidationError(c, "phone_number", "Phone number is required")
+	}
+
+	if err := h.authService.RequestPinReset(req.PhoneNumber, clientInfo(c, "")); err != nil {
+		var attemptsErr *TooManyAttemptsError
+		if errors.As(err, &attemptsErr) {
+			return response.SendTooManyAttemptsError(c, attemptsErr.RetryAfter, "Please wait before requesting another verification code")
+		}
+		return sendAuthError(c, err, "Failed to send verification code")
+	}
+
+	return response.SendSuccess(c, nil, "If the phone number is registered, a verification code has been sent")
+}
+
+// ConfirmPinReset handles POST /auth/pin/reset/confirm endpoint
+// Sets a new PIN using the code sent by SMS and signs out all of the user's sessions
+func (h *handler) ConfirmPinReset(c *fiber.Ctx) error {
+	var req PinResetConfirmRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.PhoneNumber == "" {
+		return response.SendFieldValidationError(c, "phone_number", "Phone number is required")
+	}
+	if req.Code == "" {
+		return response.SendFieldValidationError(c, "code", "Verification code is required")
+	}
+	if req.NewPin == "" {
+		return response.SendFieldValidationError(c, "new_pin", "New PIN is required")
+	}
+
+	if err := h.authService.ConfirmPinReset(req.PhoneNumber, req.Code, req.NewPin, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to reset PIN")
+	}
+
+	return response.SendSuccess(c, nil, "PIN reset successfully")
+}
+
+// ListAuthEvents handles GET /auth/events endpoint
+// Returns a page of the authentication audit log, newest first, filtered by the query parameters
+// user_id, phone_number, event_type, ip_address, device_id, actor_id, from and to (RFC 3339)
+func (h *handler) ListAuthEvents(c *fiber.Ctx) error {
+	filter := AuthEventFilter{
+		PhoneNumber: c.Query("phone_number"),
+		EventType:   c.Query("event_type"),
+		IPAddress:   c.Query("ip_address"),
+		DeviceID:    c.Query("device_id"),
+	}
+
+	if v := c.Query("user_id"); v != "" {
+		userID, err := uuid.Parse(v)
+		if err != nil {
+			return response.SendFieldValidationError(c, "user_id", "Invalid user ID")
+		}
+		filter.UserID = userID
+	}
+	if v := c.Query("actor_id"); v != "" {
+		actorID, err := uuid.Parse(v)
+		if err != nil {
+			return response.SendFieldValidationError(c, "actor_id", "Invalid actor ID")
+		}
+		filter.ActorID = actorID
+	}
+
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		v := c.Query(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return response.SendFieldValidationError(c, param.name, "Must be an RFC 3339 timestamp")
+		}
+		*param.dest = t
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		return response.SendFieldValidationError(c, "page", "Must be a positive number")
+	}
+	pageSize := c.QueryInt("page_size", defaultAuthEventPageSize)
+	if pageSize < 1 || pageSize > maxAuthEventPageSize {
+		return response.SendFieldValidationError(c, "page_size", fmt.Sprintf("Must be between 1 and %d", maxAuthEventPageSize))
+	}
+
+	events, err := h.authService.ListAuthEvents(filter, page, pageSize)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to list auth events")
+	}
+	for i := range events.Events {
+		events.Events[i].PhoneNumber = phone.FormatLocal(events.Events[i].PhoneNumber)
+	}
+
+	return response.SendSuccess(c, events, "Auth events retrieved successfully")
+}
+
+// ListLoginAlerts handles GET /auth/alerts endpoint
+// Returns a page of login alerts, newest first, filtered by the query parameters user_id and
+// reviewed (true or false). Users without the login_alert.review permission only see their own.
+func (h *handler) ListLoginAlerts(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	var filter LoginAlertFilter
+	if v := c.Query("user_id"); v != "" {
+		userID, err := uuid.Parse(v)
+		if err != nil {
+			return response.SendFieldValidationError(c, "user_id", "Invalid user ID")
+		}
+		filter.UserID = userID
+	}
+	switch v := c.Query("reviewed"); v {
+	case "":
+	case "true", "false":
+		reviewed := v == "true"
+		filter.Reviewed = &reviewed
+	default:
+		return response.SendFieldValidationError(c, "reviewed", "Must be true or false")
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		return response.SendFieldValidationError(c, "page", "Must be a positive number")
+	}
+	pageSize := c.QueryInt("page_size", defaultLoginAlertPageSize)
+	if pageSize < 1 || pageSize > maxLoginAlertPageSize {
+		return response.SendFieldValidationError(c, "page_size", fmt.Sprintf("Must be between 1 and %d", maxLoginAlertPageSize))
+	}
+
+	alerts, err := h.authService.ListLoginAlerts(claims, filter, page, pageSize)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to list login alerts")
+	}
+	for i := range alerts.Alerts {
+		alerts.Alerts[i].PhoneNumber = phone.FormatLocal(alerts.Alerts[i].PhoneNumber)
+	}
+
+	return response.SendSuccess(c, alerts, "Login alerts retrieved successfully")
+}
+
+// ReviewLoginAlert handles POST /auth/alerts/:id/review endpoint
+// Marks a login alert as reviewed by the authenticated user
+func (h *handler) ReviewLoginAlert(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	alertID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid login alert ID")
+	}
+
+	alert, err := h.authService.ReviewLoginAlert(claims, alertID)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to review login alert")
+	}
+	alert.PhoneNumber = phone.FormatLocal(alert.PhoneNumber)
+
+	return response.SendSuccess(c, alert, "Login alert reviewed successfully")
+}
+
+// CreateUser handles POST /admin/users endpoint
+// Creates an employee account with an initial PIN that must be changed at first login
+func (h *handler) CreateUser(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	var req CreateUserRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.PhoneNumber == "" {
+		return response.SendFieldValidationError(c, "phone_number", "Phone number is required")
+	}
+	if req.Pin == "" {
+		return response.SendFieldValidationError(c, "pin", "PIN is required")
+	}
+
+	input := CreateUserInput{
+		PhoneNumber: req.PhoneNumber,
+		Pin:         req.Pin,
+		Role:        req.Role,
+		Branch:      req.Branch,
+		OTPLogin:    req.OTPLogin,
+	}
+	if req.BirthDate != "" {
+		birthDate, err := time.Parse(birthDateLayout, req.BirthDate)
+		if err != nil {
+			return response.SendFieldValidationError(c, "birth_date", "Must be a date in YYYY-MM-DD format")
+		}
+		input.BirthDate = &birthDate
+	}
+
+	u, err := h.authService.CreateUser(claims, input, clientInfo(c, ""))
+	if err != nil {
+		return sendAuthError(c, err, "Failed to create user")
+	}
+
+	return response.SendSuccess(c, userView(u), "User created successfully")
+}
+
+// ListUsers handles GET /admin/users endpoint
+// Returns a page of users, newest first, filtered by the query parameters role, branch,
+// phone_number and status
+func (h *handler) ListUsers(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	filter := user.Filter{
+		Role:        c.Query("role"),
+		Branch:      c.Query("branch"),
+		PhoneNumber: c.Query("phone_number"),
+		Status:      c.Query("status"),
+	}
+	if filter.Status != "" && !user.IsValidStatus(filter.Status) {
+		return response.SendFieldValidationError(c, "status", "Must be active, suspended, locked or pending_pin_change")
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		return response.SendFieldValidationError(c, "page", "Must be a positive number")
+	}
+	pageSize := c.QueryInt("page_size", defaultUserPageSize)
+	if pageSize < 1 || pageSize > maxUserPageSize {
+		return response.SendFieldValidationError(c, "page_size", fmt.Sprintf("Must be between 1 and %d", maxUserPageSize))
+	}
+
+	users, err := h.authService.ListUsers(claims, filter, page, pageSize)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to list users")
+	}
+	for i := range users.Users {
+		users.Users[i] = *userView(&users.Users[i])
+	}
+
+	return response.SendSuccess(c, users, "Users retrieved successfully")
+}
+
+// GetUser handles GET /admin/users/:id endpoint
+// Returns a user's account
+func (h *handler) GetUser(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid user ID")
+	}
+
+	u, err := h.authService.GetUser(claims, userID)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to get user")
+	}
+
+	return response.SendSuccess(c, userView(u), "User retrieved successfully")
+}
+
+// UpdateUser handles PATCH /admin/users/:id endpoint
+// Changes the profile fields present in the request body
+func (h *handler) UpdateUser(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid user ID")
+	}
+
+	var req UpdateUserRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	input := UpdateUserInput{
+		PhoneNumber: req.PhoneNumber,
+		Role:        req.Role,
+		Branch:      req.Branch,
+		OTPLogin:    req.OTPLogin,
+	}
+	if req.BirthDate != nil {
+		birthDate, err := time.Parse(birthDateLayout, *req.BirthDate)
+		if err != nil {
+			return response.SendFieldValidationError(c, "birth_date", "Must be a date in YYYY-MM-DD format")
+		}
+		input.BirthDate = &birthDate
+	}
+
+	u, err := h.authService.UpdateUser(claims, userID, input, clientInfo(c, ""))
+	if err != nil {
+		return sendAuthError(c, err, "Failed to update user")
+	}
+
+	return response.SendSuccess(c, userView(u), "User updated successfully")
+}
+
+// SetUserStatus handles PUT /admin/users/:id/status endpoint
+// Changes a user's account status; suspending or locking signs them out everywhere
+func (h *handler) SetUserStatus(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid user ID")
+	}
+
+	var req SetUserStatusRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	if req.Status == "" {
+		return response.SendFieldValidationError(c, "status", "Status is required")
+	}
+
+	u, err := h.authService.SetUserStatus(claims, userID, req.Status, clientInfo(c, ""))
+	if err != nil {
+		return sendAuthError(c, err, "Failed to update user status")
+	}
+
+	return response.SendSuccess(c, userView(u), "User status updated successfully")
+}
+
+// DeleteUser handles DELETE /admin/users/:id endpoint
+// Deletes a user's account; suspending is preferred for employees who leave
+func (h *handler) DeleteUser(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid user ID")
+	}
+
+	if err := h.authService.DeleteUser(claims, userID, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to delete user")
+	}
+
+	return response.SendSuccess(c, nil, "User deleted successfully")
+}
+
+// ForceLogout handles POST /admin/users/:id/logout endpoint
+// Logs a user out on every device; the user can log in again
+func (h *handler) ForceLogout(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid user ID")
+	}
+
+	if err := h.authService.ForceLogout(claims, userID, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to log out user")
+	}
+
+	return response.SendSuccess(c, nil, "User logged out of all devices")
+}
+
+// UnlockUser handles POST /admin/users/:id/unlock endpoint
+// Lifts the lock on an account after too many failed PIN attempts
+func (h *handler) UnlockUser(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid user ID")
+	}
+
+	if err := h.authService.UnlockUser(claims, userID, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to unlock user")
+	}
+
+	return response.SendSuccess(c, nil, "User unlocked successfully")
+}
+
+// CreateServiceAccount handles POST /admin/service-accounts endpoint
+// Creates a service account, which can then be given API keys
+func (h *handler) CreateServiceAccount(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	var req CreateServiceAccountRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if req.Name == "" {
+		return response.SendFieldValidationError(c, "name", "Name is required")
+	}
+
+	input := CreateServiceAccountInput{
+		Name:        req.Name,
+		Description: req.Description,
+		Branch:      req.Branch,
+	}
+
+	account, err := h.authService.CreateServiceAccount(claims, input, clientInfo(c, ""))
+	if err != nil {
+		return sendAuthError(c, err, "Failed to create service account")
+	}
+
+	return response.SendSuccess(c, account, "Service account created successfully")
+}
+
+// ListServiceAccounts handles GET /admin/service-accounts endpoint
+// Returns every service account, ordered by name
+func (h *handler) ListServiceAccounts(c *fiber.Ctx) error {
+	accounts, err := h.authService.ListServiceAccounts()
+	if err != nil {
+		return response.SendInternalServerError(c, "Failed to list service accounts")
+	}
+
+	return response.SendSuccess(c, accounts, "Service accounts retrieved successfully")
+}
+
+// DeleteServiceAccount handles DELETE /admin/service-accounts/:id endpoint
+// Deletes a service account together with its API keys
+func (h *handler) DeleteServiceAccount(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid service account ID")
+	}
+
+	if err := h.authService.DeleteServiceAccount(claims, id, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to delete service account")
+	}
+
+	return response.SendSuccess(c, nil, "Service account deleted successfully")
+}
+
+// CreateAPIKey handles POST /admin/service-accounts/:id/keys endpoint
+// Creates an API key for a service account; the key is only ever shown in this response
+func (h *handler) CreateAPIKey(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	serviceAccountID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid service account ID")
+	}
+
+	var req CreateAPIKeyRequest
+
+	// Parse request body
+	if err := c.BodyParser(&req); err != nil {
+		return response.SendValidationError(c, "Invalid request body")
+	}
+
+	// Validate required fields
+	if len(req.Permissions) == 0 {
+		return response.SendFieldValidationError(c, "permissions", "At least one permission is required")
+	}
+
+	input := CreateAPIKeyInput{
+		Name:        req.Name,
+		Permissions: req.Permissions,
+	}
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return response.SendFieldValidationError(c, "expires_at", "Must be an RFC 3339 timestamp")
+		}
+		input.ExpiresAt = &expiresAt
+	}
+
+	key, err := h.authService.CreateAPIKey(claims, serviceAccountID, input, clientInfo(c, ""))
+	if err != nil {
+		return sendAuthError(c, err, "Failed to create API key")
+	}
+
+	return response.SendSuccess(c, key, "API key created successfully; store it now, it will not be shown again")
+}
+
+// ListAPIKeys handles GET /admin/service-accounts/:id/keys endpoint
+// Returns the unrevoked API keys of a service account, without the keys themselves
+func (h *handler) ListAPIKeys(c *fiber.Ctx) error {
+	serviceAccountID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid service account ID")
+	}
+
+	keys, err := h.authService.ListAPIKeys(serviceAccountID)
+	if err != nil {
+		return sendAuthError(c, err, "Failed to list API keys")
+	}
+
+	return response.SendSuccess(c, keys, "API keys retrieved successfully")
+}
+
+// RevokeAPIKey handles DELETE /admin/service-accounts/:id/keys/:key_id endpoint
+// Revokes one of a service account's API keys
+func (h *handler) RevokeAPIKey(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return response.SendAuthenticationError(c, "Failed to extract user information")
+	}
+
+	serviceAccountID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid service account ID")
+	}
+	keyID, err := uuid.Parse(c.Params("key_id"))
+	if err != nil {
+		return response.SendValidationError(c, "Invalid API key ID")
+	}
+
+	if err := h.authService.RevokeAPIKey(claims, serviceAccountID, keyID, clientInfo(c, "")); err != nil {
+		return sendAuthError(c, err, "Failed to revoke API key")
+	}
+
+	return response.SendSuccess(c, nil, "API key revoked successfully")
+}
+
+// IntrospectToken handles POST /oauth/introspect endpoint (RFC 7662)
+// Takes the token as a form parameter and answers with the standard introspection response
+// instead of the usual envelope; inactive tokens are answered with only "active": false.
+// token_type_hint is not needed, since tokens carry their type.
+func (h *handler) IntrospectToken(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return sendOAuthError(c, fiber.StatusUnauthorized, oauthErrorInvalidClient, "Failed to extract service account information")
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return sendOAuthError(c, fiber.StatusBadRequest, oauthErrorInvalidRequest, "The token parameter is required")
+	}
+
+	introspection, err := h.authService.IntrospectToken(claims, token)
+	if err != nil {
+		return sendOAuthError(c, fiber.StatusInternalServerError, oauthErrorServerError, "Failed to introspect token")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(introspection)
+}
+
+// RevokeToken handles POST /oauth/revoke endpoint (RFC 7009)
+// Answers 200 with an empty body whether or not the token was valid, as the RFC requires.
+// Tokens of another branch than a branch-limited service account's are refused with
+// unauthorized_client.
+func (h *handler) RevokeToken(c *fiber.Ctx) error {
+	claims, ok := ExtractClaimsFromContext(c)
+	if !ok {
+		return sendOAuthError(c, fiber.StatusUnauthorized, oauthErrorInvalidClient, "Failed to extract service account information")
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return sendOAuthError(c, fiber.StatusBadRequest, oauthErrorInvalidRequest, "The token parameter is required")
+	}
+
+	if err := h.authService.RevokeToken(claims, token, clientInfo(c, "")); err != nil {
+		if errors.Is(err, ErrPermissionDenied) {
+			return sendOAuthError(c, fiber.StatusBadRequest, oauthErrorUnauthorizedClient, "The token belongs to another branch")
+		}
+		return sendOAuthError(c, fiber.StatusInternalServerError, oauthErrorServerError, "Failed to revoke token")
+	}
+
+	return c.Status(fiber.StatusOK).Send(nil)
+}
+
+// userView returns a copy of a user for a response, with the phone number in the local format
+func userView(u *user.User) *user.User {
+	view := *u
+	view.PhoneNumber = phone.FormatLocal(u.PhoneNumber)
+	return &view
+}
+
+// JWKS handles GET /.well-known/jwks.json endpoint
+// Publishes the public keys that verify our tokens in standard JWK Set format, so the
+// response is not wrapped in the usual success envelope
+func (h *handler) JWKS(c *fiber.Ctx) error {
+	set, err := h.authService.JWKS()
+	if err != nil {
+		return response.SendInternalServerError(c, "Failed to load signing keys")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(set)
+}
+
+// clientInfo collects metadata about the client making the request
+func clientInfo(c *fiber.Ctx, deviceName string) ClientInfo {
+	return ClientInfo{
+		IPAddress:  c.IP(),
+		UserAgent:  c.Get("User-Agent"),
+		DeviceID:   c.Get(DeviceIDHeader),
+		DeviceName: deviceName,
+	}
+}
+
+// RFC 6749 error codes used by the OAuth endpoints
+const (
+	oauthErrorInvalidRequest     = "invalid_request"     // The request is malformed (400)
+	oauthErrorInvalidClient      = "invalid_client"      // The API key is missing or invalid (401)
+	oauthErrorUnauthorizedClient = "unauthorized_client" // The service account may not do this (400)
+	oauthErrorServerError        = "server_error"        // The request could not be processed (500)
+)
+
+// sendOAuthError sends a response in the RFC 6749 error format used by the OAuth endpoints
+func sendOAuthError(c *fiber.Ctx, status int, code, description string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// sendAuthError sends the response for an error returned by the authentication service,
+// mapping known errors to their stable error codes
+// Any other error is reported as an internal error with the given message
+func sendAuthError(c *fiber.Ctx, err error, internalMessage string) error {
+	var validationErr *ValidationError
+	var lockedErr *AccountLockedError
+	var attemptsErr *TooManyAttemptsError
+
+	switch {
+	case errors.As(err, &validationErr):
+		return response.SendFieldValidationError(c, validationErr.Field, validationErr.Message)
+	case errors.As(err, &lockedErr):
+		return response.SendAccountLockedError(c, lockedErr.RetryAfter, "Account is temporarily locked due to too many failed attempts")
+	case errors.As(err, &attemptsErr):
+		return response.SendTooManyAttemptsError(c, attemptsErr.RetryAfter, "Too many login attempts, please try again later")
+	case errors.Is(err, ErrInvalidCredentials):
+		return response.SendUnauthorizedError(c, response.CodeInvalidCredentials, "Invalid phone number or PIN")
+	case errors.Is(err, ErrTokenExpired):
+		return response.SendTokenExpiredError(c, "Token has expired")
+	case errors.Is(err, ErrTokenRevoked):
+		return response.SendUnauthorizedError(c, response.CodeTokenRevoked, "Token has been revoked")
+	case errors.Is(err, ErrWrongTokenType):
+		return response.SendUnauthorizedError(c, response.CodeWrongTokenType, "Invalid token type")
+	case errors.Is(err, ErrInvalidToken):
+		return response.SendUnauthorizedError(c, response.CodeInvalidToken, "Invalid token")
+	case errors.Is(err, ErrInvalidRefreshToken):
+		return response.SendUnauthorizedError(c, response.CodeInvalidToken, "Invalid or expired refresh token")
+	case errors.Is(err, ErrRefreshTokenReused):
+		return response.SendUnauthorizedError(c, response.CodeRefreshTokenReused, "Refresh token has already been used; please log in again")
+	case errors.Is(err, ErrSessionExpired):
+		return response.SendUnauthorizedError(c, response.CodeSessionExpired, "Session has expired; please log in again")
+	case errors.Is(err, ErrInvalidOTP):
+		return response.SendUnauthorizedError(c, response.CodeInvalidOTP, "Invalid or expired verification code")
+	case errors.Is(err, ErrOTPAttemptsExceeded):
+		return response.SendUnauthorizedError(c, response.CodeOTPAttemptsExceeded, "Too many attempts for this verification code; please request a new one")
+	case errors.Is(err, ErrSessionNotFound):
+		return response.SendNotFoundError(c, "Session not found")
+	case errors.Is(err, ErrDeviceNotAllowed):
+		return response.SendForbiddenError(c, response.CodeDeviceNotAllowed, "This device is not registered for the shop")
+	case errors.Is(err, ErrDeviceMismatch):
+		return response.SendUnauthorizedError(c, response.CodeDeviceMismatch, "Token is bound to another device")
+	case errors.Is(err, ErrDeviceNotFound):
+		return response.SendNotFoundError(c, "Device not found")
+	case errors.Is(err, ErrInvalidDeviceKey):
+		return response.SendUnauthorizedError(c, response.CodeInvalidDeviceKey, "Invalid device key or signature")
+	case errors.Is(err, ErrDeviceKeyNotFound):
+		return response.SendNotFoundError(c, "Device key not found")
+	case errors.Is(err, ErrLoginAlertNotFound):
+		return response.SendNotFoundError(c, "Login alert not found")
+	case errors.Is(err, ErrAccountSuspended):
+		return response.SendForbiddenError(c, response.CodeAccountSuspended, "This account has been suspended")
+	case errors.Is(err, ErrAccountLocked):
+		return response.SendForbiddenError(c, response.CodeAccountDisabled, "This account has been locked by an administrator")
+	case errors.Is(err, ErrUserNotFound):
+		return response.SendNotFoundError(c, "User not found")
+	case errors.Is(err, ErrPhoneNumberTaken):
+		return response.SendConflictError(c, response.CodePhoneNumberTaken, "Phone number is already registered")
+	case errors.Is(err, ErrPermissionDenied):
+		return response.SendForbiddenError(c, response.CodeForbidden, "You do not have permission to perform this action")
+	case errors.Is(err, ErrServiceAccountNotFound):
+		return response.SendNotFoundError(c, "Service account not found")
+	case errors.Is(err, ErrServiceAccountNameTaken):
+		return response.SendConflictError(c, response.CodeServiceAccountNameTaken, "Service account name is already taken")
+	case errors.Is(err, ErrAPIKeyNotFound):
+		return response.SendNotFoundError(c, "API key not found")
+	case errors.Is(err, ErrOwnAccount):
+		return response.SendValidationError(c, "You cannot change the status of or delete your own account")
+	default:
+		return response.SendInternalServerError(c, internalMessage)
+	}
+}
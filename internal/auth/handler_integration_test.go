@@ -16,6 +16,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"tt-stock-api/internal/config"
 	"tt-stock-api/internal/db"
+	"tt-stock-api/internal/notify"
+	"tt-stock-api/internal/phone"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/sms"
 	"tt-stock-api/internal/user"
 	"tt-stock-api/pkg/response"
 	"tt-stock-api/pkg/utils"
@@ -60,11 +64,30 @@ func setupIntegrationTest(t *testing.T) *IntegrationTestSuite {
 	// Initialize repositories and services
 	userRepo := user.NewRepository(database)
 	blacklistRepo := NewBlacklistRepository(database)
+	attemptRepo := NewLoginAttemptRepository(database)
 	
 	cfg := &config.Config{
 		JWTSecret: "test-jwt-secret-key-for-integration-tests",
+		TokenLifetimes: config.TokenLifetimes{
+			Access:     15 * time.Minute,
+			Refresh:    24 * time.Hour,
+			SessionMax: 7 * 24 * time.Hour,
+		},
 	}
-	authService := NewService(userRepo, blacklistRepo, cfg)
+	authService := NewService(Repositories{
+		Users:           userRepo,
+		Blacklist:       blacklistRepo,
+		LoginAttempts:   attemptRepo,
+		TokenFamilies:   NewTokenFamilyRepository(database),
+		SecurityEvents:  NewSecurityEventRepository(database),
+		Sessions:        NewSessionRepository(database),
+		OTPs:            NewOTPRepository(database),
+		Devices:         NewDeviceRepository(database),
+		DeviceKeys:      NewDeviceKeyRepository(database),
+		AuthEvents:      NewAuthEventRepository(database),
+		LoginAlerts:     NewLoginAlertRepository(database),
+		ServiceAccounts: NewServiceAccountRepository(database),
+	}, NewHMACKeyManager(cfg.JWTSecret), sms.NewLogSender(nil), notify.NewLogNotifier(nil), policy.NewEngine(policy.NewRepository(database), 0), phone.NewRegistry(phone.Thailand), cfg)
 	handler := NewHandler(authService)
 
 	// Setup Fiber app
@@ -72,6 +95,8 @@ func setupIntegrationTest(t *testing.T) *IntegrationTestSuite {
 	app.Post("/auth/login", handler.Login)
 	app.Post("/auth/refresh", handler.Refresh)
 	app.Post("/auth/logout", handler.Logout)
+	app.Get("/auth/sessions", JWTProtected(authService), handler.ListSessions)
+	app.Delete("/auth/sessions/:id", JWTProtected(authService), handler.RevokeSession)
 
 	// Create test user
 	testUser := createTestUserInDB(t, database)
@@ -100,13 +125,14 @@ func createTestUserInDB(t *testing.T, database *db.DB) *user.User {
 		VALUES ($1, $2, $3, $4, $5)
 	`
 	now := time.Now()
-	_, err = database.Exec(query, userID, "0812345678", pinHash, now, now)
+	_, err = database.Exec(query, userID, "+66812345678", pinHash, now, now)
 	require.NoError(t, err, "Failed to create test user")
 
 	return &user.User{
 		ID:          userID,
-		PhoneNumber: "0812345678",
+		PhoneNumber: "+66812345678",
 		PinHash:     pinHash,
+		Status:      user.StatusActive,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
@@ -117,6 +143,18 @@ func (suite *IntegrationTestSuite) cleanup(t *testing.T) {
 	// Clean up test data
 	_, err := suite.db.Exec("DELETE FROM token_blacklist")
 	require.NoError(t, err, "Failed to clean up token_blacklist table")
+
+	_, err = suite.db.Exec("DELETE FROM login_throttles")
+	require.NoError(t, err, "Failed to clean up login_throttles table")
+
+	_, err = suite.db.Exec("DELETE FROM security_events")
+	require.NoError(t, err, "Failed to clean up security_events table")
+
+	_, err = suite.db.Exec("DELETE FROM sessions")
+	require.NoError(t, err, "Failed to clean up sessions table")
+
+	_, err = suite.db.Exec("DELETE FROM token_families")
+	require.NoError(t, err, "Failed to clean up token_families table")
 	
 	_, err = suite.db.Exec("DELETE FROM users")
 	require.NoError(t, err, "Failed to clean up users table")
@@ -159,7 +197,7 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		assert.NotEmpty(t, loginResp.Data.RefreshToken)
 		assert.Equal(t, int64(900), loginResp.Data.ExpiresIn) // 15 minutes
 		assert.Equal(t, suite.testUser.ID.String(), loginResp.Data.User.ID)
-		assert.Equal(t, suite.testUser.PhoneNumber, loginResp.Data.User.PhoneNumber)
+		assert.Equal(t, "0812345678", loginResp.Data.User.PhoneNumber)
 
 		// Verify tokens are valid JWT tokens
 		accessClaims, err := suite.authService.ValidateToken(loginResp.Data.AccessToken)
@@ -173,7 +211,7 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		assert.Equal(t, suite.testUser.ID, refreshClaims.UserID)
 
 		// Verify last login was updated in database
-		updatedUser, err := suite.userRepo.FindByPhoneNumber("0812345678")
+		updatedUser, err := suite.userRepo.FindByPhoneNumber("+66812345678")
 		require.NoError(t, err)
 		assert.NotNil(t, updatedUser.LastLoginAt)
 		assert.True(t, updatedUser.LastLoginAt.After(suite.testUser.CreatedAt))
@@ -192,7 +230,7 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		resp, err := suite.app.Test(req)
 		require.NoError(t, err)
 
-		assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
 
 		body, _ := io.ReadAll(resp.Body)
 		var errorResp response.ErrorResponse
@@ -200,8 +238,9 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+		assert.Equal(t, "VALIDATION_ERROR", errorResp.Error.Code)
 		assert.Contains(t, errorResp.Error.Message, "invalid phone number format")
+		assert.Equal(t, "phone_number", errorResp.Error.Field)
 	})
 
 	t.Run("login with invalid PIN format", func(t *testing.T) {
@@ -217,7 +256,7 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		resp, err := suite.app.Test(req)
 		require.NoError(t, err)
 
-		assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
 
 		body, _ := io.ReadAll(resp.Body)
 		var errorResp response.ErrorResponse
@@ -225,8 +264,9 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+		assert.Equal(t, "VALIDATION_ERROR", errorResp.Error.Code)
 		assert.Contains(t, errorResp.Error.Message, "invalid PIN format")
+		assert.Equal(t, "pin", errorResp.Error.Field)
 	})
 
 	t.Run("login with non-existent phone number", func(t *testing.T) {
@@ -250,8 +290,8 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
-		assert.Equal(t, "invalid credentials", errorResp.Error.Message)
+		assert.Equal(t, "INVALID_CREDENTIALS", errorResp.Error.Code)
+		assert.Equal(t, "Invalid phone number or PIN", errorResp.Error.Message)
 	})
 
 	t.Run("login with wrong PIN", func(t *testing.T) {
@@ -275,8 +315,8 @@ func TestLoginEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
-		assert.Equal(t, "invalid credentials", errorResp.Error.Message)
+		assert.Equal(t, "INVALID_CREDENTIALS", errorResp.Error.Code)
+		assert.Equal(t, "Invalid phone number or PIN", errorResp.Error.Message)
 	})
 }
 
@@ -286,7 +326,7 @@ func TestRefreshEndpoint_Integration(t *testing.T) {
 	defer suite.cleanup(t)
 
 	// First, get valid tokens by logging in
-	tokens, err := suite.authService.GenerateTokens(suite.testUser.ID, suite.testUser.PhoneNumber)
+	tokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{})
 	require.NoError(t, err)
 
 	t.Run("successful token refresh with valid refresh token", func(t *testing.T) {
@@ -316,7 +356,7 @@ func TestRefreshEndpoint_Integration(t *testing.T) {
 		assert.NotEmpty(t, refreshResp.Data.RefreshToken)
 		assert.Equal(t, int64(900), refreshResp.Data.ExpiresIn)
 		assert.Equal(t, suite.testUser.ID.String(), refreshResp.Data.User.ID)
-		assert.Equal(t, suite.testUser.PhoneNumber, refreshResp.Data.User.PhoneNumber)
+		assert.Equal(t, "0812345678", refreshResp.Data.User.PhoneNumber)
 
 		// Verify new tokens are different from original
 		assert.NotEqual(t, tokens.AccessToken, refreshResp.Data.AccessToken)
@@ -357,7 +397,7 @@ func TestRefreshEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+		assert.Equal(t, "INVALID_TOKEN", errorResp.Error.Code)
 		assert.Equal(t, "Invalid or expired refresh token", errorResp.Error.Message)
 	})
 
@@ -381,14 +421,18 @@ func TestRefreshEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+		assert.Equal(t, "WRONG_TOKEN_TYPE", errorResp.Error.Code)
 		assert.Equal(t, "Invalid token type", errorResp.Error.Message)
 	})
 
 	t.Run("refresh with already used (blacklisted) refresh token", func(t *testing.T) {
+		// Start a fresh token family for this scenario
+		familyTokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{})
+		require.NoError(t, err)
+
 		// First, use the refresh token
 		refreshReq := RefreshRequest{
-			RefreshToken: tokens.RefreshToken,
+			RefreshToken: familyTokens.RefreshToken,
 		}
 		reqBody, _ := json.Marshal(refreshReq)
 
@@ -399,6 +443,11 @@ func TestRefreshEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 
+		rotatedBody, _ := io.ReadAll(resp.Body)
+		var rotatedResp response.LoginResponse
+		err = json.Unmarshal(rotatedBody, &rotatedResp)
+		require.NoError(t, err)
+
 		// Now try to use the same refresh token again
 		req2 := httptest.NewRequest("POST", "/auth/refresh", bytes.NewReader(reqBody))
 		req2.Header.Set("Content-Type", "application/json")
@@ -414,8 +463,14 @@ func TestRefreshEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
-		assert.Equal(t, "Invalid or expired refresh token", errorResp.Error.Message)
+		assert.Equal(t, "REFRESH_TOKEN_REUSED", errorResp.Error.Code)
+		assert.Equal(t, "Refresh token has already been used; please log in again", errorResp.Error.Message)
+
+		// Reuse revokes the whole family, including the tokens issued by the legitimate rotation
+		_, err = suite.authService.ValidateToken(rotatedResp.Data.AccessToken)
+		assert.Error(t, err)
+		_, err = suite.authService.ValidateToken(rotatedResp.Data.RefreshToken)
+		assert.Error(t, err)
 	})
 }
 
@@ -425,7 +480,7 @@ func TestLogoutEndpoint_Integration(t *testing.T) {
 	defer suite.cleanup(t)
 
 	// Generate valid tokens for testing
-	tokens, err := suite.authService.GenerateTokens(suite.testUser.ID, suite.testUser.PhoneNumber)
+	tokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{})
 	require.NoError(t, err)
 
 	t.Run("successful logout with access token only", func(t *testing.T) {
@@ -456,12 +511,12 @@ func TestLogoutEndpoint_Integration(t *testing.T) {
 		// Verify blacklisted token cannot be used
 		_, err = suite.authService.ValidateToken(tokens.AccessToken)
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "token has been invalidated")
+		assert.ErrorIs(t, err, ErrTokenRevoked)
 	})
 
 	t.Run("successful logout with both access and refresh tokens", func(t *testing.T) {
 		// Generate new tokens for this test
-		newTokens, err := suite.authService.GenerateTokens(suite.testUser.ID, suite.testUser.PhoneNumber)
+		newTokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{})
 		require.NoError(t, err)
 
 		logoutReq := RefreshRequest{
@@ -514,13 +569,13 @@ func TestLogoutEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
-		assert.Equal(t, "Invalid or expired access token", errorResp.Error.Message)
+		assert.Equal(t, "INVALID_TOKEN", errorResp.Error.Code)
+		assert.Equal(t, "Invalid token", errorResp.Error.Message)
 	})
 
 	t.Run("logout with refresh token in authorization header", func(t *testing.T) {
 		// Generate new tokens for this test
-		newTokens, err := suite.authService.GenerateTokens(suite.testUser.ID, suite.testUser.PhoneNumber)
+		newTokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{})
 		require.NoError(t, err)
 
 		req := httptest.NewRequest("POST", "/auth/logout", nil)
@@ -537,13 +592,13 @@ func TestLogoutEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+		assert.Equal(t, "WRONG_TOKEN_TYPE", errorResp.Error.Code)
 		assert.Equal(t, "Invalid token type", errorResp.Error.Message)
 	})
 
 	t.Run("logout with already blacklisted token", func(t *testing.T) {
 		// Generate new tokens for this test
-		newTokens, err := suite.authService.GenerateTokens(suite.testUser.ID, suite.testUser.PhoneNumber)
+		newTokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{})
 		require.NoError(t, err)
 
 		// First logout (blacklist the token)
@@ -569,8 +624,8 @@ func TestLogoutEndpoint_Integration(t *testing.T) {
 		require.NoError(t, err)
 
 		assert.False(t, errorResp.Success)
-		assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
-		assert.Equal(t, "Invalid or expired access token", errorResp.Error.Message)
+		assert.Equal(t, "TOKEN_REVOKED", errorResp.Error.Code)
+		assert.Equal(t, "Token has been revoked", errorResp.Error.Message)
 	})
 }
 
@@ -670,11 +725,77 @@ func TestCompleteAuthenticationFlow_Integration(t *testing.T) {
 		// Verify tokens cannot be used anymore
 		_, err = suite.authService.ValidateToken(newAccessToken)
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "token has been invalidated")
+		assert.ErrorIs(t, err, ErrTokenRevoked)
 
 		_, err = suite.authService.ValidateToken(newRefreshToken)
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "token has been invalidated")
+		assert.ErrorIs(t, err, ErrTokenRevoked)
+	})
+}
+
+// TestSessionEndpoints_Integration tests listing and revoking sessions
+func TestSessionEndpoints_Integration(t *testing.T) {
+	suite := setupIntegrationTest(t)
+	defer suite.cleanup(t)
+
+	t.Run("list and revoke sessions", func(t *testing.T) {
+		shopTokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{DeviceName: "Shop tablet", IPAddress: "203.0.113.7"})
+		require.NoError(t, err)
+		phoneTokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{DeviceName: "Lost phone"})
+		require.NoError(t, err)
+
+		// List sessions from the shop tablet
+		req := httptest.NewRequest("GET", "/auth/sessions", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", shopTokens.AccessToken))
+
+		resp, err := suite.app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		body, _ := io.ReadAll(resp.Body)
+		var listResp struct {
+			Success bool      `json:"success"`
+			Data    []Session `json:"data"`
+		}
+		err = json.Unmarshal(body, &listResp)
+		require.NoError(t, err)
+		require.Len(t, listResp.Data, 2)
+
+		var lostSessionID uuid.UUID
+		for _, session := range listResp.Data {
+			if session.DeviceName == "Lost phone" {
+				lostSessionID = session.ID
+				assert.False(t, session.Current)
+			} else {
+				assert.True(t, session.Current)
+				assert.Equal(t, "203.0.113.7", session.IPAddress)
+			}
+		}
+		require.NotEqual(t, uuid.Nil, lostSessionID)
+
+		// Revoke the lost phone's session
+		req = httptest.NewRequest("DELETE", "/auth/sessions/"+lostSessionID.String(), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", shopTokens.AccessToken))
+
+		resp, err = suite.app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		// The lost phone's access token is rejected by the middleware
+		req = httptest.NewRequest("GET", "/auth/sessions", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", phoneTokens.AccessToken))
+
+		resp, err = suite.app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+
+		// Revoking it again reports the session as not found
+		req = httptest.NewRequest("DELETE", "/auth/sessions/"+lostSessionID.String(), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", shopTokens.AccessToken))
+
+		resp, err = suite.app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
 	})
 }
 
@@ -688,7 +809,7 @@ func TestTokenExpiration_Integration(t *testing.T) {
 		// For now, we'll test the validation logic with manually created expired tokens
 		
 		// Generate tokens
-		tokens, err := suite.authService.GenerateTokens(suite.testUser.ID, suite.testUser.PhoneNumber)
+		tokens, err := suite.authService.GenerateTokens(suite.testUser, ClientInfo{})
 		require.NoError(t, err)
 
 		// Verify tokens are initially valid
@@ -745,8 +866,9 @@ func TestConcurrentRequests_Integration(t *testing.T) {
 			assert.NoError(t, err, "Concurrent login request failed")
 		}
 	})
-}// g
-etEnvOrDefault returns environment variable value or default if not set
+}
+
+// getEnvOrDefault returns environment variable value or default if not set
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"tt-stock-api/internal/config"
+	"tt-stock-api/pkg/utils"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// keyReloadInterval limits how often the key set is reloaded from the database
+// Keys created by other instances are picked up within this interval
+const keyReloadInterval = time.Minute
+
+// JWK represents a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA public exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKSet represents a JSON Web Key Set as published at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyManager signs tokens and resolves the keys needed to verify them
+type KeyManager interface {
+	SignToken(claims jwt.Claims) (string, error)
+	Keyfunc(token *jwt.Token) (interface{}, error)
+	JWKS() (*JWKSet, error)
+}
+
+// NewKeyManager creates the key manager for the configured signing algorithm
+// HS256 signs with JWT_SECRET directly; RS256 and EdDSA use rotating key pairs stored in the
+// database, with private keys encrypted by a key derived from JWT_SECRET
+func NewKeyManager(cfg *config.Config, repo SigningKeyRepository) (KeyManager, error) {
+	// A rotated-out key keeps verifying until the longest-lived token it may have signed has expired
+	verifyFor := newTokenLifetimePolicy(cfg).longest()
+
+	switch cfg.JWTAlgorithm {
+	case "", AlgorithmHS256:
+		return NewHMACKeyManager(cfg.JWTSecret), nil
+	case AlgorithmRS256:
+		return newAsymmetricKeyManager(repo, jwt.SigningMethodRS256, cfg.JWTSecret, cfg.JWTKeyRotationInterval, verifyFor), nil
+	case AlgorithmEdDSA:
+		return newAsymmetricKeyManager(repo, jwt.SigningMethodEdDSA, cfg.JWTSecret, cfg.JWTKeyRotationInterval, verifyFor), nil
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", cfg.JWTAlgorithm)
+	}
+}
+
+// hmacKeyManager signs tokens with a single shared secret
+type hmacKeyManager struct {
+	secret []byte
+}
+
+// NewHMACKeyManager creates a key manager that signs and verifies with HS256
+func NewHMACKeyManager(secret string) KeyManager {
+	return &hmacKeyManager{
+		secret: []byte(secret),
+	}
+}
+
+// SignToken signs claims with HS256
+func (m *hmacKeyManager) SignToken(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+}
+
+// Keyfunc returns the shared secret for HS256 tokens
+func (m *hmacKeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != AlgorithmHS256 {
+		return nil, errors.New("invalid token signing method")
+	}
+	return m.secret, nil
+}
+
+// JWKS returns an empty key set; a shared secret is never published
+func (m *hmacKeyManager) JWKS() (*JWKSet, error) {
+	return &JWKSet{Keys: []JWK{}}, nil
+}
+
+// loadedKey is a signing key with its key material decoded
+type loadedKey struct {
+	kid       string
+	private   crypto.Signer
+	public    crypto.PublicKey
+	rotateAt  time.Time
+	expiresAt time.Time
+}
+
+// asymmetricKeyManager signs with the newest stored key pair and rotates it when it is due
+type asymmetricKeyManager struct {
+	repo             SigningKeyRepository
+	method           jwt.SigningMethod
+	encryptionKey    []byte
+	rotationInterval time.Duration
+	verifyFor        time.Duration // How long a key keeps verifying after it stops signing
+
+	mu       sync.Mutex
+	keys     map[string]*loadedKey
+	current  *loadedKey
+	loadedAt time.Time
+}
+
+// newAsymmetricKeyManager creates a key manager for RS256 or EdDSA
+func newAsymmetricKeyManager(repo SigningKeyRepository, method jwt.SigningMethod, secret string, rotationInterval, verifyFor time.Duration) *asymmetricKeyManager {
+	return &asymmetricKeyManager{
+		repo:             repo,
+		method:           method,
+		encryptionKey:    utils.DeriveKey("jwt-signing-key", secret),
+		rotationInterval: rotationInterval,
+		verifyFor:        verifyFor,
+		keys:             map[string]*loadedKey{},
+	}
+}
+
+// SignToken signs claims with the current key and sets its kid header
+func (m *asymmetricKeyManager) SignToken(claims jwt.Claims) (string, error) {
+	key, err := m.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(m.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Keyfunc returns the public key named by the token's kid header
+func (m *asymmetricKeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != m.method.Alg() {
+		return nil, errors.New("invalid token signing method")
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no key ID")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	key, ok := m.keys[kid]
+	if !ok && now.Sub(m.loadedAt) > time.Second {
+		// The key may have been created by another instance since the last load
+		if err := m.reload(now); err != nil {
+			return nil, err
+		}
+		key, ok = m.keys[kid]
+	}
+	if !ok || !now.Before(key.expiresAt) {
+		return nil, errors.New("unknown signing key")
+	}
+
+	return key.public, nil
+}
+
+// JWKS returns the public keys that can still verify tokens
+func (m *asymmetricKeyManager) JWKS() (*JWKSet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.loadedAt) > keyReloadInterval {
+		if err := m.reload(now); err != nil {
+			return nil, err
+		}
+	}
+
+	set := &JWKSet{Keys: []JWK{}}
+	for _, key := range m.keys {
+		if !now.Before(key.expiresAt) {
+			continue
+		}
+		jwk, err := publicJWK(key.kid, m.method.Alg(), key.public)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// signingKey returns the key new tokens are signed with, rotating it if it is due
+func (m *asymmetricKeyManager) signingKey() (*loadedKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.current != nil && now.Before(m.current.rotateAt) {
+		return m.current, nil
+	}
+
+	// Another instance may already have rotated the key
+	if err := m.reload(now); err != nil {
+		return nil, err
+	}
+	if m.current != nil && now.Before(m.current.rotateAt) {
+		return m.current, nil
+	}
+
+	key, err := m.generateKey(now)
+	if err != nil {
+		return nil, err
+	}
+	m.keys[key.kid] = key
+	m.current = key
+
+	return key, nil
+}
+
+// reload replaces the in-memory key set with the valid keys stored in the database
+// Must be called with mu held
+func (m *asymmetricKeyManager) reload(now time.Time) error {
+	stored, err := m.repo.ListValidKeys(m.method.Alg(), now)
+	if err != nil {
+		return errors.New("failed to load signing keys")
+	}
+
+	keys := make(map[string]*loadedKey, len(stored))
+	var current *loadedKey
+	for _, sk := range stored {
+		key, err := m.decodeKey(sk)
+		if err != nil {
+			// Log error but keep loading the remaining keys
+			// In a real application, you'd use a proper logger here
+			continue
+		}
+		keys[key.kid] = key
+		if current == nil && key.private != nil && now.Before(key.rotateAt) {
+			current = key
+		}
+	}
+
+	m.keys = keys
+	m.current = current
+	m.loadedAt = now
+	return nil
+}
+
+// generateKey creates and stores a new key pair
+func (m *asymmetricKeyManager) generateKey(now time.Time) (*loadedKey, error) {
+	var private crypto.Signer
+	switch m.method.Alg() {
+	case AlgorithmRS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, errors.New("failed to generate signing key")
+		}
+		private = rsaKey
+	case AlgorithmEdDSA:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.New("failed to generate signing key")
+		}
+		private = edKey
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", m.method.Alg())
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, errors.New("failed to encode signing key")
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, errors.New("failed to encode signing key")
+	}
+	encrypted, err := utils.Encrypt(m.encryptionKey, privateDER)
+	if err != nil {
+		return nil, errors.New("failed to encrypt signing key")
+	}
+
+	rotateAt := now.Add(m.rotationInterval)
+	stored := &SigningKey{
+		KeyID:      uuid.NewString(),
+		Algorithm:  m.method.Alg(),
+		PrivateKey: encrypted,
+		PublicKey:  publicDER,
+		CreatedAt:  now,
+		RotateAt:   rotateAt,
+		ExpiresAt:  rotateAt.Add(m.verifyFor),
+	}
+	if err := m.repo.CreateKey(stored); err != nil {
+		return nil, errors.New("failed to store signing key")
+	}
+
+	return &loadedKey{
+		kid:       stored.KeyID,
+		private:   private,
+		public:    private.Public(),
+		rotateAt:  stored.RotateAt,
+		expiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+// decodeKey parses a stored key
+// A private key that cannot be decrypted (e.g. after JWT_SECRET was changed) is left out, so
+// the key still verifies existing tokens but is never used for signing
+func (m *asymmetricKeyManager) decodeKey(sk SigningKey) (*loadedKey, error) {
+	public, err := x509.ParsePKIXPublicKey(sk.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &loadedKey{
+		kid:       sk.KeyID,
+		public:    public,
+		rotateAt:  sk.RotateAt,
+		expiresAt: sk.ExpiresAt,
+	}
+
+	privateDER, err := utils.Decrypt(m.encryptionKey, sk.PrivateKey)
+	if err != nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(privateDER)
+	if err != nil {
+		return key, nil
+	}
+	if private, ok := parsed.(crypto.Signer); ok {
+		key.private = private
+	}
+
+	return key, nil
+}
+
+// publicJWK converts a public key to its JWK representation
+func publicJWK(kid, algorithm string, public crypto.PublicKey) (JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: algorithm,
+			N:         encode(key.N.Bytes()),
+			E:         encode(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: algorithm,
+			Curve:     "Ed25519",
+			X:         encode(key),
+		}, nil
+	default:
+		return JWK{}, errors.New("unsupported public key type")
+	}
+}
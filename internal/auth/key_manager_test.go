@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tt-stock-api/internal/config"
+)
+
+// memorySigningKeyRepository is an in-memory SigningKeyRepository shared between key managers
+type memorySigningKeyRepository struct {
+	mu   sync.Mutex
+	keys []SigningKey
+}
+
+func (r *memorySigningKeyRepository) CreateKey(key *SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append([]SigningKey{*key}, r.keys...)
+	return nil
+}
+
+func (r *memorySigningKeyRepository) ListValidKeys(algorithm string, now time.Time) ([]SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var keys []SigningKey
+	for _, key := range r.keys {
+		if key.Algorithm == algorithm && key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// testClaims builds claims for key manager tests
+func testClaims() *Claims {
+	now := time.Now()
+	return &Claims{
+		PhoneNumber: "0812345678",
+		TokenType:   "access",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+// verify parses a token with the given key manager
+func verify(keys KeyManager, tokenString string) error {
+	_, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.Keyfunc)
+	return err
+}
+
+func TestNewKeyManager(t *testing.T) {
+	repo := &memorySigningKeyRepository{}
+
+	for _, algorithm := range []string{"", AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA} {
+		keys, err := NewKeyManager(&config.Config{JWTSecret: "secret", JWTAlgorithm: algorithm}, repo)
+		assert.NoError(t, err, algorithm)
+		assert.NotNil(t, keys, algorithm)
+	}
+
+	keys, err := NewKeyManager(&config.Config{JWTSecret: "secret", JWTAlgorithm: "none"}, repo)
+	assert.Error(t, err)
+	assert.Nil(t, keys)
+}
+
+func TestHMACKeyManager(t *testing.T) {
+	keys := NewHMACKeyManager("test-secret-key")
+
+	token, err := keys.SignToken(testClaims())
+	require.NoError(t, err)
+	assert.NoError(t, verify(keys, token))
+
+	// A token signed with another secret is rejected
+	other := NewHMACKeyManager("other-secret-key")
+	otherToken, err := other.SignToken(testClaims())
+	require.NoError(t, err)
+	assert.Error(t, verify(keys, otherToken))
+
+	// The shared secret is never published
+	set, err := keys.JWKS()
+	assert.NoError(t, err)
+	assert.Empty(t, set.Keys)
+}
+
+func TestAsymmetricKeyManager_SignAndVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		keyType   string
+	}{
+		{name: "RS256", algorithm: AlgorithmRS256, keyType: "RSA"},
+		{name: "EdDSA", algorithm: AlgorithmEdDSA, keyType: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memorySigningKeyRepository{}
+			keys, err := NewKeyManager(&config.Config{
+				JWTSecret:              "test-secret-key",
+				JWTAlgorithm:           tt.algorithm,
+				JWTKeyRotationInterval: time.Hour,
+				TokenLifetimes:         config.TokenLifetimes{Access: 15 * time.Minute, Refresh: 24 * time.Hour},
+			}, repo)
+			require.NoError(t, err)
+
+			first, err := keys.SignToken(testClaims())
+			require.NoError(t, err)
+			second, err := keys.SignToken(testClaims())
+			require.NoError(t, err)
+
+			assert.NoError(t, verify(keys, first))
+			assert.NoError(t, verify(keys, second))
+
+			// Both tokens are signed with the same stored key, named in the kid header
+			parsed, _, err := jwt.NewParser().ParseUnverified(first, &Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.algorithm, parsed.Method.Alg())
+			require.Len(t, repo.keys, 1)
+			assert.Equal(t, repo.keys[0].KeyID, parsed.Header["kid"])
+			assert.NotContains(t, string(repo.keys[0].PrivateKey), "PRIVATE KEY")
+
+			set, err := keys.JWKS()
+			require.NoError(t, err)
+			require.Len(t, set.Keys, 1)
+			assert.Equal(t, tt.keyType, set.Keys[0].KeyType)
+			assert.Equal(t, repo.keys[0].KeyID, set.Keys[0].KeyID)
+			assert.Equal(t, tt.algorithm, set.Keys[0].Algorithm)
+			assert.Equal(t, "sig", set.Keys[0].Use)
+		})
+	}
+}
+
+func TestAsymmetricKeyManager_Rotation(t *testing.T) {
+	repo := &memorySigningKeyRepository{}
+	// A zero rotation interval makes every signature rotate the key
+	keys := newAsymmetricKeyManager(repo, jwt.SigningMethodEdDSA, "test-secret-key", 0, 24*time.Hour)
+
+	oldToken, err := keys.SignToken(testClaims())
+	require.NoError(t, err)
+	newToken, err := keys.SignToken(testClaims())
+	require.NoError(t, err)
+
+	require.Len(t, repo.keys, 2)
+	assert.NotEqual(t, repo.keys[0].KeyID, repo.keys[1].KeyID)
+
+	// The rotated-out key still verifies its tokens and stays published
+	assert.NoError(t, verify(keys, oldToken))
+	assert.NoError(t, verify(keys, newToken))
+
+	set, err := keys.JWKS()
+	require.NoError(t, err)
+	assert.Len(t, set.Keys, 2)
+
+	// Once the key expires its tokens are rejected
+	parsed, _, err := jwt.NewParser().ParseUnverified(oldToken, &Claims{})
+	require.NoError(t, err)
+	keys.keys[parsed.Header["kid"].(string)].expiresAt = time.Now().Add(-time.Minute)
+	assert.Error(t, verify(keys, oldToken))
+}
+
+func TestAsymmetricKeyManager_SharedBetweenInstances(t *testing.T) {
+	repo := &memorySigningKeyRepository{}
+	first := newAsymmetricKeyManager(repo, jwt.SigningMethodRS256, "test-secret-key", time.Hour, 24*time.Hour)
+	second := newAsymmetricKeyManager(repo, jwt.SigningMethodRS256, "test-secret-key", time.Hour, 24*time.Hour)
+
+	token, err := first.SignToken(testClaims())
+	require.NoError(t, err)
+
+	// Another instance loads the key from storage and reuses it for signing
+	assert.NoError(t, verify(second, token))
+	_, err = second.SignToken(testClaims())
+	require.NoError(t, err)
+	assert.Len(t, repo.keys, 1)
+
+	// An instance with a different secret cannot decrypt the private key, but still verifies
+	// with the public key and signs with a key of its own
+	third := newAsymmetricKeyManager(repo, jwt.SigningMethodRS256, "other-secret-key", time.Hour, 24*time.Hour)
+	assert.NoError(t, verify(third, token))
+	_, err = third.SignToken(testClaims())
+	require.NoError(t, err)
+	assert.Len(t, repo.keys, 2)
+}
+
+func TestAsymmetricKeyManager_RejectsOtherAlgorithms(t *testing.T) {
+	repo := &memorySigningKeyRepository{}
+	keys := newAsymmetricKeyManager(repo, jwt.SigningMethodRS256, "test-secret-key", time.Hour, 24*time.Hour)
+
+	signed, err := keys.SignToken(testClaims())
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(signed, &Claims{})
+	require.NoError(t, err)
+
+	// An HS256 token carrying a valid kid must not be accepted
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = parsed.Header["kid"]
+	forgedString, err := forged.SignedString([]byte("test-secret-key"))
+	require.NoError(t, err)
+	assert.Error(t, verify(keys, forgedString))
+
+	// A token without a kid is rejected
+	unnamed := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims())
+	unnamedString, err := unnamed.SignedString(keys.current.private)
+	require.NoError(t, err)
+	assert.Error(t, verify(keys, unnamedString))
+}
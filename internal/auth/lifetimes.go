@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"time"
+
+	"tt-stock-api/internal/config"
+)
+
+// tokenLifetimePolicy resolves token lifetimes for a user's role and client type
+type tokenLifetimePolicy struct {
+	defaults  config.TokenLifetimes
+	overrides map[string]config.TokenLifetimes
+}
+
+// newTokenLifetimePolicy creates a lifetime policy from configuration
+func newTokenLifetimePolicy(cfg *config.Config) tokenLifetimePolicy {
+	return tokenLifetimePolicy{
+		defaults:  cfg.TokenLifetimes,
+		overrides: cfg.TokenLifetimeOverrides,
+	}
+}
+
+// resolve returns the lifetimes for a role and client type
+// Overrides are applied field by field from least to most specific:
+// role, then client type, then the combination of both
+func (p tokenLifetimePolicy) resolve(role, clientType string) config.TokenLifetimes {
+	lifetimes := p.defaults
+
+	if role != "" {
+		lifetimes = applyLifetimeOverride(lifetimes, p.overrides["role:"+role])
+	}
+	if clientType != "" {
+		lifetimes = applyLifetimeOverride(lifetimes, p.overrides["client:"+clientType])
+	}
+	if role != "" && clientType != "" {
+		lifetimes = applyLifetimeOverride(lifetimes, p.overrides["role:"+role+",client:"+clientType])
+	}
+
+	return lifetimes
+}
+
+// longest returns the longest lifetime any token can be issued with
+func (p tokenLifetimePolicy) longest() time.Duration {
+	longest := max(p.defaults.Access, p.defaults.Refresh)
+	for _, override := range p.overrides {
+		longest = max(longest, override.Access, override.Refresh)
+	}
+	return longest
+}
+
+// applyLifetimeOverride replaces the non-zero fields of an override
+func applyLifetimeOverride(lifetimes, override config.TokenLifetimes) config.TokenLifetimes {
+	if override.Access > 0 {
+		lifetimes.Access = override.Access
+	}
+	if override.Refresh > 0 {
+		lifetimes.Refresh = override.Refresh
+	}
+	if override.SessionMax > 0 {
+		lifetimes.SessionMax = override.SessionMax
+	}
+	return lifetimes
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"tt-stock-api/internal/config"
+)
+
+func TestTokenLifetimePolicy_Resolve(t *testing.T) {
+	policy := newTokenLifetimePolicy(&config.Config{
+		TokenLifetimes: config.TokenLifetimes{
+			Access:     15 * time.Minute,
+			Refresh:    24 * time.Hour,
+			SessionMax: 7 * 24 * time.Hour,
+		},
+		TokenLifetimeOverrides: map[string]config.TokenLifetimes{
+			"role:owner":            {Refresh: 30 * 24 * time.Hour, SessionMax: 90 * 24 * time.Hour},
+			"client:pos":            {Access: 12 * time.Hour, SessionMax: 12 * time.Hour},
+			"role:owner,client:pos": {SessionMax: 14 * time.Hour},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		role       string
+		clientType string
+		expected   config.TokenLifetimes
+	}{
+		{
+			name:     "Defaults without overrides",
+			role:     "staff",
+			expected: config.TokenLifetimes{Access: 15 * time.Minute, Refresh: 24 * time.Hour, SessionMax: 7 * 24 * time.Hour},
+		},
+		{
+			name:     "Role override replaces only its fields",
+			role:     "owner",
+			expected: config.TokenLifetimes{Access: 15 * time.Minute, Refresh: 30 * 24 * time.Hour, SessionMax: 90 * 24 * time.Hour},
+		},
+		{
+			name:       "Client type override wins over role override",
+			role:       "staff",
+			clientType: "pos",
+			expected:   config.TokenLifetimes{Access: 12 * time.Hour, Refresh: 24 * time.Hour, SessionMax: 12 * time.Hour},
+		},
+		{
+			name:       "Combined override is the most specific",
+			role:       "owner",
+			clientType: "pos",
+			expected:   config.TokenLifetimes{Access: 12 * time.Hour, Refresh: 30 * 24 * time.Hour, SessionMax: 14 * time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, policy.resolve(tt.role, tt.clientType))
+		})
+	}
+
+	// Keys must keep verifying for as long as the longest-lived token
+	assert.Equal(t, 30*24*time.Hour, policy.longest())
+}
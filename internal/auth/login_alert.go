@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/config"
+	"tt-stock-api/internal/notify"
+	"tt-stock-api/internal/phone"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/user"
+)
+
+// Login alert reasons, also the names of the rules in LOGIN_ALERT_RULES
+const (
+	AlertReasonNewDevice    = "new_device"    // First login of the user from this device
+	AlertReasonNewIP        = "new_ip"        // First login of the user from this IP address
+	AlertReasonOutsideHours = "outside_hours" // Login outside the shop's opening hours
+)
+
+// Login alert page sizes
+const (
+	defaultLoginAlertPageSize = 50
+	maxLoginAlertPageSize     = 200
+)
+
+// loginAlertConfig holds the rules that raise an alert on login
+type loginAlertConfig struct {
+	rules        map[string]bool
+	openingHours *config.OpeningHours // nil disables the outside_hours rule
+	location     *time.Location       // Time zone of the opening hours
+}
+
+// newLoginAlertConfig builds the login alert rules from the configuration
+// An unknown time zone falls back to the server's; ValidateEnvironment reports it
+func newLoginAlertConfig(cfg *config.Config) loginAlertConfig {
+	location, err := time.LoadLocation(cfg.ShopTimezone)
+	if err != nil {
+		location = time.Local
+	}
+
+	rules := make(map[string]bool, len(cfg.LoginAlertRules))
+	for _, rule := range cfg.LoginAlertRules {
+		rules[rule] = true
+	}
+
+	return loginAlertConfig{
+		rules:        rules,
+		openingHours: cfg.ShopOpeningHours,
+		location:     location,
+	}
+}
+
+// loginAlertReasons applies the login alert rules to a login that is about to succeed
+// The new device and new IP rules only apply once the user has logged in before, so a
+// user's very first login raises no alert for them.
+func (s *service) loginAlertReasons(u *user.User, client ClientInfo, now time.Time) []string {
+	var reasons []string
+
+	if s.alerts.rules[AlertReasonNewDevice] || s.alerts.rules[AlertReasonNewIP] {
+		history, err := s.authEventRepo.LoginHistory(u.ID, client.IPAddress, client.DeviceID)
+		if err != nil {
+			// Log error but don't fail the login
+			// In a real application, you'd use a proper logger here
+		} else if history.HasLogins {
+			if s.alerts.rules[AlertReasonNewDevice] && client.DeviceID != "" && !history.KnownDevice {
+				reasons = append(reasons, AlertReasonNewDevice)
+			}
+			if s.alerts.rules[AlertReasonNewIP] && client.IPAddress != "" && !history.KnownIP {
+				reasons = append(reasons, AlertReasonNewIP)
+			}
+		}
+	}
+
+	if s.alerts.rules[AlertReasonOutsideHours] && s.alerts.openingHours != nil &&
+		!s.alerts.openingHours.Contains(now.In(s.alerts.location)) {
+		reasons = append(reasons, AlertReasonOutsideHours)
+	}
+
+	return reasons
+}
+
+// raiseLoginAlert records an alert for an unusual login and notifies the user and the shop owners
+// Failures are ignored so they never fail the login
+func (s *service) raiseLoginAlert(u *user.User, sessionID uuid.UUID, client ClientInfo, reasons []string, now time.Time) {
+	alert := &LoginAlert{
+		UserID:      u.ID,
+		PhoneNumber: u.PhoneNumber,
+		Reasons:     reasons,
+		SessionID:   &sessionID,
+		IPAddress:   client.IPAddress,
+		UserAgent:   client.UserAgent,
+		DeviceID:    client.DeviceID,
+		CreatedAt:   now,
+	}
+	if err := s.alertRepo.CreateAlert(alert); err != nil {
+		// Log error but still notify
+		// In a real application, you'd use a proper logger here
+	}
+
+	description := describeLoginAlert(alert, s.alerts.location)
+	s.notifyLoginAlert(notify.Message{
+		PhoneNumber: u.PhoneNumber,
+		Subject:     "Unusual login to your account",
+		Body:        fmt.Sprintf("TT Stock: new login to your account %s. If this wasn't you, change your PIN and end the session in the app.", description),
+	})
+
+	owners, err := s.userRepo.FindByRole(user.RoleOwner)
+	if err != nil {
+		// Log error but don't fail the login
+		// In a real application, you'd use a proper logger here
+		return
+	}
+	for _, owner := range owners {
+		if owner.ID == u.ID {
+			continue
+		}
+		s.notifyLoginAlert(notify.Message{
+			PhoneNumber: owner.PhoneNumber,
+			Subject:     fmt.Sprintf("Unusual login by %s", phone.FormatLocal(u.PhoneNumber)),
+			Body:        fmt.Sprintf("TT Stock: %s (%s) logged in %s. Review the alert in the app.", phone.FormatLocal(u.PhoneNumber), u.Role, description),
+		})
+	}
+}
+
+// notifyLoginAlert sends one login alert notification
+func (s *service) notifyLoginAlert(msg notify.Message) {
+	if err := s.notifier.Notify(msg); err != nil {
+		// Log error but don't fail the login
+		// In a real application, you'd use a proper logger here
+	}
+}
+
+// describeLoginAlert describes what made a login unusual, e.g.
+// "from a new device (phone-7f3a9c21), outside opening hours at 2024-01-01 22:15"
+func describeLoginAlert(alert *LoginAlert, location *time.Location) string {
+	parts := make([]string, 0, len(alert.Reasons))
+	for _, reason := range alert.Reasons {
+		switch reason {
+		case AlertReasonNewDevice:
+			parts = append(parts, fmt.Sprintf("from a new device (%s)", alert.DeviceID))
+		case AlertReasonNewIP:
+			parts = append(parts, fmt.Sprintf("from a new IP address (%s)", alert.IPAddress))
+		case AlertReasonOutsideHours:
+			parts = append(parts, "outside opening hours")
+		}
+	}
+
+	return fmt.Sprintf("%s at %s", strings.Join(parts, ", "), alert.CreatedAt.In(location).Format("2006-01-02 15:04"))
+}
+
+// canReviewAllLoginAlerts reports whether the user may see and review the alerts of every user
+// Everyone else only sees their own
+func (s *service) canReviewAllLoginAlerts(claims *Claims) (bool, error) {
+	err := s.authorizer.Authorize(context.Background(), SubjectFromClaims(claims), policy.PermissionLoginAlertReview, nil)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, policy.ErrDenied) {
+		return false, nil
+	}
+	return false, errors.New("failed to check permissions")
+}
+
+// ListLoginAlerts returns one page of login alerts, newest first
+// Users without the login_alert.review permission only get their own alerts, whatever the filter says
+func (s *service) ListLoginAlerts(claims *Claims, filter LoginAlertFilter, page, pageSize int) (*LoginAlertPage, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	all, err := s.canReviewAllLoginAlerts(claims)
+	if err != nil {
+		return nil, err
+	}
+	if !all {
+		filter.UserID = claims.UserID
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > maxLoginAlertPageSize {
+		pageSize = defaultLoginAlertPageSize
+	}
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	alerts, total, err := s.alertRepo.ListAlerts(filter)
+	if err != nil {
+		return nil, errors.New("failed to list login alerts")
+	}
+
+	return &LoginAlertPage{
+		Alerts:   alerts,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	}, nil
+}
+
+// ReviewLoginAlert marks a login alert as reviewed by the user of the claims
+// Users may review their own alerts; reviewing others' requires the login_alert.review permission.
+// Returns ErrLoginAlertNotFound for alerts the user may not see.
+func (s *service) ReviewLoginAlert(claims *Claims, alertID uuid.UUID) (*LoginAlert, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	alert, err := s.alertRepo.FindAlert(alertID)
+	if err != nil {
+		return nil, errors.New("failed to find login alert")
+	}
+	if alert == nil {
+		return nil, ErrLoginAlertNotFound
+	}
+
+	if alert.UserID != claims.UserID {
+		all, err := s.canReviewAllLoginAlerts(claims)
+		if err != nil {
+			return nil, err
+		}
+		if !all {
+			return nil, ErrLoginAlertNotFound
+		}
+	}
+
+	reviewed, err := s.alertRepo.MarkReviewed(alertID, claims.UserID)
+	if err != nil {
+		return nil, errors.New("failed to review login alert")
+	}
+	if reviewed == nil {
+		return nil, ErrLoginAlertNotFound
+	}
+
+	return reviewed, nil
+}
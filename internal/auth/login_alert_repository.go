@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// LoginAlertRepository defines the interface for login alert storage
+type LoginAlertRepository interface {
+	CreateAlert(alert *LoginAlert) error
+	FindAlert(id uuid.UUID) (*LoginAlert, error)
+	ListAlerts(filter LoginAlertFilter) ([]LoginAlert, int, error)
+	MarkReviewed(id, reviewerID uuid.UUID) (*LoginAlert, error)
+}
+
+// loginAlertRepository implements the LoginAlertRepository interface
+type loginAlertRepository struct {
+	db *db.DB
+}
+
+// NewLoginAlertRepository creates a new login alert repository instance
+func NewLoginAlertRepository(database *db.DB) LoginAlertRepository {
+	return &loginAlertRepository{
+		db: database,
+	}
+}
+
+// loginAlertColumns are the columns read by scanLoginAlert
+const loginAlertColumns = `id, user_id, phone_number, reasons, session_id, ip_address, user_agent, device_id, created_at, reviewed_at, reviewed_by`
+
+// CreateAlert stores a new login alert
+func (r *loginAlertRepository) CreateAlert(alert *LoginAlert) error {
+	if alert == nil || alert.UserID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if len(alert.Reasons) == 0 {
+		return errors.New("reasons cannot be empty")
+	}
+
+	reasons, err := json.Marshal(alert.Reasons)
+	if err != nil {
+		return fmt.Errorf("failed to encode reasons: %w", err)
+	}
+
+	if alert.ID == uuid.Nil {
+		alert.ID = uuid.New()
+	}
+	if alert.CreatedAt.IsZero() {
+		alert.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO login_alerts (id, user_id, phone_number, reasons, session_id, ip_address, user_agent, device_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err = r.db.Exec(query,
+		alert.ID,
+		alert.UserID,
+		alert.PhoneNumber,
+		reasons,
+		alert.SessionID,
+		alert.IPAddress,
+		alert.UserAgent,
+		alert.DeviceID,
+		alert.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create login alert: %w", err)
+	}
+
+	return nil
+}
+
+// FindAlert retrieves a login alert by ID
+// Returns nil without an error if there is no such alert
+func (r *loginAlertRepository) FindAlert(id uuid.UUID) (*LoginAlert, error) {
+	query := `SELECT ` + loginAlertColumns + ` FROM login_alerts WHERE id = $1`
+
+	alert, err := scanLoginAlert(r.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find login alert: %w", err)
+	}
+
+	return alert, nil
+}
+
+// ListAlerts returns the alerts matching the filter, newest first, together with the
+// number of matching alerts across all pages
+func (r *loginAlertRepository) ListAlerts(filter LoginAlertFilter) ([]LoginAlert, int, error) {
+	var conditions []string
+	var args []any
+
+	if filter.UserID != uuid.Nil {
+		args = append(args, filter.UserID)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if filter.Reviewed != nil {
+		if *filter.Reviewed {
+			conditions = append(conditions, "reviewed_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "reviewed_at IS NULL")
+		}
+	}
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM login_alerts " + whereClause
+	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count login alerts: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM login_alerts
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, loginAlertColumns, whereClause, len(args)+1, len(args)+2)
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list login alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []LoginAlert{}
+	for rows.Next() {
+		alert, err := scanLoginAlert(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan login alert: %w", err)
+		}
+		alerts = append(alerts, *alert)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list login alerts: %w", err)
+	}
+
+	return alerts, total, nil
+}
+
+// MarkReviewed marks an alert as reviewed by a user and returns it
+// An alert that has already been reviewed keeps its first reviewer. Returns nil without an
+// error if there is no such alert.
+func (r *loginAlertRepository) MarkReviewed(id, reviewerID uuid.UUID) (*LoginAlert, error) {
+	if reviewerID == uuid.Nil {
+		return nil, errors.New("reviewer ID cannot be empty")
+	}
+
+	query := `
+		UPDATE login_alerts
+		SET reviewed_at = COALESCE(reviewed_at, $2), reviewed_by = COALESCE(reviewed_by, $3)
+		WHERE id = $1
+		RETURNING ` + loginAlertColumns
+
+	alert, err := scanLoginAlert(r.db.QueryRow(query, id, time.Now(), reviewerID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to mark login alert as reviewed: %w", err)
+	}
+
+	return alert, nil
+}
+
+// scanLoginAlert scans a login alert row selected with loginAlertColumns
+func scanLoginAlert(row interface{ Scan(dest ...any) error }) (*LoginAlert, error) {
+	var alert LoginAlert
+	var reasons []byte
+	var sessionID, reviewedBy uuid.NullUUID
+	var reviewedAt sql.NullTime
+
+	err := row.Scan(
+		&alert.ID,
+		&alert.UserID,
+		&alert.PhoneNumber,
+		&reasons,
+		&sessionID,
+		&alert.IPAddress,
+		&alert.UserAgent,
+		&alert.DeviceID,
+		&alert.CreatedAt,
+		&reviewedAt,
+		&reviewedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(reasons, &alert.Reasons); err != nil {
+		return nil, fmt.Errorf("failed to decode reasons: %w", err)
+	}
+	if sessionID.Valid {
+		alert.SessionID = &sessionID.UUID
+	}
+	if reviewedAt.Valid {
+		alert.ReviewedAt = &reviewedAt.Time
+	}
+	if reviewedBy.Valid {
+		alert.ReviewedBy = &reviewedBy.UUID
+	}
+
+	return &alert, nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tt-stock-api/internal/db"
+)
+
+var loginAlertRowColumns = []string{"id", "user_id", "phone_number", "reasons", "session_id", "ip_address", "user_agent", "device_id", "created_at", "reviewed_at", "reviewed_by"}
+
+func TestLoginAlertRepository_CreateAlert(t *testing.T) {
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	tests := []struct {
+		name        string
+		alert       *LoginAlert
+		setupMock   func(mock sqlmock.Sqlmock)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "stores the alert with its reasons",
+			alert: &LoginAlert{
+				UserID:      userID,
+				PhoneNumber: "0812345678",
+				Reasons:     []string{AlertReasonNewDevice, AlertReasonOutsideHours},
+				SessionID:   &sessionID,
+				IPAddress:   "203.0.113.7",
+				DeviceID:    "phone-1",
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO login_alerts`).
+					WithArgs(sqlmock.AnyArg(), userID, "0812345678", []byte(`["new_device","outside_hours"]`), &sessionID, "203.0.113.7", "", "phone-1", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:  "database error",
+			alert: &LoginAlert{UserID: userID, Reasons: []string{AlertReasonNewIP}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO login_alerts`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			expectError: true,
+			errorMsg:    "failed to create login alert",
+		},
+		{
+			name:  "missing reasons",
+			alert: &LoginAlert{UserID: userID},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				// No mock setup needed as validation happens before query
+			},
+			expectError: true,
+			errorMsg:    "reasons cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+
+			repo := NewLoginAlertRepository(&db.DB{DB: mockDB})
+			err = repo.CreateAlert(tt.alert)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEqual(t, uuid.Nil, tt.alert.ID)
+				assert.False(t, tt.alert.CreatedAt.IsZero())
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestLoginAlertRepository_ListAlerts(t *testing.T) {
+	alertID := uuid.New()
+	userID := uuid.New()
+	createdAt := time.Date(2024, 1, 1, 22, 15, 0, 0, time.UTC)
+	open := false
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM login_alerts WHERE user_id = \$1 AND reviewed_at IS NULL`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	rows := sqlmock.NewRows(loginAlertRowColumns).
+		AddRow(alertID, userID, "0812345678", []byte(`["outside_hours"]`), nil, "203.0.113.7", "tt-stock-app/1.0", "", createdAt, nil, nil)
+	mock.ExpectQuery(`FROM login_alerts\s+WHERE user_id = \$1 AND reviewed_at IS NULL\s+ORDER BY created_at DESC, id DESC\s+LIMIT \$2 OFFSET \$3`).
+		WithArgs(userID, 50, 0).
+		WillReturnRows(rows)
+
+	repo := NewLoginAlertRepository(&db.DB{DB: mockDB})
+
+	alerts, total, err := repo.ListAlerts(LoginAlertFilter{UserID: userID, Reviewed: &open, Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []LoginAlert{{
+		ID:          alertID,
+		UserID:      userID,
+		PhoneNumber: "0812345678",
+		Reasons:     []string{AlertReasonOutsideHours},
+		IPAddress:   "203.0.113.7",
+		UserAgent:   "tt-stock-app/1.0",
+		CreatedAt:   createdAt,
+	}}, alerts)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginAlertRepository_MarkReviewed(t *testing.T) {
+	alertID := uuid.New()
+	userID := uuid.New()
+	reviewerID := uuid.New()
+	createdAt := time.Date(2024, 1, 1, 22, 15, 0, 0, time.UTC)
+	reviewedAt := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		setupMock   func(mock sqlmock.Sqlmock)
+		expected    *LoginAlert
+		expectError bool
+	}{
+		{
+			name: "marks the alert as reviewed",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(loginAlertRowColumns).
+					AddRow(alertID, userID, "0812345678", []byte(`["new_ip"]`), nil, "203.0.113.7", "", "", createdAt, reviewedAt, reviewerID)
+				mock.ExpectQuery(`UPDATE login_alerts\s+SET reviewed_at = COALESCE\(reviewed_at, \$2\), reviewed_by = COALESCE\(reviewed_by, \$3\)\s+WHERE id = \$1`).
+					WithArgs(alertID, sqlmock.AnyArg(), reviewerID).
+					WillReturnRows(rows)
+			},
+			expected: &LoginAlert{
+				ID:          alertID,
+				UserID:      userID,
+				PhoneNumber: "0812345678",
+				Reasons:     []string{AlertReasonNewIP},
+				IPAddress:   "203.0.113.7",
+				CreatedAt:   createdAt,
+				ReviewedAt:  &reviewedAt,
+				ReviewedBy:  &reviewerID,
+			},
+		},
+		{
+			name: "unknown alert",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`UPDATE login_alerts`).
+					WithArgs(alertID, sqlmock.AnyArg(), reviewerID).
+					WillReturnError(sql.ErrNoRows)
+			},
+		},
+		{
+			name: "database error",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`UPDATE login_alerts`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+
+			repo := NewLoginAlertRepository(&db.DB{DB: mockDB})
+			alert, err := repo.MarkReviewed(alertID, reviewerID)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "failed to mark login alert as reviewed")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, alert)
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
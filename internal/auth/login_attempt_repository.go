@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tt-stock-api/internal/db"
+)
+
+// LoginAttemptRepository defines the interface for failed login attempt tracking
+type LoginAttemptRepository interface {
+	GetThrottle(key string) (*LoginThrottle, error)
+	RecordFailure(key string, windowStart time.Time) (*LoginThrottle, error)
+	LockUntil(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// loginAttemptRepository implements the LoginAttemptRepository interface
+type loginAttemptRepository struct {
+	db *db.DB
+}
+
+// NewLoginAttemptRepository creates a new login attempt repository instance
+func NewLoginAttemptRepository(database *db.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{
+		db: database,
+	}
+}
+
+// GetThrottle retrieves the throttle state for a key
+// Returns nil without an error if no failures have been recorded for the key
+func (r *loginAttemptRepository) GetThrottle(key string) (*LoginThrottle, error) {
+	if key == "" {
+		return nil, errors.New("throttle key cannot be empty")
+	}
+
+	query := `
+		SELECT throttle_key, failed_count, last_failed_at, locked_until
+		FROM login_throttles
+		WHERE throttle_key = $1
+	`
+
+	throttle, err := scanLoginThrottle(r.db.QueryRow(query, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query login throttle: %w", err)
+	}
+
+	return throttle, nil
+}
+
+// RecordFailure increments the failure counter for a key and returns the updated state
+// The counter starts over if the previous failure happened before windowStart
+func (r *loginAttemptRepository) RecordFailure(key string, windowStart time.Time) (*LoginThrottle, error) {
+	if key == "" {
+		return nil, errors.New("throttle key cannot be empty")
+	}
+
+	query := `
+		INSERT INTO login_throttles (throttle_key, failed_count, last_failed_at, updated_at)
+		VALUES ($1, 1, $2, $2)
+		ON CONFLICT (throttle_key) DO UPDATE SET
+			failed_count = CASE
+				WHEN login_throttles.last_failed_at < $3 THEN 1
+				ELSE login_throttles.failed_count + 1
+			END,
+			last_failed_at = $2,
+			updated_at = $2
+		RETURNING throttle_key, failed_count, last_failed_at, locked_until
+	`
+
+	now := time.Now()
+	throttle, err := scanLoginThrottle(r.db.QueryRow(query, key, now, windowStart))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	return throttle, nil
+}
+
+// LockUntil locks a key until the given time
+func (r *loginAttemptRepository) LockUntil(key string, until time.Time) error {
+	if key == "" {
+		return errors.New("throttle key cannot be empty")
+	}
+
+	query := `
+		UPDATE login_throttles
+		SET locked_until = $1, updated_at = $2
+		WHERE throttle_key = $3
+	`
+
+	_, err := r.db.Exec(query, until, time.Now(), key)
+	if err != nil {
+		return fmt.Errorf("failed to lock throttle key: %w", err)
+	}
+
+	return nil
+}
+
+// Reset clears all recorded failures and any lock for a key
+func (r *loginAttemptRepository) Reset(key string) error {
+	if key == "" {
+		return errors.New("throttle key cannot be empty")
+	}
+
+	query := `DELETE FROM login_throttles WHERE throttle_key = $1`
+
+	_, err := r.db.Exec(query, key)
+	if err != nil {
+		return fmt.Errorf("failed to reset login throttle: %w", err)
+	}
+
+	return nil
+}
+
+// scanLoginThrottle scans a single login_throttles row
+func scanLoginThrottle(row *sql.Row) (*LoginThrottle, error) {
+	var throttle LoginThrottle
+	var lockedUntil sql.NullTime
+
+	if err := row.Scan(&throttle.Key, &throttle.FailedCount, &throttle.LastFailedAt, &lockedUntil); err != nil {
+		return nil, err
+	}
+
+	// Handle nullable locked_until field
+	if lockedUntil.Valid {
+		throttle.LockedUntil = &lockedUntil.Time
+	}
+
+	return &throttle, nil
+}
@@ -1,14 +1,40 @@
 package auth
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/user"
 	"tt-stock-api/pkg/response"
 )
 
+// DeviceIDHeader is the request header in which clients send their device ID
+// Tokens issued to a device are only accepted together with the same device ID
+const DeviceIDHeader = "X-Device-ID"
+
+// APIKeyHeader is the request header in which service accounts send their API key
+const APIKeyHeader = "X-API-Key"
+
 // JWTProtected creates a middleware function that validates JWT tokens for protected routes
+// It also checks the user's current account status, so the tokens of suspended and locked users
+// stop working within USER_STATUS_CACHE_TTL. Tokens of users who must still change their PIN are
+// rejected; see PinChangeProtected.
 func JWTProtected(authService Service) fiber.Handler {
+	return jwtProtected(authService, false)
+}
+
+// PinChangeProtected works like JWTProtected but also accepts the tokens of users whose account
+// is pending_pin_change. It is meant for the routes such a user needs:
+// changing the PIN and logging out.
+func PinChangeProtected(authService Service) fiber.Handler {
+	return jwtProtected(authService, true)
+}
+
+// jwtProtected creates the token validation middleware, optionally letting through tokens
+// that are only good for changing the PIN
+func jwtProtected(authService Service, allowPinChange bool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Extract token from Authorization header
 		authHeader := c.Get("Authorization")
@@ -30,18 +56,47 @@ func JWTProtected(authService Service) fiber.Handler {
 		// Validate the token
 		claims, err := authService.ValidateToken(token)
 		if err != nil {
-			if strings.Contains(err.Error(), "expired") {
+			switch {
+			case errors.Is(err, ErrTokenExpired):
 				return response.SendTokenExpiredError(c, "Access token has expired")
+			case errors.Is(err, ErrTokenRevoked):
+				return response.SendUnauthorizedError(c, response.CodeTokenRevoked, "Token has been invalidated")
+			case errors.Is(err, ErrInvalidToken):
+				return response.SendUnauthorizedError(c, response.CodeInvalidToken, "Invalid access token")
+			default:
+				// Not a problem with the token itself (e.g. the blacklist could not be checked)
+				return response.SendInternalServerError(c, "Failed to validate access token")
 			}
-			if strings.Contains(err.Error(), "invalidated") {
-				return response.SendAuthenticationError(c, "Token has been invalidated")
-			}
-			return response.SendAuthenticationError(c, "Invalid access token")
 		}
 
 		// Ensure this is an access token (not a refresh token)
 		if claims.TokenType != "access" {
-			return response.SendAuthenticationError(c, "Invalid token type: access token required")
+			return response.SendUnauthorizedError(c, response.CodeWrongTokenType, "Invalid token type: access token required")
+		}
+
+		// Ensure a device-bound token is used from its device
+		if claims.DeviceID != "" && c.Get(DeviceIDHeader) != claims.DeviceID {
+			return response.SendUnauthorizedError(c, response.CodeDeviceMismatch, "Token is bound to another device")
+		}
+
+		// The account may have been suspended or locked since the token was issued
+		status, err := authService.AccountStatus(claims.UserID)
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				return response.SendUnauthorizedError(c, response.CodeInvalidToken, "Account no longer exists")
+			}
+			return response.SendInternalServerError(c, "Failed to check account status")
+		}
+		switch err := accountStatusError(status); {
+		case errors.Is(err, ErrAccountSuspended):
+			return response.SendForbiddenError(c, response.CodeAccountSuspended, "This account has been suspended")
+		case err != nil:
+			return response.SendForbiddenError(c, response.CodeAccountDisabled, "This account has been locked by an administrator")
+		}
+
+		// A user with a PIN given by an administrator, or reset by one, must choose their own first
+		if status == user.StatusPendingPinChange && !allowPinChange {
+			return response.SendForbiddenError(c, response.CodePinChangeRequired, "PIN must be changed before continuing")
 		}
 
 		// Add user information to context for use in handlers
@@ -54,6 +109,130 @@ func JWTProtected(authService Service) fiber.Handler {
 	}
 }
 
+// APIKeyProtected creates a middleware function that authenticates service accounts by the
+// API key in the X-API-Key header
+// It sets the same context values as JWTProtected: user_id is the service account's ID,
+// phone_number is empty, and token_claims has token type api_key with the key's permissions
+// as Scopes, so RequirePermission checks those permissions.
+func APIKeyProtected(authService Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(APIKeyHeader)
+		if key == "" {
+			return response.SendAuthenticationError(c, "API key is required")
+		}
+
+		claims, err := authService.AuthenticateAPIKey(key, clientInfo(c, ""))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrAPIKeyExpired):
+				return response.SendUnauthorizedError(c, response.CodeAPIKeyExpired, "API key has expired")
+			case errors.Is(err, ErrInvalidAPIKey):
+				return response.SendUnauthorizedError(c, response.CodeInvalidAPIKey, "Invalid API key")
+			default:
+				return response.SendInternalServerError(c, "Failed to validate API key")
+			}
+		}
+
+		c.Locals("user_id", claims.UserID.String())
+		c.Locals("phone_number", claims.PhoneNumber)
+		c.Locals("token_claims", claims)
+
+		return c.Next()
+	}
+}
+
+// OAuthClientProtected creates a middleware function for the OAuth endpoints: it authenticates
+// service accounts like APIKeyProtected and requires the permission like RequirePermission, but
+// answers failures in the RFC 6749 error format that OAuth clients understand
+func OAuthClientProtected(authService Service, authorizer policy.Authorizer, permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(APIKeyHeader)
+		if key == "" {
+			return sendOAuthError(c, fiber.StatusUnauthorized, oauthErrorInvalidClient, "API key is required")
+		}
+
+		claims, err := authService.AuthenticateAPIKey(key, clientInfo(c, ""))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrAPIKeyExpired):
+				return sendOAuthError(c, fiber.StatusUnauthorized, oauthErrorInvalidClient, "API key has expired")
+			case errors.Is(err, ErrInvalidAPIKey):
+				return sendOAuthError(c, fiber.StatusUnauthorized, oauthErrorInvalidClient, "Invalid API key")
+			default:
+				return sendOAuthError(c, fiber.StatusInternalServerError, oauthErrorServerError, "Failed to validate API key")
+			}
+		}
+
+		err = authorizer.Authorize(c.UserContext(), SubjectFromClaims(claims), permission, nil)
+		if errors.Is(err, policy.ErrDenied) {
+			return sendOAuthError(c, fiber.StatusBadRequest, oauthErrorUnauthorizedClient, "The API key does not have the "+permission+" permission")
+		}
+		if err != nil {
+			return sendOAuthError(c, fiber.StatusInternalServerError, oauthErrorServerError, "Failed to check permissions")
+		}
+
+		c.Locals("user_id", claims.UserID.String())
+		c.Locals("phone_number", claims.PhoneNumber)
+		c.Locals("token_claims", claims)
+
+		return c.Next()
+	}
+}
+
+// JWTOrAPIKeyProtected creates a middleware function for routes open to both users and
+// service accounts. Requests with an X-API-Key header are authenticated like APIKeyProtected,
+// all others like JWTProtected.
+func JWTOrAPIKeyProtected(authService Service) fiber.Handler {
+	jwtHandler := JWTProtected(authService)
+	apiKeyHandler := APIKeyProtected(authService)
+
+	return func(c *fiber.Ctx) error {
+		if c.Get(APIKeyHeader) != "" {
+			return apiKeyHandler(c)
+		}
+		return jwtHandler(c)
+	}
+}
+
+// RequirePermission creates a middleware function that only lets users through who hold all of
+// the given permissions under the authorizer's policy
+// Conditions on the resource cannot be checked here; handlers authorize the actual resource
+// with the authorizer once it is known.
+// It reads the claims set by JWTProtected or APIKeyProtected, so it must come after one of them
+func RequirePermission(authorizer policy.Authorizer, permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := ExtractClaimsFromContext(c)
+		if !ok {
+			return response.SendAuthenticationError(c, "Failed to extract user information")
+		}
+
+		subject := SubjectFromClaims(claims)
+		for _, permission := range permissions {
+			err := authorizer.Authorize(c.UserContext(), subject, permission, nil)
+			if errors.Is(err, policy.ErrDenied) {
+				return response.SendForbiddenError(c, response.CodeForbidden, "You do not have permission to access this resource")
+			}
+			if err != nil {
+				return response.SendInternalServerError(c, "Failed to check permissions")
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+// SubjectFromClaims returns the policy subject for the user a token was issued to, or for the
+// service account whose API key authenticated the request
+func SubjectFromClaims(claims *Claims) policy.Subject {
+	return policy.Subject{
+		UserID:         claims.UserID,
+		Role:           claims.Role,
+		Branch:         claims.Branch,
+		ServiceAccount: claims.TokenType == TokenTypeAPIKey,
+		Permissions:    claims.Scopes,
+	}
+}
+
 // ExtractUserFromContext extracts user information from the Fiber context
 // This is a helper function for handlers to get user info from protected routes
 func ExtractUserFromContext(c *fiber.Ctx) (userID string, phoneNumber string, ok bool) {
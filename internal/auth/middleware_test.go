@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http/httptest"
 	"testing"
@@ -12,6 +14,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/user"
 	"tt-stock-api/pkg/response"
 )
 
@@ -147,6 +152,7 @@ func TestJWTProtected_ValidToken(t *testing.T) {
 	claims := createValidClaims(userID, phoneNumber, "access", time.Now().Add(15*time.Minute))
 
 	mockService.On("ValidateToken", token).Return(claims, nil)
+	mockService.On("AccountStatus", userID).Return(user.StatusActive, nil)
 
 	req := httptest.NewRequest("GET", "/protected", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -174,7 +180,7 @@ func TestJWTProtected_InvalidToken(t *testing.T) {
 
 	token := "invalid.jwt.token"
 
-	mockService.On("ValidateToken", token).Return(nil, errors.New("invalid token"))
+	mockService.On("ValidateToken", token).Return(nil, ErrInvalidToken)
 
 	req := httptest.NewRequest("GET", "/protected", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -190,7 +196,7 @@ func TestJWTProtected_InvalidToken(t *testing.T) {
 	err = json.Unmarshal(body, &errorResp)
 	assert.NoError(t, err)
 	assert.False(t, errorResp.Success)
-	assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+	assert.Equal(t, "INVALID_TOKEN", errorResp.Error.Code)
 	assert.Equal(t, "Invalid access token", errorResp.Error.Message)
 
 	mockService.AssertExpectations(t)
@@ -202,7 +208,7 @@ func TestJWTProtected_ExpiredToken(t *testing.T) {
 
 	token := "expired.jwt.token"
 
-	mockService.On("ValidateToken", token).Return(nil, errors.New("token has expired"))
+	mockService.On("ValidateToken", token).Return(nil, ErrTokenExpired)
 
 	req := httptest.NewRequest("GET", "/protected", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -230,7 +236,7 @@ func TestJWTProtected_BlacklistedToken(t *testing.T) {
 
 	token := "blacklisted.jwt.token"
 
-	mockService.On("ValidateToken", token).Return(nil, errors.New("token has been invalidated"))
+	mockService.On("ValidateToken", token).Return(nil, ErrTokenRevoked)
 
 	req := httptest.NewRequest("GET", "/protected", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -246,7 +252,7 @@ func TestJWTProtected_BlacklistedToken(t *testing.T) {
 	err = json.Unmarshal(body, &errorResp)
 	assert.NoError(t, err)
 	assert.False(t, errorResp.Success)
-	assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+	assert.Equal(t, "TOKEN_REVOKED", errorResp.Error.Code)
 	assert.Equal(t, "Token has been invalidated", errorResp.Error.Message)
 
 	mockService.AssertExpectations(t)
@@ -279,36 +285,179 @@ func TestJWTProtected_RefreshTokenInsteadOfAccessToken(t *testing.T) {
 	err = json.Unmarshal(body, &errorResp)
 	assert.NoError(t, err)
 	assert.False(t, errorResp.Success)
-	assert.Equal(t, "AUTHENTICATION_ERROR", errorResp.Error.Code)
+	assert.Equal(t, "WRONG_TOKEN_TYPE", errorResp.Error.Code)
 	assert.Equal(t, "Invalid token type: access token required", errorResp.Error.Message)
 
 	mockService.AssertExpectations(t)
 }
 
+func TestJWTProtected_DeviceBoundToken(t *testing.T) {
+	userID := uuid.New()
+	token := "device.jwt.token"
+	claims := createValidClaims(userID, "0812345678", "access", time.Now().Add(15*time.Minute))
+	claims.DeviceID = "tablet-0001"
+
+	tests := []struct {
+		name           string
+		deviceID       string
+		expectedStatus int
+	}{
+		{name: "Same device", deviceID: "tablet-0001", expectedStatus: fiber.StatusOK},
+		{name: "Other device", deviceID: "tablet-0002", expectedStatus: fiber.StatusUnauthorized},
+		{name: "No device header", deviceID: "", expectedStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &MockAuthService{}
+			app := createTestApp(mockService)
+			mockService.On("ValidateToken", token).Return(claims, nil).Once()
+			if tt.expectedStatus == fiber.StatusOK {
+				mockService.On("AccountStatus", userID).Return(user.StatusActive, nil).Once()
+			}
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if tt.deviceID != "" {
+				req.Header.Set(DeviceIDHeader, tt.deviceID)
+			}
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedStatus == fiber.StatusUnauthorized {
+				body, _ := io.ReadAll(resp.Body)
+				var errorResp response.ErrorResponse
+				assert.NoError(t, json.Unmarshal(body, &errorResp))
+				assert.Equal(t, "DEVICE_MISMATCH", errorResp.Error.Code)
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPinChangeProtected(t *testing.T) {
+	userID := uuid.New()
+	token := "first.login.token"
+	claims := createValidClaims(userID, "0812345678", "access", time.Now().Add(15*time.Minute))
+	claims.PinChangeRequired = true
+
+	mockService := &MockAuthService{}
+	app := createTestApp(mockService)
+	app.Put("/auth/pin", PinChangeProtected(mockService), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "success"})
+	})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{name: "PIN change is allowed", method: "PUT", path: "/auth/pin", expectedStatus: fiber.StatusOK},
+		{name: "Other routes are blocked", method: "GET", path: "/protected", expectedStatus: fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.On("ValidateToken", token).Return(claims, nil).Once()
+			mockService.On("AccountStatus", userID).Return(user.StatusPendingPinChange, nil).Once()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedStatus == fiber.StatusForbidden {
+				body, _ := io.ReadAll(resp.Body)
+				var errorResp response.ErrorResponse
+				assert.NoError(t, json.Unmarshal(body, &errorResp))
+				assert.Equal(t, "PIN_CHANGE_REQUIRED", errorResp.Error.Code)
+			}
+		})
+	}
+
+	mockService.AssertExpectations(t)
+}
+
+func TestJWTProtected_AccountStatus(t *testing.T) {
+	userID := uuid.New()
+	token := "valid.jwt.token"
+	claims := createValidClaims(userID, "0812345678", "access", time.Now().Add(15*time.Minute))
+
+	tests := []struct {
+		name           string
+		status         string
+		statusErr      error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "Active account", status: user.StatusActive, expectedStatus: fiber.StatusOK},
+		{name: "Suspended account", status: user.StatusSuspended, expectedStatus: fiber.StatusForbidden, expectedCode: "ACCOUNT_SUSPENDED"},
+		{name: "Locked account", status: user.StatusLocked, expectedStatus: fiber.StatusForbidden, expectedCode: "ACCOUNT_DISABLED"},
+		{name: "Pending PIN change", status: user.StatusPendingPinChange, expectedStatus: fiber.StatusForbidden, expectedCode: "PIN_CHANGE_REQUIRED"},
+		{name: "Deleted account", statusErr: ErrUserNotFound, expectedStatus: fiber.StatusUnauthorized, expectedCode: "INVALID_TOKEN"},
+		{name: "Status lookup fails", statusErr: errors.New("failed to check account status"), expectedStatus: fiber.StatusInternalServerError, expectedCode: "INTERNAL_SERVER_ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &MockAuthService{}
+			app := createTestApp(mockService)
+			mockService.On("ValidateToken", token).Return(claims, nil).Once()
+			mockService.On("AccountStatus", userID).Return(tt.status, tt.statusErr).Once()
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedCode != "" {
+				body, _ := io.ReadAll(resp.Body)
+				var errorResp response.ErrorResponse
+				assert.NoError(t, json.Unmarshal(body, &errorResp))
+				assert.Equal(t, tt.expectedCode, errorResp.Error.Code)
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
 func TestJWTProtected_TokenValidationErrors(t *testing.T) {
 	tests := []struct {
 		name           string
-		validationErr  string
+		validationErr  error
+		expectedStatus int
 		expectedCode   string
 		expectedMsg    string
 	}{
 		{
-			name:          "Generic validation error",
-			validationErr: "malformed token",
-			expectedCode:  "AUTHENTICATION_ERROR",
-			expectedMsg:   "Invalid access token",
+			name:           "Wrapped invalid token error",
+			validationErr:  fmt.Errorf("malformed token: %w", ErrInvalidToken),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedCode:   "INVALID_TOKEN",
+			expectedMsg:    "Invalid access token",
 		},
 		{
-			name:          "Token expired error with different message",
-			validationErr: "jwt token expired",
-			expectedCode:  "TOKEN_EXPIRED",
-			expectedMsg:   "Access token has expired",
+			name:           "Wrapped token expired error",
+			validationErr:  fmt.Errorf("jwt: %w", ErrTokenExpired),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedCode:   "TOKEN_EXPIRED",
+			expectedMsg:    "Access token has expired",
 		},
 		{
-			name:          "Token invalidated error with different message",
-			validationErr: "token has been invalidated by user",
-			expectedCode:  "AUTHENTICATION_ERROR",
-			expectedMsg:   "Token has been invalidated",
+			name:           "Message mentioning expiry is not an expired token",
+			validationErr:  errors.New("blacklist lookup expired"),
+			expectedStatus: fiber.StatusInternalServerError,
+			expectedCode:   "INTERNAL_SERVER_ERROR",
+			expectedMsg:    "Failed to validate access token",
 		},
 	}
 
@@ -319,14 +468,14 @@ func TestJWTProtected_TokenValidationErrors(t *testing.T) {
 
 			token := "test.jwt.token"
 
-			mockService.On("ValidateToken", token).Return(nil, errors.New(tt.validationErr))
+			mockService.On("ValidateToken", token).Return(nil, tt.validationErr)
 
 			req := httptest.NewRequest("GET", "/protected", nil)
 			req.Header.Set("Authorization", "Bearer "+token)
 			resp, err := app.Test(req)
 
 			assert.NoError(t, err)
-			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
 
 			body, err := io.ReadAll(resp.Body)
 			assert.NoError(t, err)
@@ -479,4 +628,262 @@ func TestExtractClaimsFromContext_InvalidContextType(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
-}
\ No newline at end of file
+}
+
+func TestRequirePermission(t *testing.T) {
+	userID := uuid.New()
+	authorizer := policy.NewEngine(policy.NewStaticRepository(
+		policy.Rule{Permission: policy.PermissionPriceOverride, UserID: userID, Effect: policy.EffectDeny},
+	), 0)
+
+	tests := []struct {
+		name           string
+		role           string
+		userID         uuid.UUID
+		authorizer     policy.Authorizer
+		permissions    []string
+		expectedStatus int
+	}{
+		{name: "Owner manages shop devices", role: user.RoleOwner, permissions: []string{policy.PermissionShopDeviceManage}, expectedStatus: fiber.StatusOK},
+		{name: "Staff cannot manage shop devices", role: user.RoleStaff, permissions: []string{policy.PermissionShopDeviceManage}, expectedStatus: fiber.StatusForbidden},
+		{name: "Staff adjusts stock", role: user.RoleStaff, permissions: []string{policy.PermissionStockAdjust}, expectedStatus: fiber.StatusOK},
+		{name: "All permissions are required", role: user.RoleManager, permissions: []string{policy.PermissionPriceOverride, policy.PermissionUserManage}, expectedStatus: fiber.StatusForbidden},
+		{name: "Unknown role", role: "intern", permissions: []string{policy.PermissionStockAdjust}, expectedStatus: fiber.StatusForbidden},
+		{name: "User override denies", role: user.RoleManager, userID: userID, permissions: []string{policy.PermissionPriceOverride}, expectedStatus: fiber.StatusForbidden},
+		{name: "Policy cannot be loaded", role: user.RoleOwner, authorizer: failingAuthorizer{}, permissions: []string{policy.PermissionUserManage}, expectedStatus: fiber.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := createValidClaims(uuid.New(), "0812345678", "access", time.Now().Add(15*time.Minute))
+			claims.Role = tt.role
+			if tt.userID != uuid.Nil {
+				claims.UserID = tt.userID
+			}
+			checker := authorizer
+			if tt.authorizer != nil {
+				checker = tt.authorizer
+			}
+
+			app := fiber.New()
+			app.Get("/resource", withTestClaims(claims), RequirePermission(checker, tt.permissions...), func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/resource", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestRequirePermission_APIKeyScopes(t *testing.T) {
+	authorizer := policy.NewEngine(policy.NewStaticRepository(), 0)
+	claims := &Claims{
+		UserID:    uuid.New(),
+		TokenType: TokenTypeAPIKey,
+		Scopes:    []string{policy.PermissionStockAdjust},
+	}
+
+	app := fiber.New()
+	app.Get("/stock", withTestClaims(claims), RequirePermission(authorizer, policy.PermissionStockAdjust), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Get("/costs", withTestClaims(claims), RequirePermission(authorizer, policy.PermissionReportViewCost), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/stock", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/costs", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+}
+
+// failingAuthorizer fails every check as if the policy rules could not be loaded
+type failingAuthorizer struct{}
+
+func (failingAuthorizer) Authorize(ctx context.Context, subject policy.Subject, action string, resource *policy.Resource) error {
+	return errors.New("failed to load policy rules")
+}
+
+func TestAPIKeyProtected(t *testing.T) {
+	accountID := uuid.New()
+	key := APIKeyPrefix + "valid"
+	claims := &Claims{UserID: accountID, TokenType: TokenTypeAPIKey, Scopes: []string{policy.PermissionStockAdjust}}
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		serviceErr     error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "Valid key", apiKey: key, expectedStatus: fiber.StatusOK},
+		{name: "Missing key", expectedStatus: fiber.StatusUnauthorized, expectedCode: response.CodeAuthenticationError},
+		{name: "Invalid key", apiKey: key, serviceErr: ErrInvalidAPIKey, expectedStatus: fiber.StatusUnauthorized, expectedCode: response.CodeInvalidAPIKey},
+		{name: "Expired key", apiKey: key, serviceErr: ErrAPIKeyExpired, expectedStatus: fiber.StatusUnauthorized, expectedCode: response.CodeAPIKeyExpired},
+		{name: "Key cannot be checked", apiKey: key, serviceErr: errors.New("database down"), expectedStatus: fiber.StatusInternalServerError, expectedCode: response.CodeInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &MockAuthService{}
+			if tt.apiKey != "" {
+				if tt.serviceErr != nil {
+					mockService.On("AuthenticateAPIKey", tt.apiKey, mock.AnythingOfType("auth.ClientInfo")).Return(nil, tt.serviceErr)
+				} else {
+					mockService.On("AuthenticateAPIKey", tt.apiKey, mock.AnythingOfType("auth.ClientInfo")).Return(claims, nil)
+				}
+			}
+
+			app := fiber.New()
+			app.Get("/protected", APIKeyProtected(mockService), func(c *fiber.Ctx) error {
+				userID, phoneNumber, ok := ExtractUserFromContext(c)
+				if !ok {
+					return c.SendStatus(fiber.StatusInternalServerError)
+				}
+				return c.JSON(fiber.Map{"user_id": userID, "phone_number": phoneNumber})
+			})
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			if tt.apiKey != "" {
+				req.Header.Set(APIKeyHeader, tt.apiKey)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			if tt.expectedCode != "" {
+				var errorResp response.ErrorResponse
+				assert.NoError(t, json.Unmarshal(body, &errorResp))
+				assert.Equal(t, tt.expectedCode, errorResp.Error.Code)
+			} else {
+				var result map[string]string
+				assert.NoError(t, json.Unmarshal(body, &result))
+				assert.Equal(t, accountID.String(), result["user_id"])
+				assert.Equal(t, "", result["phone_number"])
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOAuthClientProtected(t *testing.T) {
+	accountID := uuid.New()
+	key := APIKeyPrefix + "valid"
+	authorizer := policy.NewEngine(policy.NewStaticRepository(), 0)
+	introspector := &Claims{UserID: accountID, TokenType: TokenTypeAPIKey, Scopes: []string{policy.PermissionTokenIntrospect}}
+	stockClient := &Claims{UserID: accountID, TokenType: TokenTypeAPIKey, Scopes: []string{policy.PermissionStockAdjust}}
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		claims         *Claims
+		serviceErr     error
+		authorizer     policy.Authorizer
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "Permitted key", apiKey: key, claims: introspector, expectedStatus: fiber.StatusOK},
+		{name: "Missing key", expectedStatus: fiber.StatusUnauthorized, expectedError: "invalid_client"},
+		{name: "Invalid key", apiKey: key, serviceErr: ErrInvalidAPIKey, expectedStatus: fiber.StatusUnauthorized, expectedError: "invalid_client"},
+		{name: "Expired key", apiKey: key, serviceErr: ErrAPIKeyExpired, expectedStatus: fiber.StatusUnauthorized, expectedError: "invalid_client"},
+		{name: "Key cannot be checked", apiKey: key, serviceErr: errors.New("database down"), expectedStatus: fiber.StatusInternalServerError, expectedError: "server_error"},
+		{name: "Key without the permission", apiKey: key, claims: stockClient, expectedStatus: fiber.StatusBadRequest, expectedError: "unauthorized_client"},
+		{name: "Policy cannot be loaded", apiKey: key, claims: introspector, authorizer: failingAuthorizer{}, expectedStatus: fiber.StatusInternalServerError, expectedError: "server_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &MockAuthService{}
+			if tt.apiKey != "" {
+				if tt.serviceErr != nil {
+					mockService.On("AuthenticateAPIKey", tt.apiKey, mock.AnythingOfType("auth.ClientInfo")).Return(nil, tt.serviceErr)
+				} else {
+					mockService.On("AuthenticateAPIKey", tt.apiKey, mock.AnythingOfType("auth.ClientInfo")).Return(tt.claims, nil)
+				}
+			}
+			checker := policy.Authorizer(authorizer)
+			if tt.authorizer != nil {
+				checker = tt.authorizer
+			}
+
+			app := fiber.New()
+			app.Post("/oauth/introspect", OAuthClientProtected(mockService, checker, policy.PermissionTokenIntrospect), func(c *fiber.Ctx) error {
+				userID, _, ok := ExtractUserFromContext(c)
+				if !ok {
+					return c.SendStatus(fiber.StatusInternalServerError)
+				}
+				return c.JSON(fiber.Map{"user_id": userID})
+			})
+
+			req := httptest.NewRequest("POST", "/oauth/introspect", nil)
+			if tt.apiKey != "" {
+				req.Header.Set(APIKeyHeader, tt.apiKey)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			if tt.expectedError != "" {
+				assert.Equal(t, tt.expectedError, body["error"])
+				assert.NotEmpty(t, body["error_description"])
+				assert.NotContains(t, body, "success")
+			} else {
+				assert.Equal(t, accountID.String(), body["user_id"])
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestJWTOrAPIKeyProtected(t *testing.T) {
+	mockService := &MockAuthService{}
+	userID := uuid.New()
+	accountID := uuid.New()
+	token := "valid.jwt.token"
+	key := APIKeyPrefix + "valid"
+
+	userClaims := createValidClaims(userID, "+66812345678", "access", time.Now().Add(15*time.Minute))
+	mockService.On("ValidateToken", token).Return(userClaims, nil)
+	mockService.On("AccountStatus", userID).Return(user.StatusActive, nil)
+	mockService.On("AuthenticateAPIKey", key, mock.AnythingOfType("auth.ClientInfo")).Return(&Claims{UserID: accountID, TokenType: TokenTypeAPIKey}, nil)
+
+	app := fiber.New()
+	app.Get("/protected", JWTOrAPIKeyProtected(mockService), func(c *fiber.Ctx) error {
+		userID, _, _ := ExtractUserFromContext(c)
+		return c.SendString(userID)
+	})
+
+	// A bearer token authenticates the user
+	req := httptest.NewRequest("GET", "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, userID.String(), string(body))
+
+	// An API key authenticates the service account
+	req = httptest.NewRequest("GET", "/protected", nil)
+	req.Header.Set(APIKeyHeader, key)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, accountID.String(), string(body))
+
+	// Without either, a bearer token is asked for
+	resp, err = app.Test(httptest.NewRequest("GET", "/protected", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+
+	mockService.AssertExpectations(t)
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"tt-stock-api/internal/user"
 )
 
 // TokenBlacklist represents a blacklisted token in the system
@@ -14,4 +15,260 @@ type TokenBlacklist struct {
 	TokenType     string    `json:"token_type" db:"token_type"` // "access" or "refresh"
 	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
 	BlacklistedAt time.Time `json:"blacklisted_at" db:"blacklisted_at"`
-}
\ No newline at end of file
+	Successor     []byte    `json:"-" db:"successor"` // Sealed token pair issued when a refresh token was rotated
+}
+
+// LoginThrottle tracks failed login attempts for a single throttle key
+// Keys are prefixed with their scope, e.g. "phone:+66812345678" or "ip:203.0.113.7"
+type LoginThrottle struct {
+	Key          string     `json:"key" db:"throttle_key"`
+	FailedCount  int        `json:"failed_count" db:"failed_count"`
+	LastFailedAt time.Time  `json:"last_failed_at" db:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+// TokenFamily represents the chain of refresh tokens started by a single login
+// Every rotation stays in the same family; revoking the family invalidates all of its tokens
+type TokenFamily struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	UserID        uuid.UUID  `json:"user_id" db:"user_id"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	RevokedReason string     `json:"revoked_reason,omitempty" db:"revoked_reason"`
+}
+
+// SecurityEvent represents a security-relevant event recorded for a user
+type SecurityEvent struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	EventType string    `json:"event_type" db:"event_type"`
+	Details   string    `json:"details" db:"details"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AuthEvent is an entry of the append-only authentication audit log
+// Failed logins for unknown phone numbers have no user; the phone number tried is kept instead
+type AuthEvent struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	EventType   string     `json:"event_type" db:"event_type"`
+	UserID      *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	PhoneNumber string     `json:"phone_number,omitempty" db:"phone_number"`
+	Method      string     `json:"method,omitempty" db:"method"` // Login method: "pin", "otp" or "device_key"
+	Reason      string     `json:"reason,omitempty" db:"reason"` // Why a failure happened, e.g. "invalid_pin"
+	SessionID   *uuid.UUID `json:"session_id,omitempty" db:"session_id"`
+	IPAddress   string     `json:"ip_address" db:"ip_address"`
+	UserAgent   string     `json:"user_agent" db:"user_agent"`
+	DeviceID    string     `json:"device_id,omitempty" db:"device_id"`
+	ActorID     *uuid.UUID `json:"actor_id,omitempty" db:"actor_id"` // Administrator who acted on the user's account, for user management events
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// AuthEventFilter selects audit log entries; zero fields do not filter
+type AuthEventFilter struct {
+	UserID      uuid.UUID
+	PhoneNumber string
+	EventType   string
+	IPAddress   string
+	DeviceID    string
+	ActorID     uuid.UUID
+	From        time.Time // Inclusive
+	To          time.Time // Exclusive
+	Limit       int
+	Offset      int
+}
+
+// AuthEventPage is one page of audit log entries, newest first
+type AuthEventPage struct {
+	Events   []AuthEvent `json:"events"`
+	Total    int         `json:"total"` // Number of entries matching the filter across all pages
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+}
+
+// LoginHistory summarizes a user's earlier successful logins, as far as the audit log records them
+type LoginHistory struct {
+	HasLogins   bool // At least one earlier login
+	KnownIP     bool // An earlier login came from the same IP address
+	KnownDevice bool // An earlier login came from the same device
+}
+
+// LoginAlert records a login that looked unusual, e.g. from a new device or outside opening hours
+// The user and the shop owners are notified; an alert stays open until someone marks it as reviewed
+type LoginAlert struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	PhoneNumber string     `json:"phone_number" db:"phone_number"`
+	Reasons     []string   `json:"reasons" db:"reasons"` // What made the login unusual, e.g. "new_device"
+	SessionID   *uuid.UUID `json:"session_id,omitempty" db:"session_id"`
+	IPAddress   string     `json:"ip_address" db:"ip_address"`
+	UserAgent   string     `json:"user_agent" db:"user_agent"`
+	DeviceID    string     `json:"device_id,omitempty" db:"device_id"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty" db:"reviewed_at"`
+	ReviewedBy  *uuid.UUID `json:"reviewed_by,omitempty" db:"reviewed_by"`
+}
+
+// LoginAlertFilter selects login alerts; zero fields do not filter
+type LoginAlertFilter struct {
+	UserID   uuid.UUID
+	Reviewed *bool // Only reviewed (true) or open (false) alerts
+	Limit    int
+	Offset   int
+}
+
+// LoginAlertPage is one page of login alerts, newest first
+type LoginAlertPage struct {
+	Alerts   []LoginAlert `json:"alerts"`
+	Total    int          `json:"total"` // Number of alerts matching the filter across all pages
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+}
+
+// UserPage is one page of users, newest first
+type UserPage struct {
+	Users    []user.User `json:"users"`
+	Total    int         `json:"total"` // Number of users matching the filter across all pages
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+}
+
+// Session represents a logged-in device, created at login and sharing its ID with the token family
+// Revocation is tracked on the token family; RevokedAt mirrors it when a session is loaded
+type Session struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	JTI        string     `json:"-" db:"jti"` // ID of the session's current refresh token
+	DeviceID   string     `json:"device_id" db:"device_id"` // Device the session's tokens are bound to; empty if none was sent
+	DeviceName string     `json:"device_name" db:"device_name"`
+	UserAgent  string     `json:"user_agent" db:"user_agent"`
+	IPAddress  string     `json:"ip_address" db:"ip_address"`
+	ClientType string     `json:"client_type" db:"client_type"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at" db:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"-"`
+	Current    bool       `json:"current" db:"-"` // True for the session of the requesting token
+}
+
+// SigningKey represents an asymmetric JWT signing key
+// A key signs new tokens until RotateAt and keeps verifying them until ExpiresAt
+type SigningKey struct {
+	KeyID      string    `json:"kid" db:"kid"`
+	Algorithm  string    `json:"alg" db:"algorithm"`
+	PrivateKey []byte    `json:"-" db:"private_key"` // PKCS#8 DER, encrypted with a key derived from JWT_SECRET
+	PublicKey  []byte    `json:"-" db:"public_key"`  // PKIX DER
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	RotateAt   time.Time `json:"rotate_at" db:"rotate_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// OTP represents a one-time code sent to a user by SMS
+// Only an HMAC of the code is stored; a user has at most one code per purpose
+type OTP struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	Purpose    string     `json:"purpose" db:"purpose"`
+	CodeHash   string     `json:"-" db:"code_hash"`
+	Attempts   int        `json:"attempts" db:"attempts"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty" db:"consumed_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Device represents a device a user has logged in from, identified by the device ID its client sends
+// Devices are recorded on every login and can be removed by the user, which signs them out
+type Device struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	UserID     uuid.UUID `json:"user_id" db:"user_id"`
+	DeviceID   string    `json:"device_id" db:"device_id"`
+	Name       string    `json:"name" db:"name"`
+	LastIP     string    `json:"last_ip" db:"last_ip"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"` // First login from the device
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
+	Current    bool      `json:"current" db:"-"` // True for the device making the request
+}
+
+// DeviceKey represents a public key registered by a device for PIN-less login
+// The private key stays on the device behind a biometric unlock; logging in signs a server nonce
+type DeviceKey struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	DeviceID   string     `json:"device_id" db:"device_id"` // Device the key was registered from; logins must come from it
+	Name       string     `json:"name" db:"name"`
+	Algorithm  string     `json:"algorithm" db:"algorithm"` // "ES256" or "EdDSA"
+	PublicKey  []byte     `json:"-" db:"public_key"`        // PKIX DER
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// DeviceKeyChallenge is a nonce issued for a device key login; each key has at most one
+type DeviceKeyChallenge struct {
+	KeyID     uuid.UUID `json:"key_id" db:"key_id"`
+	Nonce     string    `json:"nonce" db:"nonce"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// ShopDevice represents a device registered by an owner as belonging to the shop
+// When logins are restricted to shop devices, only these devices can log in as staff
+type ShopDevice struct {
+	DeviceID     string    `json:"device_id" db:"device_id"`
+	Name         string    `json:"name" db:"name"`
+	RegisteredBy uuid.UUID `json:"registered_by" db:"registered_by"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// ServiceAccount represents a non-human client of the API, such as a POS terminal or an
+// accounting integration, that authenticates with API keys instead of a phone number and PIN
+type ServiceAccount struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	Name        string     `json:"name" db:"name"`
+	Description string     `json:"description" db:"description"`
+	Branch      string     `json:"branch" db:"branch"` // Branch the account acts for; empty for every branch
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// APIKey is a credential of a service account, granting it a set of permissions
+// Only a SHA-256 hash of the key is stored; the key itself is shown once, when it is created
+type APIKey struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	ServiceAccountID uuid.UUID  `json:"service_account_id" db:"service_account_id"`
+	Name             string     `json:"name" db:"name"`
+	Prefix           string     `json:"prefix" db:"prefix"` // Start of the key, to tell keys apart without revealing them
+	KeyHash          string     `json:"-" db:"key_hash"`
+	Permissions      []string   `json:"permissions" db:"permissions"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	LastUsedIP       string     `json:"last_used_ip,omitempty" db:"last_used_ip"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// CreatedAPIKey is a newly created API key together with the key itself
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"` // Shown only in the creation response
+}
+
+// TokenIntrospection describes a token in the format of an RFC 7662 introspection response
+// Inactive tokens only have Active set, so nothing is revealed about them.
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"` // "access_token" or "refresh_token"
+	Username  string `json:"username,omitempty"`   // The user's phone number in local format
+	Subject   string `json:"sub,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	JTI       string `json:"jti,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+
+	// Claims of our own tokens
+	SessionID         string `json:"session_id,omitempty"`
+	Role              string `json:"role,omitempty"`
+	Branch            string `json:"branch,omitempty"`
+	ClientType        string `json:"client_type,omitempty"`
+	DeviceID          string `json:"device_id,omitempty"` // Resource servers should check it against X-Device-ID
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	PinChangeRequired bool   `json:"pin_change_required,omitempty"` // The user must change their PIN before using the API
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"tt-stock-api/internal/phone"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/user"
+)
+
+// Token types as named by RFC 7009 and RFC 7662
+const (
+	OAuthTokenTypeAccess  = "access_token"
+	OAuthTokenTypeRefresh = "refresh_token"
+)
+
+// oauthTokenTypes maps the token types of our tokens to their RFC 7009 names
+var oauthTokenTypes = map[string]string{
+	"access":  OAuthTokenTypeAccess,
+	"refresh": OAuthTokenTypeRefresh,
+}
+
+// IntrospectToken reports whether a token is active and, if it is, what it says, for other
+// services that need to check our tokens without the signing secret (RFC 7662)
+// A token is active if it would be accepted by this API: it is valid and unrevoked, and its
+// user may still use the API. Tokens of another branch than a branch-limited service account's
+// are reported inactive.
+func (s *service) IntrospectToken(claims *Claims, token string) (*TokenIntrospection, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	tokenClaims, err := s.activeTokenClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	if tokenClaims == nil {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	if err := s.authorizeTokenAccess(claims, policy.PermissionTokenIntrospect, tokenClaims); err != nil {
+		if errors.Is(err, ErrPermissionDenied) {
+			return &TokenIntrospection{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	status, err := s.AccountStatus(tokenClaims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return &TokenIntrospection{Active: false}, nil
+		}
+		return nil, err
+	}
+	if accountStatusError(status) != nil {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	introspection := &TokenIntrospection{
+		Active:     true,
+		TokenType:  oauthTokenTypes[tokenClaims.TokenType],
+		Username:   phone.FormatLocal(tokenClaims.PhoneNumber),
+		Subject:    tokenClaims.Subject,
+		Issuer:     tokenClaims.Issuer,
+		JTI:        tokenClaims.ID,
+		ExpiresAt:  numericDateUnix(tokenClaims.ExpiresAt),
+		IssuedAt:   numericDateUnix(tokenClaims.IssuedAt),
+		NotBefore:  numericDateUnix(tokenClaims.NotBefore),
+		SessionID:  tokenClaims.FamilyID,
+		Role:       tokenClaims.Role,
+		Branch:     tokenClaims.Branch,
+		ClientType: tokenClaims.ClientType,
+		DeviceID:   tokenClaims.DeviceID,
+		AuthTime:   numericDateUnix(tokenClaims.AuthTime),
+
+		PinChangeRequired: tokenClaims.PinChangeRequired || status == user.StatusPendingPinChange,
+	}
+	return introspection, nil
+}
+
+// RevokeToken revokes an access or refresh token on behalf of a service account (RFC 7009)
+// Revoking a refresh token also ends its session, so access tokens issued with it stop working
+// too. Tokens that are already invalid, expired or revoked are ignored, as the RFC requires.
+// Returns ErrPermissionDenied for tokens of another branch than a branch-limited service
+// account's.
+func (s *service) RevokeToken(claims *Claims, token string, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	tokenClaims, err := s.activeTokenClaims(token)
+	if err != nil {
+		return err
+	}
+	if tokenClaims == nil {
+		return nil
+	}
+
+	if err := s.authorizeTokenAccess(claims, policy.PermissionTokenRevoke, tokenClaims); err != nil {
+		return err
+	}
+
+	if err := s.BlacklistToken(token); err != nil {
+		return err
+	}
+
+	event := AuthEvent{
+		EventType:   AuthEventTokenRevoked,
+		UserID:      &tokenClaims.UserID,
+		PhoneNumber: tokenClaims.PhoneNumber,
+		Reason:      oauthTokenTypes[tokenClaims.TokenType],
+		ActorID:     &claims.UserID,
+	}
+	if sessionID, err := uuid.Parse(tokenClaims.FamilyID); err == nil {
+		event.SessionID = &sessionID
+
+		if tokenClaims.TokenType == "refresh" {
+			if err := s.familyRepo.RevokeFamily(sessionID, RevokedReasonTokenRevoked); err != nil {
+				return errors.New("failed to end session")
+			}
+		}
+	}
+
+	s.recordAuthEvent(event, client)
+	return nil
+}
+
+// activeTokenClaims validates an access or refresh token, returning nil claims without an
+// error if the token is not valid. Only failures to check the token are returned as errors.
+func (s *service) activeTokenClaims(token string) (*Claims, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	claims, err := s.ValidateToken(token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenRevoked) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if _, ok := oauthTokenTypes[claims.TokenType]; !ok {
+		return nil, nil
+	}
+	return claims, nil
+}
+
+// authorizeTokenAccess checks that the service account may inspect or revoke a token
+// Branch-limited service accounts only have access to the tokens of their own branch.
+func (s *service) authorizeTokenAccess(claims *Claims, permission string, tokenClaims *Claims) error {
+	resource := &policy.Resource{
+		Branch:     tokenClaims.Branch,
+		Attributes: map[string]any{"role": tokenClaims.Role},
+	}
+
+	err := s.authorizer.Authorize(context.Background(), SubjectFromClaims(claims), permission, resource)
+	if errors.Is(err, policy.ErrDenied) {
+		return ErrPermissionDenied
+	}
+	if err != nil {
+		return errors.New("failed to check permissions")
+	}
+	return nil
+}
+
+// numericDateUnix returns a JWT date as Unix seconds, or 0 if it is not set
+func numericDateUnix(date *jwt.NumericDate) int64 {
+	if date == nil {
+		return 0
+	}
+	return date.Unix()
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// One-time code purposes
+const (
+	OTPPurposePinReset = "pin_reset"
+	OTPPurposeLogin    = "login"
+)
+
+// OTPRepository defines the interface for one-time code storage
+type OTPRepository interface {
+	SaveOTP(otp *OTP) error
+	FindOTP(userID uuid.UUID, purpose string) (*OTP, error)
+	IncrementOTPAttempts(otpID uuid.UUID) (int, error)
+	ConsumeOTP(otpID uuid.UUID) (bool, error)
+}
+
+// otpRepository implements the OTPRepository interface
+type otpRepository struct {
+	db *db.DB
+}
+
+// NewOTPRepository creates a new one-time code repository instance
+func NewOTPRepository(database *db.DB) OTPRepository {
+	return &otpRepository{
+		db: database,
+	}
+}
+
+// SaveOTP stores a new code, replacing any earlier code of the user for the same purpose
+func (r *otpRepository) SaveOTP(otp *OTP) error {
+	if otp == nil || otp.UserID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if otp.Purpose == "" {
+		return errors.New("purpose cannot be empty")
+	}
+	if otp.CodeHash == "" {
+		return errors.New("code hash cannot be empty")
+	}
+
+	if otp.ID == uuid.Nil {
+		otp.ID = uuid.New()
+	}
+	if otp.CreatedAt.IsZero() {
+		otp.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO otp_codes (id, user_id, purpose, code_hash, attempts, expires_at, consumed_at, created_at)
+		VALUES ($1, $2, $3, $4, 0, $5, NULL, $6)
+		ON CONFLICT (user_id, purpose) DO UPDATE
+		SET id = EXCLUDED.id,
+			code_hash = EXCLUDED.code_hash,
+			attempts = 0,
+			expires_at = EXCLUDED.expires_at,
+			consumed_at = NULL,
+			created_at = EXCLUDED.created_at
+	`
+
+	_, err := r.db.Exec(query, otp.ID, otp.UserID, otp.Purpose, otp.CodeHash, otp.ExpiresAt, otp.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save one-time code: %w", err)
+	}
+
+	return nil
+}
+
+// FindOTP returns the latest code of a user for a purpose, or nil if there is none
+// Expired and consumed codes are returned as well; callers decide whether a code is usable
+func (r *otpRepository) FindOTP(userID uuid.UUID, purpose string) (*OTP, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		SELECT id, user_id, purpose, code_hash, attempts, expires_at, consumed_at, created_at
+		FROM otp_codes
+		WHERE user_id = $1 AND purpose = $2
+	`
+
+	var otp OTP
+	var consumedAt sql.NullTime
+
+	err := r.db.QueryRow(query, userID, purpose).Scan(
+		&otp.ID,
+		&otp.UserID,
+		&otp.Purpose,
+		&otp.CodeHash,
+		&otp.Attempts,
+		&otp.ExpiresAt,
+		&consumedAt,
+		&otp.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query one-time code: %w", err)
+	}
+
+	if consumedAt.Valid {
+		otp.ConsumedAt = &consumedAt.Time
+	}
+
+	return &otp, nil
+}
+
+// IncrementOTPAttempts counts a verification attempt against a code and returns the new count
+// Counting happens before the code is compared, so concurrent guesses cannot exceed the limit
+func (r *otpRepository) IncrementOTPAttempts(otpID uuid.UUID) (int, error) {
+	if otpID == uuid.Nil {
+		return 0, errors.New("code ID cannot be empty")
+	}
+
+	query := `
+		UPDATE otp_codes
+		SET attempts = attempts + 1
+		WHERE id = $1
+		RETURNING attempts
+	`
+
+	var attempts int
+	err := r.db.QueryRow(query, otpID).Scan(&attempts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record one-time code attempt: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// ConsumeOTP marks a code as used and reports whether this call consumed it
+// A code can only be consumed once, even by concurrent requests
+func (r *otpRepository) ConsumeOTP(otpID uuid.UUID) (bool, error) {
+	if otpID == uuid.Nil {
+		return false, errors.New("code ID cannot be empty")
+	}
+
+	query := `
+		UPDATE otp_codes
+		SET consumed_at = $1
+		WHERE id = $2 AND consumed_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), otpID)
+	if err != nil {
+		return false, fmt.Errorf("failed to consume one-time code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected == 1, nil
+}
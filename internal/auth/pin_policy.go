@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buddhistEraOffset converts a Gregorian year to the Thai Buddhist Era, which many users
+// think of as their birth year
+const buddhistEraOffset = 543
+
+// checkPinStrength rejects PINs that are easy to guess: a single repeated digit, a run of
+// consecutive ascending or descending digits, or a PIN containing the owner's birth year
+// The PIN must already have a valid format
+func checkPinStrength(pin string, birthDate *time.Time) error {
+	if isRepeatedDigits(pin) {
+		return &ValidationError{Field: "new_pin", Message: "PIN is too easy to guess: all digits are the same"}
+	}
+
+	if isSequentialDigits(pin) {
+		return &ValidationError{Field: "new_pin", Message: "PIN is too easy to guess: digits are in sequence"}
+	}
+
+	if birthDate != nil {
+		year := birthDate.Year()
+		for _, candidate := range []int{year, year + buddhistEraOffset} {
+			if strings.Contains(pin, strconv.Itoa(candidate)) {
+				return &ValidationError{Field: "new_pin", Message: "PIN is too easy to guess: it contains your birth year"}
+			}
+		}
+	}
+
+	return nil
+}
+
+// isRepeatedDigits reports whether every digit of the PIN is the same, e.g. 111111
+func isRepeatedDigits(pin string) bool {
+	for i := 1; i < len(pin); i++ {
+		if pin[i] != pin[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// isSequentialDigits reports whether the PIN is an ascending or descending run, e.g. 123456 or 987654
+func isSequentialDigits(pin string) bool {
+	if len(pin) < 2 {
+		return false
+	}
+
+	step := int(pin[1]) - int(pin[0])
+	if step != 1 && step != -1 {
+		return false
+	}
+
+	for i := 2; i < len(pin); i++ {
+		if int(pin[i])-int(pin[i-1]) != step {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPinStrength(t *testing.T) {
+	birthDate := time.Date(1985, 11, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		pin       string
+		birthDate *time.Time
+		weak      bool
+	}{
+		{name: "Random digits", pin: "739164", birthDate: &birthDate},
+		{name: "Repeated digits", pin: "000000", weak: true},
+		{name: "Ascending run", pin: "123456", weak: true},
+		{name: "Ascending run from zero", pin: "012345", weak: true},
+		{name: "Descending run", pin: "654321", weak: true},
+		{name: "Run that changes direction is allowed", pin: "123210"},
+		{name: "Gregorian birth year", pin: "198577", birthDate: &birthDate, weak: true},
+		{name: "Buddhist Era birth year", pin: "022528", birthDate: &birthDate, weak: true},
+		{name: "Birth year unknown", pin: "198577"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPinStrength(tt.pin, tt.birthDate)
+			if tt.weak {
+				assert.ErrorIs(t, err, ErrValidation)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// Security event types
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventPinChanged        = "pin_changed"
+	SecurityEventPinReset          = "pin_reset"
+	SecurityEventDeviceKeyAdded    = "device_key_added"
+)
+
+// SecurityEventRepository defines the interface for recording security events
+type SecurityEventRepository interface {
+	RecordEvent(userID uuid.UUID, eventType, details string) error
+}
+
+// securityEventRepository implements the SecurityEventRepository interface
+type securityEventRepository struct {
+	db *db.DB
+}
+
+// NewSecurityEventRepository creates a new security event repository instance
+func NewSecurityEventRepository(database *db.DB) SecurityEventRepository {
+	return &securityEventRepository{
+		db: database,
+	}
+}
+
+// RecordEvent stores a security event for a user
+func (r *securityEventRepository) RecordEvent(userID uuid.UUID, eventType, details string) error {
+	if userID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if eventType == "" {
+		return errors.New("event type cannot be empty")
+	}
+
+	query := `
+		INSERT INTO security_events (user_id, event_type, details, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := r.db.Exec(query, userID, eventType, details, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record security event: %w", err)
+	}
+
+	return nil
+}
@@ -1,13 +1,22 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"tt-stock-api/internal/config"
+	"tt-stock-api/internal/notify"
+	"tt-stock-api/internal/phone"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/sms"
 	"tt-stock-api/internal/user"
 	"tt-stock-api/pkg/utils"
 )
@@ -17,96 +26,1429 @@ type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"` // Access token expiration in seconds
+
+	PinChangeRequired bool `json:"pin_change_required,omitempty"` // The tokens only allow changing the PIN and logging out
+}
+
+// ClientInfo carries request metadata about the client performing an authentication
+type ClientInfo struct {
+	IPAddress   string
+	UserAgent   string
+	DeviceID    string // Stable ID the client sends for the device it runs on; tokens are bound to it
+	DeviceName  string
+	ClientType  string // Kind of client, e.g. "pos" or "mobile"; selects token lifetime overrides
+	LoginMethod string // How the user authenticated, e.g. "pin"; recorded in the audit log at login
+}
+
+// Claims represents JWT token claims
+type Claims struct {
+	UserID      uuid.UUID        `json:"user_id"`
+	PhoneNumber string           `json:"phone_number"`
+	TokenType   string           `json:"token_type"`            // "access" or "refresh"
+	FamilyID    string           `json:"family_id,omitempty"`   // Refresh token family started at login, also the session ID
+	Role        string           `json:"role,omitempty"`        // Role of the user at login
+	Branch      string           `json:"branch,omitempty"`      // Branch of the user at login, for branch-limited permissions
+	ClientType  string           `json:"client_type,omitempty"` // Client type given at login
+	DeviceID    string           `json:"device_id,omitempty"`   // Device the token is bound to, sent back in X-Device-ID
+	AuthTime    *jwt.NumericDate `json:"auth_time,omitempty"`   // Time of the login that started the session
+
+	PinChangeRequired bool `json:"pin_change_required,omitempty"` // The user must change their PIN before using the API
+
+	Scopes []string `json:"-"` // Permissions of the API key a request authenticated with; never part of a token
+	jwt.RegisteredClaims
+}
+
+// tokenSubject describes who a token pair is issued to and the session it belongs to
+type tokenSubject struct {
+	UserID      uuid.UUID
+	PhoneNumber string
+	Role        string
+	Branch      string
+	ClientType  string
+	DeviceID    string
+	FamilyID    string
+	AuthTime    time.Time
+
+	PinChangeRequired bool
+}
+
+// Service defines the interface for authentication operations
+type Service interface {
+	ValidatePhoneNumber(phoneNumber string) error
+	ValidatePin(pin string) error
+	AuthenticateUser(phoneNumber, pin string, client ClientInfo) (*user.User, error)
+	UnlockAccount(phoneNumber string) error
+	ChangePin(claims *Claims, currentPin, newPin string, client ClientInfo) error
+	RequestPinReset(phoneNumber string, client ClientInfo) error
+	ConfirmPinReset(phoneNumber, code, newPin string, client ClientInfo) error
+	RequestLoginOTP(phoneNumber string, client ClientInfo) error
+	AuthenticateUserWithOTP(phoneNumber, code string, client ClientInfo) (*user.User, error)
+	GenerateAccessToken(userID uuid.UUID, phoneNumber string) (string, error)
+	GenerateRefreshToken(userID uuid.UUID, phoneNumber string) (string, error)
+	GenerateTokens(u *user.User, client ClientInfo) (*TokenPair, error)
+	RefreshTokens(refreshToken string, client ClientInfo) (*TokenPair, *Claims, error)
+	Logout(claims *Claims, client ClientInfo) error
+	LogoutAll(claims *Claims, client ClientInfo) error
+	ListSessions(userID uuid.UUID) ([]Session, error)
+	RevokeSession(userID, sessionID uuid.UUID, client ClientInfo) error
+	ListDevices(userID uuid.UUID) ([]Device, error)
+	RemoveDevice(userID, id uuid.UUID) error
+	ListShopDevices() ([]ShopDevice, error)
+	RegisterShopDevice(ownerID uuid.UUID, deviceID, name string) (*ShopDevice, error)
+	RemoveShopDevice(deviceID string) error
+	RegisterDeviceKey(claims *Claims, pin, algorithm, publicKey, name string, client ClientInfo) (*DeviceKey, error)
+	ListDeviceKeys(userID uuid.UUID) ([]DeviceKey, error)
+	RevokeDeviceKey(userID, id uuid.UUID) error
+	CreateDeviceKeyChallenge(keyID uuid.UUID, client ClientInfo) (*DeviceKeyChallenge, error)
+	AuthenticateUserWithDeviceKey(keyID uuid.UUID, signature string, client ClientInfo) (*user.User, error)
+	ValidateToken(tokenString string) (*Claims, error)
+	AccountStatus(userID uuid.UUID) (string, error)
+	ParseToken(tokenString string) (*Claims, error)
+	BlacklistToken(tokenString string) error
+	IsTokenBlacklisted(tokenString string) (bool, error)
+	JWKS() (*JWKSet, error)
+	ListAuthEvents(filter AuthEventFilter, page, pageSize int) (*AuthEventPage, error)
+	ListLoginAlerts(claims *Claims, filter LoginAlertFilter, page, pageSize int) (*LoginAlertPage, error)
+	ReviewLoginAlert(claims *Claims, alertID uuid.UUID) (*LoginAlert, error)
+	CreateUser(claims *Claims, input CreateUserInput, client ClientInfo) (*user.User, error)
+	GetUser(claims *Claims, id uuid.UUID) (*user.User, error)
+	ListUsers(claims *Claims, filter user.Filter, page, pageSize int) (*UserPage, error)
+	UpdateUser(claims *Claims, id uuid.UUID, input UpdateUserInput, client ClientInfo) (*user.User, error)
+	SetUserStatus(claims *Claims, id uuid.UUID, status string, client ClientInfo) (*user.User, error)
+	DeleteUser(claims *Claims, id uuid.UUID, client ClientInfo) error
+	ForceLogout(claims *Claims, id uuid.UUID, client ClientInfo) error
+	UnlockUser(claims *Claims, id uuid.UUID, client ClientInfo) error
+	CreateServiceAccount(claims *Claims, input CreateServiceAccountInput, client ClientInfo) (*ServiceAccount, error)
+	ListServiceAccounts() ([]ServiceAccount, error)
+	DeleteServiceAccount(claims *Claims, id uuid.UUID, client ClientInfo) error
+	CreateAPIKey(claims *Claims, serviceAccountID uuid.UUID, input CreateAPIKeyInput, client ClientInfo) (*CreatedAPIKey, error)
+	ListAPIKeys(serviceAccountID uuid.UUID) ([]APIKey, error)
+	RevokeAPIKey(claims *Claims, serviceAccountID, keyID uuid.UUID, client ClientInfo) error
+	AuthenticateAPIKey(key string, client ClientInfo) (*Claims, error)
+	IntrospectToken(claims *Claims, token string) (*TokenIntrospection, error)
+	RevokeToken(claims *Claims, token string, client ClientInfo) error
+}
+
+// Repositories groups the data access dependencies of the authentication service
+type Repositories struct {
+	Users           user.Repository
+	Blacklist       BlacklistRepository
+	LoginAttempts   LoginAttemptRepository
+	TokenFamilies   TokenFamilyRepository
+	SecurityEvents  SecurityEventRepository
+	Sessions        SessionRepository
+	OTPs            OTPRepository
+	Devices         DeviceRepository
+	DeviceKeys      DeviceKeyRepository
+	AuthEvents      AuthEventRepository
+	LoginAlerts     LoginAlertRepository
+	ServiceAccounts ServiceAccountRepository
+}
+
+// service implements the Service interface
+type service struct {
+	userRepo           user.Repository
+	blacklistRepo      BlacklistRepository
+	attemptRepo        LoginAttemptRepository
+	familyRepo         TokenFamilyRepository
+	eventRepo          SecurityEventRepository
+	sessionRepo        SessionRepository
+	otpRepo            OTPRepository
+	deviceRepo         DeviceRepository
+	deviceKeyRepo      DeviceKeyRepository
+	authEventRepo      AuthEventRepository
+	alertRepo          LoginAlertRepository
+	serviceAccountRepo ServiceAccountRepository
+	keys               KeyManager
+	sms                sms.Sender
+	notifier           notify.Notifier
+	authorizer         policy.Authorizer
+	phones             *phone.Registry // Countries whose phone numbers are accepted
+	throttle           loginThrottleConfig
+	refreshGrace       time.Duration
+	lifetimes          tokenLifetimePolicy
+	pinHistory         int // Number of previous PINs that cannot be chosen again
+	otp                otpConfig
+	shopDevices        bool          // Logins restricted to shop devices
+	challengeTTL       time.Duration // Lifetime of device key login challenges
+	alerts             loginAlertConfig
+	accounts           *accountStateCache // Account status and token cutoff checked on every request
+}
+
+// otpConfig holds the settings for one-time codes sent by SMS
+type otpConfig struct {
+	key            []byte // Key for hashing stored codes
+	ttl            time.Duration
+	maxAttempts    int
+	resendInterval time.Duration
+	loginEnabled   bool // SMS code login allowed for every user
+}
+
+// otpCodeLength is the number of digits in a one-time code
+const otpCodeLength = 6
+
+// loginThrottleConfig holds the limits applied to failed login attempts
+type loginThrottleConfig struct {
+	maxAttempts      int
+	maxAttemptsPerIP int
+	window           time.Duration
+	lockoutDuration  time.Duration
+	delayBase        time.Duration
+	delayMax         time.Duration
+}
+
+// NewService creates a new authentication service instance
+func NewService(repos Repositories, keys KeyManager, sender sms.Sender, notifier notify.Notifier, authorizer policy.Authorizer, phones *phone.Registry, cfg *config.Config) Service {
+	return &service{
+		userRepo:           repos.Users,
+		blacklistRepo:      repos.Blacklist,
+		attemptRepo:        repos.LoginAttempts,
+		familyRepo:         repos.TokenFamilies,
+		eventRepo:          repos.SecurityEvents,
+		sessionRepo:        repos.Sessions,
+		otpRepo:            repos.OTPs,
+		deviceRepo:         repos.Devices,
+		deviceKeyRepo:      repos.DeviceKeys,
+		authEventRepo:      repos.AuthEvents,
+		alertRepo:          repos.LoginAlerts,
+		serviceAccountRepo: repos.ServiceAccounts,
+		keys:               keys,
+		sms:                sender,
+		notifier:           notifier,
+		authorizer:         authorizer,
+		phones:             phones,
+		throttle: loginThrottleConfig{
+			maxAttempts:      cfg.LoginMaxAttempts,
+			maxAttemptsPerIP: cfg.LoginMaxAttemptsPerIP,
+			window:           cfg.LoginAttemptWindow,
+			lockoutDuration:  cfg.LoginLockoutDuration,
+			delayBase:        cfg.LoginDelayBase,
+			delayMax:         cfg.LoginDelayMax,
+		},
+		refreshGrace: cfg.RefreshGracePeriod,
+		lifetimes:    newTokenLifetimePolicy(cfg),
+		pinHistory:   cfg.PinHistorySize,
+		otp: otpConfig{
+			key:            utils.DeriveKey("otp-code", cfg.JWTSecret),
+			ttl:            cfg.OTPTTL,
+			maxAttempts:    cfg.OTPMaxAttempts,
+			resendInterval: cfg.OTPResendInterval,
+			loginEnabled:   cfg.OTPLoginEnabled,
+		},
+		shopDevices:  cfg.RestrictLoginToShopDevices,
+		challengeTTL: cfg.DeviceKeyChallengeTTL,
+		alerts:       newLoginAlertConfig(cfg),
+		accounts:     newAccountStateCache(cfg.UserStatusCacheTTL),
+	}
+}
+
+// ValidatePhoneNumber validates a mobile or landline number of one of the shop's countries, in
+// any format phone.Registry.Parse accepts
+func (s *service) ValidatePhoneNumber(phoneNumber string) error {
+	_, err := s.normalizePhoneNumber(phoneNumber)
+	return err
+}
+
+// normalizePhoneNumber validates a phone number and returns it in E.164 format, the format
+// phone numbers are stored, looked up and throttled in
+func (s *service) normalizePhoneNumber(phoneNumber string) (string, error) {
+	if phoneNumber == "" {
+		return "", &ValidationError{Field: "phone_number", Message: "phone number is required"}
+	}
+
+	normalized, err := s.phones.Normalize(phoneNumber)
+	if err != nil {
+		return "", &ValidationError{Field: "phone_number", Message: err.Error()}
+	}
+
+	return normalized, nil
+}
+
+// ValidatePin validates 6-digit PIN format (^[0-9]{6}$)
+func (s *service) ValidatePin(pin string) error {
+	if pin == "" {
+		return &ValidationError{Field: "pin", Message: "PIN is required"}
+	}
+
+	// PIN format: exactly 6 digits
+	pinRegex := regexp.MustCompile(`^[0-9]{6}$`)
+	if !pinRegex.MatchString(pin) {
+		return &ValidationError{Field: "pin", Message: "invalid PIN format: must be exactly 6 digits"}
+	}
+
+	return nil
+}
+
+// AuthenticateUser validates user credentials and returns the user if authentication succeeds
+// Failed attempts are counted per phone number and per client IP; repeated failures
+// trigger progressive delays and finally a temporary account lock
+func (s *service) AuthenticateUser(phoneNumber, pin string, client ClientInfo) (*user.User, error) {
+	// Validate input format; phone numbers are stored in E.164
+	phoneNumber, err := s.normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ValidatePin(pin); err != nil {
+		return nil, err
+	}
+
+	// Reject the attempt early if the account or client is locked or cooling down
+	if err := s.checkLoginThrottle(phoneNumber, client.IPAddress); err != nil {
+		s.recordLoginFailed(LoginMethodPin, phoneNumber, nil, client, authFailureReason(err))
+		return nil, err
+	}
+
+	// Find user by phone number
+	foundUser, err := s.userRepo.FindByPhoneNumber(phoneNumber)
+	if err != nil {
+		return nil, s.failLogin(LoginMethodPin, phoneNumber, nil, client, AuthReasonUnknownUser)
+	}
+
+	// Verify PIN against stored hash
+	if err := utils.CheckPin(foundUser.PinHash, pin); err != nil {
+		return nil, s.failLogin(LoginMethodPin, phoneNumber, foundUser, client, AuthReasonInvalidPin)
+	}
+
+	// Clear failures for this phone number; the IP counter is left to expire on its own
+	if err := s.attemptRepo.Reset(phoneThrottleKey(phoneNumber)); err != nil {
+		// Log error but don't fail authentication
+		// In a real application, you'd use a proper logger here
+	}
+
+	// Update last login timestamp
+	if err := s.userRepo.UpdateLastLogin(foundUser.ID); err != nil {
+		// Log error but don't fail authentication
+		// In a real application, you'd use a proper logger here
+	}
+
+	return foundUser, nil
+}
+
+// UnlockAccount clears failed attempts and any lock for a phone number
+// This is an administrative operation and must only be exposed to administrators
+func (s *service) UnlockAccount(phoneNumber string) error {
+	phoneNumber, err := s.normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	if err := s.attemptRepo.Reset(phoneThrottleKey(phoneNumber)); err != nil {
+		return errors.New("failed to unlock account")
+	}
+
+	return nil
+}
+
+// ChangePin replaces the user's PIN after verifying the current one
+// The new PIN must pass the weak-PIN rules and differ from the current and recent PINs.
+// Wrong current PINs count as failed login attempts. All of the user's other sessions are
+// revoked; the session making the request stays signed in.
+func (s *service) ChangePin(claims *Claims, currentPin, newPin string, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	if err := s.ValidatePin(currentPin); err != nil {
+		return pinFieldError(err, "current_pin")
+	}
+	if err := s.ValidatePin(newPin); err != nil {
+		return pinFieldError(err, "new_pin")
+	}
+
+	foundUser, err := s.userRepo.FindByID(claims.UserID)
+	if err != nil {
+		return errors.New("failed to find user")
+	}
+
+	// Guessing the current PIN here is throttled exactly like guessing it at login
+	if err := s.checkLoginThrottle(foundUser.PhoneNumber, client.IPAddress); err != nil {
+		return err
+	}
+	if err := utils.CheckPin(foundUser.PinHash, currentPin); err != nil {
+		return s.failAttempt(AuthEventPinChangeFailed, "", foundUser.PhoneNumber, foundUser, client, AuthReasonInvalidPin)
+	}
+
+	if err := s.checkNewPin(foundUser, newPin); err != nil {
+		return err
+	}
+
+	pinHash, err := utils.HashPin(newPin)
+	if err != nil {
+		return errors.New("failed to hash PIN")
+	}
+
+	// Revoke first: if that fails nothing has changed and the request can simply be retried
+	revoked, err := s.revokeOtherSessions(foundUser.ID, claims.FamilyID, RevokedReasonPinChanged)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdatePin(foundUser.ID, pinHash, s.pinHistory); err != nil {
+		return errors.New("failed to update PIN")
+	}
+	// A pending_pin_change account is now active
+	s.accounts.forget(foundUser.ID)
+
+	if err := s.attemptRepo.Reset(phoneThrottleKey(foundUser.PhoneNumber)); err != nil {
+		// Log error but don't fail the PIN change
+		// In a real application, you'd use a proper logger here
+	}
+
+	details := fmt.Sprintf("PIN changed; %d other session(s) revoked", revoked)
+	if err := s.eventRepo.RecordEvent(foundUser.ID, SecurityEventPinChanged, details); err != nil {
+		// Log error but don't fail the PIN change
+		// In a real application, you'd use a proper logger here
+	}
+	s.recordSessionEvent(AuthEventPinChanged, claims, client, nil)
+
+	return nil
+}
+
+// RequestPinReset sends a one-time code to the phone number so a forgotten PIN can be reset
+// Unknown phone numbers succeed without sending anything, so the response does not reveal
+// which numbers are registered. A new code replaces any earlier one.
+func (s *service) RequestPinReset(phoneNumber string, client ClientInfo) error {
+	phoneNumber, err := s.normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	// Suspended and locked accounts get no code either; they could not log in with the new PIN
+	foundUser, err := s.userRepo.FindByPhoneNumber(phoneNumber)
+	if err != nil || !foundUser.CanLogin() {
+		return nil
+	}
+
+	return s.sendOTP(foundUser, OTPPurposePinReset, "Your PIN reset code is %s. It expires in %d minutes. Do not share this code with anyone.")
+}
+
+// ConfirmPinReset sets a new PIN for the user if the one-time code is valid
+// Every confirmation counts against the code's attempt limit, right or wrong. On success the
+// code is used up, the account is unlocked and all of the user's sessions are revoked.
+func (s *service) ConfirmPinReset(phoneNumber, code, newPin string, client ClientInfo) error {
+	phoneNumber, err := s.normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return err
+	}
+	if err := validateOTPCode(code); err != nil {
+		return err
+	}
+	if err := s.ValidatePin(newPin); err != nil {
+		return pinFieldError(err, "new_pin")
+	}
+
+	foundUser, err := s.userRepo.FindByPhoneNumber(phoneNumber)
+	if err != nil {
+		return ErrInvalidOTP
+	}
+
+	otp, err := s.verifyOTP(foundUser, OTPPurposePinReset, code)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkNewPin(foundUser, newPin); err != nil {
+		return err
+	}
+
+	pinHash, err := utils.HashPin(newPin)
+	if err != nil {
+		return errors.New("failed to hash PIN")
+	}
+
+	consumed, err := s.otpRepo.ConsumeOTP(otp.ID)
+	if err != nil {
+		return errors.New("failed to check verification code")
+	}
+	if !consumed {
+		return ErrInvalidOTP
+	}
+
+	// Whoever holds the old sessions may be the reason for the reset, so none of them survive
+	revoked, err := s.revokeOtherSessions(foundUser.ID, "", RevokedReasonPinReset)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdatePin(foundUser.ID, pinHash, s.pinHistory); err != nil {
+		return errors.New("failed to update PIN")
+	}
+	s.accounts.forget(foundUser.ID)
+
+	if err := s.attemptRepo.Reset(phoneThrottleKey(foundUser.PhoneNumber)); err != nil {
+		// Log error but don't fail the PIN reset
+		// In a real application, you'd use a proper logger here
+	}
+
+	details := fmt.Sprintf("PIN reset by SMS code from %s; %d session(s) revoked", client.IPAddress, revoked)
+	if err := s.eventRepo.RecordEvent(foundUser.ID, SecurityEventPinReset, details); err != nil {
+		// Log error but don't fail the PIN reset
+		// In a real application, you'd use a proper logger here
+	}
+	s.recordAuthEvent(AuthEvent{EventType: AuthEventPinReset, UserID: &foundUser.ID, PhoneNumber: foundUser.PhoneNumber}, client)
+
+	return nil
 }
 
-// Claims represents JWT token claims
-type Claims struct {
-	UserID      uuid.UUID `json:"user_id"`
-	PhoneNumber string    `json:"phone_number"`
-	TokenType   string    `json:"token_type"` // "access" or "refresh"
-	jwt.RegisteredClaims
+// RequestLoginOTP sends a one-time login code to the phone number of a user allowed to log in
+// without a PIN. Like RequestPinReset it succeeds silently for unknown numbers and for users
+// without SMS code login, so the response does not reveal either.
+func (s *service) RequestLoginOTP(phoneNumber string, client ClientInfo) error {
+	phoneNumber, err := s.normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	// A locked account cannot log in, so there is no point in sending it a code
+	if err := s.checkLoginThrottle(phoneNumber, client.IPAddress); err != nil {
+		return err
+	}
+
+	foundUser, err := s.userRepo.FindByPhoneNumber(phoneNumber)
+	if err != nil || !foundUser.CanLogin() || !s.otpLoginAllowed(foundUser) {
+		return nil
+	}
+
+	return s.sendOTP(foundUser, OTPPurposeLogin, "Your login code is %s. It expires in %d minutes. Do not share this code with anyone.")
+}
+
+// AuthenticateUserWithOTP authenticates a user with a one-time code sent by RequestLoginOTP
+// instead of a PIN. Wrong codes count as failed login attempts, so the same delays and
+// account lock apply as for PIN logins.
+func (s *service) AuthenticateUserWithOTP(phoneNumber, code string, client ClientInfo) (*user.User, error) {
+	phoneNumber, err := s.normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOTPCode(code); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkLoginThrottle(phoneNumber, client.IPAddress); err != nil {
+		s.recordLoginFailed(LoginMethodOTP, phoneNumber, nil, client, authFailureReason(err))
+		return nil, err
+	}
+
+	foundUser, err := s.userRepo.FindByPhoneNumber(phoneNumber)
+	if err != nil {
+		return nil, s.failOTPLogin(phoneNumber, nil, client, AuthReasonUnknownUser)
+	}
+	if !s.otpLoginAllowed(foundUser) {
+		return nil, s.failOTPLogin(phoneNumber, foundUser, client, AuthReasonOTPLoginDisabled)
+	}
+
+	otp, err := s.verifyOTP(foundUser, OTPPurposeLogin, code)
+	if errors.Is(err, ErrInvalidOTP) {
+		return nil, s.failOTPLogin(phoneNumber, foundUser, client, AuthReasonInvalidOTP)
+	}
+	if err != nil {
+		s.recordLoginFailed(LoginMethodOTP, phoneNumber, foundUser, client, authFailureReason(err))
+		return nil, err
+	}
+
+	consumed, err := s.otpRepo.ConsumeOTP(otp.ID)
+	if err != nil {
+		return nil, errors.New("failed to check verification code")
+	}
+	if !consumed {
+		s.recordLoginFailed(LoginMethodOTP, phoneNumber, foundUser, client, AuthReasonInvalidOTP)
+		return nil, ErrInvalidOTP
+	}
+
+	// Clear failures for this phone number; the IP counter is left to expire on its own
+	if err := s.attemptRepo.Reset(phoneThrottleKey(phoneNumber)); err != nil {
+		// Log error but don't fail authentication
+		// In a real application, you'd use a proper logger here
+	}
+
+	// Update last login timestamp
+	if err := s.userRepo.UpdateLastLogin(foundUser.ID); err != nil {
+		// Log error but don't fail authentication
+		// In a real application, you'd use a proper logger here
+	}
+
+	return foundUser, nil
+}
+
+// otpLoginAllowed reports whether a user may log in with an SMS code,
+// either because the shop allows it for everyone or because it is enabled for the user
+func (s *service) otpLoginAllowed(u *user.User) bool {
+	return s.otp.loginEnabled || u.OTPLogin
+}
+
+// failOTPLogin counts a failed SMS code login like a failed PIN login
+// and reports it as an invalid code unless the account has just been locked
+func (s *service) failOTPLogin(phoneNumber string, u *user.User, client ClientInfo, reason string) error {
+	err := s.failLogin(LoginMethodOTP, phoneNumber, u, client, reason)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return ErrInvalidOTP
+	}
+	return err
+}
+
+// sendOTP generates a one-time code for the user, stores its hash and sends it by SMS
+// The message format receives the code and its lifetime in minutes. A code can only be
+// replaced once the resend interval of the previous unused code has passed.
+func (s *service) sendOTP(u *user.User, purpose, messageFormat string) error {
+	existing, err := s.otpRepo.FindOTP(u.ID, purpose)
+	if err != nil {
+		return errors.New("failed to check verification code")
+	}
+	if existing != nil && existing.ConsumedAt == nil {
+		if wait := time.Until(existing.CreatedAt.Add(s.otp.resendInterval)); wait > 0 {
+			return &TooManyAttemptsError{RetryAfter: wait}
+		}
+	}
+
+	code, err := utils.GenerateNumericCode(otpCodeLength)
+	if err != nil {
+		return errors.New("failed to generate verification code")
+	}
+
+	now := time.Now()
+	otp := &OTP{
+		UserID:    u.ID,
+		Purpose:   purpose,
+		CodeHash:  s.hashOTP(u.ID, purpose, code),
+		ExpiresAt: now.Add(s.otp.ttl),
+		CreatedAt: now,
+	}
+	if err := s.otpRepo.SaveOTP(otp); err != nil {
+		return errors.New("failed to store verification code")
+	}
+
+	message := fmt.Sprintf(messageFormat, code, int(s.otp.ttl.Round(time.Minute)/time.Minute))
+	if err := s.sms.Send(u.PhoneNumber, message); err != nil {
+		return errors.New("failed to send verification code")
+	}
+
+	return nil
+}
+
+// verifyOTP checks a one-time code of the user and returns the stored code if it matches
+// Every call counts against the code's attempt limit, right or wrong. The code is not
+// consumed; callers consume it once the rest of their checks have passed.
+func (s *service) verifyOTP(u *user.User, purpose, code string) (*OTP, error) {
+	otp, err := s.otpRepo.FindOTP(u.ID, purpose)
+	if err != nil {
+		return nil, errors.New("failed to check verification code")
+	}
+	if otp == nil || otp.ConsumedAt != nil || time.Now().After(otp.ExpiresAt) {
+		return nil, ErrInvalidOTP
+	}
+	if otp.Attempts >= s.otp.maxAttempts {
+		return nil, ErrOTPAttemptsExceeded
+	}
+
+	// Count the attempt before comparing so parallel guesses cannot get past the limit
+	attempts, err := s.otpRepo.IncrementOTPAttempts(otp.ID)
+	if err != nil {
+		return nil, errors.New("failed to check verification code")
+	}
+	if attempts > s.otp.maxAttempts {
+		return nil, ErrOTPAttemptsExceeded
+	}
+	if !hmac.Equal([]byte(otp.CodeHash), []byte(s.hashOTP(u.ID, purpose, code))) {
+		return nil, ErrInvalidOTP
+	}
+
+	return otp, nil
+}
+
+// checkNewPin applies the rules every new PIN must pass: it must differ from the current PIN,
+// pass the weak-PIN rules and not match one of the user's recent PINs
+func (s *service) checkNewPin(u *user.User, newPin string) error {
+	if utils.CheckPin(u.PinHash, newPin) == nil {
+		return &ValidationError{Field: "new_pin", Message: "new PIN must be different from the current PIN"}
+	}
+	if err := checkPinStrength(newPin, u.BirthDate); err != nil {
+		return err
+	}
+
+	previous, err := s.userRepo.RecentPinHashes(u.ID, s.pinHistory)
+	if err != nil {
+		return errors.New("failed to check PIN history")
+	}
+	for _, pinHash := range previous {
+		if utils.CheckPin(pinHash, newPin) == nil {
+			return &ValidationError{Field: "new_pin", Message: "new PIN must not match a recently used PIN"}
+		}
+	}
+
+	return nil
+}
+
+// hashOTP returns the keyed hash stored for a one-time code
+// The user and purpose are part of the input so a stored hash cannot be replayed elsewhere
+func (s *service) hashOTP(userID uuid.UUID, purpose, code string) string {
+	return utils.HashSecret(s.otp.key, purpose+":"+userID.String()+":"+code)
+}
+
+// validateOTPCode checks that a one-time code has the expected number of digits
+func validateOTPCode(code string) error {
+	if code == "" {
+		return &ValidationError{Field: "code", Message: "verification code is required"}
+	}
+	if len(code) != otpCodeLength || strings.Trim(code, "0123456789") != "" {
+		return &ValidationError{Field: "code", Message: fmt.Sprintf("invalid verification code format: must be exactly %d digits", otpCodeLength)}
+	}
+	return nil
+}
+
+// revokeOtherSessions revokes every active session of a user except the one with the given ID
+// and returns how many were revoked. An empty ID revokes all sessions.
+func (s *service) revokeOtherSessions(userID uuid.UUID, currentSessionID, reason string) (int, error) {
+	sessions, err := s.sessionRepo.ListActiveSessions(userID)
+	if err != nil {
+		return 0, errors.New("failed to list sessions")
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID.String() == currentSessionID {
+			continue
+		}
+		if err := s.familyRepo.RevokeFamily(session.ID, reason); err != nil {
+			return revoked, errors.New("failed to revoke other sessions")
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// pinFieldError reports a PIN format error against the given request field
+func pinFieldError(err error, field string) error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return &ValidationError{Field: field, Message: validationErr.Message}
+	}
+	return err
+}
+
+// checkLoginThrottle returns an error if the phone number is locked or still in its
+// progressive delay, or if the client IP has been blocked
+func (s *service) checkLoginThrottle(phoneNumber, clientIP string) error {
+	now := time.Now()
+
+	phoneThrottle, err := s.attemptRepo.GetThrottle(phoneThrottleKey(phoneNumber))
+	if err != nil {
+		return errors.New("failed to check login attempts")
+	}
+	if phoneThrottle != nil {
+		if phoneThrottle.LockedUntil != nil && phoneThrottle.LockedUntil.After(now) {
+			return &AccountLockedError{RetryAfter: phoneThrottle.LockedUntil.Sub(now)}
+		}
+
+		// Only failures inside the current window count towards the delay
+		if phoneThrottle.LastFailedAt.After(now.Add(-s.throttle.window)) {
+			nextAllowed := phoneThrottle.LastFailedAt.Add(s.loginDelay(phoneThrottle.FailedCount))
+			if nextAllowed.After(now) {
+				return &TooManyAttemptsError{RetryAfter: nextAllowed.Sub(now)}
+			}
+		}
+	}
+
+	if clientIP == "" {
+		return nil
+	}
+
+	ipThrottle, err := s.attemptRepo.GetThrottle(ipThrottleKey(clientIP))
+	if err != nil {
+		return errors.New("failed to check login attempts")
+	}
+	if ipThrottle != nil && ipThrottle.LockedUntil != nil && ipThrottle.LockedUntil.After(now) {
+		return &TooManyAttemptsError{RetryAfter: ipThrottle.LockedUntil.Sub(now)}
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts a failed attempt against the phone number and client IP,
+// locking either one once its limit is reached, and returns the error to report to the caller
+func (s *service) recordLoginFailure(phoneNumber, clientIP string) error {
+	now := time.Now()
+	windowStart := now.Add(-s.throttle.window)
+	lockedUntil := now.Add(s.throttle.lockoutDuration)
+
+	if clientIP != "" {
+		ipThrottle, err := s.attemptRepo.RecordFailure(ipThrottleKey(clientIP), windowStart)
+		if err == nil && s.throttle.maxAttemptsPerIP > 0 && ipThrottle.FailedCount >= s.throttle.maxAttemptsPerIP {
+			if err := s.attemptRepo.LockUntil(ipThrottle.Key, lockedUntil); err != nil {
+				// Log error but still report the failed attempt
+				// In a real application, you'd use a proper logger here
+			}
+		}
+	}
+
+	phoneThrottle, err := s.attemptRepo.RecordFailure(phoneThrottleKey(phoneNumber), windowStart)
+	if err != nil {
+		return ErrInvalidCredentials
+	}
+
+	if s.throttle.maxAttempts > 0 && phoneThrottle.FailedCount >= s.throttle.maxAttempts {
+		if err := s.attemptRepo.LockUntil(phoneThrottle.Key, lockedUntil); err != nil {
+			return ErrInvalidCredentials
+		}
+		return &AccountLockedError{RetryAfter: s.throttle.lockoutDuration}
+	}
+
+	return ErrInvalidCredentials
+}
+
+// loginDelay returns how long a client must wait after failedCount consecutive failures
+// The delay starts at the configured base and doubles with each failure, up to the configured maximum
+func (s *service) loginDelay(failedCount int) time.Duration {
+	if s.throttle.delayBase <= 0 || failedCount <= 0 {
+		return 0
+	}
+
+	delay := s.throttle.delayBase
+	for i := 1; i < failedCount; i++ {
+		delay *= 2
+		if s.throttle.delayMax > 0 && delay >= s.throttle.delayMax {
+			return s.throttle.delayMax
+		}
+	}
+
+	if s.throttle.delayMax > 0 && delay > s.throttle.delayMax {
+		return s.throttle.delayMax
+	}
+	return delay
+}
+
+// phoneThrottleKey builds the throttle key for a phone number
+func phoneThrottleKey(phoneNumber string) string {
+	return "phone:" + phoneNumber
+}
+
+// ipThrottleKey builds the throttle key for a client IP address
+func ipThrottleKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// GenerateAccessToken creates a new access token with the default access token lifetime
+func (s *service) GenerateAccessToken(userID uuid.UUID, phoneNumber string) (string, error) {
+	now := time.Now()
+	subject := tokenSubject{UserID: userID, PhoneNumber: phoneNumber}
+	tokenString, err := s.generateToken(subject, "access", uuid.NewString(), now, now.Add(s.lifetimes.defaults.Access))
+	if err != nil {
+		return "", errors.New("failed to generate access token")
+	}
+
+	return tokenString, nil
+}
+
+// GenerateRefreshToken creates a new refresh token with the default refresh token lifetime
+func (s *service) GenerateRefreshToken(userID uuid.UUID, phoneNumber string) (string, error) {
+	now := time.Now()
+	subject := tokenSubject{UserID: userID, PhoneNumber: phoneNumber}
+	tokenString, err := s.generateToken(subject, "refresh", uuid.NewString(), now, now.Add(s.lifetimes.defaults.Refresh))
+	if err != nil {
+		return "", errors.New("failed to generate refresh token")
+	}
+
+	return tokenString, nil
+}
+
+// GenerateTokens creates both access and refresh tokens for a user
+// Each call starts a new token family and registers it as a session, so it should be used once per login
+// Token lifetimes are resolved from the user's role and the client type
+func (s *service) GenerateTokens(u *user.User, client ClientInfo) (*TokenPair, error) {
+	if u == nil {
+		return nil, errors.New("user is required")
+	}
+
+	// Every login method ends here, so suspended and locked accounts are turned away in one place
+	if err := accountStatusError(u.Status); err != nil {
+		s.recordLoginFailed(client.LoginMethod, u.PhoneNumber, u, client, authFailureReason(err))
+		return nil, err
+	}
+
+	subject := tokenSubject{
+		UserID:      u.ID,
+		PhoneNumber: u.PhoneNumber,
+		Role:        u.Role,
+		Branch:      u.Branch,
+		ClientType:  client.ClientType,
+		DeviceID:    client.DeviceID,
+
+		PinChangeRequired: u.Status == user.StatusPendingPinChange,
+	}
+	if err := s.checkDevice(subject); err != nil {
+		s.recordLoginFailed(client.LoginMethod, u.PhoneNumber, u, client, authFailureReason(err))
+		return nil, err
+	}
+
+	tokens, sessionID, err := s.startSession(subject, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare with earlier logins before this one is recorded
+	now := time.Now()
+	alertReasons := s.loginAlertReasons(u, client, now)
+
+	s.recordAuthEvent(AuthEvent{
+		EventType:   AuthEventLoginSucceeded,
+		UserID:      &u.ID,
+		PhoneNumber: u.PhoneNumber,
+		Method:      client.LoginMethod,
+		SessionID:   &sessionID,
+	}, client)
+
+	if len(alertReasons) > 0 {
+		s.raiseLoginAlert(u, sessionID, client, alertReasons, now)
+	}
+
+	if client.DeviceID != "" {
+		device := &Device{UserID: u.ID, DeviceID: client.DeviceID, Name: client.DeviceName, LastIP: client.IPAddress}
+		if err := s.deviceRepo.RecordDevice(device); err != nil {
+			// Log error but don't fail the login
+			// In a real application, you'd use a proper logger here
+		}
+	}
+
+	return tokens, nil
+}
+
+// checkDevice returns ErrDeviceNotAllowed if logins are restricted to shop devices and the
+// subject's device is not one of them. Users who may manage shop devices are exempt so they
+// can always reach the device settings.
+func (s *service) checkDevice(subject tokenSubject) error {
+	if !s.shopDevices {
+		return nil
+	}
+
+	policySubject := policy.Subject{UserID: subject.UserID, Role: subject.Role, Branch: subject.Branch}
+	err := s.authorizer.Authorize(context.Background(), policySubject, policy.PermissionShopDeviceManage, nil)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, policy.ErrDenied) {
+		return errors.New("failed to check device")
+	}
+
+	registered, err := s.deviceRepo.IsShopDevice(subject.DeviceID)
+	if err != nil {
+		return errors.New("failed to check device")
+	}
+	if !registered {
+		return ErrDeviceNotAllowed
+	}
+
+	return nil
+}
+
+// startSession creates a token family with its session and issues the first token pair
+// Returns the ID of the new session. The session's absolute lifetime is counted from now
+func (s *service) startSession(subject tokenSubject, client ClientInfo) (*TokenPair, uuid.UUID, error) {
+	familyID, err := s.familyRepo.CreateFamily(subject.UserID)
+	if err != nil {
+		return nil, uuid.Nil, errors.New("failed to create token family")
+	}
+
+	subject.FamilyID = familyID.String()
+	subject.AuthTime = time.Now()
+
+	refreshJTI := uuid.NewString()
+	tokens, err := s.generateTokenPair(subject, refreshJTI)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	session := &Session{
+		ID:         familyID,
+		UserID:     subject.UserID,
+		JTI:        refreshJTI,
+		DeviceID:   subject.DeviceID,
+		DeviceName: client.DeviceName,
+		UserAgent:  client.UserAgent,
+		IPAddress:  client.IPAddress,
+		ClientType: subject.ClientType,
+	}
+	if err := s.sessionRepo.CreateSession(session); err != nil {
+		return nil, uuid.Nil, errors.New("failed to create session")
+	}
+
+	return tokens, familyID, nil
+}
+
+// RefreshTokens rotates a refresh token: the presented token is invalidated and a new
+// token pair is issued in the same family. Consumption is atomic, so concurrent requests
+// with the same token cannot both rotate it; a duplicate arriving within the grace period
+// gets the pair already issued for that token. Presenting a refresh token after that
+// revokes the whole family, cutting off whoever holds its live tokens. Rotation never
+// extends the session past its absolute maximum lifetime; after that ErrSessionExpired is returned.
+// Every refresh of a valid token is recorded in the audit log, whether it succeeds or not.
+func (s *service) RefreshTokens(refreshToken string, client ClientInfo) (*TokenPair, *Claims, error) {
+	claims, err := s.ParseToken(refreshToken)
+	if err != nil {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	tokens, err := s.useRefreshToken(refreshToken, claims, client)
+	if err != nil {
+		s.recordSessionEvent(AuthEventRefreshFailed, claims, client, err)
+		return nil, nil, err
+	}
+
+	s.recordSessionEvent(AuthEventTokenRefreshed, claims, client, nil)
+	return tokens, claims, nil
+}
+
+// useRefreshToken consumes a parsed refresh token and returns the pair that replaces it
+func (s *service) useRefreshToken(refreshToken string, claims *Claims, client ClientInfo) (*TokenPair, error) {
+	// Ensure this is actually a refresh token
+	if claims.TokenType != "refresh" {
+		return nil, ErrWrongTokenType
+	}
+
+	// A refresh token bound to a device only works from that device, and only while the
+	// device is still allowed to log in
+	if claims.DeviceID != "" && claims.DeviceID != client.DeviceID {
+		return nil, ErrDeviceMismatch
+	}
+	if err := s.checkDevice(tokenSubject{UserID: claims.UserID, Role: claims.Role, Branch: claims.Branch, DeviceID: claims.DeviceID}); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkTokenFamily(claims); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Suspended and locked users cannot stay logged in by refreshing, and neither can sessions
+	// that were logged out everywhere
+	state, err := s.accountState(claims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if err := accountStatusError(state.Status); err != nil {
+		return nil, err
+	}
+	if issuedBeforeCutoff(claims, state.TokensValidAfter) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	expiresAt := time.Now().Add(s.lifetimes.resolve(claims.Role, claims.ClientType).Refresh)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	var tokens *TokenPair
+	var rotateErr error
+	use, err := s.blacklistRepo.ConsumeRefreshToken(refreshToken, claims.UserID.String(), expiresAt, func() ([]byte, error) {
+		tokens, rotateErr = s.rotateRefreshToken(claims, state.Status == user.StatusPendingPinChange, client)
+		if rotateErr != nil {
+			return nil, rotateErr
+		}
+		return sealTokenPair(refreshToken, tokens)
+	})
+	if err != nil {
+		if rotateErr != nil {
+			return nil, rotateErr
+		}
+		return nil, errors.New("failed to invalidate old refresh token")
+	}
+
+	if use.Consumed {
+		return tokens, nil
+	}
+
+	// A concurrent or retried request already rotated this token; hand back the same pair
+	if use.Successor != nil && time.Since(use.ConsumedAt) <= s.refreshGrace {
+		tokens, err := openTokenPair(refreshToken, use.Successor)
+		if err == nil {
+			return tokens, nil
+		}
+		// Log error but fall through to reuse handling
+		// In a real application, you'd use a proper logger here
+	}
+
+	return nil, s.handleRefreshTokenReuse(claims)
+}
+
+// rotateRefreshToken issues the token pair that replaces a consumed refresh token
+// The new pair keeps the role, client type and login time of the session
+// Tokens issued before families existed start a family and session on their first rotation
+// The new tokens require a PIN change for as long as the account is pending_pin_change.
+func (s *service) rotateRefreshToken(claims *Claims, pinChangeRequired bool, client ClientInfo) (*TokenPair, error) {
+	subject := tokenSubject{
+		UserID:      claims.UserID,
+		PhoneNumber: claims.PhoneNumber,
+		Role:        claims.Role,
+		Branch:      claims.Branch,
+		ClientType:  claims.ClientType,
+		DeviceID:    claims.DeviceID,
+		FamilyID:    claims.FamilyID,
+
+		PinChangeRequired: pinChangeRequired,
+	}
+
+	if claims.FamilyID == "" {
+		tokens, _, err := s.startSession(subject, client)
+		return tokens, err
+	}
+
+	switch {
+	case claims.AuthTime != nil:
+		subject.AuthTime = claims.AuthTime.Time
+	case claims.IssuedAt != nil:
+		// Tokens issued before auth_time existed count the session from their own issue time
+		subject.AuthTime = claims.IssuedAt.Time
+	default:
+		subject.AuthTime = time.Now()
+	}
+
+	refreshJTI := uuid.NewString()
+	tokens, err := s.generateTokenPair(subject, refreshJTI)
+	if err != nil {
+		return nil, err
+	}
+
+	if sessionID, err := uuid.Parse(claims.FamilyID); err == nil {
+		if err := s.sessionRepo.TouchSession(sessionID, refreshJTI, client.IPAddress); err != nil {
+			// Log error but don't fail the refresh process
+			// In a real application, you'd use a proper logger here
+		}
+	}
+
+	return tokens, nil
+}
+
+// sealTokenPair encrypts a token pair with a key derived from the refresh token it replaces,
+// so only a holder of that refresh token can recover the pair during the grace period
+func sealTokenPair(refreshToken string, tokens *TokenPair) ([]byte, error) {
+	plaintext, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Encrypt(utils.DeriveKey("refresh-successor", refreshToken), plaintext)
+}
+
+// openTokenPair decrypts a token pair sealed by sealTokenPair
+func openTokenPair(refreshToken string, sealed []byte) (*TokenPair, error) {
+	plaintext, err := utils.Decrypt(utils.DeriveKey("refresh-successor", refreshToken), sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens TokenPair
+	if err := json.Unmarshal(plaintext, &tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+// Logout ends the session an access token belongs to and records the logout
+// Tokens issued before sessions existed have no session to end; their logout is still recorded
+func (s *service) Logout(claims *Claims, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	s.recordSessionEvent(AuthEventLogout, claims, client, nil)
+
+	sessionID, err := uuid.Parse(claims.FamilyID)
+	if err != nil {
+		return nil
+	}
+
+	if err := s.familyRepo.RevokeFamily(sessionID, RevokedReasonLogout); err != nil {
+		return errors.New("failed to end session")
+	}
+
+	return nil
+}
+
+// LogoutAll logs the user out on every device, including the one making the request
+// All of the user's tokens issued so far are rejected from then on.
+func (s *service) LogoutAll(claims *Claims, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	if err := s.revokeAllTokens(claims.UserID, RevokedReasonLogoutAll); err != nil {
+		return err
+	}
+
+	s.recordSessionEvent(AuthEventLogoutAll, claims, client, nil)
+	return nil
+}
+
+// ListSessions returns the active sessions of a user
+func (s *service) ListSessions(userID uuid.UUID) ([]Session, error) {
+	sessions, err := s.sessionRepo.ListActiveSessions(userID)
+	if err != nil {
+		return nil, errors.New("failed to list sessions")
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends one of a user's sessions by revoking its token family
+// Tokens of the session are rejected from then on, including access tokens that have not expired yet
+func (s *service) RevokeSession(userID, sessionID uuid.UUID, client ClientInfo) error {
+	session, err := s.sessionRepo.FindSession(sessionID)
+	if err != nil {
+		return errors.New("failed to find session")
+	}
+	if session == nil || session.UserID != userID || session.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+
+	if err := s.familyRepo.RevokeFamily(sessionID, RevokedReasonSessionRevoked); err != nil {
+		return errors.New("failed to revoke session")
+	}
+
+	s.recordAuthEvent(AuthEvent{EventType: AuthEventSessionRevoked, UserID: &userID, SessionID: &sessionID}, client)
+	return nil
 }
 
-// Service defines the interface for authentication operations
-type Service interface {
-	ValidatePhoneNumber(phoneNumber string) error
-	ValidatePin(pin string) error
-	AuthenticateUser(phoneNumber, pin string) (*user.User, error)
-	GenerateAccessToken(userID uuid.UUID, phoneNumber string) (string, error)
-	GenerateRefreshToken(userID uuid.UUID, phoneNumber string) (string, error)
-	GenerateTokens(userID uuid.UUID, phoneNumber string) (*TokenPair, error)
-	ValidateToken(tokenString string) (*Claims, error)
-	ParseToken(tokenString string) (*Claims, error)
-	BlacklistToken(tokenString string) error
-	IsTokenBlacklisted(tokenString string) (bool, error)
+// ListDevices returns the devices a user has logged in from, most recently used first
+func (s *service) ListDevices(userID uuid.UUID) ([]Device, error) {
+	devices, err := s.deviceRepo.ListDevices(userID)
+	if err != nil {
+		return nil, errors.New("failed to list devices")
+	}
+
+	return devices, nil
 }
 
-// service implements the Service interface
-type service struct {
-	userRepo       user.Repository
-	blacklistRepo  BlacklistRepository
-	jwtSecret      string
+// RemoveDevice removes one of the user's devices and revokes every session bound to it
+// The device is added again the next time someone logs in on it
+func (s *service) RemoveDevice(userID, id uuid.UUID) error {
+	device, err := s.deviceRepo.DeleteDevice(userID, id)
+	if err != nil {
+		return errors.New("failed to remove device")
+	}
+	if device == nil {
+		return ErrDeviceNotFound
+	}
+
+	// A removed device must not be able to log back in with its device key either
+	if err := s.deviceKeyRepo.RevokeDeviceKeysForDevice(userID, device.DeviceID); err != nil {
+		return errors.New("failed to revoke device keys")
+	}
+
+	sessions, err := s.sessionRepo.ListActiveSessions(userID)
+	if err != nil {
+		return errors.New("failed to list sessions")
+	}
+	for _, session := range sessions {
+		if session.DeviceID != device.DeviceID {
+			continue
+		}
+		if err := s.familyRepo.RevokeFamily(session.ID, RevokedReasonDeviceRemoved); err != nil {
+			return errors.New("failed to revoke device sessions")
+		}
+	}
+
+	return nil
 }
 
-// NewService creates a new authentication service instance
-func NewService(userRepo user.Repository, blacklistRepo BlacklistRepository, cfg *config.Config) Service {
-	return &service{
-		userRepo:      userRepo,
-		blacklistRepo: blacklistRepo,
-		jwtSecret:     cfg.JWTSecret,
+// ListShopDevices returns the devices registered as belonging to the shop
+func (s *service) ListShopDevices() ([]ShopDevice, error) {
+	devices, err := s.deviceRepo.ListShopDevices()
+	if err != nil {
+		return nil, errors.New("failed to list shop devices")
 	}
+
+	return devices, nil
 }
 
-// ValidatePhoneNumber validates Thai phone number format (^0[0-9]{9}$)
-func (s *service) ValidatePhoneNumber(phoneNumber string) error {
-	if phoneNumber == "" {
-		return errors.New("phone number is required")
+// RegisterShopDevice registers a device as belonging to the shop, or renames it if it already is
+// This is an owner operation; callers must check the role
+func (s *service) RegisterShopDevice(ownerID uuid.UUID, deviceID, name string) (*ShopDevice, error) {
+	if err := ValidateDeviceID(deviceID); err != nil {
+		return nil, err
+	}
+
+	device := &ShopDevice{DeviceID: deviceID, Name: strings.TrimSpace(name), RegisteredBy: ownerID}
+	if err := s.deviceRepo.RegisterShopDevice(device); err != nil {
+		return nil, errors.New("failed to register shop device")
 	}
 
-	// Thai phone number format: starts with 0, followed by 9 digits (total 10 digits)
-	thaiPhoneRegex := regexp.MustCompile(`^0[0-9]{9}$`)
-	if !thaiPhoneRegex.MatchString(phoneNumber) {
-		return errors.New("invalid phone number format: must be 10 digits starting with 0")
+	return device, nil
+}
+
+// RemoveShopDevice deregisters a shop device
+// When logins are restricted to shop devices, its staff sessions stop refreshing
+func (s *service) RemoveShopDevice(deviceID string) error {
+	removed, err := s.deviceRepo.DeleteShopDevice(deviceID)
+	if err != nil {
+		return errors.New("failed to remove shop device")
+	}
+	if !removed {
+		return ErrDeviceNotFound
 	}
 
 	return nil
 }
 
-// ValidatePin validates 6-digit PIN format (^[0-9]{6}$)
-func (s *service) ValidatePin(pin string) error {
-	if pin == "" {
-		return errors.New("PIN is required")
+// RegisterDeviceKey registers the public key of the requesting device for PIN-less login
+// The user confirms the PIN, so a token obtained with a device key cannot register further keys
+// on its own. The key is bound to the device ID of the token and replaces any earlier key of
+// that device.
+func (s *service) RegisterDeviceKey(claims *Claims, pin, algorithm, publicKey, name string, client ClientInfo) (*DeviceKey, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+	if err := ValidateDeviceID(claims.DeviceID); err != nil {
+		return nil, err
+	}
+	if err := s.ValidatePin(pin); err != nil {
+		return nil, pinFieldError(err, "pin")
 	}
 
-	// PIN format: exactly 6 digits
-	pinRegex := regexp.MustCompile(`^[0-9]{6}$`)
-	if !pinRegex.MatchString(pin) {
-		return errors.New("invalid PIN format: must be exactly 6 digits")
+	der, err := parseDevicePublicKey(algorithm, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	foundUser, err := s.userRepo.FindByID(claims.UserID)
+	if err != nil {
+		return nil, errors.New("failed to find user")
+	}
+
+	// Guessing the PIN here is throttled exactly like guessing it at login
+	if err := s.checkLoginThrottle(foundUser.PhoneNumber, client.IPAddress); err != nil {
+		return nil, err
+	}
+	if err := utils.CheckPin(foundUser.PinHash, pin); err != nil {
+		return nil, s.failAttempt(AuthEventDeviceKeyRegisterFailed, "", foundUser.PhoneNumber, foundUser, client, AuthReasonInvalidPin)
+	}
+
+	if err := s.deviceKeyRepo.RevokeDeviceKeysForDevice(foundUser.ID, claims.DeviceID); err != nil {
+		return nil, errors.New("failed to replace device key")
+	}
+
+	key := &DeviceKey{
+		UserID:    foundUser.ID,
+		DeviceID:  claims.DeviceID,
+		Name:      strings.TrimSpace(name),
+		Algorithm: algorithm,
+		PublicKey: der,
+	}
+	if err := s.deviceKeyRepo.CreateDeviceKey(key); err != nil {
+		return nil, errors.New("failed to register device key")
+	}
+
+	details := fmt.Sprintf("%s device key registered for device %s", algorithm, claims.DeviceID)
+	if err := s.eventRepo.RecordEvent(foundUser.ID, SecurityEventDeviceKeyAdded, details); err != nil {
+		// Log error but don't fail the registration
+		// In a real application, you'd use a proper logger here
+	}
+
+	return key, nil
+}
+
+// ListDeviceKeys returns the user's unrevoked device keys, newest first
+func (s *service) ListDeviceKeys(userID uuid.UUID) ([]DeviceKey, error) {
+	keys, err := s.deviceKeyRepo.ListDeviceKeys(userID)
+	if err != nil {
+		return nil, errors.New("failed to list device keys")
+	}
+
+	return keys, nil
+}
+
+// RevokeDeviceKey revokes one of the user's device keys
+// Sessions started with the key stay active; revoke them separately if needed
+func (s *service) RevokeDeviceKey(userID, id uuid.UUID) error {
+	revoked, err := s.deviceKeyRepo.RevokeDeviceKey(userID, id)
+	if err != nil {
+		return errors.New("failed to revoke device key")
+	}
+	if !revoked {
+		return ErrDeviceKeyNotFound
 	}
 
 	return nil
 }
 
-// AuthenticateUser validates user credentials and returns the user if authentication succeeds
-func (s *service) AuthenticateUser(phoneNumber, pin string) (*user.User, error) {
-	// Validate input format
-	if err := s.ValidatePhoneNumber(phoneNumber); err != nil {
+// CreateDeviceKeyChallenge issues a nonce for the device key to sign
+// A new challenge replaces the previous one of the key, and each challenge can be used once.
+func (s *service) CreateDeviceKeyChallenge(keyID uuid.UUID, client ClientInfo) (*DeviceKeyChallenge, error) {
+	key, foundUser, err := s.findActiveDeviceKey(keyID)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := s.ValidatePin(pin); err != nil {
+	// A locked account cannot log in, so there is no point in issuing a challenge
+	if err := s.checkLoginThrottle(foundUser.PhoneNumber, client.IPAddress); err != nil {
 		return nil, err
 	}
 
-	// Find user by phone number
-	foundUser, err := s.userRepo.FindByPhoneNumber(phoneNumber)
+	nonce, err := newDeviceKeyNonce()
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, errors.New("failed to generate challenge")
 	}
 
-	// Verify PIN against stored hash
-	if err := utils.CheckPin(foundUser.PinHash, pin); err != nil {
-		return nil, errors.New("invalid credentials")
+	challenge := &DeviceKeyChallenge{
+		KeyID:     key.ID,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(s.challengeTTL),
+	}
+	if err := s.deviceKeyRepo.SaveChallenge(challenge); err != nil {
+		return nil, errors.New("failed to save challenge")
+	}
+
+	return challenge, nil
+}
+
+// AuthenticateUserWithDeviceKey authenticates a user with a signature of the current challenge
+// of a device key, as an alternative to the PIN. The request must come from the device the key
+// was registered on. Bad signatures count as failed login attempts.
+func (s *service) AuthenticateUserWithDeviceKey(keyID uuid.UUID, signature string, client ClientInfo) (*user.User, error) {
+	key, foundUser, err := s.findActiveDeviceKey(keyID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidDeviceKey) {
+			s.recordLoginFailed(LoginMethodDeviceKey, "", nil, client, AuthReasonInvalidDeviceKey)
+		}
+		return nil, err
+	}
+	if client.DeviceID != key.DeviceID {
+		s.recordLoginFailed(LoginMethodDeviceKey, "", foundUser, client, AuthReasonDeviceMismatch)
+		return nil, ErrDeviceMismatch
+	}
+
+	if err := s.checkLoginThrottle(foundUser.PhoneNumber, client.IPAddress); err != nil {
+		s.recordLoginFailed(LoginMethodDeviceKey, "", foundUser, client, authFailureReason(err))
+		return nil, err
+	}
+
+	challenge, err := s.deviceKeyRepo.ConsumeChallenge(key.ID)
+	if err != nil {
+		return nil, errors.New("failed to check challenge")
+	}
+	if challenge == nil || time.Now().After(challenge.ExpiresAt) {
+		s.recordLoginFailed(LoginMethodDeviceKey, "", foundUser, client, AuthReasonInvalidDeviceKey)
+		return nil, ErrInvalidDeviceKey
+	}
+
+	sig, err := decodeBase64(signature)
+	if err != nil || !verifyDeviceKeySignature(key, []byte(challenge.Nonce), sig) {
+		err := s.failLogin(LoginMethodDeviceKey, foundUser.PhoneNumber, foundUser, client, AuthReasonInvalidDeviceKey)
+		if errors.Is(err, ErrInvalidCredentials) {
+			return nil, ErrInvalidDeviceKey
+		}
+		return nil, err
+	}
+
+	// Clear failures for this phone number; the IP counter is left to expire on its own
+	if err := s.attemptRepo.Reset(phoneThrottleKey(foundUser.PhoneNumber)); err != nil {
+		// Log error but don't fail authentication
+		// In a real application, you'd use a proper logger here
+	}
+
+	if err := s.deviceKeyRepo.TouchDeviceKey(key.ID); err != nil {
+		// Log error but don't fail authentication
+		// In a real application, you'd use a proper logger here
 	}
 
 	// Update last login timestamp
@@ -118,77 +1460,165 @@ func (s *service) AuthenticateUser(phoneNumber, pin string) (*user.User, error)
 	return foundUser, nil
 }
 
-// GenerateAccessToken creates a new access token with 15-minute expiration
-func (s *service) GenerateAccessToken(userID uuid.UUID, phoneNumber string) (string, error) {
-	expirationTime := time.Now().Add(15 * time.Minute)
-	
-	claims := &Claims{
-		UserID:      userID,
-		PhoneNumber: phoneNumber,
-		TokenType:   "access",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "tt-stock-api",
-			Subject:   userID.String(),
-		},
+// findActiveDeviceKey loads an unrevoked device key and its user
+// Unknown and revoked keys are both reported as ErrInvalidDeviceKey
+func (s *service) findActiveDeviceKey(keyID uuid.UUID) (*DeviceKey, *user.User, error) {
+	key, err := s.deviceKeyRepo.FindDeviceKey(keyID)
+	if err != nil {
+		return nil, nil, errors.New("failed to find device key")
+	}
+	if key == nil || key.RevokedAt != nil {
+		return nil, nil, ErrInvalidDeviceKey
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+	foundUser, err := s.userRepo.FindByID(key.UserID)
 	if err != nil {
-		return "", errors.New("failed to generate access token")
+		return nil, nil, ErrInvalidDeviceKey
 	}
 
-	return tokenString, nil
+	return key, foundUser, nil
 }
 
-// GenerateRefreshToken creates a new refresh token with 1-day expiration
-func (s *service) GenerateRefreshToken(userID uuid.UUID, phoneNumber string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
-	
-	claims := &Claims{
-		UserID:      userID,
-		PhoneNumber: phoneNumber,
-		TokenType:   "refresh",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "tt-stock-api",
-			Subject:   userID.String(),
-		},
+// deviceIDPattern restricts device IDs to opaque identifiers such as UUIDs or vendor IDs
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{8,64}$`)
+
+// ValidateDeviceID validates the format of a client-supplied device ID
+func ValidateDeviceID(deviceID string) error {
+	if deviceID == "" {
+		return &ValidationError{Field: "device_id", Message: "device ID is required"}
+	}
+	if !deviceIDPattern.MatchString(deviceID) {
+		return &ValidationError{Field: "device_id", Message: "invalid device ID format: must be 8 to 64 letters, digits or . _ : -"}
 	}
+	return nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+// handleRefreshTokenReuse revokes the family of a refresh token that was presented after
+// it had already been rotated out, and records a security event for the user
+func (s *service) handleRefreshTokenReuse(claims *Claims) error {
+	familyID, err := uuid.Parse(claims.FamilyID)
 	if err != nil {
-		return "", errors.New("failed to generate refresh token")
+		// Tokens without a family cannot be traced to a chain; treat them as plain invalid tokens
+		return ErrInvalidRefreshToken
 	}
 
-	return tokenString, nil
+	revoked, err := s.familyRepo.IsFamilyRevoked(familyID)
+	if err != nil {
+		return errors.New("failed to check token family status")
+	}
+	if revoked {
+		// The family is already dead, nothing left to protect
+		return ErrInvalidRefreshToken
+	}
+
+	if err := s.familyRepo.RevokeFamily(familyID, SecurityEventRefreshTokenReuse); err != nil {
+		return errors.New("failed to revoke token family")
+	}
+
+	details := "rotated-out refresh token presented; token family " + familyID.String() + " revoked"
+	if err := s.eventRepo.RecordEvent(claims.UserID, SecurityEventRefreshTokenReuse, details); err != nil {
+		// Log error but still report the reuse
+		// In a real application, you'd use a proper logger here
+	}
+
+	return ErrRefreshTokenReused
 }
 
-// GenerateTokens creates both access and refresh tokens for a user
-func (s *service) GenerateTokens(userID uuid.UUID, phoneNumber string) (*TokenPair, error) {
-	accessToken, err := s.GenerateAccessToken(userID, phoneNumber)
+// checkTokenFamily returns an error if the token belongs to a revoked family
+func (s *service) checkTokenFamily(claims *Claims) error {
+	if claims.FamilyID == "" {
+		return nil
+	}
+
+	familyID, err := uuid.Parse(claims.FamilyID)
 	if err != nil {
-		return nil, err
+		return ErrInvalidToken
 	}
 
-	refreshToken, err := s.GenerateRefreshToken(userID, phoneNumber)
+	revoked, err := s.familyRepo.IsFamilyRevoked(familyID)
 	if err != nil {
-		return nil, err
+		return errors.New("failed to check token family status")
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// generateTokenPair creates access and refresh tokens belonging to the subject's family
+// Lifetimes are resolved for the subject's role and client type, and neither token outlives
+// the session's absolute maximum lifetime
+// The refresh token ID is chosen by the caller so it can be recorded on the session
+func (s *service) generateTokenPair(subject tokenSubject, refreshJTI string) (*TokenPair, error) {
+	// Token expiry is encoded in whole seconds, so work from a truncated clock
+	now := time.Now().Truncate(time.Second)
+	lifetimes := s.lifetimes.resolve(subject.Role, subject.ClientType)
+
+	accessExpiresAt := now.Add(lifetimes.Access)
+	refreshExpiresAt := now.Add(lifetimes.Refresh)
+
+	if lifetimes.SessionMax > 0 && !subject.AuthTime.IsZero() {
+		sessionEndsAt := subject.AuthTime.Add(lifetimes.SessionMax).Truncate(time.Second)
+		if !sessionEndsAt.After(now) {
+			return nil, ErrSessionExpired
+		}
+		if accessExpiresAt.After(sessionEndsAt) {
+			accessExpiresAt = sessionEndsAt
+		}
+		if refreshExpiresAt.After(sessionEndsAt) {
+			refreshExpiresAt = sessionEndsAt
+		}
+	}
+
+	accessToken, err := s.generateToken(subject, "access", uuid.NewString(), now, accessExpiresAt)
+	if err != nil {
+		return nil, errors.New("failed to generate access token")
+	}
+
+	refreshToken, err := s.generateToken(subject, "refresh", refreshJTI, now, refreshExpiresAt)
+	if err != nil {
+		return nil, errors.New("failed to generate refresh token")
 	}
 
 	return &TokenPair{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		ExpiresIn:    15 * 60, // 15 minutes in seconds
+		ExpiresIn:    int64(accessExpiresAt.Sub(now).Seconds()),
+
+		PinChangeRequired: subject.PinChangeRequired,
 	}, nil
 }
 
+// generateToken signs a token of the given type for a subject
+func (s *service) generateToken(subject tokenSubject, tokenType, tokenID string, issuedAt, expiresAt time.Time) (string, error) {
+	claims := &Claims{
+		UserID:      subject.UserID,
+		PhoneNumber: subject.PhoneNumber,
+		TokenType:   tokenType,
+		FamilyID:    subject.FamilyID,
+		Role:        subject.Role,
+		Branch:      subject.Branch,
+		ClientType:  subject.ClientType,
+		DeviceID:    subject.DeviceID,
+
+		PinChangeRequired: subject.PinChangeRequired,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			NotBefore: jwt.NewNumericDate(issuedAt),
+			Issuer:    "tt-stock-api",
+			Subject:   subject.UserID.String(),
+			ID:        tokenID,
+		},
+	}
+	if !subject.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(subject.AuthTime)
+	}
+
+	return s.keys.SignToken(claims)
+}
+
 // ValidateToken validates a JWT token and returns its claims
 func (s *service) ValidateToken(tokenString string) (*Claims, error) {
 	// First check if token is blacklisted
@@ -197,39 +1627,152 @@ func (s *service) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("failed to check token blacklist status")
 	}
 	if isBlacklisted {
-		return nil, errors.New("token has been invalidated")
+		return nil, ErrTokenRevoked
 	}
 
 	// Then parse and validate the token
-	return s.ParseToken(tokenString)
+	claims, err := s.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Then make sure the token's family has not been revoked
+	if err := s.checkTokenFamily(claims); err != nil {
+		return nil, err
+	}
+
+	// Finally make sure the user has not been logged out everywhere since the token was issued
+	if err := s.checkTokenCutoff(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// AccountStatus returns a user's current account status
+// Lookups are cached for USER_STATUS_CACHE_TTL, so checking the status on every request is cheap
+// and a status change made through another instance applies here within that delay.
+// Returns ErrUserNotFound if the user has been deleted.
+func (s *service) AccountStatus(userID uuid.UUID) (string, error) {
+	state, err := s.accountState(userID)
+	if err != nil {
+		return "", err
+	}
+	return state.Status, nil
+}
+
+// accountState returns a user's account status and token cutoff, cached for USER_STATUS_CACHE_TTL
+func (s *service) accountState(userID uuid.UUID) (user.AccountState, error) {
+	if state, ok := s.accounts.get(userID); ok {
+		return state, nil
+	}
+
+	state, err := s.userRepo.FindAccountState(userID)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return user.AccountState{}, ErrUserNotFound
+		}
+		return user.AccountState{}, errors.New("failed to check account status")
+	}
+
+	s.accounts.store(userID, *state)
+	return *state, nil
+}
+
+// checkTokenCutoff returns ErrTokenRevoked if the token was issued before the user was last
+// logged out everywhere, and ErrInvalidToken if the user no longer exists
+func (s *service) checkTokenCutoff(claims *Claims) error {
+	state, err := s.accountState(claims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	if issuedBeforeCutoff(claims, state.TokensValidAfter) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// issuedBeforeCutoff reports whether a token was issued up to the user's tokens_valid_after cutoff
+// Token issue times are whole seconds, so a token issued within the second of the cutoff cannot
+// be placed before or after it. Such a token is rejected unless it belongs to a session: the
+// sessions that existed at the cutoff were ended with it, so the family check rejects their
+// tokens, while sessions started right after, e.g. by logging in again, keep working.
+// A token without an issue time is rejected once there is a cutoff.
+func issuedBeforeCutoff(claims *Claims, tokensValidAfter *time.Time) bool {
+	if tokensValidAfter == nil {
+		return false
+	}
+	if claims.IssuedAt == nil {
+		return true
+	}
+
+	cutoff := tokensValidAfter.Truncate(time.Second)
+	if claims.IssuedAt.Time.Equal(cutoff) {
+		return claims.FamilyID == ""
+	}
+	return claims.IssuedAt.Time.Before(cutoff)
+}
+
+// revokeAllTokens logs a user out everywhere by moving their token cutoff to now and ending all
+// of their sessions
+// Every token issued so far is rejected from then on without blacklisting them one by one, and
+// no refresh token of an earlier session can be used again.
+func (s *service) revokeAllTokens(userID uuid.UUID, reason string) error {
+	if err := s.userRepo.RevokeTokens(userID, time.Now()); err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return errors.New("failed to revoke tokens")
+	}
+	s.accounts.forget(userID)
+
+	if _, err := s.revokeOtherSessions(userID, "", reason); err != nil {
+		return err
+	}
+	return nil
+}
+
+// accountStatusError returns the error that keeps a user with the given status out, or nil if
+// they may log in. Unknown statuses are treated as locked.
+func accountStatusError(status string) error {
+	switch status {
+	case user.StatusActive, user.StatusPendingPinChange:
+		return nil
+	case user.StatusSuspended:
+		return ErrAccountSuspended
+	default:
+		return ErrAccountLocked
+	}
 }
 
 // ParseToken parses and validates a JWT token, returning its claims
 func (s *service) ParseToken(tokenString string) (*Claims, error) {
 	if tokenString == "" {
-		return nil, errors.New("token is required")
+		return nil, ErrInvalidToken
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid token signing method")
-		}
-		return []byte(s.jwtSecret), nil
-	})
+	// The key manager validates the signing method and resolves the key by kid
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, s.keys.Keyfunc)
 
 	if err != nil {
-		return nil, errors.New("invalid token")
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrInvalidToken
 	}
 
 	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
-		return nil, errors.New("invalid token claims")
+		return nil, ErrInvalidToken
 	}
 
 	// Check if token is expired
 	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(time.Now()) {
-		return nil, errors.New("token has expired")
+		return nil, ErrTokenExpired
 	}
 
 	return claims, nil
@@ -244,7 +1787,7 @@ func (s *service) BlacklistToken(tokenString string) error {
 	// Parse the token to get its claims
 	claims, err := s.ParseToken(tokenString)
 	if err != nil {
-		return errors.New("invalid token")
+		return ErrInvalidToken
 	}
 
 	// Add token to blacklist
@@ -264,4 +1807,55 @@ func (s *service) IsTokenBlacklisted(tokenString string) (bool, error) {
 	}
 
 	return s.blacklistRepo.IsTokenBlacklisted(tokenString)
-}
\ No newline at end of file
+}
+
+// Audit log page sizes
+const (
+	defaultAuthEventPageSize = 50
+	maxAuthEventPageSize     = 200
+)
+
+// ListAuthEvents returns one page of the audit log entries matching the filter, newest first
+// Pages are numbered from 1; a page size outside 1 to 200 falls back to the default of 50
+func (s *service) ListAuthEvents(filter AuthEventFilter, page, pageSize int) (*AuthEventPage, error) {
+	if filter.PhoneNumber != "" {
+		phoneNumber, err := s.normalizePhoneNumber(filter.PhoneNumber)
+		if err != nil {
+			return nil, err
+		}
+		filter.PhoneNumber = phoneNumber
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > maxAuthEventPageSize {
+		pageSize = defaultAuthEventPageSize
+	}
+
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	events, total, err := s.authEventRepo.ListAuthEvents(filter)
+	if err != nil {
+		return nil, errors.New("failed to list auth events")
+	}
+
+	return &AuthEventPage{
+		Events:   events,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	}, nil
+}
+
+// JWKS returns the public keys that verify issued tokens
+// The set is empty when tokens are signed with the HS256 shared secret
+func (s *service) JWKS() (*JWKSet, error) {
+	set, err := s.keys.JWKS()
+	if err != nil {
+		return nil, errors.New("failed to load signing keys")
+	}
+
+	return set, nil
+}
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/pkg/utils"
+)
+
+// APIKeyPrefix starts every API key, so keys are easy to recognize, e.g. by secret scanners
+const APIKeyPrefix = "tts_"
+
+// TokenTypeAPIKey is the token type of the claims set for requests authenticated with an API key
+const TokenTypeAPIKey = "api_key"
+
+// API key settings
+const (
+	apiKeyBytes         = 32          // Random bytes in a key, after the prefix
+	apiKeyDisplayLength = 12          // Characters at the start of a key kept to tell keys apart
+	apiKeyTouchInterval = time.Minute // How often the last use of a busy key is recorded
+)
+
+// Service account field limits, matching the service_accounts and api_keys tables
+const (
+	maxServiceAccountNameLength        = 100
+	maxServiceAccountDescriptionLength = 255
+	maxAPIKeyNameLength                = 100
+)
+
+// CreateServiceAccountInput describes a service account created by an administrator
+type CreateServiceAccountInput struct {
+	Name        string
+	Description string
+	Branch      string // Limits the account to one branch; empty for every branch
+}
+
+// CreateAPIKeyInput describes an API key created for a service account
+type CreateAPIKeyInput struct {
+	Name        string
+	Permissions []string   // Policy permissions the key grants; the administrator must hold them all
+	ExpiresAt   *time.Time // Optional; the key never expires without it
+}
+
+// CreateServiceAccount creates a service account, which can then be given API keys
+// Returns ErrPermissionDenied if the policy does not let the administrator manage service
+// accounts of the branch, and ErrServiceAccountNameTaken if the name is in use.
+func (s *service) CreateServiceAccount(claims *Claims, input CreateServiceAccountInput, client ClientInfo) (*ServiceAccount, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, &ValidationError{Field: "name", Message: "name is required"}
+	}
+	if len(name) > maxServiceAccountNameLength {
+		return nil, &ValidationError{Field: "name", Message: fmt.Sprintf("name must be at most %d characters", maxServiceAccountNameLength)}
+	}
+
+	description := strings.TrimSpace(input.Description)
+	if len(description) > maxServiceAccountDescriptionLength {
+		return nil, &ValidationError{Field: "description", Message: fmt.Sprintf("description must be at most %d characters", maxServiceAccountDescriptionLength)}
+	}
+
+	branch, err := validateBranch(input.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &ServiceAccount{
+		Name:        name,
+		Description: description,
+		Branch:      branch,
+		CreatedBy:   &claims.UserID,
+	}
+	if err := s.authorizeServiceAccountManage(claims, account); err != nil {
+		return nil, err
+	}
+
+	if err := s.serviceAccountRepo.CreateServiceAccount(account); err != nil {
+		if errors.Is(err, ErrServiceAccountNameTaken) {
+			return nil, ErrServiceAccountNameTaken
+		}
+		return nil, errors.New("failed to create service account")
+	}
+
+	s.recordServiceAccountEvent(AuthEventServiceAccountCreated, claims, account.ID, "", client)
+
+	return account, nil
+}
+
+// ListServiceAccounts returns every service account, ordered by name
+func (s *service) ListServiceAccounts() ([]ServiceAccount, error) {
+	accounts, err := s.serviceAccountRepo.ListServiceAccounts()
+	if err != nil {
+		return nil, errors.New("failed to list service accounts")
+	}
+	return accounts, nil
+}
+
+// DeleteServiceAccount deletes a service account; its API keys stop working immediately
+func (s *service) DeleteServiceAccount(claims *Claims, id uuid.UUID, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	account, err := s.findServiceAccount(id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeServiceAccountManage(claims, account); err != nil {
+		return err
+	}
+
+	deleted, err := s.serviceAccountRepo.DeleteServiceAccount(account.ID)
+	if err != nil {
+		return errors.New("failed to delete service account")
+	}
+	if !deleted {
+		return ErrServiceAccountNotFound
+	}
+
+	s.recordServiceAccountEvent(AuthEventServiceAccountDeleted, claims, account.ID, "", client)
+
+	return nil
+}
+
+// CreateAPIKey creates an API key for a service account
+// The key is returned only here; just its hash is stored. Administrators can only grant
+// permissions they hold themselves.
+func (s *service) CreateAPIKey(claims *Claims, serviceAccountID uuid.UUID, input CreateAPIKeyInput, client ClientInfo) (*CreatedAPIKey, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	account, err := s.findServiceAccount(serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeServiceAccountManage(claims, account); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if len(name) > maxAPIKeyNameLength {
+		return nil, &ValidationError{Field: "name", Message: fmt.Sprintf("name must be at most %d characters", maxAPIKeyNameLength)}
+	}
+
+	permissions, err := s.grantablePermissions(claims, input.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, &ValidationError{Field: "expires_at", Message: "expiry must be in the future"}
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		return nil, errors.New("failed to generate API key")
+	}
+
+	apiKey := &APIKey{
+		ServiceAccountID: account.ID,
+		Name:             name,
+		Prefix:           key[:apiKeyDisplayLength],
+		KeyHash:          utils.HashToken(key),
+		Permissions:      permissions,
+		ExpiresAt:        input.ExpiresAt,
+	}
+	if err := s.serviceAccountRepo.CreateAPIKey(apiKey); err != nil {
+		return nil, errors.New("failed to create API key")
+	}
+
+	s.recordServiceAccountEvent(AuthEventAPIKeyCreated, claims, account.ID, apiKey.Prefix, client)
+
+	return &CreatedAPIKey{APIKey: *apiKey, Key: key}, nil
+}
+
+// ListAPIKeys returns the unrevoked API keys of a service account, newest first
+func (s *service) ListAPIKeys(serviceAccountID uuid.UUID) ([]APIKey, error) {
+	if _, err := s.findServiceAccount(serviceAccountID); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.serviceAccountRepo.ListAPIKeys(serviceAccountID)
+	if err != nil {
+		return nil, errors.New("failed to list API keys")
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of a service account's API keys; it stops working immediately
+func (s *service) RevokeAPIKey(claims *Claims, serviceAccountID, keyID uuid.UUID, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	account, err := s.findServiceAccount(serviceAccountID)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeServiceAccountManage(claims, account); err != nil {
+		return err
+	}
+
+	keys, err := s.serviceAccountRepo.ListAPIKeys(account.ID)
+	if err != nil {
+		return errors.New("failed to find API key")
+	}
+	var prefix string
+	for _, key := range keys {
+		if key.ID == keyID {
+			prefix = key.Prefix
+			break
+		}
+	}
+	if prefix == "" {
+		return ErrAPIKeyNotFound
+	}
+
+	revoked, err := s.serviceAccountRepo.RevokeAPIKey(account.ID, keyID)
+	if err != nil {
+		return errors.New("failed to revoke API key")
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+
+	s.recordServiceAccountEvent(AuthEventAPIKeyRevoked, claims, account.ID, prefix, client)
+
+	return nil
+}
+
+// AuthenticateAPIKey checks an API key and returns claims describing the request's principal
+// The claims carry the service account's ID as the user ID, its branch, and the key's
+// permissions as Scopes; their token type is TokenTypeAPIKey. Returns ErrInvalidAPIKey for
+// unknown and revoked keys and ErrAPIKeyExpired for expired ones.
+func (s *service) AuthenticateAPIKey(key string, client ClientInfo) (*Claims, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) <= apiKeyDisplayLength {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, account, err := s.serviceAccountRepo.FindAPIKeyByHash(utils.HashToken(key))
+	if err != nil {
+		return nil, errors.New("failed to find API key")
+	}
+	if apiKey == nil || apiKey.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if apiKey.ExpiresAt != nil && !time.Now().Before(*apiKey.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if err := s.serviceAccountRepo.TouchAPIKey(apiKey.ID, client.IPAddress, apiKeyTouchInterval); err != nil {
+		// Log error but don't fail the request; only the last use is not recorded
+		// In a real application, you'd use a proper logger here
+	}
+
+	claims := &Claims{
+		UserID:    account.ID,
+		TokenType: TokenTypeAPIKey,
+		Branch:    account.Branch,
+		Scopes:    apiKey.Permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       apiKey.ID.String(),
+			Subject:  account.ID.String(),
+			IssuedAt: jwt.NewNumericDate(apiKey.CreatedAt),
+		},
+	}
+	if apiKey.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*apiKey.ExpiresAt)
+	}
+
+	return claims, nil
+}
+
+// grantablePermissions validates the permissions requested for an API key and removes
+// duplicates. Every permission must exist and be held by the administrator.
+func (s *service) grantablePermissions(claims *Claims, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, &ValidationError{Field: "permissions", Message: "at least one permission is required"}
+	}
+
+	permissions := make([]string, 0, len(requested))
+	seen := map[string]bool{}
+	for _, permission := range requested {
+		if !policy.IsValidPermission(permission) {
+			return nil, &ValidationError{Field: "permissions", Message: fmt.Sprintf("unknown permission %q", permission)}
+		}
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+
+		err := s.authorizer.Authorize(context.Background(), SubjectFromClaims(claims), permission, nil)
+		if errors.Is(err, policy.ErrDenied) {
+			return nil, ErrPermissionDenied
+		}
+		if err != nil {
+			return nil, errors.New("failed to check permissions")
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, nil
+}
+
+// findServiceAccount looks up a service account by ID, returning ErrServiceAccountNotFound if there is none
+func (s *service) findServiceAccount(id uuid.UUID) (*ServiceAccount, error) {
+	account, err := s.serviceAccountRepo.FindServiceAccount(id)
+	if err != nil {
+		return nil, errors.New("failed to find service account")
+	}
+	if account == nil {
+		return nil, ErrServiceAccountNotFound
+	}
+	return account, nil
+}
+
+// authorizeServiceAccountManage checks that the administrator may manage the service account
+// Policy rules can limit service_account.manage by the account's branch.
+func (s *service) authorizeServiceAccountManage(claims *Claims, account *ServiceAccount) error {
+	resource := &policy.Resource{Branch: account.Branch}
+
+	err := s.authorizer.Authorize(context.Background(), SubjectFromClaims(claims), policy.PermissionServiceAccountManage, resource)
+	if errors.Is(err, policy.ErrDenied) {
+		return ErrPermissionDenied
+	}
+	if err != nil {
+		return errors.New("failed to check permissions")
+	}
+	return nil
+}
+
+// recordServiceAccountEvent records a change an administrator made to a service account or its keys
+// The service account is recorded as the event's user, and the key prefix, if any, as the reason
+func (s *service) recordServiceAccountEvent(eventType string, claims *Claims, serviceAccountID uuid.UUID, keyPrefix string, client ClientInfo) {
+	s.recordAuthEvent(AuthEvent{
+		EventType: eventType,
+		UserID:    &serviceAccountID,
+		Reason:    keyPrefix,
+		ActorID:   &claims.UserID,
+	}, client)
+}
+
+// newAPIKey generates a random API key starting with APIKeyPrefix
+func newAPIKey() (string, error) {
+	secret := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// ServiceAccountRepository defines the interface for service account and API key storage
+type ServiceAccountRepository interface {
+	CreateServiceAccount(account *ServiceAccount) error
+	FindServiceAccount(id uuid.UUID) (*ServiceAccount, error)
+	ListServiceAccounts() ([]ServiceAccount, error)
+	DeleteServiceAccount(id uuid.UUID) (bool, error)
+	CreateAPIKey(key *APIKey) error
+	FindAPIKeyByHash(keyHash string) (*APIKey, *ServiceAccount, error)
+	ListAPIKeys(serviceAccountID uuid.UUID) ([]APIKey, error)
+	RevokeAPIKey(serviceAccountID, id uuid.UUID) (bool, error)
+	TouchAPIKey(id uuid.UUID, ipAddress string, interval time.Duration) error
+}
+
+// serviceAccountRepository implements the ServiceAccountRepository interface
+type serviceAccountRepository struct {
+	db *db.DB
+}
+
+// NewServiceAccountRepository creates a new service account repository instance
+func NewServiceAccountRepository(database *db.DB) ServiceAccountRepository {
+	return &serviceAccountRepository{
+		db: database,
+	}
+}
+
+// CreateServiceAccount stores a new service account
+// Returns ErrServiceAccountNameTaken if another service account has the same name
+func (r *serviceAccountRepository) CreateServiceAccount(account *ServiceAccount) error {
+	if account == nil || account.Name == "" {
+		return errors.New("service account name cannot be empty")
+	}
+
+	if account.ID == uuid.Nil {
+		account.ID = uuid.New()
+	}
+	if account.CreatedAt.IsZero() {
+		account.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO service_accounts (id, name, description, branch, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name) DO NOTHING
+	`
+
+	result, err := r.db.Exec(query, account.ID, account.Name, account.Description, account.Branch, account.CreatedBy, account.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrServiceAccountNameTaken
+	}
+
+	return nil
+}
+
+// FindServiceAccount returns a service account by ID, or nil if there is none
+func (r *serviceAccountRepository) FindServiceAccount(id uuid.UUID) (*ServiceAccount, error) {
+	if id == uuid.Nil {
+		return nil, errors.New("service account ID cannot be empty")
+	}
+
+	query := `
+		SELECT id, name, description, branch, created_by, created_at
+		FROM service_accounts
+		WHERE id = $1
+	`
+
+	account, err := scanServiceAccount(r.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query service account: %w", err)
+	}
+
+	return account, nil
+}
+
+// ListServiceAccounts returns every service account, ordered by name
+func (r *serviceAccountRepository) ListServiceAccounts() ([]ServiceAccount, error) {
+	query := `
+		SELECT id, name, description, branch, created_by, created_at
+		FROM service_accounts
+		ORDER BY name
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []ServiceAccount{}
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service account: %w", err)
+		}
+		accounts = append(accounts, *account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// DeleteServiceAccount deletes a service account with its API keys and reports whether it existed
+func (r *serviceAccountRepository) DeleteServiceAccount(id uuid.UUID) (bool, error) {
+	if id == uuid.Nil {
+		return false, errors.New("service account ID cannot be empty")
+	}
+
+	result, err := r.db.Exec(`DELETE FROM service_accounts WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete service account: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// CreateAPIKey stores a new API key
+func (r *serviceAccountRepository) CreateAPIKey(key *APIKey) error {
+	if key == nil || key.ServiceAccountID == uuid.Nil {
+		return errors.New("service account ID cannot be empty")
+	}
+	if key.KeyHash == "" {
+		return errors.New("key hash cannot be empty")
+	}
+
+	permissions, err := json.Marshal(key.Permissions)
+	if err != nil {
+		return fmt.Errorf("failed to encode API key permissions: %w", err)
+	}
+
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO api_keys (id, service_account_id, name, prefix, key_hash, permissions, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err = r.db.Exec(query, key.ID, key.ServiceAccountID, key.Name, key.Prefix, key.KeyHash, permissions, key.ExpiresAt, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return nil
+}
+
+// FindAPIKeyByHash returns the API key with the given hash and its service account, or nils
+// if there is none. Revoked and expired keys are returned as well; callers decide whether a
+// key is usable.
+func (r *serviceAccountRepository) FindAPIKeyByHash(keyHash string) (*APIKey, *ServiceAccount, error) {
+	if keyHash == "" {
+		return nil, nil, errors.New("key hash cannot be empty")
+	}
+
+	query := `
+		SELECT k.id, k.service_account_id, k.name, k.prefix, k.key_hash, k.permissions, k.expires_at,
+			k.created_at, k.last_used_at, k.last_used_ip, k.revoked_at,
+			a.id, a.name, a.description, a.branch, a.created_by, a.created_at
+		FROM api_keys k
+		JOIN service_accounts a ON a.id = k.service_account_id
+		WHERE k.key_hash = $1
+	`
+
+	var account ServiceAccount
+	var createdBy uuid.NullUUID
+	key, err := scanAPIKey(r.db.QueryRow(query, keyHash),
+		&account.ID, &account.Name, &account.Description, &account.Branch, &createdBy, &account.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to query API key: %w", err)
+	}
+
+	if createdBy.Valid {
+		account.CreatedBy = &createdBy.UUID
+	}
+
+	return key, &account, nil
+}
+
+// ListAPIKeys returns the unrevoked API keys of a service account, newest first
+// Expired keys are included so they can be seen and replaced
+func (r *serviceAccountRepository) ListAPIKeys(serviceAccountID uuid.UUID) ([]APIKey, error) {
+	if serviceAccountID == uuid.Nil {
+		return nil, errors.New("service account ID cannot be empty")
+	}
+
+	query := `
+		SELECT id, service_account_id, name, prefix, key_hash, permissions, expires_at,
+			created_at, last_used_at, last_used_ip, revoked_at
+		FROM api_keys
+		WHERE service_account_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, serviceAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of a service account's API keys and reports whether it was active
+func (r *serviceAccountRepository) RevokeAPIKey(serviceAccountID, id uuid.UUID) (bool, error) {
+	if serviceAccountID == uuid.Nil || id == uuid.Nil {
+		return false, errors.New("service account ID and API key ID cannot be empty")
+	}
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = $1
+		WHERE id = $2 AND service_account_id = $3 AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), id, serviceAccountID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// TouchAPIKey records that an API key has just been used from an IP address
+// The key is only updated if its last use was at least interval ago, so a busy key does not
+// cause a write on every request.
+func (r *serviceAccountRepository) TouchAPIKey(id uuid.UUID, ipAddress string, interval time.Duration) error {
+	now := time.Now()
+
+	query := `
+		UPDATE api_keys
+		SET last_used_at = $1, last_used_ip = $2
+		WHERE id = $3 AND (last_used_at IS NULL OR last_used_at <= $4)
+	`
+
+	if _, err := r.db.Exec(query, now, ipAddress, id, now.Add(-interval)); err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+
+	return nil
+}
+
+// scanServiceAccount scans a service account row produced by the service account queries
+func scanServiceAccount(row interface{ Scan(dest ...any) error }) (*ServiceAccount, error) {
+	var account ServiceAccount
+	var createdBy uuid.NullUUID
+
+	err := row.Scan(
+		&account.ID,
+		&account.Name,
+		&account.Description,
+		&account.Branch,
+		&createdBy,
+		&account.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if createdBy.Valid {
+		account.CreatedBy = &createdBy.UUID
+	}
+
+	return &account, nil
+}
+
+// scanAPIKey scans an API key row produced by the API key queries
+// Columns selected after the API key's are scanned into extra
+func scanAPIKey(row interface{ Scan(dest ...any) error }, extra ...any) (*APIKey, error) {
+	var key APIKey
+	var permissions []byte
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	var lastUsedIP sql.NullString
+
+	dest := []any{
+		&key.ID,
+		&key.ServiceAccountID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&permissions,
+		&expiresAt,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&lastUsedIP,
+		&revokedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(permissions, &key.Permissions); err != nil {
+		return nil, fmt.Errorf("failed to decode API key permissions: %w", err)
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	key.LastUsedIP = lastUsedIP.String
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tt-stock-api/internal/db"
+)
+
+var apiKeyRowColumns = []string{
+	"id", "service_account_id", "name", "prefix", "key_hash", "permissions", "expires_at",
+	"created_at", "last_used_at", "last_used_ip", "revoked_at",
+}
+
+func TestServiceAccountRepository_CreateServiceAccount(t *testing.T) {
+	ownerID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440002")
+
+	tests := []struct {
+		name        string
+		account     *ServiceAccount
+		setupMock   func(mock sqlmock.Sqlmock)
+		expectedErr error
+		errorMsg    string
+	}{
+		{
+			name:    "stores the service account",
+			account: &ServiceAccount{Name: "POS Silom", Branch: "silom", CreatedBy: &ownerID},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO service_accounts .* ON CONFLICT \(name\) DO NOTHING`).
+					WithArgs(sqlmock.AnyArg(), "POS Silom", "", "silom", &ownerID, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:    "name already taken",
+			account: &ServiceAccount{Name: "POS Silom"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO service_accounts`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedErr: ErrServiceAccountNameTaken,
+		},
+		{
+			name:    "database error",
+			account: &ServiceAccount{Name: "POS Silom"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO service_accounts`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			errorMsg: "failed to create service account",
+		},
+		{
+			name:    "missing name",
+			account: &ServiceAccount{},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				// No mock setup needed as validation happens before query
+			},
+			errorMsg: "service account name cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+
+			repo := NewServiceAccountRepository(&db.DB{DB: mockDB})
+			err = repo.CreateServiceAccount(tt.account)
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+			case tt.errorMsg != "":
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			default:
+				assert.NoError(t, err)
+				assert.NotEqual(t, uuid.Nil, tt.account.ID)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestServiceAccountRepository_FindAPIKeyByHash(t *testing.T) {
+	keyID := uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+	accountID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	createdAt := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	expiresAt := createdAt.Add(90 * 24 * time.Hour)
+	columns := append(append([]string{}, apiKeyRowColumns...), "id", "name", "description", "branch", "created_by", "created_at")
+
+	t.Run("returns the key and its service account", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		mock.ExpectQuery(`SELECT .* FROM api_keys k\s+JOIN service_accounts a ON a.id = k.service_account_id\s+WHERE k.key_hash = \$1`).
+			WithArgs("hash").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(
+				keyID, accountID, "Till 1", "tts_abcdefgh", "hash", []byte(`["stock.adjust"]`), expiresAt,
+				createdAt, nil, nil, nil,
+				accountID, "POS Silom", "", "silom", nil, createdAt,
+			))
+
+		repo := NewServiceAccountRepository(&db.DB{DB: mockDB})
+		key, account, err := repo.FindAPIKeyByHash("hash")
+		require.NoError(t, err)
+
+		assert.Equal(t, keyID, key.ID)
+		assert.Equal(t, []string{"stock.adjust"}, key.Permissions)
+		assert.Equal(t, expiresAt, *key.ExpiresAt)
+		assert.Nil(t, key.LastUsedAt)
+		assert.Nil(t, key.RevokedAt)
+		assert.Equal(t, "POS Silom", account.Name)
+		assert.Equal(t, "silom", account.Branch)
+		assert.Nil(t, account.CreatedBy)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		mock.ExpectQuery(`SELECT .* FROM api_keys`).WithArgs("hash").WillReturnError(sql.ErrNoRows)
+
+		repo := NewServiceAccountRepository(&db.DB{DB: mockDB})
+		key, account, err := repo.FindAPIKeyByHash("hash")
+		assert.NoError(t, err)
+		assert.Nil(t, key)
+		assert.Nil(t, account)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestServiceAccountRepository_RevokeAPIKey(t *testing.T) {
+	keyID := uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+	accountID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectExec(`UPDATE api_keys\s+SET revoked_at = \$1\s+WHERE id = \$2 AND service_account_id = \$3 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), keyID, accountID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE api_keys`).
+		WithArgs(sqlmock.AnyArg(), keyID, accountID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := NewServiceAccountRepository(&db.DB{DB: mockDB})
+
+	revoked, err := repo.RevokeAPIKey(accountID, keyID)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// Already revoked
+	revoked, err = repo.RevokeAPIKey(accountID, keyID)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServiceAccountRepository_TouchAPIKey(t *testing.T) {
+	keyID := uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	// Only keys not used within the interval are written
+	mock.ExpectExec(`UPDATE api_keys\s+SET last_used_at = \$1, last_used_ip = \$2\s+WHERE id = \$3 AND \(last_used_at IS NULL OR last_used_at <= \$4\)`).
+		WithArgs(sqlmock.AnyArg(), "203.0.113.7", keyID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := NewServiceAccountRepository(&db.DB{DB: mockDB})
+	assert.NoError(t, repo.TouchAPIKey(keyID, "203.0.113.7", time.Minute))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,7 +1,14 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,7 +16,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"tt-stock-api/internal/config"
+	"tt-stock-api/internal/notify"
+	"tt-stock-api/internal/phone"
+	"tt-stock-api/internal/policy"
 	"tt-stock-api/internal/user"
 	"tt-stock-api/pkg/utils"
 )
@@ -27,265 +38,4198 @@ func (m *MockUserRepository) FindByPhoneNumber(phoneNumber string) (*user.User,
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
+func (m *MockUserRepository) FindByID(userID uuid.UUID) (*user.User, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.User), args.Error(1)
+}
+
+func (m *MockUserRepository) FindByRole(role string) ([]user.User, error) {
+	args := m.Called(role)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]user.User), args.Error(1)
+}
+
 func (m *MockUserRepository) UpdateLastLogin(userID uuid.UUID) error {
 	args := m.Called(userID)
 	return args.Error(0)
 }
 
-// MockBlacklistRepository is a mock implementation of BlacklistRepository
-type MockBlacklistRepository struct {
-	mock.Mock
-}
+func (m *MockUserRepository) UpdatePin(userID uuid.UUID, pinHash string, historySize int) error {
+	args := m.Called(userID, pinHash, historySize)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) Create(u *user.User) error {
+	args := m.Called(u)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) List(filter user.Filter) ([]user.User, int, error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Int(1), args.Error(2)
+	}
+	return args.Get(0).([]user.User), args.Int(1), args.Error(2)
+}
+
+func (m *MockUserRepository) Update(u *user.User) error {
+	args := m.Called(u)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) FindAccountState(userID uuid.UUID) (*user.AccountState, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.AccountState), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdateStatus(userID uuid.UUID, status string) error {
+	args := m.Called(userID, status)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) RevokeTokens(userID uuid.UUID, issuedBefore time.Time) error {
+	args := m.Called(userID, issuedBefore)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) Delete(userID uuid.UUID) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) RecentPinHashes(userID uuid.UUID, limit int) ([]string, error) {
+	args := m.Called(userID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// MockBlacklistRepository is a mock implementation of BlacklistRepository
+type MockBlacklistRepository struct {
+	mock.Mock
+}
+
+func (m *MockBlacklistRepository) BlacklistToken(token, userID, tokenType string, expiresAt time.Time) error {
+	args := m.Called(token, userID, tokenType, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockBlacklistRepository) IsTokenBlacklisted(token string) (bool, error) {
+	args := m.Called(token)
+	return args.Bool(0), args.Error(1)
+}
+
+// ConsumeRefreshToken invokes rotate when the configured result reports the token as consumed,
+// mirroring the real repository which only rotates for the caller that wins the insert
+func (m *MockBlacklistRepository) ConsumeRefreshToken(token, userID string, expiresAt time.Time, rotate func() ([]byte, error)) (*RefreshTokenUse, error) {
+	args := m.Called(token, userID, expiresAt)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	use := *args.Get(0).(*RefreshTokenUse)
+	if use.Consumed {
+		successor, err := rotate()
+		if err != nil {
+			return nil, err
+		}
+		use.Successor = successor
+	}
+	return &use, args.Error(1)
+}
+
+func (m *MockBlacklistRepository) PurgeExpired(batchSize int) (int64, error) {
+	args := m.Called(batchSize)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// MockLoginAttemptRepository is a mock implementation of LoginAttemptRepository
+type MockLoginAttemptRepository struct {
+	mock.Mock
+}
+
+func (m *MockLoginAttemptRepository) GetThrottle(key string) (*LoginThrottle, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*LoginThrottle), args.Error(1)
+}
+
+func (m *MockLoginAttemptRepository) RecordFailure(key string, windowStart time.Time) (*LoginThrottle, error) {
+	args := m.Called(key, windowStart)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*LoginThrottle), args.Error(1)
+}
+
+func (m *MockLoginAttemptRepository) LockUntil(key string, until time.Time) error {
+	args := m.Called(key, until)
+	return args.Error(0)
+}
+
+func (m *MockLoginAttemptRepository) Reset(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+// MockTokenFamilyRepository is a mock implementation of TokenFamilyRepository
+type MockTokenFamilyRepository struct {
+	mock.Mock
+}
+
+func (m *MockTokenFamilyRepository) CreateFamily(userID uuid.UUID) (uuid.UUID, error) {
+	args := m.Called(userID)
+	return args.Get(0).(uuid.UUID), args.Error(1)
+}
+
+func (m *MockTokenFamilyRepository) IsFamilyRevoked(familyID uuid.UUID) (bool, error) {
+	args := m.Called(familyID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTokenFamilyRepository) RevokeFamily(familyID uuid.UUID, reason string) error {
+	args := m.Called(familyID, reason)
+	return args.Error(0)
+}
+
+// MockSecurityEventRepository is a mock implementation of SecurityEventRepository
+type MockSecurityEventRepository struct {
+	mock.Mock
+}
+
+func (m *MockSecurityEventRepository) RecordEvent(userID uuid.UUID, eventType, details string) error {
+	args := m.Called(userID, eventType, details)
+	return args.Error(0)
+}
+
+// MockAuthEventRepository is a mock implementation of AuthEventRepository
+// Recorded events are kept in Events instead of going through expectations, so tests only
+// check the audit log where it matters to them
+type MockAuthEventRepository struct {
+	mock.Mock
+	Events []AuthEvent
+}
+
+func (m *MockAuthEventRepository) RecordAuthEvent(event *AuthEvent) error {
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockAuthEventRepository) ListAuthEvents(filter AuthEventFilter) ([]AuthEvent, int, error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Int(1), args.Error(2)
+	}
+	return args.Get(0).([]AuthEvent), args.Int(1), args.Error(2)
+}
+
+// LoginHistory is derived from the recorded events
+func (m *MockAuthEventRepository) LoginHistory(userID uuid.UUID, ipAddress, deviceID string) (*LoginHistory, error) {
+	var history LoginHistory
+	for _, event := range m.Events {
+		if event.EventType != AuthEventLoginSucceeded || event.UserID == nil || *event.UserID != userID {
+			continue
+		}
+		history.HasLogins = true
+		history.KnownIP = history.KnownIP || (ipAddress != "" && event.IPAddress == ipAddress)
+		history.KnownDevice = history.KnownDevice || (deviceID != "" && event.DeviceID == deviceID)
+	}
+	return &history, nil
+}
+
+// eventTypes returns the types of the recorded events in order
+func (m *MockAuthEventRepository) eventTypes() []string {
+	types := make([]string, 0, len(m.Events))
+	for _, event := range m.Events {
+		types = append(types, event.EventType)
+	}
+	return types
+}
+
+// MockLoginAlertRepository is a mock implementation of LoginAlertRepository
+type MockLoginAlertRepository struct {
+	mock.Mock
+}
+
+func (m *MockLoginAlertRepository) CreateAlert(alert *LoginAlert) error {
+	args := m.Called(alert)
+	return args.Error(0)
+}
+
+func (m *MockLoginAlertRepository) FindAlert(id uuid.UUID) (*LoginAlert, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*LoginAlert), args.Error(1)
+}
+
+func (m *MockLoginAlertRepository) ListAlerts(filter LoginAlertFilter) ([]LoginAlert, int, error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Int(1), args.Error(2)
+	}
+	return args.Get(0).([]LoginAlert), args.Int(1), args.Error(2)
+}
+
+func (m *MockLoginAlertRepository) MarkReviewed(id, reviewerID uuid.UUID) (*LoginAlert, error) {
+	args := m.Called(id, reviewerID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*LoginAlert), args.Error(1)
+}
+
+// MockSessionRepository is a mock implementation of SessionRepository
+type MockSessionRepository struct {
+	mock.Mock
+}
+
+func (m *MockSessionRepository) CreateSession(session *Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) TouchSession(sessionID uuid.UUID, jti, ipAddress string) error {
+	args := m.Called(sessionID, jti, ipAddress)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) FindSession(sessionID uuid.UUID) (*Session, error) {
+	args := m.Called(sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) ListActiveSessions(userID uuid.UUID) ([]Session, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Session), args.Error(1)
+}
+
+// MockOTPRepository is a mock implementation of OTPRepository
+type MockOTPRepository struct {
+	mock.Mock
+}
+
+func (m *MockOTPRepository) SaveOTP(otp *OTP) error {
+	args := m.Called(otp)
+	return args.Error(0)
+}
+
+func (m *MockOTPRepository) FindOTP(userID uuid.UUID, purpose string) (*OTP, error) {
+	args := m.Called(userID, purpose)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*OTP), args.Error(1)
+}
+
+func (m *MockOTPRepository) IncrementOTPAttempts(otpID uuid.UUID) (int, error) {
+	args := m.Called(otpID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockOTPRepository) ConsumeOTP(otpID uuid.UUID) (bool, error) {
+	args := m.Called(otpID)
+	return args.Bool(0), args.Error(1)
+}
+
+// MockDeviceRepository is a mock implementation of DeviceRepository
+type MockDeviceRepository struct {
+	mock.Mock
+}
+
+func (m *MockDeviceRepository) RecordDevice(device *Device) error {
+	args := m.Called(device)
+	return args.Error(0)
+}
+
+func (m *MockDeviceRepository) ListDevices(userID uuid.UUID) ([]Device, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Device), args.Error(1)
+}
+
+func (m *MockDeviceRepository) DeleteDevice(userID, id uuid.UUID) (*Device, error) {
+	args := m.Called(userID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Device), args.Error(1)
+}
+
+func (m *MockDeviceRepository) RegisterShopDevice(device *ShopDevice) error {
+	args := m.Called(device)
+	return args.Error(0)
+}
+
+func (m *MockDeviceRepository) ListShopDevices() ([]ShopDevice, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]ShopDevice), args.Error(1)
+}
+
+func (m *MockDeviceRepository) DeleteShopDevice(deviceID string) (bool, error) {
+	args := m.Called(deviceID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDeviceRepository) IsShopDevice(deviceID string) (bool, error) {
+	args := m.Called(deviceID)
+	return args.Bool(0), args.Error(1)
+}
+
+// MockDeviceKeyRepository is a mock implementation of DeviceKeyRepository
+type MockDeviceKeyRepository struct {
+	mock.Mock
+}
+
+func (m *MockDeviceKeyRepository) CreateDeviceKey(key *DeviceKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockDeviceKeyRepository) FindDeviceKey(id uuid.UUID) (*DeviceKey, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*DeviceKey), args.Error(1)
+}
+
+func (m *MockDeviceKeyRepository) ListDeviceKeys(userID uuid.UUID) ([]DeviceKey, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]DeviceKey), args.Error(1)
+}
+
+func (m *MockDeviceKeyRepository) RevokeDeviceKey(userID, id uuid.UUID) (bool, error) {
+	args := m.Called(userID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDeviceKeyRepository) RevokeDeviceKeysForDevice(userID uuid.UUID, deviceID string) error {
+	args := m.Called(userID, deviceID)
+	return args.Error(0)
+}
+
+func (m *MockDeviceKeyRepository) TouchDeviceKey(id uuid.UUID) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockDeviceKeyRepository) SaveChallenge(challenge *DeviceKeyChallenge) error {
+	args := m.Called(challenge)
+	return args.Error(0)
+}
+
+func (m *MockDeviceKeyRepository) ConsumeChallenge(keyID uuid.UUID) (*DeviceKeyChallenge, error) {
+	args := m.Called(keyID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*DeviceKeyChallenge), args.Error(1)
+}
+
+// MockServiceAccountRepository is a mock implementation of ServiceAccountRepository
+type MockServiceAccountRepository struct {
+	mock.Mock
+}
+
+func (m *MockServiceAccountRepository) CreateServiceAccount(account *ServiceAccount) error {
+	args := m.Called(account)
+	return args.Error(0)
+}
+
+func (m *MockServiceAccountRepository) FindServiceAccount(id uuid.UUID) (*ServiceAccount, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ServiceAccount), args.Error(1)
+}
+
+func (m *MockServiceAccountRepository) ListServiceAccounts() ([]ServiceAccount, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]ServiceAccount), args.Error(1)
+}
+
+func (m *MockServiceAccountRepository) DeleteServiceAccount(id uuid.UUID) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockServiceAccountRepository) CreateAPIKey(key *APIKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockServiceAccountRepository) FindAPIKeyByHash(keyHash string) (*APIKey, *ServiceAccount, error) {
+	args := m.Called(keyHash)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*APIKey), args.Get(1).(*ServiceAccount), args.Error(2)
+}
+
+func (m *MockServiceAccountRepository) ListAPIKeys(serviceAccountID uuid.UUID) ([]APIKey, error) {
+	args := m.Called(serviceAccountID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]APIKey), args.Error(1)
+}
+
+func (m *MockServiceAccountRepository) RevokeAPIKey(serviceAccountID, id uuid.UUID) (bool, error) {
+	args := m.Called(serviceAccountID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockServiceAccountRepository) TouchAPIKey(id uuid.UUID, ipAddress string, interval time.Duration) error {
+	args := m.Called(id, ipAddress, interval)
+	return args.Error(0)
+}
+
+// MockSMSSender is a mock implementation of sms.Sender
+type MockSMSSender struct {
+	mock.Mock
+}
+
+func (m *MockSMSSender) Send(phoneNumber, message string) error {
+	args := m.Called(phoneNumber, message)
+	return args.Error(0)
+}
+
+// MockNotifier is a mock implementation of notify.Notifier
+type MockNotifier struct {
+	mock.Mock
+}
+
+func (m *MockNotifier) Notify(msg notify.Message) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+// Test setup helper
+func setupTestService() (*service, *MockUserRepository, *MockBlacklistRepository) {
+	mockUserRepo := &MockUserRepository{}
+	mockBlacklistRepo := &MockBlacklistRepository{}
+	cfg := &config.Config{
+		JWTSecret:             "test-secret-key",
+		LoginMaxAttempts:      5,
+		LoginMaxAttemptsPerIP: 20,
+		LoginAttemptWindow:    15 * time.Minute,
+		LoginLockoutDuration:  15 * time.Minute,
+		LoginDelayBase:        time.Second,
+		LoginDelayMax:         30 * time.Second,
+		RefreshGracePeriod:    10 * time.Second,
+		PinHistorySize:        3,
+		OTPTTL:                5 * time.Minute,
+		OTPMaxAttempts:        3,
+		OTPResendInterval:     time.Minute,
+		DeviceKeyChallengeTTL: time.Minute,
+		TokenLifetimes: config.TokenLifetimes{
+			Access:     15 * time.Minute,
+			Refresh:    24 * time.Hour,
+			SessionMax: 7 * 24 * time.Hour,
+		},
+		TokenLifetimeOverrides: map[string]config.TokenLifetimes{
+			"role:owner": {Refresh: 30 * 24 * time.Hour},
+			"client:pos": {Access: 12 * time.Hour, SessionMax: 12 * time.Hour},
+		},
+	}
+	
+	svc := NewService(Repositories{
+		Users:           mockUserRepo,
+		Blacklist:       mockBlacklistRepo,
+		LoginAttempts:   &MockLoginAttemptRepository{},
+		TokenFamilies:   &MockTokenFamilyRepository{},
+		SecurityEvents:  &MockSecurityEventRepository{},
+		Sessions:        &MockSessionRepository{},
+		OTPs:            &MockOTPRepository{},
+		Devices:         &MockDeviceRepository{},
+		DeviceKeys:      &MockDeviceKeyRepository{},
+		AuthEvents:      &MockAuthEventRepository{},
+		LoginAlerts:     &MockLoginAlertRepository{},
+		ServiceAccounts: &MockServiceAccountRepository{},
+	}, NewHMACKeyManager(cfg.JWTSecret), &MockSMSSender{}, &MockNotifier{}, policy.NewEngine(policy.NewStaticRepository(), 0), phone.NewRegistry(phone.Thailand, phone.Laos), cfg).(*service)
+	
+	return svc, mockUserRepo, mockBlacklistRepo
+}
+
+func TestValidatePhoneNumber(t *testing.T) {
+	svc, _, _ := setupTestService()
+
+	tests := []struct {
+		name        string
+		phoneNumber string
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "Valid Thai phone number",
+			phoneNumber: "0812345678",
+			expectError: false,
+		},
+		{
+			name:        "Valid Thai phone number with different prefix",
+			phoneNumber: "0987654321",
+			expectError: false,
+		},
+		{
+			name:        "Empty phone number",
+			phoneNumber: "",
+			expectError: true,
+			errorMsg:    "phone number is required",
+		},
+		{
+			name:        "Valid Thai phone number with spaces",
+			phoneNumber: "081 234 5678",
+			expectError: false,
+		},
+		{
+			name:        "Valid Thai phone number in international format",
+			phoneNumber: "+66 81-234-5678",
+			expectError: false,
+		},
+		{
+			name:        "Valid Thai landline number",
+			phoneNumber: "02-123-4567",
+			expectError: false,
+		},
+		{
+			name:        "Valid number of an enabled country",
+			phoneNumber: "+856 20 5555 1234",
+			expectError: false,
+		},
+		{
+			name:        "Number of a country that is not enabled",
+			phoneNumber: "+60 12-345 6789",
+			expectError: true,
+			errorMsg:    "invalid phone number: numbers of this country are not accepted",
+		},
+		{
+			name:        "Phone number too short",
+			phoneNumber: "081234567",
+			expectError: true,
+			errorMsg:    "invalid phone number: wrong number of digits",
+		},
+		{
+			name:        "Phone number too long",
+			phoneNumber: "08123456789",
+			expectError: true,
+			errorMsg:    "invalid phone number: wrong number of digits",
+		},
+		{
+			name:        "Phone number not starting with 0",
+			phoneNumber: "1812345678",
+			expectError: true,
+			errorMsg:    "invalid phone number: not a mobile or landline number",
+		},
+		{
+			name:        "Phone number with non-digits",
+			phoneNumber: "081234567a",
+			expectError: true,
+			errorMsg:    "invalid phone number format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.ValidatePhoneNumber(tt.phoneNumber)
+			
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errorMsg, err.Error())
+
+				// Validation failures name the offending request field
+				var validationErr *ValidationError
+				assert.ErrorIs(t, err, ErrValidation)
+				assert.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, "phone_number", validationErr.Field)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidatePin(t *testing.T) {
+	svc, _, _ := setupTestService()
+
+	tests := []struct {
+		name        string
+		pin         string
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "Valid 6-digit PIN",
+			pin:         "123456",
+			expectError: false,
+		},
+		{
+			name:        "Valid PIN with zeros",
+			pin:         "000000",
+			expectError: false,
+		},
+		{
+			name:        "Empty PIN",
+			pin:         "",
+			expectError: true,
+			errorMsg:    "PIN is required",
+		},
+		{
+			name:        "PIN too short",
+			pin:         "12345",
+			expectError: true,
+			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+		},
+		{
+			name:        "PIN too long",
+			pin:         "1234567",
+			expectError: true,
+			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+		},
+		{
+			name:        "PIN with letters",
+			pin:         "12345a",
+			expectError: true,
+			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+		},
+		{
+			name:        "PIN with special characters",
+			pin:         "12345!",
+			expectError: true,
+			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+		},
+		{
+			name:        "PIN with spaces",
+			pin:         "123 456",
+			expectError: true,
+			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.ValidatePin(tt.pin)
+			
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errorMsg, err.Error())
+
+				// Validation failures name the offending request field
+				var validationErr *ValidationError
+				assert.ErrorIs(t, err, ErrValidation)
+				assert.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, "pin", validationErr.Field)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+
+	// Create a test user with hashed PIN
+	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	hashedPin, _ := utils.HashPin("123456")
+	testUser := &user.User{
+		ID:          testUserID,
+		PhoneNumber: "+66812345678",
+		PinHash:     hashedPin,
+		Status:      user.StatusActive,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	tests := []struct {
+		name         string
+		phoneNumber  string
+		pin          string
+		setupMocks   func()
+		expectError  bool
+		errorMsg     string
+		expectedUser *user.User
+	}{
+		{
+			name:        "Successful authentication",
+			phoneNumber: "0812345678",
+			pin:         "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+				mockUserRepo.On("UpdateLastLogin", testUserID).Return(nil).Once()
+			},
+			expectError:  false,
+			expectedUser: testUser,
+		},
+		{
+			name:        "Phone number in international format is normalized",
+			phoneNumber: "+66 81-234-5678",
+			pin:         "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+				mockUserRepo.On("UpdateLastLogin", testUserID).Return(nil).Once()
+			},
+			expectError:  false,
+			expectedUser: testUser,
+		},
+		{
+			name:        "Invalid phone number format",
+			phoneNumber: "invalid",
+			pin:         "123456",
+			setupMocks:  func() {},
+			expectError: true,
+			errorMsg:    "invalid phone number format",
+		},
+		{
+			name:        "Invalid PIN format",
+			phoneNumber: "0812345678",
+			pin:         "123",
+			setupMocks:  func() {},
+			expectError: true,
+			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+		},
+		{
+			name:        "User not found",
+			phoneNumber: "0812345678",
+			pin:         "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(nil, errors.New("user not found")).Once()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 1, LastFailedAt: time.Now()}, nil).Once()
+			},
+			expectError: true,
+			errorMsg:    "invalid credentials",
+		},
+		{
+			name:        "Wrong PIN",
+			phoneNumber: "0812345678",
+			pin:         "654321",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 1, LastFailedAt: time.Now()}, nil).Once()
+			},
+			expectError: true,
+			errorMsg:    "invalid credentials",
+		},
+		{
+			name:        "UpdateLastLogin fails but authentication succeeds",
+			phoneNumber: "0812345678",
+			pin:         "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+				mockUserRepo.On("UpdateLastLogin", testUserID).Return(errors.New("db error")).Once()
+			},
+			expectError:  false,
+			expectedUser: testUser,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+			
+			// Setup mocks for this test
+			tt.setupMocks()
+			
+			// Execute test
+			result, err := svc.AuthenticateUser(tt.phoneNumber, tt.pin, ClientInfo{})
+			
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errorMsg, err.Error())
+				if tt.errorMsg == "invalid credentials" {
+					assert.ErrorIs(t, err, ErrInvalidCredentials)
+				}
+				assert.Nil(t, result)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, result)
+				assert.Equal(t, tt.expectedUser.ID, result.ID)
+				assert.Equal(t, tt.expectedUser.PhoneNumber, result.PhoneNumber)
+			}
+			
+			// Verify all expectations were met
+			mockUserRepo.AssertExpectations(t)
+			mockAttemptRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateUser_Throttling(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+
+	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	hashedPin, _ := utils.HashPin("123456")
+	testUser := &user.User{
+		ID:          testUserID,
+		PhoneNumber: "+66812345678",
+		PinHash:     hashedPin,
+		Status:      user.StatusActive,
+	}
+	client := ClientInfo{IPAddress: "203.0.113.7"}
+	lockedUntil := time.Now().Add(10 * time.Minute)
+
+	tests := []struct {
+		name            string
+		pin             string
+		setupMocks      func()
+		expectLocked    bool
+		expectThrottled bool
+		errorMsg        string
+	}{
+		{
+			name: "Locked account is rejected before PIN check",
+			pin:  "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 5, LastFailedAt: time.Now(), LockedUntil: &lockedUntil}, nil).Once()
+			},
+			expectLocked: true,
+		},
+		{
+			name: "Attempt inside progressive delay is throttled",
+			pin:  "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 3, LastFailedAt: time.Now()}, nil).Once()
+			},
+			expectThrottled: true,
+		},
+		{
+			name: "Blocked client IP is throttled",
+			pin:  "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockAttemptRepo.On("GetThrottle", "ip:203.0.113.7").
+					Return(&LoginThrottle{Key: "ip:203.0.113.7", FailedCount: 20, LastFailedAt: time.Now(), LockedUntil: &lockedUntil}, nil).Once()
+			},
+			expectThrottled: true,
+		},
+		{
+			name: "Failure after delay has passed is allowed and counted",
+			pin:  "654321",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 1, LastFailedAt: time.Now().Add(-time.Minute)}, nil).Once()
+				mockAttemptRepo.On("GetThrottle", "ip:203.0.113.7").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "ip:203.0.113.7", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "ip:203.0.113.7", FailedCount: 2}, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 2}, nil).Once()
+			},
+			errorMsg: "invalid credentials",
+		},
+		{
+			name: "Reaching the attempt limit locks the account",
+			pin:  "654321",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockAttemptRepo.On("GetThrottle", "ip:203.0.113.7").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "ip:203.0.113.7", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "ip:203.0.113.7", FailedCount: 5}, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 5}, nil).Once()
+				mockAttemptRepo.On("LockUntil", "phone:+66812345678", mock.AnythingOfType("time.Time")).Return(nil).Once()
+			},
+			expectLocked: true,
+		},
+		{
+			name: "Reaching the per-IP limit blocks the client IP",
+			pin:  "654321",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockAttemptRepo.On("GetThrottle", "ip:203.0.113.7").Return(nil, nil).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "ip:203.0.113.7", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "ip:203.0.113.7", FailedCount: 20}, nil).Once()
+				mockAttemptRepo.On("LockUntil", "ip:203.0.113.7", mock.AnythingOfType("time.Time")).Return(nil).Once()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 1}, nil).Once()
+			},
+			errorMsg: "invalid credentials",
+		},
+		{
+			name: "Throttle lookup failure rejects the attempt",
+			pin:  "123456",
+			setupMocks: func() {
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, errors.New("db error")).Once()
+			},
+			errorMsg: "failed to check login attempts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			// Execute test
+			result, err := svc.AuthenticateUser("0812345678", tt.pin, client)
+
+			assert.Error(t, err)
+			assert.Nil(t, result)
+
+			var lockedErr *AccountLockedError
+			var attemptsErr *TooManyAttemptsError
+			switch {
+			case tt.expectLocked:
+				assert.True(t, errors.As(err, &lockedErr), "expected AccountLockedError, got %v", err)
+				assert.Greater(t, lockedErr.RetryAfter, time.Duration(0))
+			case tt.expectThrottled:
+				assert.True(t, errors.As(err, &attemptsErr), "expected TooManyAttemptsError, got %v", err)
+				assert.Greater(t, attemptsErr.RetryAfter, time.Duration(0))
+			default:
+				assert.Equal(t, tt.errorMsg, err.Error())
+			}
+
+			// Verify all expectations were met
+			mockUserRepo.AssertExpectations(t)
+			mockAttemptRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	svc, _, _ := setupTestService()
+
+	tests := []struct {
+		failedCount int
+		expected    time.Duration
+	}{
+		{failedCount: 0, expected: 0},
+		{failedCount: 1, expected: time.Second},
+		{failedCount: 2, expected: 2 * time.Second},
+		{failedCount: 4, expected: 8 * time.Second},
+		{failedCount: 6, expected: 30 * time.Second}, // Capped at the configured maximum
+		{failedCount: 100, expected: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d failures", tt.failedCount), func(t *testing.T) {
+			assert.Equal(t, tt.expected, svc.loginDelay(tt.failedCount))
+		})
+	}
+}
+
+func TestUnlockAccount(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+
+	t.Run("Unlock clears the phone throttle", func(t *testing.T) {
+		mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+
+		err := svc.UnlockAccount("0812345678")
+
+		assert.NoError(t, err)
+		mockAttemptRepo.AssertExpectations(t)
+	})
+
+	t.Run("Invalid phone number", func(t *testing.T) {
+		err := svc.UnlockAccount("invalid")
+
+		assert.Error(t, err)
+	})
+
+	t.Run("Repository failure", func(t *testing.T) {
+		mockAttemptRepo.On("Reset", "phone:+66812345678").Return(errors.New("db error")).Once()
+
+		err := svc.UnlockAccount("0812345678")
+
+		assert.Error(t, err)
+		assert.Equal(t, "failed to unlock account", err.Error())
+	})
+}
+
+func TestGenerateAccessToken(t *testing.T) {
+	svc, _, _ := setupTestService()
+
+	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+
+	tests := []struct {
+		name        string
+		userID      uuid.UUID
+		phoneNumber string
+		expectError bool
+	}{
+		{
+			name:        "Valid token generation",
+			userID:      testUserID,
+			phoneNumber: "0812345678",
+			expectError: false,
+		},
+		{
+			name:        "Empty userID",
+			userID:      uuid.Nil,
+			phoneNumber: "0812345678",
+			expectError: false, // JWT library handles empty values
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := svc.GenerateAccessToken(tt.userID, tt.phoneNumber)
+			
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Empty(t, token)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, token)
+				
+				// Verify token can be parsed and has correct claims
+				claims, parseErr := svc.ParseToken(token)
+				assert.NoError(t, parseErr)
+				assert.Equal(t, tt.userID, claims.UserID)
+				assert.Equal(t, tt.phoneNumber, claims.PhoneNumber)
+				assert.Equal(t, "access", claims.TokenType)
+				assert.Equal(t, "tt-stock-api", claims.Issuer)
+				
+				// Verify expiration is approximately 15 minutes from now
+				expectedExpiry := time.Now().Add(15 * time.Minute)
+				assert.WithinDuration(t, expectedExpiry, claims.ExpiresAt.Time, time.Minute)
+			}
+		})
+	}
+}
+
+func TestGenerateRefreshToken(t *testing.T) {
+	svc, _, _ := setupTestService()
+
+	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+
+	tests := []struct {
+		name        string
+		userID      uuid.UUID
+		phoneNumber string
+		expectError bool
+	}{
+		{
+			name:        "Valid refresh token generation",
+			userID:      testUserID,
+			phoneNumber: "0812345678",
+			expectError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := svc.GenerateRefreshToken(tt.userID, tt.phoneNumber)
+			
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Empty(t, token)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, token)
+				
+				// Verify token can be parsed and has correct claims
+				claims, parseErr := svc.ParseToken(token)
+				assert.NoError(t, parseErr)
+				assert.Equal(t, tt.userID, claims.UserID)
+				assert.Equal(t, tt.phoneNumber, claims.PhoneNumber)
+				assert.Equal(t, "refresh", claims.TokenType)
+				assert.Equal(t, "tt-stock-api", claims.Issuer)
+				
+				// Verify expiration is approximately 24 hours from now
+				expectedExpiry := time.Now().Add(24 * time.Hour)
+				assert.WithinDuration(t, expectedExpiry, claims.ExpiresAt.Time, time.Minute)
+			}
+		})
+	}
+}
+
+func TestGenerateTokens(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	client := ClientInfo{IPAddress: "203.0.113.7", UserAgent: "tt-stock-app/1.0", DeviceName: "Shop tablet"}
+
+	t.Run("Generate both tokens successfully", func(t *testing.T) {
+		userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+		phoneNumber := "0812345678"
+		familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+		mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+
+		var createdSession *Session
+		mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Run(func(args mock.Arguments) {
+			createdSession = args.Get(0).(*Session)
+		}).Return(nil).Once()
+		
+		tokenPair, err := svc.GenerateTokens(&user.User{ID: userID, PhoneNumber: phoneNumber, Role: "staff", Status: user.StatusActive}, client)
+		
+		assert.NoError(t, err)
+		assert.NotNil(t, tokenPair)
+		assert.NotEmpty(t, tokenPair.AccessToken)
+		assert.NotEmpty(t, tokenPair.RefreshToken)
+		assert.Equal(t, int64(15*60), tokenPair.ExpiresIn) // 15 minutes in seconds
+		
+		// Verify both tokens are valid and have correct types
+		accessClaims, err := svc.ParseToken(tokenPair.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "access", accessClaims.TokenType)
+		
+		refreshClaims, err := svc.ParseToken(tokenPair.RefreshToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "refresh", refreshClaims.TokenType)
+
+		// Both tokens belong to the new family
+		assert.Equal(t, familyID.String(), accessClaims.FamilyID)
+		assert.Equal(t, familyID.String(), refreshClaims.FamilyID)
+
+		// Every token gets its own ID
+		assert.NotEmpty(t, accessClaims.ID)
+		assert.NotEqual(t, accessClaims.ID, refreshClaims.ID)
+
+		// The session shares the family ID and records the refresh token and client
+		require.NotNil(t, createdSession)
+		assert.Equal(t, familyID, createdSession.ID)
+		assert.Equal(t, userID, createdSession.UserID)
+		assert.Equal(t, refreshClaims.ID, createdSession.JTI)
+		assert.Equal(t, "Shop tablet", createdSession.DeviceName)
+		assert.Equal(t, "tt-stock-app/1.0", createdSession.UserAgent)
+		assert.Equal(t, "203.0.113.7", createdSession.IPAddress)
+
+		// Role and login time are carried for later rotations
+		assert.Equal(t, "staff", accessClaims.Role)
+		require.NotNil(t, refreshClaims.AuthTime)
+		assert.WithinDuration(t, time.Now(), refreshClaims.AuthTime.Time, 5*time.Second)
+
+		mockFamilyRepo.AssertExpectations(t)
+		mockSessionRepo.AssertExpectations(t)
+	})
+
+	t.Run("Lifetimes follow role and client type overrides", func(t *testing.T) {
+		userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+		familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+		mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+
+		var createdSession *Session
+		mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Run(func(args mock.Arguments) {
+			createdSession = args.Get(0).(*Session)
+		}).Return(nil).Once()
+
+		posClient := client
+		posClient.ClientType = "pos"
+		tokenPair, err := svc.GenerateTokens(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: "owner", Status: user.StatusActive}, posClient)
+		require.NoError(t, err)
+
+		// A shift-length access token, with the refresh token clamped to the 12-hour session
+		assert.Equal(t, int64(12*60*60), tokenPair.ExpiresIn)
+
+		accessClaims, err := svc.ParseToken(tokenPair.AccessToken)
+		require.NoError(t, err)
+		refreshClaims, err := svc.ParseToken(tokenPair.RefreshToken)
+		require.NoError(t, err)
+
+		assert.Equal(t, accessClaims.IssuedAt.Add(12*time.Hour), accessClaims.ExpiresAt.Time)
+		assert.Equal(t, accessClaims.ExpiresAt.Time, refreshClaims.ExpiresAt.Time)
+		assert.Equal(t, "pos", refreshClaims.ClientType)
+		require.NotNil(t, createdSession)
+		assert.Equal(t, "pos", createdSession.ClientType)
+	})
+
+	t.Run("Family creation fails", func(t *testing.T) {
+		userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+		mockFamilyRepo.On("CreateFamily", userID).Return(uuid.Nil, errors.New("db error")).Once()
+
+		tokenPair, err := svc.GenerateTokens(&user.User{ID: userID, PhoneNumber: "+66812345678", Status: user.StatusActive}, client)
+
+		assert.Error(t, err)
+		assert.Nil(t, tokenPair)
+		assert.Equal(t, "failed to create token family", err.Error())
+	})
+
+	t.Run("Session creation fails", func(t *testing.T) {
+		userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+		familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+		mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+		mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(errors.New("db error")).Once()
+
+		tokenPair, err := svc.GenerateTokens(&user.User{ID: userID, PhoneNumber: "+66812345678", Status: user.StatusActive}, client)
+
+		assert.Error(t, err)
+		assert.Nil(t, tokenPair)
+		assert.Equal(t, "failed to create session", err.Error())
+	})
+}
+
+func TestGenerateTokens_DeviceBinding(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockDeviceRepo := svc.deviceRepo.(*MockDeviceRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	client := ClientInfo{IPAddress: "203.0.113.7", DeviceID: "tablet-0001", DeviceName: "Shop tablet"}
+
+	mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+	mockSessionRepo.On("CreateSession", mock.MatchedBy(func(session *Session) bool {
+		return session.DeviceID == "tablet-0001"
+	})).Return(nil).Once()
+	mockDeviceRepo.On("RecordDevice", mock.MatchedBy(func(device *Device) bool {
+		return device.UserID == userID && device.DeviceID == "tablet-0001" && device.Name == "Shop tablet" && device.LastIP == "203.0.113.7"
+	})).Return(nil).Once()
+
+	tokens, err := svc.GenerateTokens(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusActive}, client)
+	require.NoError(t, err)
+
+	// Both tokens are bound to the device
+	accessClaims, err := svc.ParseToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "tablet-0001", accessClaims.DeviceID)
+	refreshClaims, err := svc.ParseToken(tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "tablet-0001", refreshClaims.DeviceID)
+
+	mockFamilyRepo.AssertExpectations(t)
+	mockSessionRepo.AssertExpectations(t)
+	mockDeviceRepo.AssertExpectations(t)
+}
+
+func TestGenerateTokens_ShopDeviceRestriction(t *testing.T) {
+	svc, _, _ := setupTestService()
+	svc.shopDevices = true
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockDeviceRepo := svc.deviceRepo.(*MockDeviceRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	tests := []struct {
+		name        string
+		role        string
+		deviceID    string
+		setupMocks  func()
+		expectedErr error
+	}{
+		{
+			name:     "Staff on a shop device",
+			role:     user.RoleStaff,
+			deviceID: "tablet-0001",
+			setupMocks: func() {
+				mockDeviceRepo.On("IsShopDevice", "tablet-0001").Return(true, nil).Once()
+				mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+				mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+				mockDeviceRepo.On("RecordDevice", mock.AnythingOfType("*auth.Device")).Return(nil).Once()
+			},
+		},
+		{
+			name:     "Staff on an unregistered device",
+			role:     user.RoleStaff,
+			deviceID: "personal-phone-1",
+			setupMocks: func() {
+				mockDeviceRepo.On("IsShopDevice", "personal-phone-1").Return(false, nil).Once()
+			},
+			expectedErr: ErrDeviceNotAllowed,
+		},
+		{
+			name:     "Staff without a device ID",
+			role:     user.RoleStaff,
+			deviceID: "",
+			setupMocks: func() {
+				mockDeviceRepo.On("IsShopDevice", "").Return(false, nil).Once()
+			},
+			expectedErr: ErrDeviceNotAllowed,
+		},
+		{
+			name:     "Admins can log in from any device",
+			role:     user.RoleAdmin,
+			deviceID: "personal-phone-1",
+			setupMocks: func() {
+				mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+				mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+				mockDeviceRepo.On("RecordDevice", mock.AnythingOfType("*auth.Device")).Return(nil).Once()
+			},
+		},
+		{
+			name:     "Owners can log in from any device",
+			role:     user.RoleOwner,
+			deviceID: "personal-phone-1",
+			setupMocks: func() {
+				mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+				mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+				mockDeviceRepo.On("RecordDevice", mock.AnythingOfType("*auth.Device")).Return(nil).Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset mocks
+			mockFamilyRepo.ExpectedCalls = nil
+			mockSessionRepo.ExpectedCalls = nil
+			mockDeviceRepo.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			tokens, err := svc.GenerateTokens(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: tt.role, Status: user.StatusActive}, ClientInfo{DeviceID: tt.deviceID})
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, tokens)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, tokens)
+			}
+
+			// Verify all expectations were met
+			mockFamilyRepo.AssertExpectations(t)
+			mockSessionRepo.AssertExpectations(t)
+			mockDeviceRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGenerateTokens_PolicyOverrides(t *testing.T) {
+	svc, _, _ := setupTestService()
+	svc.shopDevices = true
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockDeviceRepo := svc.deviceRepo.(*MockDeviceRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	// A manager allowed to manage shop devices is exempt from the restriction like an owner
+	svc.authorizer = policy.NewEngine(policy.NewStaticRepository(
+		policy.Rule{Permission: policy.PermissionShopDeviceManage, UserID: userID, Effect: policy.EffectAllow},
+	), 0)
+	mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+	mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+	mockDeviceRepo.On("RecordDevice", mock.AnythingOfType("*auth.Device")).Return(nil).Once()
+
+	u := &user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleManager, Branch: "silom", Status: user.StatusActive}
+	tokens, err := svc.GenerateTokens(u, ClientInfo{DeviceID: "personal-phone-1"})
+	require.NoError(t, err)
+
+	// The branch is carried in the tokens for branch-limited permissions
+	accessClaims, err := svc.ParseToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "silom", accessClaims.Branch)
+	assert.Equal(t, policy.Subject{UserID: userID, Role: user.RoleManager, Branch: "silom"}, SubjectFromClaims(accessClaims))
+
+	mockFamilyRepo.AssertExpectations(t)
+	mockSessionRepo.AssertExpectations(t)
+	mockDeviceRepo.AssertExpectations(t)
+	mockDeviceRepo.AssertNotCalled(t, "IsShopDevice", mock.Anything)
+}
+
+func TestRefreshTokens_DeviceBinding(t *testing.T) {
+	svc, mockUserRepo, mockBlacklistRepo := setupTestService()
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	now := time.Now()
+	subject := tokenSubject{UserID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, DeviceID: "tablet-0001", FamilyID: familyID.String(), AuthTime: now}
+	refreshToken, _ := svc.generateToken(subject, "refresh", uuid.NewString(), now, now.Add(24*time.Hour))
+
+	t.Run("Another device cannot use the refresh token", func(t *testing.T) {
+		tokens, _, err := svc.RefreshTokens(refreshToken, ClientInfo{DeviceID: "tablet-0002"})
+		assert.ErrorIs(t, err, ErrDeviceMismatch)
+		assert.Nil(t, tokens)
+	})
+
+	t.Run("Rotated tokens stay bound to the device", func(t *testing.T) {
+		mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+		mockUserRepo.On("FindAccountState", userID).Return(&user.AccountState{Status: user.StatusActive}, nil).Once()
+		mockBlacklistRepo.On("ConsumeRefreshToken", refreshToken, userID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{Consumed: true}, nil).Once()
+		mockSessionRepo.On("TouchSession", familyID, mock.AnythingOfType("string"), "").Return(nil).Once()
+
+		tokens, _, err := svc.RefreshTokens(refreshToken, ClientInfo{DeviceID: "tablet-0001"})
+		require.NoError(t, err)
+
+		accessClaims, err := svc.ParseToken(tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "tablet-0001", accessClaims.DeviceID)
+
+		mockFamilyRepo.AssertExpectations(t)
+		mockBlacklistRepo.AssertExpectations(t)
+		mockSessionRepo.AssertExpectations(t)
+	})
+}
+
+func TestRemoveDevice(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockDeviceRepo := svc.deviceRepo.(*MockDeviceRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockDeviceKeyRepo := svc.deviceKeyRepo.(*MockDeviceKeyRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	deviceRowID := uuid.MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	tabletSessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	phoneSessionID := uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	t.Run("Revokes only the sessions of the removed device", func(t *testing.T) {
+		mockDeviceRepo.On("DeleteDevice", userID, deviceRowID).
+			Return(&Device{ID: deviceRowID, UserID: userID, DeviceID: "tablet-0001"}, nil).Once()
+		mockSessionRepo.On("ListActiveSessions", userID).Return([]Session{
+			{ID: tabletSessionID, UserID: userID, DeviceID: "tablet-0001"},
+			{ID: phoneSessionID, UserID: userID, DeviceID: "phone-00000002"},
+		}, nil).Once()
+		mockFamilyRepo.On("RevokeFamily", tabletSessionID, RevokedReasonDeviceRemoved).Return(nil).Once()
+		mockDeviceKeyRepo.On("RevokeDeviceKeysForDevice", userID, "tablet-0001").Return(nil).Once()
+
+		assert.NoError(t, svc.RemoveDevice(userID, deviceRowID))
+
+		mockDeviceRepo.AssertExpectations(t)
+		mockSessionRepo.AssertExpectations(t)
+		mockFamilyRepo.AssertExpectations(t)
+		mockDeviceKeyRepo.AssertExpectations(t)
+	})
+
+	t.Run("Unknown device", func(t *testing.T) {
+		mockDeviceRepo.On("DeleteDevice", userID, deviceRowID).Return(nil, nil).Once()
+
+		assert.ErrorIs(t, svc.RemoveDevice(userID, deviceRowID), ErrDeviceNotFound)
+
+		mockDeviceRepo.AssertExpectations(t)
+	})
+}
+
+func TestRegisterDeviceKey(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockDeviceKeyRepo := svc.deviceKeyRepo.(*MockDeviceKeyRepository)
+	mockEventRepo := svc.eventRepo.(*MockSecurityEventRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	pinHash, _ := utils.HashPin("135790")
+	testUser := &user.User{ID: userID, PhoneNumber: "+66812345678", PinHash: pinHash, Status: user.StatusActive}
+	claims := &Claims{UserID: userID, PhoneNumber: "+66812345678", TokenType: "access", DeviceID: "phone-00000001"}
+
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	publicKey := encodePublicKey(t, edPublic)
+
+	tests := []struct {
+		name          string
+		claims        *Claims
+		pin           string
+		algorithm     string
+		setupMocks    func()
+		expectedErr   error
+		expectedField string
+		expectedEvent string
+	}{
+		{
+			name:      "Registers the key for the token's device",
+			claims:    claims,
+			pin:       "135790",
+			algorithm: DeviceKeyAlgorithmEdDSA,
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(testUser, nil).Once()
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockDeviceKeyRepo.On("RevokeDeviceKeysForDevice", userID, "phone-00000001").Return(nil).Once()
+				mockDeviceKeyRepo.On("CreateDeviceKey", mock.MatchedBy(func(key *DeviceKey) bool {
+					return key.UserID == userID && key.DeviceID == "phone-00000001" && key.Algorithm == DeviceKeyAlgorithmEdDSA && key.Name == "My phone"
+				})).Return(nil).Once()
+				mockEventRepo.On("RecordEvent", userID, SecurityEventDeviceKeyAdded, "EdDSA device key registered for device phone-00000001").Return(nil).Once()
+			},
+		},
+		{
+			name:      "Wrong PIN counts as a failed attempt",
+			claims:    claims,
+			pin:       "000001",
+			algorithm: DeviceKeyAlgorithmEdDSA,
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(testUser, nil).Once()
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 1}, nil).Once()
+			},
+			expectedErr:   ErrInvalidCredentials,
+			expectedEvent: AuthEventDeviceKeyRegisterFailed,
+		},
+		{
+			name:          "Token without a device",
+			claims:        &Claims{UserID: userID, PhoneNumber: "+66812345678", TokenType: "access"},
+			pin:           "135790",
+			algorithm:     DeviceKeyAlgorithmEdDSA,
+			setupMocks:    func() {},
+			expectedErr:   ErrValidation,
+			expectedField: "device_id",
+		},
+		{
+			name:          "Key does not match the algorithm",
+			claims:        claims,
+			pin:           "135790",
+			algorithm:     DeviceKeyAlgorithmES256,
+			setupMocks:    func() {},
+			expectedErr:   ErrValidation,
+			expectedField: "public_key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+			mockDeviceKeyRepo.ExpectedCalls = nil
+			mockEventRepo.ExpectedCalls = nil
+			mockAuthEventRepo.Events = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			key, err := svc.RegisterDeviceKey(tt.claims, tt.pin, tt.algorithm, publicKey, " My phone ", ClientInfo{})
+
+			if tt.expectedEvent != "" {
+				require.Len(t, mockAuthEventRepo.Events, 1)
+				assert.Equal(t, tt.expectedEvent, mockAuthEventRepo.Events[0].EventType)
+				assert.Equal(t, AuthReasonInvalidPin, mockAuthEventRepo.Events[0].Reason)
+				assert.Equal(t, userID, *mockAuthEventRepo.Events[0].UserID)
+			} else {
+				assert.Empty(t, mockAuthEventRepo.Events)
+			}
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, key)
+				if tt.expectedField != "" {
+					var validationErr *ValidationError
+					require.ErrorAs(t, err, &validationErr)
+					assert.Equal(t, tt.expectedField, validationErr.Field)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "My phone", key.Name)
+			}
+
+			// Verify all expectations were met
+			mockUserRepo.AssertExpectations(t)
+			mockAttemptRepo.AssertExpectations(t)
+			mockDeviceKeyRepo.AssertExpectations(t)
+			mockEventRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateDeviceKeyChallenge(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockDeviceKeyRepo := svc.deviceKeyRepo.(*MockDeviceKeyRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	keyID := uuid.MustParse("6ba7b813-9dad-11d1-80b4-00c04fd430c8")
+	revokedAt := time.Now().Add(-time.Hour)
+
+	t.Run("Issues a nonce for an active key", func(t *testing.T) {
+		mockDeviceKeyRepo.On("FindDeviceKey", keyID).Return(&DeviceKey{ID: keyID, UserID: userID, DeviceID: "phone-00000001"}, nil).Once()
+		mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Status: user.StatusActive}, nil).Once()
+		mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+		mockDeviceKeyRepo.On("SaveChallenge", mock.MatchedBy(func(challenge *DeviceKeyChallenge) bool {
+			return challenge.KeyID == keyID && len(challenge.Nonce) == 43
+		})).Return(nil).Once()
+
+		challenge, err := svc.CreateDeviceKeyChallenge(keyID, ClientInfo{})
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), challenge.ExpiresAt, 5*time.Second)
+
+		mockDeviceKeyRepo.AssertExpectations(t)
+		mockUserRepo.AssertExpectations(t)
+		mockAttemptRepo.AssertExpectations(t)
+	})
+
+	t.Run("Revoked key", func(t *testing.T) {
+		mockDeviceKeyRepo.On("FindDeviceKey", keyID).Return(&DeviceKey{ID: keyID, UserID: userID, RevokedAt: &revokedAt}, nil).Once()
+
+		challenge, err := svc.CreateDeviceKeyChallenge(keyID, ClientInfo{})
+		assert.ErrorIs(t, err, ErrInvalidDeviceKey)
+		assert.Nil(t, challenge)
+
+		mockDeviceKeyRepo.AssertExpectations(t)
+	})
+
+	t.Run("Unknown key", func(t *testing.T) {
+		mockDeviceKeyRepo.On("FindDeviceKey", keyID).Return(nil, nil).Once()
+
+		challenge, err := svc.CreateDeviceKeyChallenge(keyID, ClientInfo{})
+		assert.ErrorIs(t, err, ErrInvalidDeviceKey)
+		assert.Nil(t, challenge)
+
+		mockDeviceKeyRepo.AssertExpectations(t)
+	})
+}
+
+func TestAuthenticateUserWithDeviceKey(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockDeviceKeyRepo := svc.deviceKeyRepo.(*MockDeviceKeyRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	keyID := uuid.MustParse("6ba7b813-9dad-11d1-80b4-00c04fd430c8")
+	testUser := &user.User{ID: userID, PhoneNumber: "+66812345678", Status: user.StatusActive}
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(edPublic)
+	require.NoError(t, err)
+	deviceKey := &DeviceKey{ID: keyID, UserID: userID, DeviceID: "phone-00000001", Algorithm: DeviceKeyAlgorithmEdDSA, PublicKey: der}
+
+	nonce := "k3Jm1Fq8dXo2Vt9bZr4Lw6Ys0Hc7Ne5Pa1Ug8Qi2Tx4"
+	validSignature := base64.StdEncoding.EncodeToString(ed25519.Sign(edPrivate, []byte(nonce)))
+	otherSignature := base64.StdEncoding.EncodeToString(ed25519.Sign(edPrivate, []byte("another nonce")))
+
+	// keyFound sets up the mocks for an active key whose user is not throttled
+	keyFound := func() {
+		mockDeviceKeyRepo.On("FindDeviceKey", keyID).Return(deviceKey, nil).Once()
+		mockUserRepo.On("FindByID", userID).Return(testUser, nil).Once()
+	}
+
+	tests := []struct {
+		name        string
+		deviceID    string
+		signature   string
+		setupMocks  func()
+		expectedErr error
+	}{
+		{
+			name:      "Valid signature of the challenge",
+			deviceID:  "phone-00000001",
+			signature: validSignature,
+			setupMocks: func() {
+				keyFound()
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockDeviceKeyRepo.On("ConsumeChallenge", keyID).
+					Return(&DeviceKeyChallenge{KeyID: keyID, Nonce: nonce, ExpiresAt: time.Now().Add(time.Minute)}, nil).Once()
+				mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+				mockDeviceKeyRepo.On("TouchDeviceKey", keyID).Return(nil).Once()
+				mockUserRepo.On("UpdateLastLogin", userID).Return(nil).Once()
+			},
+		},
+		{
+			name:        "Request from another device",
+			deviceID:    "phone-00000002",
+			signature:   validSignature,
+			setupMocks:  keyFound,
+			expectedErr: ErrDeviceMismatch,
+		},
+		{
+			name:      "Signature of another nonce counts as a failed attempt",
+			deviceID:  "phone-00000001",
+			signature: otherSignature,
+			setupMocks: func() {
+				keyFound()
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockDeviceKeyRepo.On("ConsumeChallenge", keyID).
+					Return(&DeviceKeyChallenge{KeyID: keyID, Nonce: nonce, ExpiresAt: time.Now().Add(time.Minute)}, nil).Once()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 1}, nil).Once()
+			},
+			expectedErr: ErrInvalidDeviceKey,
+		},
+		{
+			name:      "Expired challenge",
+			deviceID:  "phone-00000001",
+			signature: validSignature,
+			setupMocks: func() {
+				keyFound()
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockDeviceKeyRepo.On("ConsumeChallenge", keyID).
+					Return(&DeviceKeyChallenge{KeyID: keyID, Nonce: nonce, ExpiresAt: time.Now().Add(-time.Second)}, nil).Once()
+			},
+			expectedErr: ErrInvalidDeviceKey,
+		},
+		{
+			name:      "No challenge, e.g. already used",
+			deviceID:  "phone-00000001",
+			signature: validSignature,
+			setupMocks: func() {
+				keyFound()
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+				mockDeviceKeyRepo.On("ConsumeChallenge", keyID).Return(nil, nil).Once()
+			},
+			expectedErr: ErrInvalidDeviceKey,
+		},
+		{
+			name:      "Locked account",
+			deviceID:  "phone-00000001",
+			signature: validSignature,
+			setupMocks: func() {
+				keyFound()
+				lockedUntil := time.Now().Add(10 * time.Minute)
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 5, LastFailedAt: time.Now(), LockedUntil: &lockedUntil}, nil).Once()
+			},
+			expectedErr: &AccountLockedError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+			mockDeviceKeyRepo.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			u, err := svc.AuthenticateUserWithDeviceKey(keyID, tt.signature, ClientInfo{DeviceID: tt.deviceID})
+
+			if tt.expectedErr != nil {
+				var lockedErr *AccountLockedError
+				if errors.As(tt.expectedErr, &lockedErr) {
+					assert.ErrorAs(t, err, &lockedErr)
+				} else {
+					assert.ErrorIs(t, err, tt.expectedErr)
+				}
+				assert.Nil(t, u)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, testUser, u)
+			}
+
+			// Verify all expectations were met
+			mockUserRepo.AssertExpectations(t)
+			mockAttemptRepo.AssertExpectations(t)
+			mockDeviceKeyRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeDeviceKey(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockDeviceKeyRepo := svc.deviceKeyRepo.(*MockDeviceKeyRepository)
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	keyID := uuid.MustParse("6ba7b813-9dad-11d1-80b4-00c04fd430c8")
+
+	mockDeviceKeyRepo.On("RevokeDeviceKey", userID, keyID).Return(true, nil).Once()
+	assert.NoError(t, svc.RevokeDeviceKey(userID, keyID))
+
+	mockDeviceKeyRepo.On("RevokeDeviceKey", userID, keyID).Return(false, nil).Once()
+	assert.ErrorIs(t, svc.RevokeDeviceKey(userID, keyID), ErrDeviceKeyNotFound)
+
+	mockDeviceKeyRepo.AssertExpectations(t)
+}
+
+func TestRegisterShopDevice(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockDeviceRepo := svc.deviceRepo.(*MockDeviceRepository)
+	ownerID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+
+	mockDeviceRepo.On("RegisterShopDevice", &ShopDevice{DeviceID: "tablet-0001", Name: "Front counter", RegisteredBy: ownerID}).Return(nil).Once()
+
+	device, err := svc.RegisterShopDevice(ownerID, "tablet-0001", "  Front counter ")
+	require.NoError(t, err)
+	assert.Equal(t, "Front counter", device.Name)
+
+	_, err = svc.RegisterShopDevice(ownerID, "bad id!", "")
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "device_id", validationErr.Field)
+
+	mockDeviceRepo.AssertExpectations(t)
+}
+
+func TestRefreshTokens(t *testing.T) {
+	svc, mockUserRepo, mockBlacklistRepo := setupTestService()
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockEventRepo := svc.eventRepo.(*MockSecurityEventRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	client := ClientInfo{IPAddress: "203.0.113.7"}
+
+	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	now := time.Now()
+	subject := tokenSubject{UserID: testUserID, PhoneNumber: "+66812345678", Role: "staff", FamilyID: familyID.String(), AuthTime: now}
+	refreshToken, _ := svc.generateToken(subject, "refresh", uuid.NewString(), now, now.Add(24*time.Hour))
+	accessToken, _ := svc.generateToken(subject, "access", uuid.NewString(), now, now.Add(15*time.Minute))
+
+	// A session that started eight days ago is past the seven-day maximum
+	staleSubject := subject
+	staleSubject.AuthTime = now.Add(-8 * 24 * time.Hour)
+	staleRefreshToken, _ := svc.generateToken(staleSubject, "refresh", uuid.NewString(), now, now.Add(time.Hour))
+	legacyRefreshToken, _ := svc.GenerateRefreshToken(testUserID, "0812345678")
+
+	successorPair := &TokenPair{AccessToken: "successor-access", RefreshToken: "successor-refresh", ExpiresIn: 15 * 60}
+	sealedSuccessor, _ := sealTokenPair(refreshToken, successorPair)
+
+	tests := []struct {
+		name         string
+		token        string
+		setupMocks   func()
+		expectedErr  error
+		errorMsg     string
+		expectFamily string
+		expectedPair *TokenPair
+	}{
+		{
+			name:  "Successful rotation stays in the family",
+			token: refreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+				mockBlacklistRepo.On("ConsumeRefreshToken", refreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{Consumed: true}, nil).Once()
+				mockSessionRepo.On("TouchSession", familyID, mock.AnythingOfType("string"), "203.0.113.7").Return(nil).Once()
+			},
+			expectFamily: familyID.String(),
+		},
+		{
+			name:  "Legacy token without family starts a new one",
+			token: legacyRefreshToken,
+			setupMocks: func() {
+				mockBlacklistRepo.On("ConsumeRefreshToken", legacyRefreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{Consumed: true}, nil).Once()
+				mockFamilyRepo.On("CreateFamily", testUserID).Return(familyID, nil).Once()
+				mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+			},
+			expectFamily: familyID.String(),
+		},
+		{
+			name:        "Invalid token",
+			token:       "invalid.token",
+			setupMocks:  func() {},
+			expectedErr: ErrInvalidRefreshToken,
+		},
+		{
+			name:        "Access token instead of refresh token",
+			token:       accessToken,
+			setupMocks:  func() {},
+			expectedErr: ErrWrongTokenType,
+		},
+		{
+			name:  "Duplicate request within grace period gets the same pair",
+			token: refreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+				mockBlacklistRepo.On("ConsumeRefreshToken", refreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{
+					Successor:  sealedSuccessor,
+					ConsumedAt: time.Now().Add(-2 * time.Second),
+				}, nil).Once()
+			},
+			expectedPair: successorPair,
+		},
+		{
+			name:  "Reused refresh token after grace period revokes the family",
+			token: refreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Twice()
+				mockBlacklistRepo.On("ConsumeRefreshToken", refreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{
+					Successor:  sealedSuccessor,
+					ConsumedAt: time.Now().Add(-time.Minute),
+				}, nil).Once()
+				mockFamilyRepo.On("RevokeFamily", familyID, SecurityEventRefreshTokenReuse).Return(nil).Once()
+				mockEventRepo.On("RecordEvent", testUserID, SecurityEventRefreshTokenReuse, mock.AnythingOfType("string")).Return(nil).Once()
+			},
+			expectedErr: ErrRefreshTokenReused,
+		},
+		{
+			name:  "Reused refresh token without a stored successor revokes the family",
+			token: refreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Twice()
+				mockBlacklistRepo.On("ConsumeRefreshToken", refreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{
+					ConsumedAt: time.Now(),
+				}, nil).Once()
+				mockFamilyRepo.On("RevokeFamily", familyID, SecurityEventRefreshTokenReuse).Return(nil).Once()
+				mockEventRepo.On("RecordEvent", testUserID, SecurityEventRefreshTokenReuse, mock.AnythingOfType("string")).Return(nil).Once()
+			},
+			expectedErr: ErrRefreshTokenReused,
+		},
+		{
+			name:  "Refresh token from a revoked family",
+			token: refreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(true, nil).Once()
+			},
+			expectedErr: ErrInvalidRefreshToken,
+		},
+		{
+			name:  "Failing to update the session does not fail the refresh",
+			token: refreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+				mockBlacklistRepo.On("ConsumeRefreshToken", refreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{Consumed: true}, nil).Once()
+				mockSessionRepo.On("TouchSession", familyID, mock.AnythingOfType("string"), "203.0.113.7").Return(errors.New("db error")).Once()
+			},
+			expectFamily: familyID.String(),
+		},
+		{
+			name:  "Consuming the old token fails",
+			token: refreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+				mockBlacklistRepo.On("ConsumeRefreshToken", refreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(nil, errors.New("db error")).Once()
+			},
+			errorMsg: "failed to invalidate old refresh token",
+		},
+		{
+			name:  "Session past its maximum lifetime cannot be extended",
+			token: staleRefreshToken,
+			setupMocks: func() {
+				mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+				mockBlacklistRepo.On("ConsumeRefreshToken", staleRefreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{Consumed: true}, nil).Once()
+			},
+			expectedErr: ErrSessionExpired,
+		},
+		{
+			name:  "Creating a family for a legacy token fails",
+			token: legacyRefreshToken,
+			setupMocks: func() {
+				mockBlacklistRepo.On("ConsumeRefreshToken", legacyRefreshToken, testUserID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{Consumed: true}, nil).Once()
+				mockFamilyRepo.On("CreateFamily", testUserID).Return(uuid.Nil, errors.New("db error")).Once()
+			},
+			errorMsg: "failed to create token family",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset mocks
+			mockBlacklistRepo.ExpectedCalls = nil
+			mockFamilyRepo.ExpectedCalls = nil
+			mockEventRepo.ExpectedCalls = nil
+			mockSessionRepo.ExpectedCalls = nil
+			mockUserRepo.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+			mockUserRepo.On("FindAccountState", testUserID).Return(&user.AccountState{Status: user.StatusActive}, nil).Maybe()
+
+			// Execute test
+			tokens, claims, err := svc.RefreshTokens(tt.token, client)
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, tokens)
+			case tt.errorMsg != "":
+				assert.Error(t, err)
+				assert.Equal(t, tt.errorMsg, err.Error())
+				assert.Nil(t, tokens)
+			case tt.expectedPair != nil:
+				assert.NoError(t, err)
+				assert.Equal(t, testUserID, claims.UserID)
+				assert.Equal(t, tt.expectedPair, tokens)
+			default:
+				assert.NoError(t, err)
+				assert.Equal(t, testUserID, claims.UserID)
+
+				newRefreshClaims, parseErr := svc.ParseToken(tokens.RefreshToken)
+				assert.NoError(t, parseErr)
+				assert.Equal(t, tt.expectFamily, newRefreshClaims.FamilyID)
+
+				// Rotation keeps the session's login time and role; a legacy token starts a new session
+				require.NotNil(t, newRefreshClaims.AuthTime)
+				if tt.token == refreshToken {
+					assert.Equal(t, now.Unix(), newRefreshClaims.AuthTime.Unix())
+					assert.Equal(t, "staff", newRefreshClaims.Role)
+				}
+			}
+
+			// Verify all expectations were met
+			mockBlacklistRepo.AssertExpectations(t)
+			mockFamilyRepo.AssertExpectations(t)
+			mockEventRepo.AssertExpectations(t)
+			mockSessionRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSealTokenPair(t *testing.T) {
+	tokens := &TokenPair{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 900}
+
+	sealed, err := sealTokenPair("presented-refresh-token", tokens)
+	assert.NoError(t, err)
+
+	opened, err := openTokenPair("presented-refresh-token", sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, tokens, opened)
+
+	// Only the holder of the consumed refresh token can recover the pair
+	_, err = openTokenPair("some-other-token", sealed)
+	assert.Error(t, err)
+}
+
+func TestListSessions(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessions := []Session{
+		{ID: uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"), UserID: userID, DeviceName: "Shop tablet"},
+	}
+
+	t.Run("Returns active sessions", func(t *testing.T) {
+		mockSessionRepo.On("ListActiveSessions", userID).Return(sessions, nil).Once()
+
+		result, err := svc.ListSessions(userID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, sessions, result)
+	})
+
+	t.Run("Repository error", func(t *testing.T) {
+		mockSessionRepo.On("ListActiveSessions", userID).Return(nil, errors.New("db error")).Once()
+
+		result, err := svc.ListSessions(userID)
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+		assert.Equal(t, "failed to list sessions", err.Error())
+	})
+
+	mockSessionRepo.AssertExpectations(t)
+}
+
+func TestRevokeSession(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	otherUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440001")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	revokedAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name        string
+		setupMocks  func()
+		expectedErr error
+		errorMsg    string
+	}{
+		{
+			name: "Revokes the session's token family",
+			setupMocks: func() {
+				mockSessionRepo.On("FindSession", sessionID).Return(&Session{ID: sessionID, UserID: userID}, nil).Once()
+				mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonSessionRevoked).Return(nil).Once()
+			},
+		},
+		{
+			name: "Unknown session",
+			setupMocks: func() {
+				mockSessionRepo.On("FindSession", sessionID).Return(nil, nil).Once()
+			},
+			expectedErr: ErrSessionNotFound,
+		},
+		{
+			name: "Session of another user",
+			setupMocks: func() {
+				mockSessionRepo.On("FindSession", sessionID).Return(&Session{ID: sessionID, UserID: otherUserID}, nil).Once()
+			},
+			expectedErr: ErrSessionNotFound,
+		},
+		{
+			name: "Session already revoked",
+			setupMocks: func() {
+				mockSessionRepo.On("FindSession", sessionID).Return(&Session{ID: sessionID, UserID: userID, RevokedAt: &revokedAt}, nil).Once()
+			},
+			expectedErr: ErrSessionNotFound,
+		},
+		{
+			name: "Revoking the family fails",
+			setupMocks: func() {
+				mockSessionRepo.On("FindSession", sessionID).Return(&Session{ID: sessionID, UserID: userID}, nil).Once()
+				mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonSessionRevoked).Return(errors.New("db error")).Once()
+			},
+			errorMsg: "failed to revoke session",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset mocks
+			mockSessionRepo.ExpectedCalls = nil
+			mockFamilyRepo.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			err := svc.RevokeSession(userID, sessionID, ClientInfo{})
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+			case tt.errorMsg != "":
+				assert.Error(t, err)
+				assert.Equal(t, tt.errorMsg, err.Error())
+			default:
+				assert.NoError(t, err)
+			}
+
+			// Verify all expectations were met
+			mockSessionRepo.AssertExpectations(t)
+			mockFamilyRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthEvents_Login(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	hashedPin, _ := utils.HashPin("123456")
+	testUser := &user.User{ID: testUserID, PhoneNumber: "+66812345678", PinHash: hashedPin, Role: "staff", Status: user.StatusActive}
+	client := ClientInfo{IPAddress: "203.0.113.7", UserAgent: "tt-stock-app/1.0", DeviceID: "device-1"}
+
+	reset := func() {
+		mockUserRepo.ExpectedCalls = nil
+		mockAttemptRepo.ExpectedCalls = nil
+		mockFamilyRepo.ExpectedCalls = nil
+		mockSessionRepo.ExpectedCalls = nil
+		mockEventRepo.Events = nil
+	}
+
+	t.Run("Unknown user", func(t *testing.T) {
+		reset()
+		mockAttemptRepo.On("GetThrottle", mock.AnythingOfType("string")).Return(nil, nil)
+		mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(nil, errors.New("user not found")).Once()
+		mockAttemptRepo.On("RecordFailure", mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+			Return(&LoginThrottle{FailedCount: 1}, nil)
+
+		_, err := svc.AuthenticateUser("0812345678", "123456", client)
+		assert.Error(t, err)
+
+		require.Len(t, mockEventRepo.Events, 1)
+		event := mockEventRepo.Events[0]
+		assert.Equal(t, AuthEventLoginFailed, event.EventType)
+		assert.Equal(t, LoginMethodPin, event.Method)
+		assert.Equal(t, AuthReasonUnknownUser, event.Reason)
+		assert.Equal(t, "+66812345678", event.PhoneNumber)
+		assert.Nil(t, event.UserID)
+		assert.Equal(t, "203.0.113.7", event.IPAddress)
+		assert.Equal(t, "tt-stock-app/1.0", event.UserAgent)
+		assert.Equal(t, "device-1", event.DeviceID)
+	})
+
+	t.Run("Wrong PIN that locks the account", func(t *testing.T) {
+		reset()
+		mockAttemptRepo.On("GetThrottle", mock.AnythingOfType("string")).Return(nil, nil)
+		mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+		mockAttemptRepo.On("RecordFailure", "ip:203.0.113.7", mock.AnythingOfType("time.Time")).
+			Return(&LoginThrottle{Key: "ip:203.0.113.7", FailedCount: 5}, nil).Once()
+		mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+			Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 5}, nil).Once()
+		mockAttemptRepo.On("LockUntil", "phone:+66812345678", mock.AnythingOfType("time.Time")).Return(nil).Once()
+
+		_, err := svc.AuthenticateUser("0812345678", "654321", client)
+		var lockedErr *AccountLockedError
+		assert.True(t, errors.As(err, &lockedErr))
+
+		assert.Equal(t, []string{AuthEventLoginFailed, AuthEventAccountLocked}, mockEventRepo.eventTypes())
+		assert.Equal(t, AuthReasonInvalidPin, mockEventRepo.Events[0].Reason)
+		require.NotNil(t, mockEventRepo.Events[1].UserID)
+		assert.Equal(t, testUserID, *mockEventRepo.Events[1].UserID)
+	})
+
+	t.Run("Successful login records the method and session", func(t *testing.T) {
+		reset()
+		familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+		mockFamilyRepo.On("CreateFamily", testUserID).Return(familyID, nil).Once()
+		mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+
+		loginClient := ClientInfo{IPAddress: "203.0.113.7", LoginMethod: LoginMethodOTP}
+		_, err := svc.GenerateTokens(testUser, loginClient)
+		require.NoError(t, err)
+
+		require.Len(t, mockEventRepo.Events, 1)
+		event := mockEventRepo.Events[0]
+		assert.Equal(t, AuthEventLoginSucceeded, event.EventType)
+		assert.Equal(t, LoginMethodOTP, event.Method)
+		require.NotNil(t, event.SessionID)
+		assert.Equal(t, familyID, *event.SessionID)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	claims := &Claims{
+		UserID:      uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"),
+		PhoneNumber: "+66812345678",
+		TokenType:   "access",
+		FamilyID:    sessionID.String(),
+	}
+
+	t.Run("Ends the session and records the logout", func(t *testing.T) {
+		mockEventRepo.Events = nil
+		mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonLogout).Return(nil).Once()
+
+		err := svc.Logout(claims, ClientInfo{IPAddress: "203.0.113.7"})
+		require.NoError(t, err)
+
+		require.Len(t, mockEventRepo.Events, 1)
+		event := mockEventRepo.Events[0]
+		assert.Equal(t, AuthEventLogout, event.EventType)
+		assert.Equal(t, claims.UserID, *event.UserID)
+		assert.Equal(t, sessionID, *event.SessionID)
+		assert.Equal(t, "203.0.113.7", event.IPAddress)
+		mockFamilyRepo.AssertExpectations(t)
+	})
+
+	t.Run("Token without a session", func(t *testing.T) {
+		mockEventRepo.Events = nil
+		legacyClaims := *claims
+		legacyClaims.FamilyID = ""
+
+		err := svc.Logout(&legacyClaims, ClientInfo{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{AuthEventLogout}, mockEventRepo.eventTypes())
+		assert.Nil(t, mockEventRepo.Events[0].SessionID)
+	})
+
+	t.Run("Ending the session fails", func(t *testing.T) {
+		mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonLogout).Return(errors.New("db error")).Once()
+
+		err := svc.Logout(claims, ClientInfo{})
+		assert.EqualError(t, err, "failed to end session")
+	})
+}
+
+func TestLogoutAll(t *testing.T) {
+	svc, mockUserRepo, mockBlacklistRepo := setupTestService()
+	svc.accounts = newAccountStateCache(time.Minute)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	otherSessionID := uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+	issuedAt := time.Now().Add(-time.Minute)
+	subject := tokenSubject{UserID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, FamilyID: sessionID.String(), AuthTime: issuedAt}
+	refreshToken, _ := svc.generateToken(subject, "refresh", uuid.NewString(), issuedAt, issuedAt.Add(24*time.Hour))
+	claims := &Claims{UserID: userID, PhoneNumber: "+66812345678", TokenType: "access", FamilyID: sessionID.String()}
+
+	t.Run("Moves the token cutoff and ends every session without blacklisting tokens", func(t *testing.T) {
+		mockEventRepo.Events = nil
+		svc.accounts.store(userID, user.AccountState{Status: user.StatusActive})
+		mockUserRepo.On("RevokeTokens", userID, mock.AnythingOfType("time.Time")).Return(nil).Once()
+		mockSessionRepo.On("ListActiveSessions", userID).Return([]Session{{ID: sessionID}, {ID: otherSessionID}}, nil).Once()
+		mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonLogoutAll).Return(nil).Once()
+		mockFamilyRepo.On("RevokeFamily", otherSessionID, RevokedReasonLogoutAll).Return(nil).Once()
+
+		require.NoError(t, svc.LogoutAll(claims, ClientInfo{IPAddress: "203.0.113.7"}))
+
+		_, cached := svc.accounts.get(userID)
+		assert.False(t, cached)
+
+		require.Len(t, mockEventRepo.Events, 1)
+		assert.Equal(t, AuthEventLogoutAll, mockEventRepo.Events[0].EventType)
+		assert.Equal(t, sessionID, *mockEventRepo.Events[0].SessionID)
+		mockUserRepo.AssertExpectations(t)
+		mockSessionRepo.AssertExpectations(t)
+		mockFamilyRepo.AssertExpectations(t)
+	})
+
+	t.Run("Tokens issued before the cutoff cannot be refreshed", func(t *testing.T) {
+		cutoff := time.Now()
+		mockFamilyRepo.On("IsFamilyRevoked", sessionID).Return(false, nil).Once()
+		mockUserRepo.On("FindAccountState", userID).Return(&user.AccountState{Status: user.StatusActive, TokensValidAfter: &cutoff}, nil).Once()
+
+		tokens, _, err := svc.RefreshTokens(refreshToken, ClientInfo{})
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+		assert.Nil(t, tokens)
+
+		mockFamilyRepo.AssertExpectations(t)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("Tokens issued in the second of the cutoff", func(t *testing.T) {
+		var cutoff time.Time
+		mockUserRepo.On("RevokeTokens", userID, mock.AnythingOfType("time.Time")).Run(func(args mock.Arguments) {
+			cutoff = args.Get(1).(time.Time)
+		}).Return(nil).Once()
+		mockSessionRepo.On("ListActiveSessions", userID).Return([]Session{{ID: sessionID}}, nil).Once()
+		mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonLogoutAll).Return(nil).Once()
+
+		require.NoError(t, svc.LogoutAll(claims, ClientInfo{}))
+		svc.accounts.store(userID, user.AccountState{Status: user.StatusActive, TokensValidAfter: &cutoff})
+		second := cutoff.Truncate(time.Second)
+
+		// Tokens of the sessions that were ended are rejected
+		endedToken, _ := svc.generateToken(subject, "access", uuid.NewString(), second, second.Add(time.Hour))
+		mockBlacklistRepo.On("IsTokenBlacklisted", endedToken).Return(false, nil).Once()
+		mockFamilyRepo.On("IsFamilyRevoked", sessionID).Return(true, nil).Once()
+		_, err := svc.ValidateToken(endedToken)
+		assert.ErrorIs(t, err, ErrTokenRevoked)
+
+		// Tokens without a session cannot be told apart, so they are rejected too
+		sessionless := subject
+		sessionless.FamilyID = ""
+		sessionlessToken, _ := svc.generateToken(sessionless, "access", uuid.NewString(), second, second.Add(time.Hour))
+		mockBlacklistRepo.On("IsTokenBlacklisted", sessionlessToken).Return(false, nil).Once()
+		_, err = svc.ValidateToken(sessionlessToken)
+		assert.ErrorIs(t, err, ErrTokenRevoked)
+
+		// Logging in again within the same second starts a new session, which is accepted
+		newSessionID := uuid.New()
+		tokens, err := svc.generateTokenPair(tokenSubject{UserID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, FamilyID: newSessionID.String(), AuthTime: time.Now()}, uuid.NewString())
+		require.NoError(t, err)
+
+		mockFamilyRepo.On("IsFamilyRevoked", newSessionID).Return(false, nil).Once()
+		mockBlacklistRepo.On("IsTokenBlacklisted", tokens.AccessToken).Return(false, nil).Once()
+		_, err = svc.ValidateToken(tokens.AccessToken)
+		assert.NoError(t, err)
+
+		mockUserRepo.AssertExpectations(t)
+		mockFamilyRepo.AssertExpectations(t)
+	})
+
+	t.Run("Ending the sessions fails", func(t *testing.T) {
+		mockEventRepo.Events = nil
+		mockUserRepo.On("RevokeTokens", userID, mock.AnythingOfType("time.Time")).Return(nil).Once()
+		mockSessionRepo.On("ListActiveSessions", userID).Return(nil, errors.New("db error")).Once()
+
+		assert.EqualError(t, svc.LogoutAll(claims, ClientInfo{}), "failed to list sessions")
+		assert.Empty(t, mockEventRepo.Events)
+	})
+
+	t.Run("Moving the cutoff fails", func(t *testing.T) {
+		mockEventRepo.Events = nil
+		mockUserRepo.On("RevokeTokens", userID, mock.AnythingOfType("time.Time")).Return(errors.New("db error")).Once()
+
+		assert.EqualError(t, svc.LogoutAll(claims, ClientInfo{}), "failed to revoke tokens")
+		assert.Empty(t, mockEventRepo.Events)
+	})
+}
+
+func TestForceLogout(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	adminID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440003")
+
+	mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusActive}, nil).Once()
+	mockUserRepo.On("RevokeTokens", userID, mock.AnythingOfType("time.Time")).Return(nil).Once()
+	mockSessionRepo.On("ListActiveSessions", userID).Return([]Session{{ID: sessionID}}, nil).Once()
+	mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonForcedLogout).Return(nil).Once()
+
+	require.NoError(t, svc.ForceLogout(ownerClaims, userID, ClientInfo{}))
+	require.Len(t, mockEventRepo.Events, 1)
+	assert.Equal(t, AuthEventUserLoggedOut, mockEventRepo.Events[0].EventType)
+	assert.Equal(t, userID, *mockEventRepo.Events[0].UserID)
+	assert.Equal(t, ownerClaims.UserID, *mockEventRepo.Events[0].ActorID)
+
+	// Owners cannot log admins out
+	mockUserRepo.On("FindByID", adminID).Return(&user.User{ID: adminID, Role: user.RoleAdmin, Status: user.StatusActive}, nil).Once()
+	assert.ErrorIs(t, svc.ForceLogout(ownerClaims, adminID, ClientInfo{}), ErrPermissionDenied)
+	mockUserRepo.AssertNotCalled(t, "RevokeTokens", adminID, mock.Anything)
+
+	mockUserRepo.On("FindByID", userID).Return(nil, fmt.Errorf("user with ID %s %w", userID, user.ErrNotFound)).Once()
+	assert.ErrorIs(t, svc.ForceLogout(ownerClaims, userID, ClientInfo{}), ErrUserNotFound)
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestUnlockUser(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	adminID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440003")
+
+	mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusActive}, nil).Once()
+	mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+
+	require.NoError(t, svc.UnlockUser(ownerClaims, userID, ClientInfo{}))
+	require.Len(t, mockEventRepo.Events, 1)
+	assert.Equal(t, AuthEventUserUnlocked, mockEventRepo.Events[0].EventType)
+	assert.Equal(t, userID, *mockEventRepo.Events[0].UserID)
+	assert.Equal(t, ownerClaims.UserID, *mockEventRepo.Events[0].ActorID)
+
+	// Owners cannot unlock admins
+	mockUserRepo.On("FindByID", adminID).Return(&user.User{ID: adminID, PhoneNumber: "+66812345679", Role: user.RoleAdmin, Status: user.StatusActive}, nil).Once()
+	assert.ErrorIs(t, svc.UnlockUser(ownerClaims, adminID, ClientInfo{}), ErrPermissionDenied)
+	mockAttemptRepo.AssertNotCalled(t, "Reset", "phone:+66812345679")
+
+	mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusActive}, nil).Once()
+	mockAttemptRepo.On("Reset", "phone:+66812345678").Return(errors.New("db error")).Once()
+	assert.EqualError(t, svc.UnlockUser(ownerClaims, userID, ClientInfo{}), "failed to unlock account")
+	assert.Len(t, mockEventRepo.Events, 1)
+
+	mockUserRepo.AssertExpectations(t)
+	mockAttemptRepo.AssertExpectations(t)
+}
+
+func TestIssuedBeforeCutoff(t *testing.T) {
+	issuedAt := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(issuedAt)}}
+
+	at := func(d time.Duration) *time.Time {
+		cutoff := issuedAt.Add(d)
+		return &cutoff
+	}
+
+	assert.False(t, issuedBeforeCutoff(claims, nil))
+	assert.False(t, issuedBeforeCutoff(claims, at(-time.Second)))
+	assert.True(t, issuedBeforeCutoff(claims, at(time.Minute)))
+	assert.True(t, issuedBeforeCutoff(claims, at(time.Second)))
+	// Issue times are whole seconds, so a token from the second of the cutoff is only accepted
+	// if it belongs to a session, which the family check covers
+	assert.True(t, issuedBeforeCutoff(claims, at(0)))
+	assert.True(t, issuedBeforeCutoff(claims, at(500*time.Millisecond)))
+	sessionClaims := &Claims{FamilyID: uuid.NewString(), RegisteredClaims: claims.RegisteredClaims}
+	assert.False(t, issuedBeforeCutoff(sessionClaims, at(500*time.Millisecond)))
+	assert.True(t, issuedBeforeCutoff(sessionClaims, at(time.Second)))
+	// Tokens without an issue time are rejected once there is a cutoff
+	assert.True(t, issuedBeforeCutoff(&Claims{}, at(-time.Hour)))
+}
+
+func TestListAuthEvents(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	events := []AuthEvent{{ID: uuid.New(), EventType: AuthEventLogout}}
+
+	tests := []struct {
+		name             string
+		page             int
+		pageSize         int
+		expectedPage     int
+		expectedPageSize int
+		expectedOffset   int
+	}{
+		{name: "Requested page", page: 3, pageSize: 20, expectedPage: 3, expectedPageSize: 20, expectedOffset: 40},
+		{name: "Defaults", page: 0, pageSize: 0, expectedPage: 1, expectedPageSize: 50, expectedOffset: 0},
+		{name: "Page size above the maximum", page: 1, pageSize: 1000, expectedPage: 1, expectedPageSize: 50, expectedOffset: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockEventRepo.ExpectedCalls = nil
+			filter := AuthEventFilter{EventType: AuthEventLogout}
+			expectedFilter := filter
+			expectedFilter.Limit = tt.expectedPageSize
+			expectedFilter.Offset = tt.expectedOffset
+			mockEventRepo.On("ListAuthEvents", expectedFilter).Return(events, 75, nil).Once()
+
+			page, err := svc.ListAuthEvents(filter, tt.page, tt.pageSize)
+			require.NoError(t, err)
+
+			assert.Equal(t, &AuthEventPage{Events: events, Total: 75, Page: tt.expectedPage, PageSize: tt.expectedPageSize}, page)
+			mockEventRepo.AssertExpectations(t)
+		})
+	}
+
+	t.Run("Repository error", func(t *testing.T) {
+		mockEventRepo.ExpectedCalls = nil
+		mockEventRepo.On("ListAuthEvents", mock.AnythingOfType("auth.AuthEventFilter")).Return(nil, 0, errors.New("db error")).Once()
+
+		page, err := svc.ListAuthEvents(AuthEventFilter{}, 1, 50)
+		assert.Nil(t, page)
+		assert.EqualError(t, err, "failed to list auth events")
+	})
+}
+
+func TestLoginAlerts(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockDeviceRepo := svc.deviceRepo.(*MockDeviceRepository)
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+	mockAlertRepo := svc.alertRepo.(*MockLoginAlertRepository)
+	mockNotifier := svc.notifier.(*MockNotifier)
+
+	staff := &user.User{ID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"), PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusActive}
+	owner := user.User{ID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440001"), PhoneNumber: "+66898765432", Role: user.RoleOwner, Status: user.StatusActive}
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	knownClient := ClientInfo{IPAddress: "203.0.113.7", DeviceID: "phone-1"}
+
+	// Opening hours that never include the current time
+	clock := time.Duration(time.Now().In(time.UTC).Hour()) * time.Hour
+	closedNow := &config.OpeningHours{Open: clock + 2*time.Hour, Close: clock + 3*time.Hour}
+
+	setup := func(rules []string, hours *config.OpeningHours) {
+		mockUserRepo.ExpectedCalls = nil
+		mockFamilyRepo.ExpectedCalls = nil
+		mockSessionRepo.ExpectedCalls = nil
+		mockDeviceRepo.ExpectedCalls = nil
+		mockAlertRepo.ExpectedCalls, mockAlertRepo.Calls = nil, nil
+		mockNotifier.ExpectedCalls, mockNotifier.Calls = nil, nil
+		mockEventRepo.Events = []AuthEvent{{EventType: AuthEventLoginSucceeded, UserID: &staff.ID, IPAddress: knownClient.IPAddress, DeviceID: knownClient.DeviceID}}
+		svc.alerts = newLoginAlertConfig(&config.Config{LoginAlertRules: rules, ShopOpeningHours: hours, ShopTimezone: "UTC"})
+
+		mockFamilyRepo.On("CreateFamily", staff.ID).Return(sessionID, nil).Once()
+		mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+		mockDeviceRepo.On("RecordDevice", mock.AnythingOfType("*auth.Device")).Return(nil).Maybe()
+	}
+	allRules := []string{AlertReasonNewDevice, AlertReasonNewIP, AlertReasonOutsideHours}
+
+	t.Run("New device and IP notify the user and the shop owners", func(t *testing.T) {
+		setup(allRules, nil)
+
+		var alert *LoginAlert
+		mockAlertRepo.On("CreateAlert", mock.AnythingOfType("*auth.LoginAlert")).Run(func(args mock.Arguments) {
+			alert = args.Get(0).(*LoginAlert)
+		}).Return(nil).Once()
+		mockUserRepo.On("FindByRole", user.RoleOwner).Return([]user.User{owner}, nil).Once()
+		mockNotifier.On("Notify", mock.MatchedBy(func(msg notify.Message) bool {
+			return msg.PhoneNumber == staff.PhoneNumber && strings.Contains(msg.Body, "from a new device (tablet-9)") &&
+				strings.Contains(msg.Body, "from a new IP address (198.51.100.4)")
+		})).Return(nil).Once()
+		mockNotifier.On("Notify", mock.MatchedBy(func(msg notify.Message) bool {
+			return msg.PhoneNumber == owner.PhoneNumber && msg.Subject == "Unusual login by 0812345678"
+		})).Return(nil).Once()
+
+		_, err := svc.GenerateTokens(staff, ClientInfo{IPAddress: "198.51.100.4", UserAgent: "tt-stock-app/1.0", DeviceID: "tablet-9"})
+		require.NoError(t, err)
+
+		require.NotNil(t, alert)
+		assert.Equal(t, staff.ID, alert.UserID)
+		assert.Equal(t, []string{AlertReasonNewDevice, AlertReasonNewIP}, alert.Reasons)
+		assert.Equal(t, sessionID, *alert.SessionID)
+		assert.Equal(t, "tt-stock-app/1.0", alert.UserAgent)
+		mockAlertRepo.AssertExpectations(t)
+		mockNotifier.AssertExpectations(t)
+	})
+
+	t.Run("Known device and IP raise no alert", func(t *testing.T) {
+		setup(allRules, nil)
+
+		_, err := svc.GenerateTokens(staff, knownClient)
+		require.NoError(t, err)
+
+		mockAlertRepo.AssertNotCalled(t, "CreateAlert", mock.Anything)
+		mockNotifier.AssertNotCalled(t, "Notify", mock.Anything)
+	})
+
+	t.Run("First login raises no alert", func(t *testing.T) {
+		setup(allRules, nil)
+		mockEventRepo.Events = nil
+
+		_, err := svc.GenerateTokens(staff, ClientInfo{IPAddress: "198.51.100.4", DeviceID: "tablet-9"})
+		require.NoError(t, err)
+
+		mockAlertRepo.AssertNotCalled(t, "CreateAlert", mock.Anything)
+	})
+
+	t.Run("Login outside opening hours", func(t *testing.T) {
+		setup(allRules, closedNow)
+
+		mockAlertRepo.On("CreateAlert", mock.MatchedBy(func(alert *LoginAlert) bool {
+			return assert.ObjectsAreEqual([]string{AlertReasonOutsideHours}, alert.Reasons)
+		})).Return(nil).Once()
+		mockUserRepo.On("FindByRole", user.RoleOwner).Return([]user.User{owner}, nil).Once()
+		mockNotifier.On("Notify", mock.AnythingOfType("notify.Message")).Return(nil).Twice()
+
+		_, err := svc.GenerateTokens(staff, knownClient)
+		require.NoError(t, err)
+
+		mockAlertRepo.AssertExpectations(t)
+		mockNotifier.AssertExpectations(t)
+	})
+
+	t.Run("Disabled rules raise no alert", func(t *testing.T) {
+		setup([]string{AlertReasonNewIP}, closedNow)
+
+		_, err := svc.GenerateTokens(staff, ClientInfo{IPAddress: knownClient.IPAddress, DeviceID: "tablet-9"})
+		require.NoError(t, err)
+
+		mockAlertRepo.AssertNotCalled(t, "CreateAlert", mock.Anything)
+	})
+
+	t.Run("Owners are notified once about their own login", func(t *testing.T) {
+		setup(allRules, closedNow)
+		ownerUser := owner
+		mockFamilyRepo.ExpectedCalls = nil
+		mockFamilyRepo.On("CreateFamily", owner.ID).Return(sessionID, nil).Once()
+
+		mockAlertRepo.On("CreateAlert", mock.AnythingOfType("*auth.LoginAlert")).Return(errors.New("db error")).Once()
+		mockUserRepo.On("FindByRole", user.RoleOwner).Return([]user.User{owner}, nil).Once()
+		mockNotifier.On("Notify", mock.MatchedBy(func(msg notify.Message) bool {
+			return msg.PhoneNumber == owner.PhoneNumber
+		})).Return(errors.New("gateway down")).Once()
+
+		// Failing to record or deliver the alert does not fail the login
+		_, err := svc.GenerateTokens(&ownerUser, ClientInfo{})
+		require.NoError(t, err)
+
+		mockNotifier.AssertExpectations(t)
+	})
+}
+
+func TestListLoginAlerts(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAlertRepo := svc.alertRepo.(*MockLoginAlertRepository)
+
+	staffClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"), Role: user.RoleStaff}
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440001"), Role: user.RoleOwner}
+	otherUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440002")
+	open := false
+	alerts := []LoginAlert{{ID: uuid.New(), UserID: otherUserID, Reasons: []string{AlertReasonNewDevice}}}
+
+	t.Run("Owners see the alerts of every user", func(t *testing.T) {
+		mockAlertRepo.ExpectedCalls = nil
+		mockAlertRepo.On("ListAlerts", LoginAlertFilter{UserID: otherUserID, Reviewed: &open, Limit: 20, Offset: 20}).Return(alerts, 21, nil).Once()
+
+		page, err := svc.ListLoginAlerts(ownerClaims, LoginAlertFilter{UserID: otherUserID, Reviewed: &open}, 2, 20)
+		require.NoError(t, err)
+
+		assert.Equal(t, &LoginAlertPage{Alerts: alerts, Total: 21, Page: 2, PageSize: 20}, page)
+		mockAlertRepo.AssertExpectations(t)
+	})
+
+	t.Run("Other users only see their own", func(t *testing.T) {
+		mockAlertRepo.ExpectedCalls = nil
+		mockAlertRepo.On("ListAlerts", LoginAlertFilter{UserID: staffClaims.UserID, Limit: 50}).Return([]LoginAlert{}, 0, nil).Once()
+
+		page, err := svc.ListLoginAlerts(staffClaims, LoginAlertFilter{UserID: otherUserID}, 0, 0)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, page.Page)
+		assert.Equal(t, 50, page.PageSize)
+		mockAlertRepo.AssertExpectations(t)
+	})
+
+	t.Run("Repository error", func(t *testing.T) {
+		mockAlertRepo.ExpectedCalls = nil
+		mockAlertRepo.On("ListAlerts", mock.AnythingOfType("auth.LoginAlertFilter")).Return(nil, 0, errors.New("db error")).Once()
+
+		page, err := svc.ListLoginAlerts(ownerClaims, LoginAlertFilter{}, 1, 50)
+		assert.Nil(t, page)
+		assert.EqualError(t, err, "failed to list login alerts")
+	})
+}
+
+func TestReviewLoginAlert(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAlertRepo := svc.alertRepo.(*MockLoginAlertRepository)
+
+	staffClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"), Role: user.RoleStaff}
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440001"), Role: user.RoleOwner}
+	alertID := uuid.MustParse("6ba7b815-9dad-11d1-80b4-00c04fd430c8")
+	reviewedAt := time.Now()
+
+	ownAlert := &LoginAlert{ID: alertID, UserID: staffClaims.UserID}
+	othersAlert := &LoginAlert{ID: alertID, UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002")}
+
+	tests := []struct {
+		name        string
+		claims      *Claims
+		setupMocks  func()
+		expectedErr error
+		errorMsg    string
+	}{
+		{
+			name:   "Users review their own alerts",
+			claims: staffClaims,
+			setupMocks: func() {
+				mockAlertRepo.On("FindAlert", alertID).Return(ownAlert, nil).Once()
+				mockAlertRepo.On("MarkReviewed", alertID, staffClaims.UserID).
+					Return(&LoginAlert{ID: alertID, UserID: staffClaims.UserID, ReviewedAt: &reviewedAt, ReviewedBy: &staffClaims.UserID}, nil).Once()
+			},
+		},
+		{
+			name:   "Owners review the alerts of other users",
+			claims: ownerClaims,
+			setupMocks: func() {
+				mockAlertRepo.On("FindAlert", alertID).Return(othersAlert, nil).Once()
+				mockAlertRepo.On("MarkReviewed", alertID, ownerClaims.UserID).
+					Return(&LoginAlert{ID: alertID, UserID: othersAlert.UserID, ReviewedAt: &reviewedAt, ReviewedBy: &ownerClaims.UserID}, nil).Once()
+			},
+		},
+		{
+			name:   "Alerts of other users are hidden from staff",
+			claims: staffClaims,
+			setupMocks: func() {
+				mockAlertRepo.On("FindAlert", alertID).Return(othersAlert, nil).Once()
+			},
+			expectedErr: ErrLoginAlertNotFound,
+		},
+		{
+			name:   "Unknown alert",
+			claims: ownerClaims,
+			setupMocks: func() {
+				mockAlertRepo.On("FindAlert", alertID).Return(nil, nil).Once()
+			},
+			expectedErr: ErrLoginAlertNotFound,
+		},
+		{
+			name:   "Marking the alert fails",
+			claims: staffClaims,
+			setupMocks: func() {
+				mockAlertRepo.On("FindAlert", alertID).Return(ownAlert, nil).Once()
+				mockAlertRepo.On("MarkReviewed", alertID, staffClaims.UserID).Return(nil, errors.New("db error")).Once()
+			},
+			errorMsg: "failed to review login alert",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAlertRepo.ExpectedCalls = nil
+			tt.setupMocks()
+
+			alert, err := svc.ReviewLoginAlert(tt.claims, alertID)
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, alert)
+			case tt.errorMsg != "":
+				assert.EqualError(t, err, tt.errorMsg)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.claims.UserID, *alert.ReviewedBy)
+			}
+
+			mockAlertRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	adminClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440001"), Role: user.RoleAdmin}
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+
+	tests := []struct {
+		name          string
+		claims        *Claims
+		input         CreateUserInput
+		setupMocks    func()
+		expectedErr   error
+		expectedField string
+	}{
+		{
+			name:   "Staff account with an initial PIN",
+			claims: ownerClaims,
+			input:  CreateUserInput{PhoneNumber: "081-234-5678", Pin: "582914", Branch: " silom "},
+			setupMocks: func() {
+				mockUserRepo.On("Create", mock.MatchedBy(func(u *user.User) bool {
+					return u.PhoneNumber == "+66812345678" && u.Role == user.RoleStaff && u.Branch == "silom" &&
+						u.Status == user.StatusPendingPinChange && utils.CheckPin(u.PinHash, "582914") == nil
+				})).Return(nil).Once()
+			},
+		},
+		{
+			name:   "Admins create admin accounts",
+			claims: adminClaims,
+			input:  CreateUserInput{PhoneNumber: "0812345678", Pin: "582914", Role: user.RoleAdmin},
+			setupMocks: func() {
+				mockUserRepo.On("Create", mock.AnythingOfType("*user.User")).Return(nil).Once()
+			},
+		},
+		{
+			name:        "Owners cannot create admin accounts",
+			claims:      ownerClaims,
+			input:       CreateUserInput{PhoneNumber: "0812345678", Pin: "582914", Role: user.RoleAdmin},
+			setupMocks:  func() {},
+			expectedErr: ErrPermissionDenied,
+		},
+		{
+			name:          "Invalid role",
+			claims:        ownerClaims,
+			input:         CreateUserInput{PhoneNumber: "0812345678", Pin: "582914", Role: "intern"},
+			setupMocks:    func() {},
+			expectedField: "role",
+		},
+		{
+			name:          "Invalid phone number",
+			claims:        ownerClaims,
+			input:         CreateUserInput{PhoneNumber: "12345", Pin: "582914"},
+			setupMocks:    func() {},
+			expectedField: "phone_number",
+		},
+		{
+			name:          "Weak initial PIN",
+			claims:        ownerClaims,
+			input:         CreateUserInput{PhoneNumber: "0812345678", Pin: "123456"},
+			setupMocks:    func() {},
+			expectedField: "pin",
+		},
+		{
+			name:   "Phone number already registered",
+			claims: ownerClaims,
+			input:  CreateUserInput{PhoneNumber: "0812345678", Pin: "582914"},
+			setupMocks: func() {
+				mockUserRepo.On("Create", mock.AnythingOfType("*user.User")).Return(user.ErrPhoneNumberTaken).Once()
+			},
+			expectedErr: ErrPhoneNumberTaken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserRepo.ExpectedCalls = nil
+			mockAuthEventRepo.Events = nil
+			tt.setupMocks()
+
+			u, err := svc.CreateUser(tt.claims, tt.input, ClientInfo{IPAddress: "192.168.1.10"})
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, u)
+				assert.Empty(t, mockAuthEventRepo.Events)
+			case tt.expectedField != "":
+				var validationErr *ValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, tt.expectedField, validationErr.Field)
+				assert.Empty(t, mockAuthEventRepo.Events)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, user.StatusPendingPinChange, u.Status)
+				require.Len(t, mockAuthEventRepo.Events, 1)
+				event := mockAuthEventRepo.Events[0]
+				assert.Equal(t, AuthEventUserCreated, event.EventType)
+				assert.Equal(t, u.PhoneNumber, event.PhoneNumber)
+				assert.Equal(t, tt.claims.UserID, *event.ActorID)
+			}
+
+			mockUserRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	svc.authorizer = policy.NewEngine(policy.NewStaticRepository(
+		policy.Rule{Permission: policy.PermissionUserManage, Role: user.RoleManager, Effect: policy.EffectAllow,
+			Conditions: []policy.Condition{{Attribute: "resource.branch", Operator: policy.OperatorEq, Ref: "subject.branch"}}},
+	), 0)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	managerClaims := &Claims{UserID: uuid.New(), Role: user.RoleManager, Branch: "silom"}
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	staff := &user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Branch: "asok", Status: user.StatusActive}
+
+	mockUserRepo.On("FindByID", userID).Return(staff, nil)
+
+	u, err := svc.GetUser(ownerClaims, userID)
+	require.NoError(t, err)
+	assert.Equal(t, staff, u)
+
+	// Branch managers only see the users of their own branch
+	u, err = svc.GetUser(managerClaims, userID)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.Nil(t, u)
+}
+
+func TestListUsers(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner, Branch: "silom"}
+	users := []user.User{{ID: uuid.New(), PhoneNumber: "+66812345678", Role: user.RoleStaff}}
+
+	// Owners cannot manage admins, so they do not see them either
+	mockUserRepo.On("List", user.Filter{ExcludeRoles: []string{user.RoleAdmin}, PhoneNumber: "+66812345678", Status: user.StatusActive, Limit: 20, Offset: 40}).Return(users, 41, nil).Once()
+
+	page, err := svc.ListUsers(ownerClaims, user.Filter{PhoneNumber: "081 234 5678", Status: user.StatusActive}, 3, 20)
+	require.NoError(t, err)
+	assert.Equal(t, users, page.Users)
+	assert.Equal(t, 41, page.Total)
+	assert.Equal(t, 3, page.Page)
+	assert.Equal(t, 20, page.PageSize)
+
+	// Out-of-range pages fall back to the defaults
+	mockUserRepo.On("List", user.Filter{ExcludeRoles: []string{user.RoleAdmin}, Limit: defaultUserPageSize}).Return([]user.User{}, 0, nil).Once()
+
+	page, err = svc.ListUsers(ownerClaims, user.Filter{}, 0, maxUserPageSize+1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, page.Page)
+	assert.Equal(t, defaultUserPageSize, page.PageSize)
+
+	_, err = svc.ListUsers(ownerClaims, user.Filter{Role: user.RoleAdmin}, 1, 0)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	mockUserRepo.AssertExpectations(t)
+
+	t.Run("Branch managers only list the users of their own branch", func(t *testing.T) {
+		svc.authorizer = policy.NewEngine(policy.NewStaticRepository(
+			policy.Rule{Permission: policy.PermissionUserManage, Role: user.RoleManager, Effect: policy.EffectAllow,
+				Conditions: []policy.Condition{{Attribute: "resource.branch", Operator: policy.OperatorEq, Ref: "subject.branch"}}},
+		), 0)
+		managerClaims := &Claims{UserID: uuid.New(), Role: user.RoleManager, Branch: "silom"}
+
+		mockUserRepo.On("List", user.Filter{Branch: "silom", Limit: defaultUserPageSize}).Return(users, 1, nil).Twice()
+
+		_, err := svc.ListUsers(managerClaims, user.Filter{}, 1, 0)
+		require.NoError(t, err)
+
+		_, err = svc.ListUsers(managerClaims, user.Filter{Branch: "silom"}, 1, 0)
+		require.NoError(t, err)
+
+		_, err = svc.ListUsers(managerClaims, user.Filter{Branch: "asok"}, 1, 0)
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestUpdateUser(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	staff := func() *user.User {
+		return &user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Branch: "silom", Status: user.StatusActive}
+	}
+	manager := user.RoleManager
+	admin := user.RoleAdmin
+	otpLogin := true
+	taken := "0898765432"
+
+	tests := []struct {
+		name          string
+		input         UpdateUserInput
+		setupMocks    func()
+		expectedErr   error
+		expectedField string
+		check         func(t *testing.T, u *user.User)
+	}{
+		{
+			name:  "Promotion signs the user out everywhere",
+			input: UpdateUserInput{Role: &manager},
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(staff(), nil).Once()
+				mockUserRepo.On("Update", mock.MatchedBy(func(u *user.User) bool {
+					return u.ID == userID && u.Role == user.RoleManager && u.Branch == "silom"
+				})).Return(nil).Once()
+				mockSessionRepo.On("ListActiveSessions", userID).Return([]Session{{ID: sessionID}}, nil).Once()
+				mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonAccountChanged).Return(nil).Once()
+			},
+			check: func(t *testing.T, u *user.User) {
+				assert.Equal(t, user.RoleManager, u.Role)
+			},
+		},
+		{
+			name:  "Other changes keep the sessions",
+			input: UpdateUserInput{OTPLogin: &otpLogin},
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(staff(), nil).Once()
+				mockUserRepo.On("Update", mock.AnythingOfType("*user.User")).Return(nil).Once()
+			},
+			check: func(t *testing.T, u *user.User) {
+				assert.True(t, u.OTPLogin)
+			},
+		},
+		{
+			name:  "Owners cannot promote users to admin",
+			input: UpdateUserInput{Role: &admin},
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(staff(), nil).Once()
+			},
+			expectedErr: ErrPermissionDenied,
+		},
+		{
+			name:  "Owners cannot edit admin accounts",
+			input: UpdateUserInput{OTPLogin: &otpLogin},
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, Role: user.RoleAdmin, Status: user.StatusActive}, nil).Once()
+			},
+			expectedErr: ErrPermissionDenied,
+		},
+		{
+			name:  "Phone number already registered",
+			input: UpdateUserInput{PhoneNumber: &taken},
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(staff(), nil).Once()
+				mockUserRepo.On("Update", mock.MatchedBy(func(u *user.User) bool {
+					return u.PhoneNumber == "+66898765432"
+				})).Return(user.ErrPhoneNumberTaken).Once()
+			},
+			expectedErr: ErrPhoneNumberTaken,
+		},
+		{
+			name:  "Invalid role",
+			input: UpdateUserInput{Role: func() *string { r := "intern"; return &r }()},
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(staff(), nil).Once()
+			},
+			expectedField: "role",
+		},
+		{
+			name:  "Unknown user",
+			input: UpdateUserInput{Role: &manager},
+			setupMocks: func() {
+				mockUserRepo.On("FindByID", userID).Return(nil, fmt.Errorf("user with ID %s %w", userID, user.ErrNotFound)).Once()
+			},
+			expectedErr: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserRepo.ExpectedCalls = nil
+			mockSessionRepo.ExpectedCalls = nil
+			mockFamilyRepo.ExpectedCalls = nil
+			mockAuthEventRepo.Events = nil
+			tt.setupMocks()
+
+			u, err := svc.UpdateUser(ownerClaims, userID, tt.input, ClientInfo{})
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, u)
+			case tt.expectedField != "":
+				var validationErr *ValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, tt.expectedField, validationErr.Field)
+			default:
+				require.NoError(t, err)
+				tt.check(t, u)
+				require.Len(t, mockAuthEventRepo.Events, 1)
+				assert.Equal(t, AuthEventUserUpdated, mockAuthEventRepo.Events[0].EventType)
+				assert.Equal(t, ownerClaims.UserID, *mockAuthEventRepo.Events[0].ActorID)
+			}
+
+			mockUserRepo.AssertExpectations(t)
+			mockSessionRepo.AssertExpectations(t)
+			mockFamilyRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSetUserStatus(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	t.Run("Suspension revokes every session", func(t *testing.T) {
+		mockAuthEventRepo.Events = nil
+		svc.accounts.store(userID, user.AccountState{Status: user.StatusActive})
+		mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusActive}, nil).Once()
+		mockUserRepo.On("UpdateStatus", userID, user.StatusSuspended).Return(nil).Once()
+		mockSessionRepo.On("ListActiveSessions", userID).Return([]Session{{ID: sessionID}}, nil).Once()
+		mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonAccountSuspended).Return(nil).Once()
+
+		u, err := svc.SetUserStatus(ownerClaims, userID, user.StatusSuspended, ClientInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, user.StatusSuspended, u.Status)
+		assert.NotNil(t, u.StatusChangedAt)
+		assert.False(t, u.CanLogin())
+
+		_, cached := svc.accounts.get(userID)
+		assert.False(t, cached)
+
+		require.Len(t, mockAuthEventRepo.Events, 1)
+		assert.Equal(t, AuthEventUserStatusChanged, mockAuthEventRepo.Events[0].EventType)
+		assert.Equal(t, user.StatusSuspended, mockAuthEventRepo.Events[0].Reason)
+		assert.Equal(t, userID, *mockAuthEventRepo.Events[0].UserID)
+		assert.Equal(t, ownerClaims.UserID, *mockAuthEventRepo.Events[0].ActorID)
+
+		mockUserRepo.AssertExpectations(t)
+		mockSessionRepo.AssertExpectations(t)
+		mockFamilyRepo.AssertExpectations(t)
+	})
+
+	t.Run("Reactivation keeps the sessions and lifts a lockout", func(t *testing.T) {
+		mockAuthEventRepo.Events = nil
+		mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+		mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusSuspended}, nil).Once()
+		mockUserRepo.On("UpdateStatus", userID, user.StatusActive).Return(nil).Once()
+		mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+
+		u, err := svc.SetUserStatus(ownerClaims, userID, user.StatusActive, ClientInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, user.StatusActive, u.Status)
+		assert.True(t, u.CanLogin())
+
+		mockUserRepo.AssertExpectations(t)
+		mockAttemptRepo.AssertExpectations(t)
+		mockSessionRepo.AssertNumberOfCalls(t, "ListActiveSessions", 1)
+	})
+
+	t.Run("Invalid status", func(t *testing.T) {
+		u, err := svc.SetUserStatus(ownerClaims, userID, "retired", ClientInfo{})
+		var validationErr *ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		assert.Equal(t, "status", validationErr.Field)
+		assert.Nil(t, u)
+	})
+
+	t.Run("Administrators cannot change their own status", func(t *testing.T) {
+		u, err := svc.SetUserStatus(ownerClaims, ownerClaims.UserID, user.StatusSuspended, ClientInfo{})
+		assert.ErrorIs(t, err, ErrOwnAccount)
+		assert.Nil(t, u)
+		mockUserRepo.AssertNotCalled(t, "FindByID", ownerClaims.UserID)
+	})
+
+	t.Run("Owners cannot suspend admins", func(t *testing.T) {
+		adminID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440003")
+		mockUserRepo.On("FindByID", adminID).Return(&user.User{ID: adminID, Role: user.RoleAdmin, Status: user.StatusActive}, nil).Once()
+
+		u, err := svc.SetUserStatus(ownerClaims, adminID, user.StatusSuspended, ClientInfo{})
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+		assert.Nil(t, u)
+		mockUserRepo.AssertNotCalled(t, "UpdateStatus", adminID, user.StatusSuspended)
+	})
+}
+
+func TestAccountStatus(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	svc.accounts = newAccountStateCache(time.Minute)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+
+	// Only the first lookup reaches the database
+	mockUserRepo.On("FindAccountState", userID).Return(&user.AccountState{Status: user.StatusActive}, nil).Once()
+	for i := 0; i < 3; i++ {
+		status, err := svc.AccountStatus(userID)
+		require.NoError(t, err)
+		assert.Equal(t, user.StatusActive, status)
+	}
+	mockUserRepo.AssertExpectations(t)
+
+	// Errors are not cached
+	otherID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440001")
+	mockUserRepo.On("FindAccountState", otherID).Return(nil, errors.New("db error")).Once()
+	_, err := svc.AccountStatus(otherID)
+	assert.EqualError(t, err, "failed to check account status")
+
+	mockUserRepo.On("FindAccountState", otherID).Return(nil, fmt.Errorf("user with ID %s %w", otherID, user.ErrNotFound)).Once()
+	_, err = svc.AccountStatus(otherID)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestDeleteUser(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+
+	mockUserRepo.On("FindByID", userID).Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusActive}, nil).Once()
+	mockUserRepo.On("Delete", userID).Return(nil).Once()
+
+	require.NoError(t, svc.DeleteUser(ownerClaims, userID, ClientInfo{}))
+	require.Len(t, mockAuthEventRepo.Events, 1)
+	assert.Equal(t, AuthEventUserDeleted, mockAuthEventRepo.Events[0].EventType)
+	assert.Equal(t, "+66812345678", mockAuthEventRepo.Events[0].PhoneNumber)
+
+	assert.ErrorIs(t, svc.DeleteUser(ownerClaims, ownerClaims.UserID, ClientInfo{}), ErrOwnAccount)
+
+	mockUserRepo.On("FindByID", userID).Return(nil, fmt.Errorf("user with ID %s %w", userID, user.ErrNotFound)).Once()
+	assert.ErrorIs(t, svc.DeleteUser(ownerClaims, userID, ClientInfo{}), ErrUserNotFound)
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestCreateServiceAccount(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAccountRepo := svc.serviceAccountRepo.(*MockServiceAccountRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	managerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440003"), Role: user.RoleManager}
+
+	mockAccountRepo.On("CreateServiceAccount", mock.MatchedBy(func(account *ServiceAccount) bool {
+		return account.Name == "POS Silom" && account.Branch == "silom" && *account.CreatedBy == ownerClaims.UserID
+	})).Return(nil).Once()
+
+	account, err := svc.CreateServiceAccount(ownerClaims, CreateServiceAccountInput{Name: " POS Silom ", Branch: "silom"}, ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "POS Silom", account.Name)
+	require.Len(t, mockAuthEventRepo.Events, 1)
+	assert.Equal(t, AuthEventServiceAccountCreated, mockAuthEventRepo.Events[0].EventType)
+	assert.Equal(t, ownerClaims.UserID, *mockAuthEventRepo.Events[0].ActorID)
+
+	mockAccountRepo.On("CreateServiceAccount", mock.Anything).Return(ErrServiceAccountNameTaken).Once()
+	_, err = svc.CreateServiceAccount(ownerClaims, CreateServiceAccountInput{Name: "POS Silom"}, ClientInfo{})
+	assert.ErrorIs(t, err, ErrServiceAccountNameTaken)
+
+	_, err = svc.CreateServiceAccount(ownerClaims, CreateServiceAccountInput{Name: "  "}, ClientInfo{})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = svc.CreateServiceAccount(managerClaims, CreateServiceAccountInput{Name: "Accounting"}, ClientInfo{})
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	mockAccountRepo.AssertExpectations(t)
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAccountRepo := svc.serviceAccountRepo.(*MockServiceAccountRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	accountID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	account := &ServiceAccount{ID: accountID, Name: "POS Silom", Branch: "silom"}
+	mockAccountRepo.On("FindServiceAccount", accountID).Return(account, nil)
+
+	t.Run("Creates a hashed key that is only returned once", func(t *testing.T) {
+		expiresAt := time.Now().Add(90 * 24 * time.Hour)
+		var stored *APIKey
+		mockAccountRepo.On("CreateAPIKey", mock.AnythingOfType("*auth.APIKey")).Run(func(args mock.Arguments) {
+			stored = args.Get(0).(*APIKey)
+		}).Return(nil).Once()
+
+		created, err := svc.CreateAPIKey(ownerClaims, accountID, CreateAPIKeyInput{
+			Name:        "Till 1",
+			Permissions: []string{policy.PermissionStockAdjust, policy.PermissionStockAdjust, policy.PermissionReportViewCost},
+			ExpiresAt:   &expiresAt,
+		}, ClientInfo{})
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(created.Key, APIKeyPrefix))
+		assert.Equal(t, created.Key[:apiKeyDisplayLength], created.Prefix)
+		assert.Equal(t, utils.HashToken(created.Key), stored.KeyHash)
+		assert.NotContains(t, stored.KeyHash, created.Key)
+		assert.Equal(t, []string{policy.PermissionStockAdjust, policy.PermissionReportViewCost}, stored.Permissions)
+		assert.Equal(t, &expiresAt, stored.ExpiresAt)
+
+		require.NotEmpty(t, mockAuthEventRepo.Events)
+		event := mockAuthEventRepo.Events[len(mockAuthEventRepo.Events)-1]
+		assert.Equal(t, AuthEventAPIKeyCreated, event.EventType)
+		assert.Equal(t, created.Prefix, event.Reason)
+	})
+
+	t.Run("Rejects invalid requests", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		tests := []struct {
+			name        string
+			claims      *Claims
+			input       CreateAPIKeyInput
+			expectedErr error
+		}{
+			{name: "No permissions", claims: ownerClaims, input: CreateAPIKeyInput{}, expectedErr: ErrValidation},
+			{name: "Unknown permission", claims: ownerClaims, input: CreateAPIKeyInput{Permissions: []string{"stock.delete"}}, expectedErr: ErrValidation},
+			{name: "Expiry in the past", claims: ownerClaims, input: CreateAPIKeyInput{Permissions: []string{policy.PermissionStockAdjust}, ExpiresAt: &past}, expectedErr: ErrValidation},
+			{name: "Administrator without service_account.manage", claims: &Claims{UserID: uuid.New(), Role: user.RoleManager}, input: CreateAPIKeyInput{Permissions: []string{policy.PermissionStockAdjust}}, expectedErr: ErrPermissionDenied},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := svc.CreateAPIKey(tt.claims, accountID, tt.input, ClientInfo{})
+				assert.ErrorIs(t, err, tt.expectedErr)
+			})
+		}
+	})
+
+	t.Run("Administrators can only grant permissions they hold", func(t *testing.T) {
+		svc.authorizer = policy.NewEngine(policy.NewStaticRepository(
+			policy.Rule{Permission: policy.PermissionServiceAccountManage, Role: user.RoleManager, Effect: policy.EffectAllow},
+		), 0)
+		managerClaims := &Claims{UserID: uuid.New(), Role: user.RoleManager}
+
+		_, err := svc.CreateAPIKey(managerClaims, accountID, CreateAPIKeyInput{Permissions: []string{policy.PermissionUserManage}}, ClientInfo{})
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+	})
+
+	t.Run("Unknown service account", func(t *testing.T) {
+		missingID := uuid.New()
+		mockAccountRepo.On("FindServiceAccount", missingID).Return(nil, nil).Once()
+
+		_, err := svc.CreateAPIKey(ownerClaims, missingID, CreateAPIKeyInput{Permissions: []string{policy.PermissionStockAdjust}}, ClientInfo{})
+		assert.ErrorIs(t, err, ErrServiceAccountNotFound)
+	})
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAccountRepo := svc.serviceAccountRepo.(*MockServiceAccountRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	accountID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	keyID := uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+
+	mockAccountRepo.On("FindServiceAccount", accountID).Return(&ServiceAccount{ID: accountID, Name: "POS Silom"}, nil)
+	mockAccountRepo.On("ListAPIKeys", accountID).Return([]APIKey{{ID: keyID, ServiceAccountID: accountID, Prefix: "tts_abcdefgh"}}, nil)
+	mockAccountRepo.On("RevokeAPIKey", accountID, keyID).Return(true, nil).Once()
+
+	require.NoError(t, svc.RevokeAPIKey(ownerClaims, accountID, keyID, ClientInfo{}))
+	require.Len(t, mockAuthEventRepo.Events, 1)
+	assert.Equal(t, AuthEventAPIKeyRevoked, mockAuthEventRepo.Events[0].EventType)
+	assert.Equal(t, "tts_abcdefgh", mockAuthEventRepo.Events[0].Reason)
+	assert.Equal(t, accountID, *mockAuthEventRepo.Events[0].UserID)
+
+	// Keys of other service accounts are not found
+	assert.ErrorIs(t, svc.RevokeAPIKey(ownerClaims, accountID, uuid.New(), ClientInfo{}), ErrAPIKeyNotFound)
+
+	mockAccountRepo.AssertExpectations(t)
+}
+
+func TestDeleteServiceAccount(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAccountRepo := svc.serviceAccountRepo.(*MockServiceAccountRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	ownerClaims := &Claims{UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"), Role: user.RoleOwner}
+	accountID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	mockAccountRepo.On("FindServiceAccount", accountID).Return(&ServiceAccount{ID: accountID, Name: "POS Silom"}, nil)
+	mockAccountRepo.On("DeleteServiceAccount", accountID).Return(true, nil).Once()
+
+	require.NoError(t, svc.DeleteServiceAccount(ownerClaims, accountID, ClientInfo{}))
+	require.Len(t, mockAuthEventRepo.Events, 1)
+	assert.Equal(t, AuthEventServiceAccountDeleted, mockAuthEventRepo.Events[0].EventType)
+
+	// Deleted concurrently by someone else
+	mockAccountRepo.On("DeleteServiceAccount", accountID).Return(false, nil).Once()
+	assert.ErrorIs(t, svc.DeleteServiceAccount(ownerClaims, accountID, ClientInfo{}), ErrServiceAccountNotFound)
+
+	mockAccountRepo.AssertExpectations(t)
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	svc, _, _ := setupTestService()
+	mockAccountRepo := svc.serviceAccountRepo.(*MockServiceAccountRepository)
+
+	accountID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	keyID := uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+	account := &ServiceAccount{ID: accountID, Name: "POS Silom", Branch: "silom"}
+	client := ClientInfo{IPAddress: "203.0.113.7"}
+
+	key, err := newAPIKey()
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		key         string
+		storedKey   *APIKey
+		expectTouch bool
+		expectedErr error
+	}{
+		{
+			name:        "Valid key",
+			key:         key,
+			storedKey:   &APIKey{ID: keyID, ServiceAccountID: accountID, Permissions: []string{policy.PermissionStockAdjust}, ExpiresAt: &future},
+			expectTouch: true,
+		},
+		{name: "Key without the prefix", key: "abc", expectedErr: ErrInvalidAPIKey},
+		{name: "Unknown key", key: key, expectedErr: ErrInvalidAPIKey},
+		{name: "Revoked key", key: key, storedKey: &APIKey{ID: keyID, RevokedAt: &past}, expectedErr: ErrInvalidAPIKey},
+		{name: "Expired key", key: key, storedKey: &APIKey{ID: keyID, ExpiresAt: &past}, expectedErr: ErrAPIKeyExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAccountRepo.ExpectedCalls = nil
+			mockAccountRepo.Calls = nil
+			if tt.storedKey != nil {
+				mockAccountRepo.On("FindAPIKeyByHash", utils.HashToken(tt.key)).Return(tt.storedKey, account, nil).Once()
+			} else {
+				mockAccountRepo.On("FindAPIKeyByHash", utils.HashToken(tt.key)).Return(nil, nil, nil).Maybe()
+			}
+			if tt.expectTouch {
+				mockAccountRepo.On("TouchAPIKey", keyID, "203.0.113.7", apiKeyTouchInterval).Return(nil).Once()
+			}
+
+			claims, err := svc.AuthenticateAPIKey(tt.key, client)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				mockAccountRepo.AssertNotCalled(t, "TouchAPIKey", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
 
-func (m *MockBlacklistRepository) BlacklistToken(token, userID, tokenType string, expiresAt time.Time) error {
-	args := m.Called(token, userID, tokenType, expiresAt)
-	return args.Error(0)
+			require.NoError(t, err)
+			assert.Equal(t, accountID, claims.UserID)
+			assert.Equal(t, TokenTypeAPIKey, claims.TokenType)
+			assert.Equal(t, "silom", claims.Branch)
+			assert.Equal(t, []string{policy.PermissionStockAdjust}, claims.Scopes)
+			assert.Equal(t, keyID.String(), claims.ID)
+
+			subject := SubjectFromClaims(claims)
+			assert.True(t, subject.ServiceAccount)
+			assert.Equal(t, claims.Scopes, subject.Permissions)
+			mockAccountRepo.AssertExpectations(t)
+		})
+	}
 }
 
-func (m *MockBlacklistRepository) IsTokenBlacklisted(token string) (bool, error) {
-	args := m.Called(token)
-	return args.Bool(0), args.Error(1)
+// oauthTestTokens creates an access and a refresh token of a staff member's session
+func oauthTestTokens(t *testing.T, svc *service, userID, sessionID uuid.UUID) (string, string) {
+	issuedAt := time.Now().Add(-time.Minute)
+	subject := tokenSubject{UserID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Branch: "silom", FamilyID: sessionID.String(), DeviceID: "device-1", AuthTime: issuedAt}
+
+	accessToken, err := svc.generateToken(subject, "access", uuid.NewString(), issuedAt, issuedAt.Add(15*time.Minute))
+	require.NoError(t, err)
+	refreshToken, err := svc.generateToken(subject, "refresh", uuid.NewString(), issuedAt, issuedAt.Add(24*time.Hour))
+	require.NoError(t, err)
+	return accessToken, refreshToken
 }
 
-// Test setup helper
-func setupTestService() (*service, *MockUserRepository, *MockBlacklistRepository) {
-	mockUserRepo := &MockUserRepository{}
-	mockBlacklistRepo := &MockBlacklistRepository{}
-	cfg := &config.Config{
-		JWTSecret: "test-secret-key",
+func TestIntrospectToken(t *testing.T) {
+	svc, _, mockBlacklistRepo := setupTestService()
+	svc.accounts = newAccountStateCache(time.Minute)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	accessToken, refreshToken := oauthTestTokens(t, svc, userID, sessionID)
+
+	dashboard := &Claims{UserID: uuid.New(), TokenType: TokenTypeAPIKey, Scopes: []string{policy.PermissionTokenIntrospect}}
+	sathornPrinter := &Claims{UserID: uuid.New(), TokenType: TokenTypeAPIKey, Branch: "sathorn", Scopes: []string{policy.PermissionTokenIntrospect}}
+
+	tests := []struct {
+		name        string
+		claims      *Claims
+		token       string
+		blacklisted bool
+		status      string
+		active      bool
+	}{
+		{name: "Active access token", claims: dashboard, token: accessToken, status: user.StatusActive, active: true},
+		{name: "Active refresh token", claims: dashboard, token: refreshToken, status: user.StatusActive, active: true},
+		{name: "User must change their PIN", claims: dashboard, token: accessToken, status: user.StatusPendingPinChange, active: true},
+		{name: "Suspended user", claims: dashboard, token: accessToken, status: user.StatusSuspended},
+		{name: "Revoked token", claims: dashboard, token: accessToken, blacklisted: true},
+		{name: "Malformed token", claims: dashboard, token: "not-a-token"},
+		{name: "Token of another branch", claims: sathornPrinter, token: accessToken, status: user.StatusActive},
 	}
-	
-	svc := &service{
-		userRepo:      mockUserRepo,
-		blacklistRepo: mockBlacklistRepo,
-		jwtSecret:     cfg.JWTSecret,
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBlacklistRepo.ExpectedCalls = nil
+			mockFamilyRepo.ExpectedCalls = nil
+			mockBlacklistRepo.On("IsTokenBlacklisted", tt.token).Return(tt.blacklisted, nil).Once()
+			mockFamilyRepo.On("IsFamilyRevoked", sessionID).Return(false, nil).Maybe()
+			svc.accounts.store(userID, user.AccountState{Status: tt.status})
+
+			introspection, err := svc.IntrospectToken(tt.claims, tt.token)
+			require.NoError(t, err)
+
+			if !tt.active {
+				assert.Equal(t, &TokenIntrospection{Active: false}, introspection)
+				return
+			}
+			assert.True(t, introspection.Active)
+			assert.Equal(t, "0812345678", introspection.Username)
+			assert.Equal(t, userID.String(), introspection.Subject)
+			assert.Equal(t, sessionID.String(), introspection.SessionID)
+			assert.Equal(t, user.RoleStaff, introspection.Role)
+			assert.Equal(t, "silom", introspection.Branch)
+			assert.Equal(t, "device-1", introspection.DeviceID)
+			assert.NotZero(t, introspection.ExpiresAt)
+			assert.NotZero(t, introspection.AuthTime)
+			assert.Equal(t, tt.status == user.StatusPendingPinChange, introspection.PinChangeRequired)
+			if tt.token == refreshToken {
+				assert.Equal(t, OAuthTokenTypeRefresh, introspection.TokenType)
+			} else {
+				assert.Equal(t, OAuthTokenTypeAccess, introspection.TokenType)
+			}
+		})
 	}
-	
-	return svc, mockUserRepo, mockBlacklistRepo
+
+	t.Run("Blacklist check fails", func(t *testing.T) {
+		mockBlacklistRepo.ExpectedCalls = nil
+		mockBlacklistRepo.On("IsTokenBlacklisted", accessToken).Return(false, errors.New("db error")).Once()
+
+		introspection, err := svc.IntrospectToken(dashboard, accessToken)
+		assert.Error(t, err)
+		assert.Nil(t, introspection)
+	})
 }
 
-func TestValidatePhoneNumber(t *testing.T) {
-	svc, _, _ := setupTestService()
+func TestRevokeToken(t *testing.T) {
+	svc, _, mockBlacklistRepo := setupTestService()
+	svc.accounts = newAccountStateCache(time.Minute)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	accessToken, refreshToken := oauthTestTokens(t, svc, userID, sessionID)
+	svc.accounts.store(userID, user.AccountState{Status: user.StatusActive})
+
+	printer := &Claims{UserID: uuid.New(), TokenType: TokenTypeAPIKey, Branch: "silom", Scopes: []string{policy.PermissionTokenRevoke}}
+	sathornPrinter := &Claims{UserID: uuid.New(), TokenType: TokenTypeAPIKey, Branch: "sathorn", Scopes: []string{policy.PermissionTokenRevoke}}
+
+	reset := func() {
+		mockBlacklistRepo.ExpectedCalls = nil
+		mockBlacklistRepo.Calls = nil
+		mockFamilyRepo.ExpectedCalls = nil
+		mockFamilyRepo.Calls = nil
+		mockEventRepo.Events = nil
+		mockFamilyRepo.On("IsFamilyRevoked", sessionID).Return(false, nil).Maybe()
+	}
+
+	t.Run("Access token is blacklisted", func(t *testing.T) {
+		reset()
+		mockBlacklistRepo.On("IsTokenBlacklisted", accessToken).Return(false, nil).Once()
+		mockBlacklistRepo.On("BlacklistToken", accessToken, userID.String(), "access", mock.AnythingOfType("time.Time")).Return(nil).Once()
+
+		require.NoError(t, svc.RevokeToken(printer, accessToken, ClientInfo{IPAddress: "203.0.113.7"}))
+
+		mockFamilyRepo.AssertNotCalled(t, "RevokeFamily", mock.Anything, mock.Anything)
+		require.Len(t, mockEventRepo.Events, 1)
+		event := mockEventRepo.Events[0]
+		assert.Equal(t, AuthEventTokenRevoked, event.EventType)
+		assert.Equal(t, userID, *event.UserID)
+		assert.Equal(t, printer.UserID, *event.ActorID)
+		assert.Equal(t, sessionID, *event.SessionID)
+		assert.Equal(t, OAuthTokenTypeAccess, event.Reason)
+		mockBlacklistRepo.AssertExpectations(t)
+	})
+
+	t.Run("Refresh token also ends its session", func(t *testing.T) {
+		reset()
+		mockBlacklistRepo.On("IsTokenBlacklisted", refreshToken).Return(false, nil).Once()
+		mockBlacklistRepo.On("BlacklistToken", refreshToken, userID.String(), "refresh", mock.AnythingOfType("time.Time")).Return(nil).Once()
+		mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonTokenRevoked).Return(nil).Once()
+
+		require.NoError(t, svc.RevokeToken(printer, refreshToken, ClientInfo{}))
+
+		require.Len(t, mockEventRepo.Events, 1)
+		assert.Equal(t, OAuthTokenTypeRefresh, mockEventRepo.Events[0].Reason)
+		mockBlacklistRepo.AssertExpectations(t)
+		mockFamilyRepo.AssertExpectations(t)
+	})
+
+	t.Run("Invalid tokens are ignored", func(t *testing.T) {
+		reset()
+		mockBlacklistRepo.On("IsTokenBlacklisted", accessToken).Return(true, nil).Once()
+		mockBlacklistRepo.On("IsTokenBlacklisted", "not-a-token").Return(false, nil).Once()
+
+		assert.NoError(t, svc.RevokeToken(printer, accessToken, ClientInfo{}))
+		assert.NoError(t, svc.RevokeToken(printer, "not-a-token", ClientInfo{}))
+
+		mockBlacklistRepo.AssertNotCalled(t, "BlacklistToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		assert.Empty(t, mockEventRepo.Events)
+	})
+
+	t.Run("Token of another branch", func(t *testing.T) {
+		reset()
+		mockBlacklistRepo.On("IsTokenBlacklisted", accessToken).Return(false, nil).Once()
+
+		assert.ErrorIs(t, svc.RevokeToken(sathornPrinter, accessToken, ClientInfo{}), ErrPermissionDenied)
+
+		mockBlacklistRepo.AssertNotCalled(t, "BlacklistToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		assert.Empty(t, mockEventRepo.Events)
+	})
+}
+
+func TestGenerateTokens_AdministeredAccounts(t *testing.T) {
+	svc, mockUserRepo, mockBlacklistRepo := setupTestService()
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockDeviceRepo := svc.deviceRepo.(*MockDeviceRepository)
+	mockAuthEventRepo := svc.authEventRepo.(*MockAuthEventRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	t.Run("Suspended and locked users cannot log in", func(t *testing.T) {
+		tests := []struct {
+			status         string
+			expectedErr    error
+			expectedReason string
+		}{
+			{status: user.StatusSuspended, expectedErr: ErrAccountSuspended, expectedReason: AuthReasonAccountSuspended},
+			{status: user.StatusLocked, expectedErr: ErrAccountLocked, expectedReason: AuthReasonAccountLocked},
+		}
+
+		for _, tt := range tests {
+			mockAuthEventRepo.Events = nil
+			u := &user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: tt.status}
+
+			tokens, err := svc.GenerateTokens(u, ClientInfo{})
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Nil(t, tokens)
+
+			require.Len(t, mockAuthEventRepo.Events, 1)
+			assert.Equal(t, AuthEventLoginFailed, mockAuthEventRepo.Events[0].EventType)
+			assert.Equal(t, tt.expectedReason, mockAuthEventRepo.Events[0].Reason)
+		}
+		mockFamilyRepo.AssertNotCalled(t, "CreateFamily", userID)
+	})
+
+	t.Run("Suspended users cannot refresh", func(t *testing.T) {
+		now := time.Now()
+		subject := tokenSubject{UserID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, FamilyID: familyID.String(), AuthTime: now}
+		refreshToken, _ := svc.generateToken(subject, "refresh", uuid.NewString(), now, now.Add(24*time.Hour))
+
+		mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+		mockUserRepo.On("FindAccountState", userID).Return(&user.AccountState{Status: user.StatusSuspended}, nil).Once()
+
+		tokens, _, err := svc.RefreshTokens(refreshToken, ClientInfo{})
+		assert.ErrorIs(t, err, ErrAccountSuspended)
+		assert.Nil(t, tokens)
+		mockBlacklistRepo.AssertNotCalled(t, "ConsumeRefreshToken", refreshToken, userID.String(), mock.Anything)
+
+		mockFamilyRepo.AssertExpectations(t)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("Tokens require a PIN change until the PIN is changed", func(t *testing.T) {
+		mockFamilyRepo.On("CreateFamily", userID).Return(familyID, nil).Once()
+		mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+		mockDeviceRepo.On("RecordDevice", mock.AnythingOfType("*auth.Device")).Return(nil).Maybe()
+
+		u := &user.User{ID: userID, PhoneNumber: "+66812345678", Role: user.RoleStaff, Status: user.StatusPendingPinChange}
+		tokens, err := svc.GenerateTokens(u, ClientInfo{})
+		require.NoError(t, err)
+		assert.True(t, tokens.PinChangeRequired)
+
+		accessClaims, err := svc.ParseToken(tokens.AccessToken)
+		require.NoError(t, err)
+		assert.True(t, accessClaims.PinChangeRequired)
+
+		// After the PIN change, the next refresh issues unrestricted tokens
+		mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+		mockBlacklistRepo.On("ConsumeRefreshToken", tokens.RefreshToken, userID.String(), mock.AnythingOfType("time.Time")).Return(&RefreshTokenUse{Consumed: true}, nil).Once()
+		mockSessionRepo.On("TouchSession", familyID, mock.AnythingOfType("string"), "").Return(nil).Once()
+		mockUserRepo.On("FindAccountState", userID).Return(&user.AccountState{Status: user.StatusActive}, nil).Once()
+
+		refreshed, _, err := svc.RefreshTokens(tokens.RefreshToken, ClientInfo{})
+		require.NoError(t, err)
+		assert.False(t, refreshed.PinChangeRequired)
+
+		accessClaims, err = svc.ParseToken(refreshed.AccessToken)
+		require.NoError(t, err)
+		assert.False(t, accessClaims.PinChangeRequired)
+
+		mockFamilyRepo.AssertExpectations(t)
+		mockSessionRepo.AssertExpectations(t)
+		mockBlacklistRepo.AssertExpectations(t)
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestChangePin(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockEventRepo := svc.eventRepo.(*MockSecurityEventRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	currentSessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	otherSessionID := uuid.MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	currentHash, _ := utils.HashPin("135790")
+	previousHash, _ := utils.HashPin("246802")
+	birthDate := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	testUser := &user.User{
+		ID:          userID,
+		PhoneNumber: "+66812345678",
+		PinHash:     currentHash,
+		Status:      user.StatusActive,
+		BirthDate:   &birthDate,
+	}
+	claims := &Claims{UserID: userID, PhoneNumber: "+66812345678", TokenType: "access", FamilyID: currentSessionID.String()}
+
+	// verifiedCurrentPin sets up the mocks for a user whose current PIN is checked
+	verifiedCurrentPin := func() {
+		mockUserRepo.On("FindByID", userID).Return(testUser, nil).Once()
+		mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+	}
 
 	tests := []struct {
-		name        string
-		phoneNumber string
-		expectError bool
-		errorMsg    string
+		name          string
+		currentPin    string
+		newPin        string
+		setupMocks    func()
+		expectedErr   error
+		expectedField string
+		errorMsg      string
 	}{
 		{
-			name:        "Valid Thai phone number",
-			phoneNumber: "0812345678",
-			expectError: false,
+			name:       "Changes the PIN and revokes the other sessions",
+			currentPin: "135790",
+			newPin:     "731950",
+			setupMocks: func() {
+				verifiedCurrentPin()
+				mockUserRepo.On("RecentPinHashes", userID, 3).Return([]string{previousHash}, nil).Once()
+				mockSessionRepo.On("ListActiveSessions", userID).
+					Return([]Session{{ID: currentSessionID, UserID: userID}, {ID: otherSessionID, UserID: userID}}, nil).Once()
+				mockFamilyRepo.On("RevokeFamily", otherSessionID, RevokedReasonPinChanged).Return(nil).Once()
+				mockUserRepo.On("UpdatePin", userID, mock.MatchedBy(func(pinHash string) bool {
+					return utils.CheckPin(pinHash, "731950") == nil
+				}), 3).Return(nil).Once()
+				mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+				mockEventRepo.On("RecordEvent", userID, SecurityEventPinChanged, "PIN changed; 1 other session(s) revoked").Return(nil).Once()
+			},
 		},
 		{
-			name:        "Valid Thai phone number with different prefix",
-			phoneNumber: "0987654321",
-			expectError: false,
+			name:          "Invalid new PIN format",
+			currentPin:    "135790",
+			newPin:        "12ab56",
+			setupMocks:    func() {},
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
 		},
 		{
-			name:        "Empty phone number",
-			phoneNumber: "",
-			expectError: true,
-			errorMsg:    "phone number is required",
+			name:       "Wrong current PIN counts as a failed attempt",
+			currentPin: "000001",
+			newPin:     "731950",
+			setupMocks: func() {
+				verifiedCurrentPin()
+				mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 1}, nil).Once()
+			},
+			expectedErr: ErrInvalidCredentials,
 		},
 		{
-			name:        "Phone number too short",
-			phoneNumber: "081234567",
-			expectError: true,
-			errorMsg:    "invalid phone number format: must be 10 digits starting with 0",
+			name:          "New PIN equal to the current PIN",
+			currentPin:    "135790",
+			newPin:        "135790",
+			setupMocks:    verifiedCurrentPin,
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
 		},
 		{
-			name:        "Phone number too long",
-			phoneNumber: "08123456789",
-			expectError: true,
-			errorMsg:    "invalid phone number format: must be 10 digits starting with 0",
+			name:          "Repeated digits",
+			currentPin:    "135790",
+			newPin:        "777777",
+			setupMocks:    verifiedCurrentPin,
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
 		},
 		{
-			name:        "Phone number not starting with 0",
-			phoneNumber: "1812345678",
-			expectError: true,
-			errorMsg:    "invalid phone number format: must be 10 digits starting with 0",
+			name:          "Descending run",
+			currentPin:    "135790",
+			newPin:        "987654",
+			setupMocks:    verifiedCurrentPin,
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
 		},
 		{
-			name:        "Phone number with non-digits",
-			phoneNumber: "081234567a",
-			expectError: true,
-			errorMsg:    "invalid phone number format: must be 10 digits starting with 0",
+			name:          "Birth year in the Buddhist Era",
+			currentPin:    "135790",
+			newPin:        "253317",
+			setupMocks:    verifiedCurrentPin,
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
 		},
 		{
-			name:        "Phone number with spaces",
-			phoneNumber: "081 234 5678",
-			expectError: true,
-			errorMsg:    "invalid phone number format: must be 10 digits starting with 0",
+			name:       "Recently used PIN",
+			currentPin: "135790",
+			newPin:     "246802",
+			setupMocks: func() {
+				verifiedCurrentPin()
+				mockUserRepo.On("RecentPinHashes", userID, 3).Return([]string{previousHash}, nil).Once()
+			},
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
+		},
+		{
+			name:       "PIN is left unchanged when sessions cannot be revoked",
+			currentPin: "135790",
+			newPin:     "731950",
+			setupMocks: func() {
+				verifiedCurrentPin()
+				mockUserRepo.On("RecentPinHashes", userID, 3).Return(nil, nil).Once()
+				mockSessionRepo.On("ListActiveSessions", userID).Return(nil, errors.New("db error")).Once()
+			},
+			errorMsg: "failed to list sessions",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := svc.ValidatePhoneNumber(tt.phoneNumber)
-			
-			if tt.expectError {
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+			mockSessionRepo.ExpectedCalls = nil
+			mockFamilyRepo.ExpectedCalls = nil
+			mockEventRepo.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			err := svc.ChangePin(claims, tt.currentPin, tt.newPin, ClientInfo{})
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+			case tt.errorMsg != "":
 				assert.Error(t, err)
 				assert.Equal(t, tt.errorMsg, err.Error())
-			} else {
+			default:
 				assert.NoError(t, err)
 			}
+
+			if tt.expectedField != "" {
+				var validationErr *ValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, tt.expectedField, validationErr.Field)
+			}
+
+			// Verify all expectations were met
+			mockUserRepo.AssertExpectations(t)
+			mockAttemptRepo.AssertExpectations(t)
+			mockSessionRepo.AssertExpectations(t)
+			mockFamilyRepo.AssertExpectations(t)
+			mockEventRepo.AssertExpectations(t)
 		})
 	}
 }
 
-func TestValidatePin(t *testing.T) {
-	svc, _, _ := setupTestService()
+func TestRequestPinReset(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockOTPRepo := svc.otpRepo.(*MockOTPRepository)
+	mockSender := svc.sms.(*MockSMSSender)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	testUser := &user.User{ID: userID, PhoneNumber: "+66812345678", Status: user.StatusActive}
 
 	tests := []struct {
 		name        string
-		pin         string
-		expectError bool
+		phoneNumber string
+		setupMocks  func()
+		expectedErr error
+		retryAfter  bool
 		errorMsg    string
 	}{
 		{
-			name:        "Valid 6-digit PIN",
-			pin:         "123456",
-			expectError: false,
-		},
-		{
-			name:        "Valid PIN with zeros",
-			pin:         "000000",
-			expectError: false,
-		},
-		{
-			name:        "Empty PIN",
-			pin:         "",
-			expectError: true,
-			errorMsg:    "PIN is required",
+			name:        "Sends a hashed, expiring code",
+			phoneNumber: "0812345678",
+			setupMocks: func() {
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(nil, nil).Once()
+				mockOTPRepo.On("SaveOTP", mock.MatchedBy(func(otp *OTP) bool {
+					return otp.UserID == userID &&
+						otp.Purpose == OTPPurposePinReset &&
+						len(otp.CodeHash) == 64 &&
+						otp.ExpiresAt.Sub(otp.CreatedAt) == 5*time.Minute
+				})).Return(nil).Once()
+				mockSender.On("Send", "+66812345678", mock.MatchedBy(func(message string) bool {
+					return strings.HasPrefix(message, "Your PIN reset code is ")
+				})).Return(nil).Once()
+			},
 		},
 		{
-			name:        "PIN too short",
-			pin:         "12345",
-			expectError: true,
-			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+			name:        "Unknown phone number succeeds without sending anything",
+			phoneNumber: "0898765432",
+			setupMocks: func() {
+				mockUserRepo.On("FindByPhoneNumber", "+66898765432").Return(nil, errors.New("user not found")).Once()
+			},
 		},
 		{
-			name:        "PIN too long",
-			pin:         "1234567",
-			expectError: true,
-			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+			name:        "Invalid phone number",
+			phoneNumber: "12345",
+			setupMocks:  func() {},
+			expectedErr: ErrValidation,
 		},
 		{
-			name:        "PIN with letters",
-			pin:         "12345a",
-			expectError: true,
-			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+			name:        "Resend too soon",
+			phoneNumber: "0812345678",
+			setupMocks: func() {
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).
+					Return(&OTP{ID: uuid.New(), UserID: userID, CreatedAt: time.Now().Add(-10 * time.Second), ExpiresAt: time.Now().Add(time.Minute)}, nil).Once()
+			},
+			retryAfter: true,
 		},
 		{
-			name:        "PIN with special characters",
-			pin:         "12345!",
-			expectError: true,
-			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+			name:        "Resend is allowed once the previous code was used",
+			phoneNumber: "0812345678",
+			setupMocks: func() {
+				consumedAt := time.Now()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).
+					Return(&OTP{ID: uuid.New(), UserID: userID, CreatedAt: time.Now().Add(-10 * time.Second), ConsumedAt: &consumedAt}, nil).Once()
+				mockOTPRepo.On("SaveOTP", mock.AnythingOfType("*auth.OTP")).Return(nil).Once()
+				mockSender.On("Send", "+66812345678", mock.AnythingOfType("string")).Return(nil).Once()
+			},
 		},
 		{
-			name:        "PIN with spaces",
-			pin:         "123 456",
-			expectError: true,
-			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+			name:        "SMS delivery failure",
+			phoneNumber: "0812345678",
+			setupMocks: func() {
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(nil, nil).Once()
+				mockOTPRepo.On("SaveOTP", mock.AnythingOfType("*auth.OTP")).Return(nil).Once()
+				mockSender.On("Send", "+66812345678", mock.AnythingOfType("string")).Return(errors.New("gateway down")).Once()
+			},
+			errorMsg: "failed to send verification code",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := svc.ValidatePin(tt.pin)
-			
-			if tt.expectError {
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockOTPRepo.ExpectedCalls = nil
+			mockSender.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			err := svc.RequestPinReset(tt.phoneNumber, ClientInfo{IPAddress: "192.0.2.1"})
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+			case tt.retryAfter:
+				var attemptsErr *TooManyAttemptsError
+				require.ErrorAs(t, err, &attemptsErr)
+				assert.InDelta(t, 50*time.Second, attemptsErr.RetryAfter, float64(5*time.Second))
+			case tt.errorMsg != "":
 				assert.Error(t, err)
 				assert.Equal(t, tt.errorMsg, err.Error())
-			} else {
+			default:
 				assert.NoError(t, err)
 			}
+
+			// Verify all expectations were met
+			mockUserRepo.AssertExpectations(t)
+			mockOTPRepo.AssertExpectations(t)
+			mockSender.AssertExpectations(t)
 		})
 	}
 }
 
-func TestAuthenticateUser(t *testing.T) {
+func TestRequestPinReset_StoresOnlyTheHashOfTheSentCode(t *testing.T) {
 	svc, mockUserRepo, _ := setupTestService()
+	mockOTPRepo := svc.otpRepo.(*MockOTPRepository)
+	mockSender := svc.sms.(*MockSMSSender)
 
-	// Create a test user with hashed PIN
-	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
-	hashedPin, _ := utils.HashPin("123456")
-	testUser := &user.User{
-		ID:          testUserID,
-		PhoneNumber: "0812345678",
-		PinHash:     hashedPin,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Status: user.StatusActive}, nil).Once()
+	mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(nil, nil).Once()
+
+	var saved *OTP
+	var message string
+	mockOTPRepo.On("SaveOTP", mock.AnythingOfType("*auth.OTP")).Run(func(args mock.Arguments) {
+		saved = args.Get(0).(*OTP)
+	}).Return(nil).Once()
+	mockSender.On("Send", "+66812345678", mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+		message = args.String(1)
+	}).Return(nil).Once()
+
+	require.NoError(t, svc.RequestPinReset("0812345678", ClientInfo{}))
+
+	code := regexp.MustCompile(`\b[0-9]{6}\b`).FindString(message)
+	require.NotEmpty(t, code, message)
+	require.NotNil(t, saved)
+	assert.Equal(t, svc.hashOTP(userID, OTPPurposePinReset, code), saved.CodeHash)
+	assert.NotContains(t, saved.CodeHash, code)
+	assert.Contains(t, message, "expires in 5 minutes")
+}
+
+func TestConfirmPinReset(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockOTPRepo := svc.otpRepo.(*MockOTPRepository)
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockEventRepo := svc.eventRepo.(*MockSecurityEventRepository)
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	sessionID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	otpID := uuid.MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	currentHash, _ := utils.HashPin("135790")
+	testUser := &user.User{ID: userID, PhoneNumber: "+66812345678", PinHash: currentHash, Status: user.StatusActive}
+
+	// activeOTP returns the stored code "482913" with the given number of attempts
+	activeOTP := func(attempts int) *OTP {
+		return &OTP{
+			ID:        otpID,
+			UserID:    userID,
+			Purpose:   OTPPurposePinReset,
+			CodeHash:  svc.hashOTP(userID, OTPPurposePinReset, "482913"),
+			Attempts:  attempts,
+			ExpiresAt: time.Now().Add(4 * time.Minute),
+			CreatedAt: time.Now().Add(-time.Minute),
+		}
+	}
+
+	// codeChecked sets up the mocks up to and including a counted attempt
+	codeChecked := func(otp *OTP) {
+		mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+		mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(otp, nil).Once()
+		mockOTPRepo.On("IncrementOTPAttempts", otpID).Return(otp.Attempts+1, nil).Once()
 	}
 
 	tests := []struct {
-		name         string
-		phoneNumber  string
-		pin          string
-		setupMocks   func()
-		expectError  bool
-		errorMsg     string
-		expectedUser *user.User
+		name          string
+		code          string
+		newPin        string
+		setupMocks    func()
+		expectedErr   error
+		expectedField string
 	}{
 		{
-			name:        "Successful authentication",
-			phoneNumber: "0812345678",
-			pin:         "123456",
+			name:   "Resets the PIN, unlocks the account and revokes every session",
+			code:   "482913",
+			newPin: "731950",
+			setupMocks: func() {
+				codeChecked(activeOTP(0))
+				mockUserRepo.On("RecentPinHashes", userID, 3).Return(nil, nil).Once()
+				mockOTPRepo.On("ConsumeOTP", otpID).Return(true, nil).Once()
+				mockSessionRepo.On("ListActiveSessions", userID).Return([]Session{{ID: sessionID, UserID: userID}}, nil).Once()
+				mockFamilyRepo.On("RevokeFamily", sessionID, RevokedReasonPinReset).Return(nil).Once()
+				mockUserRepo.On("UpdatePin", userID, mock.MatchedBy(func(pinHash string) bool {
+					return utils.CheckPin(pinHash, "731950") == nil
+				}), 3).Return(nil).Once()
+				mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+				mockEventRepo.On("RecordEvent", userID, SecurityEventPinReset, "PIN reset by SMS code from 192.0.2.1; 1 session(s) revoked").Return(nil).Once()
+			},
+		},
+		{
+			name:   "Wrong code",
+			code:   "482914",
+			newPin: "731950",
 			setupMocks: func() {
-				mockUserRepo.On("FindByPhoneNumber", "0812345678").Return(testUser, nil).Once()
-				mockUserRepo.On("UpdateLastLogin", testUserID).Return(nil).Once()
+				codeChecked(activeOTP(1))
 			},
-			expectError:  false,
-			expectedUser: testUser,
+			expectedErr: ErrInvalidOTP,
 		},
 		{
-			name:        "Invalid phone number format",
-			phoneNumber: "invalid",
-			pin:         "123456",
-			setupMocks:  func() {},
-			expectError: true,
-			errorMsg:    "invalid phone number format: must be 10 digits starting with 0",
+			name:   "Attempt limit reached by this attempt",
+			code:   "482913",
+			newPin: "731950",
+			setupMocks: func() {
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(activeOTP(2), nil).Once()
+				// A concurrent request counted an attempt in the meantime
+				mockOTPRepo.On("IncrementOTPAttempts", otpID).Return(4, nil).Once()
+			},
+			expectedErr: ErrOTPAttemptsExceeded,
 		},
 		{
-			name:        "Invalid PIN format",
-			phoneNumber: "0812345678",
-			pin:         "123",
-			setupMocks:  func() {},
-			expectError: true,
-			errorMsg:    "invalid PIN format: must be exactly 6 digits",
+			name:   "Attempt limit already reached",
+			code:   "482913",
+			newPin: "731950",
+			setupMocks: func() {
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(activeOTP(3), nil).Once()
+			},
+			expectedErr: ErrOTPAttemptsExceeded,
 		},
 		{
-			name:        "User not found",
-			phoneNumber: "0812345678",
-			pin:         "123456",
+			name:   "Expired code",
+			code:   "482913",
+			newPin: "731950",
 			setupMocks: func() {
-				mockUserRepo.On("FindByPhoneNumber", "0812345678").Return(nil, errors.New("user not found")).Once()
+				otp := activeOTP(0)
+				otp.ExpiresAt = time.Now().Add(-time.Second)
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(otp, nil).Once()
 			},
-			expectError: true,
-			errorMsg:    "invalid credentials",
+			expectedErr: ErrInvalidOTP,
 		},
 		{
-			name:        "Wrong PIN",
-			phoneNumber: "0812345678",
-			pin:         "654321",
+			name:   "Code already used",
+			code:   "482913",
+			newPin: "731950",
 			setupMocks: func() {
-				mockUserRepo.On("FindByPhoneNumber", "0812345678").Return(testUser, nil).Once()
+				consumedAt := time.Now()
+				otp := activeOTP(1)
+				otp.ConsumedAt = &consumedAt
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(otp, nil).Once()
 			},
-			expectError: true,
-			errorMsg:    "invalid credentials",
+			expectedErr: ErrInvalidOTP,
 		},
 		{
-			name:        "UpdateLastLogin fails but authentication succeeds",
-			phoneNumber: "0812345678",
-			pin:         "123456",
+			name:   "No code was requested",
+			code:   "482913",
+			newPin: "731950",
 			setupMocks: func() {
-				mockUserRepo.On("FindByPhoneNumber", "0812345678").Return(testUser, nil).Once()
-				mockUserRepo.On("UpdateLastLogin", testUserID).Return(errors.New("db error")).Once()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposePinReset).Return(nil, nil).Once()
 			},
-			expectError:  false,
-			expectedUser: testUser,
+			expectedErr: ErrInvalidOTP,
+		},
+		{
+			name:   "Code consumed by a concurrent request",
+			code:   "482913",
+			newPin: "731950",
+			setupMocks: func() {
+				codeChecked(activeOTP(0))
+				mockUserRepo.On("RecentPinHashes", userID, 3).Return(nil, nil).Once()
+				mockOTPRepo.On("ConsumeOTP", otpID).Return(false, nil).Once()
+			},
+			expectedErr: ErrInvalidOTP,
+		},
+		{
+			name:          "Weak new PIN leaves the code usable",
+			code:          "482913",
+			newPin:        "111111",
+			setupMocks:    func() { codeChecked(activeOTP(0)) },
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
+		},
+		{
+			name:          "New PIN equal to the current PIN",
+			code:          "482913",
+			newPin:        "135790",
+			setupMocks:    func() { codeChecked(activeOTP(0)) },
+			expectedErr:   ErrValidation,
+			expectedField: "new_pin",
+		},
+		{
+			name:          "Invalid code format",
+			code:          "48291",
+			newPin:        "731950",
+			setupMocks:    func() {},
+			expectedErr:   ErrValidation,
+			expectedField: "code",
 		},
 	}
 
@@ -293,152 +4237,290 @@ func TestAuthenticateUser(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Reset mocks
 			mockUserRepo.ExpectedCalls = nil
-			
+			mockOTPRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+			mockSessionRepo.ExpectedCalls = nil
+			mockFamilyRepo.ExpectedCalls = nil
+			mockEventRepo.ExpectedCalls = nil
+
 			// Setup mocks for this test
 			tt.setupMocks()
-			
-			// Execute test
-			result, err := svc.AuthenticateUser(tt.phoneNumber, tt.pin)
-			
-			if tt.expectError {
-				assert.Error(t, err)
-				assert.Equal(t, tt.errorMsg, err.Error())
-				assert.Nil(t, result)
+
+			err := svc.ConfirmPinReset("0812345678", tt.code, tt.newPin, ClientInfo{IPAddress: "192.0.2.1"})
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
 			} else {
 				assert.NoError(t, err)
-				assert.NotNil(t, result)
-				assert.Equal(t, tt.expectedUser.ID, result.ID)
-				assert.Equal(t, tt.expectedUser.PhoneNumber, result.PhoneNumber)
 			}
-			
+
+			if tt.expectedField != "" {
+				var validationErr *ValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, tt.expectedField, validationErr.Field)
+			}
+
 			// Verify all expectations were met
 			mockUserRepo.AssertExpectations(t)
+			mockOTPRepo.AssertExpectations(t)
+			mockAttemptRepo.AssertExpectations(t)
+			mockSessionRepo.AssertExpectations(t)
+			mockFamilyRepo.AssertExpectations(t)
+			mockEventRepo.AssertExpectations(t)
 		})
 	}
 }
 
-func TestGenerateAccessToken(t *testing.T) {
-	svc, _, _ := setupTestService()
+func TestRequestLoginOTP(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockOTPRepo := svc.otpRepo.(*MockOTPRepository)
+	mockSender := svc.sms.(*MockSMSSender)
 
-	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	client := ClientInfo{IPAddress: "192.0.2.1"}
+
+	// notThrottled sets up the mocks for a phone number and client IP without failed attempts
+	notThrottled := func() {
+		mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+		mockAttemptRepo.On("GetThrottle", "ip:192.0.2.1").Return(nil, nil).Once()
+	}
 
 	tests := []struct {
-		name        string
-		userID      uuid.UUID
-		phoneNumber string
-		expectError bool
+		name         string
+		shopEnabled  bool
+		userOTPLogin bool
+		setupMocks   func()
+		expectedErr  error
 	}{
 		{
-			name:        "Valid token generation",
-			userID:      testUserID,
-			phoneNumber: "0812345678",
-			expectError: false,
+			name:         "Sends a login code to a user with SMS code login",
+			userOTPLogin: true,
+			setupMocks: func() {
+				notThrottled()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposeLogin).Return(nil, nil).Once()
+				mockOTPRepo.On("SaveOTP", mock.MatchedBy(func(otp *OTP) bool {
+					return otp.UserID == userID && otp.Purpose == OTPPurposeLogin
+				})).Return(nil).Once()
+				mockSender.On("Send", "+66812345678", mock.MatchedBy(func(message string) bool {
+					return strings.HasPrefix(message, "Your login code is ")
+				})).Return(nil).Once()
+			},
 		},
 		{
-			name:        "Empty userID",
-			userID:      uuid.Nil,
-			phoneNumber: "0812345678",
-			expectError: false, // JWT library handles empty values
+			name:        "Sends a login code when the shop allows SMS code login for everyone",
+			shopEnabled: true,
+			setupMocks: func() {
+				notThrottled()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposeLogin).Return(nil, nil).Once()
+				mockOTPRepo.On("SaveOTP", mock.AnythingOfType("*auth.OTP")).Return(nil).Once()
+				mockSender.On("Send", "+66812345678", mock.AnythingOfType("string")).Return(nil).Once()
+			},
+		},
+		{
+			name:       "User without SMS code login gets nothing and no error",
+			setupMocks: notThrottled,
+		},
+		{
+			name:         "Locked account gets no code",
+			userOTPLogin: true,
+			setupMocks: func() {
+				lockedUntil := time.Now().Add(10 * time.Minute)
+				mockAttemptRepo.On("GetThrottle", "phone:+66812345678").
+					Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: 5, LockedUntil: &lockedUntil}, nil).Once()
+			},
+			expectedErr: &AccountLockedError{},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			token, err := svc.GenerateAccessToken(tt.userID, tt.phoneNumber)
-			
-			if tt.expectError {
-				assert.Error(t, err)
-				assert.Empty(t, token)
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+			mockOTPRepo.ExpectedCalls = nil
+			mockSender.ExpectedCalls = nil
+
+			svc.otp.loginEnabled = tt.shopEnabled
+			mockUserRepo.On("FindByPhoneNumber", "+66812345678").
+				Return(&user.User{ID: userID, PhoneNumber: "+66812345678", OTPLogin: tt.userOTPLogin, Status: user.StatusActive}, nil).Maybe()
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			err := svc.RequestLoginOTP("0812345678", client)
+
+			if tt.expectedErr != nil {
+				assert.IsType(t, tt.expectedErr, err)
 			} else {
 				assert.NoError(t, err)
-				assert.NotEmpty(t, token)
-				
-				// Verify token can be parsed and has correct claims
-				claims, parseErr := svc.ParseToken(token)
-				assert.NoError(t, parseErr)
-				assert.Equal(t, tt.userID, claims.UserID)
-				assert.Equal(t, tt.phoneNumber, claims.PhoneNumber)
-				assert.Equal(t, "access", claims.TokenType)
-				assert.Equal(t, "tt-stock-api", claims.Issuer)
-				
-				// Verify expiration is approximately 15 minutes from now
-				expectedExpiry := time.Now().Add(15 * time.Minute)
-				assert.WithinDuration(t, expectedExpiry, claims.ExpiresAt.Time, time.Minute)
 			}
+
+			// Verify all expectations were met
+			mockAttemptRepo.AssertExpectations(t)
+			mockOTPRepo.AssertExpectations(t)
+			mockSender.AssertExpectations(t)
 		})
 	}
 }
 
-func TestGenerateRefreshToken(t *testing.T) {
-	svc, _, _ := setupTestService()
+func TestAuthenticateUserWithOTP(t *testing.T) {
+	svc, mockUserRepo, _ := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockOTPRepo := svc.otpRepo.(*MockOTPRepository)
 
-	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	otpID := uuid.MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	testUser := &user.User{ID: userID, PhoneNumber: "+66812345678", OTPLogin: true, Status: user.StatusActive}
+	client := ClientInfo{IPAddress: "192.0.2.1"}
+
+	loginOTP := &OTP{
+		ID:        otpID,
+		UserID:    userID,
+		Purpose:   OTPPurposeLogin,
+		CodeHash:  svc.hashOTP(userID, OTPPurposeLogin, "482913"),
+		ExpiresAt: time.Now().Add(4 * time.Minute),
+		CreatedAt: time.Now().Add(-time.Minute),
+	}
+
+	// notThrottled sets up the mocks for a phone number and client IP without failed attempts
+	notThrottled := func() {
+		mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+		mockAttemptRepo.On("GetThrottle", "ip:192.0.2.1").Return(nil, nil).Once()
+	}
+
+	// failureRecorded sets up the mocks for a failed attempt that is the given failure in a row
+	failureRecorded := func(failedCount int) {
+		mockAttemptRepo.On("RecordFailure", "ip:192.0.2.1", mock.AnythingOfType("time.Time")).
+			Return(&LoginThrottle{Key: "ip:192.0.2.1", FailedCount: 1}, nil).Once()
+		mockAttemptRepo.On("RecordFailure", "phone:+66812345678", mock.AnythingOfType("time.Time")).
+			Return(&LoginThrottle{Key: "phone:+66812345678", FailedCount: failedCount}, nil).Once()
+	}
 
 	tests := []struct {
 		name        string
-		userID      uuid.UUID
-		phoneNumber string
-		expectError bool
+		code        string
+		setupMocks  func()
+		expectedErr error
+		expectUser  bool
 	}{
 		{
-			name:        "Valid refresh token generation",
-			userID:      testUserID,
-			phoneNumber: "0812345678",
-			expectError: false,
+			name: "Valid code logs the user in",
+			code: "482913",
+			setupMocks: func() {
+				notThrottled()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposeLogin).Return(loginOTP, nil).Once()
+				mockOTPRepo.On("IncrementOTPAttempts", otpID).Return(1, nil).Once()
+				mockOTPRepo.On("ConsumeOTP", otpID).Return(true, nil).Once()
+				mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
+				mockUserRepo.On("UpdateLastLogin", userID).Return(nil).Once()
+			},
+			expectUser: true,
+		},
+		{
+			name: "Wrong code counts as a failed login",
+			code: "482914",
+			setupMocks: func() {
+				notThrottled()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposeLogin).Return(loginOTP, nil).Once()
+				mockOTPRepo.On("IncrementOTPAttempts", otpID).Return(1, nil).Once()
+				failureRecorded(1)
+			},
+			expectedErr: ErrInvalidOTP,
+		},
+		{
+			name: "Wrong code that reaches the login limit locks the account",
+			code: "482914",
+			setupMocks: func() {
+				notThrottled()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposeLogin).Return(loginOTP, nil).Once()
+				mockOTPRepo.On("IncrementOTPAttempts", otpID).Return(2, nil).Once()
+				failureRecorded(5)
+				mockAttemptRepo.On("LockUntil", "phone:+66812345678", mock.AnythingOfType("time.Time")).Return(nil).Once()
+			},
+			expectedErr: &AccountLockedError{},
+		},
+		{
+			name: "User without SMS code login",
+			code: "482913",
+			setupMocks: func() {
+				notThrottled()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").
+					Return(&user.User{ID: userID, PhoneNumber: "+66812345678", Status: user.StatusActive}, nil).Once()
+				failureRecorded(1)
+			},
+			expectedErr: ErrInvalidOTP,
+		},
+		{
+			name: "Code attempt limit reached",
+			code: "482913",
+			setupMocks: func() {
+				notThrottled()
+				exhausted := *loginOTP
+				exhausted.Attempts = 3
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				mockOTPRepo.On("FindOTP", userID, OTPPurposeLogin).Return(&exhausted, nil).Once()
+			},
+			expectedErr: ErrOTPAttemptsExceeded,
+		},
+		{
+			name: "A PIN reset code cannot be used to log in",
+			code: "482913",
+			setupMocks: func() {
+				notThrottled()
+				mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+				resetOTP := *loginOTP
+				resetOTP.CodeHash = svc.hashOTP(userID, OTPPurposePinReset, "482913")
+				mockOTPRepo.On("FindOTP", userID, OTPPurposeLogin).Return(&resetOTP, nil).Once()
+				mockOTPRepo.On("IncrementOTPAttempts", otpID).Return(1, nil).Once()
+				failureRecorded(1)
+			},
+			expectedErr: ErrInvalidOTP,
+		},
+		{
+			name:        "Invalid code format",
+			code:        "abc",
+			setupMocks:  func() {},
+			expectedErr: ErrValidation,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			token, err := svc.GenerateRefreshToken(tt.userID, tt.phoneNumber)
-			
-			if tt.expectError {
-				assert.Error(t, err)
-				assert.Empty(t, token)
-			} else {
+			// Reset mocks
+			mockUserRepo.ExpectedCalls = nil
+			mockAttemptRepo.ExpectedCalls = nil
+			mockOTPRepo.ExpectedCalls = nil
+
+			// Setup mocks for this test
+			tt.setupMocks()
+
+			foundUser, err := svc.AuthenticateUserWithOTP("0812345678", tt.code, client)
+
+			switch expected := tt.expectedErr.(type) {
+			case nil:
 				assert.NoError(t, err)
-				assert.NotEmpty(t, token)
-				
-				// Verify token can be parsed and has correct claims
-				claims, parseErr := svc.ParseToken(token)
-				assert.NoError(t, parseErr)
-				assert.Equal(t, tt.userID, claims.UserID)
-				assert.Equal(t, tt.phoneNumber, claims.PhoneNumber)
-				assert.Equal(t, "refresh", claims.TokenType)
-				assert.Equal(t, "tt-stock-api", claims.Issuer)
-				
-				// Verify expiration is approximately 24 hours from now
-				expectedExpiry := time.Now().Add(24 * time.Hour)
-				assert.WithinDuration(t, expectedExpiry, claims.ExpiresAt.Time, time.Minute)
+			case *AccountLockedError:
+				assert.IsType(t, expected, err)
+			default:
+				assert.ErrorIs(t, err, expected)
 			}
-		})
-	}
-}
 
-func TestGenerateTokens(t *testing.T) {
-	svc, _, _ := setupTestService()
+			if tt.expectUser {
+				assert.Equal(t, testUser, foundUser)
+			} else {
+				assert.Nil(t, foundUser)
+			}
 
-	t.Run("Generate both tokens successfully", func(t *testing.T) {
-		userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
-		phoneNumber := "0812345678"
-		
-		tokenPair, err := svc.GenerateTokens(userID, phoneNumber)
-		
-		assert.NoError(t, err)
-		assert.NotNil(t, tokenPair)
-		assert.NotEmpty(t, tokenPair.AccessToken)
-		assert.NotEmpty(t, tokenPair.RefreshToken)
-		assert.Equal(t, int64(15*60), tokenPair.ExpiresIn) // 15 minutes in seconds
-		
-		// Verify both tokens are valid and have correct types
-		accessClaims, err := svc.ParseToken(tokenPair.AccessToken)
-		assert.NoError(t, err)
-		assert.Equal(t, "access", accessClaims.TokenType)
-		
-		refreshClaims, err := svc.ParseToken(tokenPair.RefreshToken)
-		assert.NoError(t, err)
-		assert.Equal(t, "refresh", refreshClaims.TokenType)
-	})
+			// Verify all expectations were met
+			mockUserRepo.AssertExpectations(t)
+			mockAttemptRepo.AssertExpectations(t)
+			mockOTPRepo.AssertExpectations(t)
+		})
+	}
 }
 
 func TestParseToken(t *testing.T) {
@@ -451,7 +4533,7 @@ func TestParseToken(t *testing.T) {
 	// Create an expired token
 	expiredClaims := &Claims{
 		UserID:      testUserID,
-		PhoneNumber: "0812345678",
+		PhoneNumber: "+66812345678",
 		TokenType:   "access",
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)), // Expired 1 hour ago
@@ -479,7 +4561,7 @@ func TestParseToken(t *testing.T) {
 			name:        "Empty token",
 			token:       "",
 			expectError: true,
-			errorMsg:    "token is required",
+			errorMsg:    "invalid token",
 		},
 		{
 			name:        "Invalid token format",
@@ -497,7 +4579,7 @@ func TestParseToken(t *testing.T) {
 			name:        "Expired token",
 			token:       expiredTokenString,
 			expectError: true,
-			errorMsg:    "invalid token",
+			errorMsg:    "token has expired",
 		},
 	}
 
@@ -521,11 +4603,13 @@ func TestParseToken(t *testing.T) {
 }
 
 func TestValidateToken(t *testing.T) {
-	svc, _, mockBlacklistRepo := setupTestService()
+	svc, mockUserRepo, mockBlacklistRepo := setupTestService()
 
 	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
 	// Generate a valid token for testing
 	validToken, _ := svc.GenerateAccessToken(testUserID, "0812345678")
+	earlierLogoutAll := time.Now().Add(-time.Hour)
+	laterLogoutAll := time.Now().Add(time.Second)
 
 	tests := []struct {
 		name        string
@@ -539,9 +4623,39 @@ func TestValidateToken(t *testing.T) {
 			token: validToken,
 			setupMocks: func() {
 				mockBlacklistRepo.On("IsTokenBlacklisted", validToken).Return(false, nil).Once()
+				mockUserRepo.On("FindAccountState", testUserID).Return(&user.AccountState{Status: user.StatusActive}, nil).Once()
+			},
+			expectError: false,
+		},
+		{
+			name:  "Token issued after the user logged out everywhere",
+			token: validToken,
+			setupMocks: func() {
+				mockBlacklistRepo.On("IsTokenBlacklisted", validToken).Return(false, nil).Once()
+				mockUserRepo.On("FindAccountState", testUserID).Return(&user.AccountState{Status: user.StatusActive, TokensValidAfter: &earlierLogoutAll}, nil).Once()
 			},
 			expectError: false,
 		},
+		{
+			name:  "Token issued before the user logged out everywhere",
+			token: validToken,
+			setupMocks: func() {
+				mockBlacklistRepo.On("IsTokenBlacklisted", validToken).Return(false, nil).Once()
+				mockUserRepo.On("FindAccountState", testUserID).Return(&user.AccountState{Status: user.StatusActive, TokensValidAfter: &laterLogoutAll}, nil).Once()
+			},
+			expectError: true,
+			errorMsg:    "token has been revoked",
+		},
+		{
+			name:  "Token of a deleted user",
+			token: validToken,
+			setupMocks: func() {
+				mockBlacklistRepo.On("IsTokenBlacklisted", validToken).Return(false, nil).Once()
+				mockUserRepo.On("FindAccountState", testUserID).Return(nil, fmt.Errorf("user with ID %s %w", testUserID, user.ErrNotFound)).Once()
+			},
+			expectError: true,
+			errorMsg:    "invalid token",
+		},
 		{
 			name:  "Blacklisted token",
 			token: validToken,
@@ -549,7 +4663,7 @@ func TestValidateToken(t *testing.T) {
 				mockBlacklistRepo.On("IsTokenBlacklisted", validToken).Return(true, nil).Once()
 			},
 			expectError: true,
-			errorMsg:    "token has been invalidated",
+			errorMsg:    "token has been revoked",
 		},
 		{
 			name:  "Blacklist check fails",
@@ -575,6 +4689,7 @@ func TestValidateToken(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Reset mocks
 			mockBlacklistRepo.ExpectedCalls = nil
+			mockUserRepo.ExpectedCalls = nil
 			
 			// Setup mocks for this test
 			tt.setupMocks()
@@ -594,6 +4709,7 @@ func TestValidateToken(t *testing.T) {
 			
 			// Verify all expectations were met
 			mockBlacklistRepo.AssertExpectations(t)
+			mockUserRepo.AssertExpectations(t)
 		})
 	}
 }
@@ -744,36 +4860,47 @@ func TestIsTokenBlacklisted(t *testing.T) {
 // Integration test for the complete authentication flow
 func TestAuthenticationFlow_Integration(t *testing.T) {
 	svc, mockUserRepo, mockBlacklistRepo := setupTestService()
+	mockAttemptRepo := svc.attemptRepo.(*MockLoginAttemptRepository)
+	mockFamilyRepo := svc.familyRepo.(*MockTokenFamilyRepository)
+	mockSessionRepo := svc.sessionRepo.(*MockSessionRepository)
+	familyID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
 
 	testUserID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
 	// Create a test user with hashed PIN
 	hashedPin, _ := utils.HashPin("123456")
 	testUser := &user.User{
 		ID:          testUserID,
-		PhoneNumber: "0812345678",
+		PhoneNumber: "+66812345678",
 		PinHash:     hashedPin,
+		Status:      user.StatusActive,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
 	t.Run("Complete authentication and token lifecycle", func(t *testing.T) {
 		// Setup mocks for authentication
-		mockUserRepo.On("FindByPhoneNumber", "0812345678").Return(testUser, nil).Once()
+		mockAttemptRepo.On("GetThrottle", "phone:+66812345678").Return(nil, nil).Once()
+		mockUserRepo.On("FindByPhoneNumber", "+66812345678").Return(testUser, nil).Once()
+		mockAttemptRepo.On("Reset", "phone:+66812345678").Return(nil).Once()
 		mockUserRepo.On("UpdateLastLogin", testUserID).Return(nil).Once()
 
 		// 1. Authenticate user
-		authenticatedUser, err := svc.AuthenticateUser("0812345678", "123456")
+		authenticatedUser, err := svc.AuthenticateUser("0812345678", "123456", ClientInfo{})
 		assert.NoError(t, err)
 		assert.Equal(t, testUser.ID, authenticatedUser.ID)
 
 		// 2. Generate tokens
-		tokenPair, err := svc.GenerateTokens(authenticatedUser.ID, authenticatedUser.PhoneNumber)
+		mockFamilyRepo.On("CreateFamily", testUserID).Return(familyID, nil).Once()
+		mockSessionRepo.On("CreateSession", mock.AnythingOfType("*auth.Session")).Return(nil).Once()
+		tokenPair, err := svc.GenerateTokens(authenticatedUser, ClientInfo{})
 		assert.NoError(t, err)
 		assert.NotEmpty(t, tokenPair.AccessToken)
 		assert.NotEmpty(t, tokenPair.RefreshToken)
 
 		// 3. Validate access token (not blacklisted)
 		mockBlacklistRepo.On("IsTokenBlacklisted", tokenPair.AccessToken).Return(false, nil).Once()
+		mockFamilyRepo.On("IsFamilyRevoked", familyID).Return(false, nil).Once()
+		mockUserRepo.On("FindAccountState", testUserID).Return(&user.AccountState{Status: user.StatusActive}, nil).Once()
 		claims, err := svc.ValidateToken(tokenPair.AccessToken)
 		assert.NoError(t, err)
 		assert.Equal(t, authenticatedUser.ID, claims.UserID)
@@ -787,11 +4914,11 @@ func TestAuthenticationFlow_Integration(t *testing.T) {
 		// 5. Try to validate blacklisted token
 		mockBlacklistRepo.On("IsTokenBlacklisted", tokenPair.AccessToken).Return(true, nil).Once()
 		_, err = svc.ValidateToken(tokenPair.AccessToken)
-		assert.Error(t, err)
-		assert.Equal(t, "token has been invalidated", err.Error())
+		assert.ErrorIs(t, err, ErrTokenRevoked)
 
 		// Verify all expectations were met
 		mockUserRepo.AssertExpectations(t)
 		mockBlacklistRepo.AssertExpectations(t)
+		mockFamilyRepo.AssertExpectations(t)
 	})
 }
\ No newline at end of file
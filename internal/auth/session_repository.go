@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// Token family revocation reasons for sessions ended on purpose
+const (
+	RevokedReasonSessionRevoked = "session_revoked" // Ended by the user
+	RevokedReasonPinChanged     = "pin_changed"     // Ended because the user changed their PIN
+	RevokedReasonPinReset       = "pin_reset"       // Ended because the user reset a forgotten PIN
+	RevokedReasonDeviceRemoved  = "device_removed"  // Ended because the user removed the session's device
+	RevokedReasonLogout         = "logout"          // Ended by logging out
+	RevokedReasonLogoutAll      = "logout_all"      // Ended by logging out on every device
+
+	// Ended by an administrator
+	RevokedReasonAccountChanged   = "account_changed"   // The user's phone number, role or branch changed
+	RevokedReasonAccountSuspended = "account_suspended" // The user's account was suspended
+	RevokedReasonAccountLocked    = "account_locked"    // The user's account was locked
+	RevokedReasonForcedLogout     = "forced_logout"     // The user was logged out everywhere
+
+	// Ended by a service account revoking the session's refresh token
+	RevokedReasonTokenRevoked = "token_revoked"
+)
+
+// SessionRepository defines the interface for session registry operations
+// A session shares its ID with the token family started at login, so revoking the
+// family ends the session
+type SessionRepository interface {
+	CreateSession(session *Session) error
+	TouchSession(sessionID uuid.UUID, jti, ipAddress string) error
+	FindSession(sessionID uuid.UUID) (*Session, error)
+	ListActiveSessions(userID uuid.UUID) ([]Session, error)
+}
+
+// sessionRepository implements the SessionRepository interface
+type sessionRepository struct {
+	db *db.DB
+}
+
+// NewSessionRepository creates a new session repository instance
+func NewSessionRepository(database *db.DB) SessionRepository {
+	return &sessionRepository{
+		db: database,
+	}
+}
+
+// CreateSession registers a new session for the token family it belongs to
+func (r *sessionRepository) CreateSession(session *Session) error {
+	if session == nil {
+		return errors.New("session cannot be nil")
+	}
+	if session.ID == uuid.Nil {
+		return errors.New("session ID cannot be empty")
+	}
+	if session.UserID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		INSERT INTO sessions (id, user_id, jti, device_id, device_name, user_agent, ip_address, client_type, created_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	now := time.Now()
+	_, err := r.db.Exec(query, session.ID, session.UserID, session.JTI, session.DeviceID, session.DeviceName,
+		session.UserAgent, session.IPAddress, session.ClientType, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	return nil
+}
+
+// TouchSession records activity on a session after its refresh token was rotated
+func (r *sessionRepository) TouchSession(sessionID uuid.UUID, jti, ipAddress string) error {
+	if sessionID == uuid.Nil {
+		return errors.New("session ID cannot be empty")
+	}
+
+	query := `
+		UPDATE sessions
+		SET jti = $1, ip_address = COALESCE(NULLIF($2, ''), ip_address), last_seen_at = $3
+		WHERE id = $4
+	`
+
+	_, err := r.db.Exec(query, jti, ipAddress, time.Now(), sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return nil
+}
+
+// FindSession retrieves a session by ID, including its revocation status
+// Returns nil without an error if the session does not exist
+func (r *sessionRepository) FindSession(sessionID uuid.UUID) (*Session, error) {
+	if sessionID == uuid.Nil {
+		return nil, errors.New("session ID cannot be empty")
+	}
+
+	query := `
+		SELECT s.id, s.user_id, s.jti, s.device_id, s.device_name, s.user_agent, s.ip_address, s.client_type,
+			s.created_at, s.last_seen_at, f.revoked_at
+		FROM sessions s
+		JOIN token_families f ON f.id = s.id
+		WHERE s.id = $1
+	`
+
+	session, err := scanSession(r.db.QueryRow(query, sessionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+
+	return session, nil
+}
+
+// ListActiveSessions returns the sessions of a user that have not been revoked, most recently used first
+// Sessions last used before the user was logged out everywhere are left out, since their tokens
+// are rejected by the user's token cutoff.
+func (r *sessionRepository) ListActiveSessions(userID uuid.UUID) ([]Session, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		SELECT s.id, s.user_id, s.jti, s.device_id, s.device_name, s.user_agent, s.ip_address, s.client_type,
+			s.created_at, s.last_seen_at, f.revoked_at
+		FROM sessions s
+		JOIN token_families f ON f.id = s.id
+		JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = $1 AND f.revoked_at IS NULL
+			AND (u.tokens_valid_after IS NULL OR s.last_seen_at > u.tokens_valid_after)
+		ORDER BY s.last_seen_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// scanSession scans a session row produced by the session queries
+func scanSession(row interface{ Scan(dest ...any) error }) (*Session, error) {
+	var session Session
+	var revokedAt sql.NullTime
+
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.JTI,
+		&session.DeviceID,
+		&session.DeviceName,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.ClientType,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"tt-stock-api/internal/db"
+)
+
+// SigningKeyRepository defines the interface for JWT signing key storage
+type SigningKeyRepository interface {
+	CreateKey(key *SigningKey) error
+	ListValidKeys(algorithm string, now time.Time) ([]SigningKey, error)
+}
+
+// signingKeyRepository implements the SigningKeyRepository interface
+type signingKeyRepository struct {
+	db *db.DB
+}
+
+// NewSigningKeyRepository creates a new signing key repository instance
+func NewSigningKeyRepository(database *db.DB) SigningKeyRepository {
+	return &signingKeyRepository{
+		db: database,
+	}
+}
+
+// CreateKey stores a new signing key
+// The private key must already be encrypted by the caller
+func (r *signingKeyRepository) CreateKey(key *SigningKey) error {
+	if key == nil {
+		return errors.New("signing key cannot be nil")
+	}
+	if key.KeyID == "" {
+		return errors.New("key ID cannot be empty")
+	}
+
+	query := `
+		INSERT INTO signing_keys (kid, algorithm, private_key, public_key, created_at, rotate_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := r.db.Exec(query, key.KeyID, key.Algorithm, key.PrivateKey, key.PublicKey,
+		key.CreatedAt, key.RotateAt, key.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create signing key: %w", err)
+	}
+
+	return nil
+}
+
+// ListValidKeys returns the keys of an algorithm that can still verify tokens, newest first
+func (r *signingKeyRepository) ListValidKeys(algorithm string, now time.Time) ([]SigningKey, error) {
+	query := `
+		SELECT kid, algorithm, private_key, public_key, created_at, rotate_at, expires_at
+		FROM signing_keys
+		WHERE algorithm = $1 AND expires_at > $2
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, algorithm, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		err := rows.Scan(
+			&key.KeyID,
+			&key.Algorithm,
+			&key.PrivateKey,
+			&key.PublicKey,
+			&key.CreatedAt,
+			&key.RotateAt,
+			&key.ExpiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// TokenFamilyRepository defines the interface for refresh token family operations
+type TokenFamilyRepository interface {
+	CreateFamily(userID uuid.UUID) (uuid.UUID, error)
+	IsFamilyRevoked(familyID uuid.UUID) (bool, error)
+	RevokeFamily(familyID uuid.UUID, reason string) error
+}
+
+// tokenFamilyRepository implements the TokenFamilyRepository interface
+type tokenFamilyRepository struct {
+	db *db.DB
+}
+
+// NewTokenFamilyRepository creates a new token family repository instance
+func NewTokenFamilyRepository(database *db.DB) TokenFamilyRepository {
+	return &tokenFamilyRepository{
+		db: database,
+	}
+}
+
+// CreateFamily starts a new token family for a user and returns its ID
+func (r *tokenFamilyRepository) CreateFamily(userID uuid.UUID) (uuid.UUID, error) {
+	if userID == uuid.Nil {
+		return uuid.Nil, errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		INSERT INTO token_families (id, user_id, created_at)
+		VALUES ($1, $2, $3)
+	`
+
+	familyID := uuid.New()
+	_, err := r.db.Exec(query, familyID, userID, time.Now())
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to create token family: %w", err)
+	}
+
+	return familyID, nil
+}
+
+// IsFamilyRevoked checks if a token family has been revoked
+// Unknown families are treated as revoked so that tokens cannot reference made-up families
+func (r *tokenFamilyRepository) IsFamilyRevoked(familyID uuid.UUID) (bool, error) {
+	if familyID == uuid.Nil {
+		return false, errors.New("family ID cannot be empty")
+	}
+
+	query := `
+		SELECT NOT EXISTS(
+			SELECT 1 FROM token_families
+			WHERE id = $1 AND revoked_at IS NULL
+		)
+	`
+
+	var revoked bool
+	err := r.db.QueryRow(query, familyID).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check token family status: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// RevokeFamily marks a token family as revoked
+// Revoking an already revoked family keeps the original reason and timestamp
+func (r *tokenFamilyRepository) RevokeFamily(familyID uuid.UUID, reason string) error {
+	if familyID == uuid.Nil {
+		return errors.New("family ID cannot be empty")
+	}
+
+	query := `
+		UPDATE token_families
+		SET revoked_at = $1, revoked_reason = $2
+		WHERE id = $3 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, time.Now(), reason, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+
+	return nil
+}
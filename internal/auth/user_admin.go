@@ -0,0 +1,475 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/policy"
+	"tt-stock-api/internal/user"
+	"tt-stock-api/pkg/utils"
+)
+
+// User list page sizes
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+// maxBranchLength is the longest branch name the users table can hold
+const maxBranchLength = 50
+
+// CreateUserInput describes an employee account created by an administrator
+type CreateUserInput struct {
+	PhoneNumber string
+	Pin         string // Initial PIN; the user must change it at their first login
+	Role        string // Defaults to staff
+	Branch      string
+	BirthDate   *time.Time
+	OTPLogin    bool
+}
+
+// UpdateUserInput holds the profile fields an administrator changes; nil fields are kept
+type UpdateUserInput struct {
+	PhoneNumber *string
+	Role        *string
+	Branch      *string
+	BirthDate   *time.Time
+	OTPLogin    *bool
+}
+
+// CreateUser creates an employee account with an initial PIN that the user must change at
+// their first login. The PIN must pass the weak-PIN rules.
+// Returns ErrPermissionDenied if the policy does not let the administrator manage users of
+// the new account's role and branch, and ErrPhoneNumberTaken if the number is registered.
+func (s *service) CreateUser(claims *Claims, input CreateUserInput, client ClientInfo) (*user.User, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	phoneNumber, err := s.normalizePhoneNumber(input.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	role := input.Role
+	if role == "" {
+		role = user.RoleStaff
+	}
+	if !user.IsValidRole(role) {
+		return nil, &ValidationError{Field: "role", Message: "invalid role"}
+	}
+
+	branch, err := validateBranch(input.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ValidatePin(input.Pin); err != nil {
+		return nil, pinFieldError(err, "pin")
+	}
+	if err := checkPinStrength(input.Pin, input.BirthDate); err != nil {
+		return nil, pinFieldError(err, "pin")
+	}
+
+	u := &user.User{
+		PhoneNumber: phoneNumber,
+		Role:        role,
+		Branch:      branch,
+		BirthDate:   input.BirthDate,
+		OTPLogin:    input.OTPLogin,
+		Status:      user.StatusPendingPinChange,
+	}
+	if err := s.authorizeUserManage(claims, u); err != nil {
+		return nil, err
+	}
+
+	u.PinHash, err = utils.HashPin(input.Pin)
+	if err != nil {
+		return nil, errors.New("failed to hash PIN")
+	}
+
+	if err := s.userRepo.Create(u); err != nil {
+		if errors.Is(err, user.ErrPhoneNumberTaken) {
+			return nil, ErrPhoneNumberTaken
+		}
+		return nil, errors.New("failed to create user")
+	}
+
+	s.recordUserEvent(AuthEventUserCreated, claims, u, client)
+
+	return u, nil
+}
+
+// GetUser returns a user's account
+// Returns ErrPermissionDenied if the policy does not let the administrator manage the user.
+func (s *service) GetUser(claims *Claims, id uuid.UUID) (*user.User, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	u, err := s.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeUserManage(claims, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ListUsers returns one page of users, newest first
+// Only the users the administrator may manage are listed, and asking for a role or branch they
+// may not manage returns ErrPermissionDenied.
+func (s *service) ListUsers(claims *Claims, filter user.Filter, page, pageSize int) (*UserPage, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+	if err := s.scopeUserFilter(claims, &filter); err != nil {
+		return nil, err
+	}
+
+	if filter.PhoneNumber != "" {
+		phoneNumber, err := s.normalizePhoneNumber(filter.PhoneNumber)
+		if err != nil {
+			return nil, err
+		}
+		filter.PhoneNumber = phoneNumber
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > maxUserPageSize {
+		pageSize = defaultUserPageSize
+	}
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	users, total, err := s.userRepo.List(filter)
+	if err != nil {
+		return nil, errors.New("failed to list users")
+	}
+
+	return &UserPage{
+		Users:    users,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	}, nil
+}
+
+// UpdateUser changes a user's profile
+// The administrator must be allowed to manage the user both before and after the change, so
+// nobody can move an account out of, or into, the part of the shop they are responsible for.
+// A change of phone number, role or branch signs the user out everywhere, since their tokens
+// carry the old values.
+func (s *service) UpdateUser(claims *Claims, id uuid.UUID, input UpdateUserInput, client ClientInfo) (*user.User, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+
+	u, err := s.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeUserManage(claims, u); err != nil {
+		return nil, err
+	}
+
+	updated := *u
+	if input.PhoneNumber != nil {
+		phoneNumber, err := s.normalizePhoneNumber(*input.PhoneNumber)
+		if err != nil {
+			return nil, err
+		}
+		updated.PhoneNumber = phoneNumber
+	}
+	if input.Role != nil {
+		if !user.IsValidRole(*input.Role) {
+			return nil, &ValidationError{Field: "role", Message: "invalid role"}
+		}
+		updated.Role = *input.Role
+	}
+	if input.Branch != nil {
+		branch, err := validateBranch(*input.Branch)
+		if err != nil {
+			return nil, err
+		}
+		updated.Branch = branch
+	}
+	if input.BirthDate != nil {
+		updated.BirthDate = input.BirthDate
+	}
+	if input.OTPLogin != nil {
+		updated.OTPLogin = *input.OTPLogin
+	}
+
+	if err := s.authorizeUserManage(claims, &updated); err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.Update(&updated); err != nil {
+		switch {
+		case errors.Is(err, user.ErrPhoneNumberTaken):
+			return nil, ErrPhoneNumberTaken
+		case errors.Is(err, user.ErrNotFound):
+			return nil, ErrUserNotFound
+		default:
+			return nil, errors.New("failed to update user")
+		}
+	}
+
+	if updated.PhoneNumber != u.PhoneNumber || updated.Role != u.Role || updated.Branch != u.Branch {
+		if _, err := s.revokeOtherSessions(u.ID, "", RevokedReasonAccountChanged); err != nil {
+			// Log error but don't fail the update; the sessions end when their tokens expire
+			// In a real application, you'd use a proper logger here
+		}
+	}
+
+	s.recordUserEvent(AuthEventUserUpdated, claims, &updated, client)
+
+	return &updated, nil
+}
+
+// SetUserStatus changes the status of a user's account
+// Suspending or locking an account revokes all of its sessions, and its access tokens stop
+// working within USER_STATUS_CACHE_TTL. Setting pending_pin_change makes the user choose a new
+// PIN before doing anything else. Setting active also clears a lock from failed PIN attempts.
+// Administrators cannot change the status of their own account.
+func (s *service) SetUserStatus(claims *Claims, id uuid.UUID, status string, client ClientInfo) (*user.User, error) {
+	if claims == nil {
+		return nil, errors.New("claims are required")
+	}
+	if !user.IsValidStatus(status) {
+		return nil, &ValidationError{Field: "status", Message: "invalid status"}
+	}
+	if id == claims.UserID {
+		return nil, ErrOwnAccount
+	}
+
+	u, err := s.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeUserManage(claims, u); err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.UpdateStatus(u.ID, status); err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, errors.New("failed to update user status")
+	}
+	s.accounts.forget(u.ID)
+
+	if reason, ok := statusRevokedReasons[status]; ok {
+		if _, err := s.revokeOtherSessions(u.ID, "", reason); err != nil {
+			// Log error but don't fail the status change; the status check rejects the tokens anyway
+			// In a real application, you'd use a proper logger here
+		}
+	}
+
+	// A reactivated user should not stay locked out by earlier failed PIN attempts
+	if status == user.StatusActive {
+		if err := s.attemptRepo.Reset(phoneThrottleKey(u.PhoneNumber)); err != nil {
+			// Log error but don't fail the status change; the lock expires on its own
+			// In a real application, you'd use a proper logger here
+		}
+	}
+
+	s.recordAuthEvent(AuthEvent{
+		EventType:   AuthEventUserStatusChanged,
+		UserID:      &u.ID,
+		PhoneNumber: u.PhoneNumber,
+		Reason:      status,
+		ActorID:     &claims.UserID,
+	}, client)
+
+	if u.Status != status {
+		now := time.Now()
+		u.Status = status
+		u.StatusChangedAt = &now
+	}
+	return u, nil
+}
+
+// statusRevokedReasons maps the statuses that end a user's sessions to the revocation reason
+var statusRevokedReasons = map[string]string{
+	user.StatusSuspended: RevokedReasonAccountSuspended,
+	user.StatusLocked:    RevokedReasonAccountLocked,
+}
+
+// DeleteUser deletes a user's account with their sessions, devices and other per-user data
+// The audit log keeps its entries about the user. Administrators cannot delete their own account.
+func (s *service) DeleteUser(claims *Claims, id uuid.UUID, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+	if id == claims.UserID {
+		return ErrOwnAccount
+	}
+
+	u, err := s.findUser(id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeUserManage(claims, u); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.Delete(u.ID); err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return errors.New("failed to delete user")
+	}
+
+	s.recordUserEvent(AuthEventUserDeleted, claims, u, client)
+
+	return nil
+}
+
+// ForceLogout logs a user out on every device
+// All of the user's tokens issued so far are rejected from then on; the user can log in again.
+func (s *service) ForceLogout(claims *Claims, id uuid.UUID, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	u, err := s.findUser(id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeUserManage(claims, u); err != nil {
+		return err
+	}
+
+	if err := s.revokeAllTokens(u.ID, RevokedReasonForcedLogout); err != nil {
+		return err
+	}
+
+	s.recordUserEvent(AuthEventUserLoggedOut, claims, u, client)
+
+	return nil
+}
+
+// UnlockUser lifts the lock on a user's account after too many failed PIN attempts
+// It clears the failed attempts for the user's phone number, so they can log in again at once.
+func (s *service) UnlockUser(claims *Claims, id uuid.UUID, client ClientInfo) error {
+	if claims == nil {
+		return errors.New("claims are required")
+	}
+
+	u, err := s.findUser(id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeUserManage(claims, u); err != nil {
+		return err
+	}
+
+	if err := s.UnlockAccount(u.PhoneNumber); err != nil {
+		return err
+	}
+
+	s.recordUserEvent(AuthEventUserUnlocked, claims, u, client)
+
+	return nil
+}
+
+// findUser looks up a user by ID, returning ErrUserNotFound if there is none
+func (s *service) findUser(id uuid.UUID) (*user.User, error) {
+	u, err := s.userRepo.FindByID(id)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, errors.New("failed to find user")
+	}
+	return u, nil
+}
+
+// authorizeUserManage checks that the administrator may manage the account
+// Policy rules can limit user.manage by the account's branch and role, e.g. to keep owners
+// away from admin accounts.
+func (s *service) authorizeUserManage(claims *Claims, u *user.User) error {
+	resource := &policy.Resource{
+		Branch:     u.Branch,
+		Attributes: map[string]any{"role": u.Role},
+	}
+
+	err := s.authorizer.Authorize(context.Background(), SubjectFromClaims(claims), policy.PermissionUserManage, resource)
+	if errors.Is(err, policy.ErrDenied) {
+		return ErrPermissionDenied
+	}
+	if err != nil {
+		return errors.New("failed to check permissions")
+	}
+	return nil
+}
+
+// scopeUserFilter limits a user list to the users the administrator may manage, so the list
+// agrees with GetUser
+// Users of roles the administrator may not manage, e.g. admins for owners, are left out, and
+// administrators with a branch who may not manage users outside of it are limited to their own
+// branch. A requested role or branch they may not manage returns ErrPermissionDenied.
+func (s *service) scopeUserFilter(claims *Claims, filter *user.Filter) error {
+	excluded, err := s.unmanageableRoles(claims, filter.Branch)
+	if err != nil {
+		return err
+	}
+	if len(excluded) == len(user.Roles) && filter.Branch == "" && claims.Branch != "" {
+		filter.Branch = claims.Branch
+		if excluded, err = s.unmanageableRoles(claims, filter.Branch); err != nil {
+			return err
+		}
+	}
+
+	if len(excluded) == len(user.Roles) || slices.Contains(excluded, filter.Role) {
+		return ErrPermissionDenied
+	}
+	if filter.Role == "" {
+		filter.ExcludeRoles = excluded
+	}
+	return nil
+}
+
+// unmanageableRoles returns the roles whose users of the branch the administrator may not manage
+func (s *service) unmanageableRoles(claims *Claims, branch string) ([]string, error) {
+	var roles []string
+	for _, role := range user.Roles {
+		err := s.authorizeUserManage(claims, &user.User{Role: role, Branch: branch})
+		if errors.Is(err, ErrPermissionDenied) {
+			roles = append(roles, role)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+// recordUserEvent records a change an administrator made to a user's account
+func (s *service) recordUserEvent(eventType string, claims *Claims, u *user.User, client ClientInfo) {
+	s.recordAuthEvent(AuthEvent{
+		EventType:   eventType,
+		UserID:      &u.ID,
+		PhoneNumber: u.PhoneNumber,
+		ActorID:     &claims.UserID,
+	}, client)
+}
+
+// validateBranch trims a branch name and checks that it fits in the users table
+func validateBranch(branch string) (string, error) {
+	branch = strings.TrimSpace(branch)
+	if len(branch) > maxBranchLength {
+		return "", &ValidationError{Field: "branch", Message: "branch must be at most 50 characters"}
+	}
+	return branch, nil
+}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the application
@@ -12,6 +16,89 @@ type Config struct {
 	DBUrl     string
 	Port      string
 	Env       string
+
+	// JWT signing
+	JWTAlgorithm           string        // HS256 (shared secret), RS256 or EdDSA (rotating key pairs)
+	JWTKeyRotationInterval time.Duration // How long an RS256/EdDSA key signs new tokens before a new key is generated
+
+	// Token lifetimes
+	TokenLifetimes         TokenLifetimes            // Default access/refresh token lifetimes and session maximum
+	TokenLifetimeOverrides map[string]TokenLifetimes // Overrides keyed by "role:<role>", "client:<type>" or "role:<role>,client:<type>"
+
+	// Login throttling
+	LoginMaxAttempts      int           // Failed PIN attempts per phone number before the account is locked
+	LoginMaxAttemptsPerIP int           // Failed attempts per client IP before the IP is blocked
+	LoginAttemptWindow    time.Duration // Window after which the failure counter starts over
+	LoginLockoutDuration  time.Duration // How long a locked account or blocked IP stays locked
+	LoginDelayBase        time.Duration // Progressive delay after the first failure, doubled on each further failure
+	LoginDelayMax         time.Duration // Upper bound for the progressive delay
+
+	// PIN changes
+	PinHistorySize int // Number of previous PINs a user cannot switch back to
+
+	// One-time codes sent by SMS
+	OTPTTL            time.Duration // How long a code stays valid
+	OTPMaxAttempts    int           // Verification attempts allowed per code
+	OTPResendInterval time.Duration // Minimum time between two codes for the same user and purpose
+	OTPLoginEnabled   bool          // Allow SMS code login for every user of the shop, not just those with otp_login set
+
+	// Devices
+	RestrictLoginToShopDevices bool          // Only registered shop devices may log in, except for users who may manage them
+	DeviceKeyChallengeTTL      time.Duration // How long a device key login challenge can be signed
+
+	// Permission policies
+	PolicyCacheTTL time.Duration // How long policy rules loaded from the database are cached; bounds how late rule changes apply
+
+	// SMS delivery
+	SMSProvider string // "log" writes messages to the log, "file" appends them to SMSFilePath
+	SMSFilePath string // Output file of the "file" provider
+
+	// Phone numbers
+	PhoneCountries []string // Countries besides Thailand whose phone numbers are accepted, as ISO 3166-1 alpha-2 codes, e.g. "LA"
+
+	// Login alerts
+	LoginAlertRules  []string      // Checks that raise an alert on login: "new_device", "new_ip" and "outside_hours"
+	ShopOpeningHours *OpeningHours // Logins outside these hours raise an outside_hours alert; nil disables the check
+	ShopTimezone     string        // IANA time zone of the opening hours, e.g. "Asia/Bangkok"
+
+	// Notifications
+	NotifierProvider string // "log" writes notifications to the log, "file" appends them to NotifierFilePath, "sms" sends them by SMS
+	NotifierFilePath string // Output file of the "file" provider
+
+	// Token refresh
+	RefreshGracePeriod time.Duration // How long a duplicate refresh with the same token gets the already issued pair back
+
+	// Account status
+	UserStatusCacheTTL time.Duration // How long a user's account status and token cutoff are cached; bounds how late a suspension or logout everywhere cuts off their tokens
+
+	// Token blacklist
+	BlacklistCacheTTL       time.Duration // How long a "not blacklisted" or "session not revoked" lookup is cached; bounds how late other instances see a revocation
+	BlacklistPurgeInterval  time.Duration // How often expired blacklist rows are deleted; zero disables the background purge
+	BlacklistPurgeBatchSize int           // Maximum rows deleted per statement during a purge
+}
+
+// TokenLifetimes holds how long tokens and sessions stay valid
+// Zero fields in an override keep the value they would otherwise have
+type TokenLifetimes struct {
+	Access     time.Duration // Access token lifetime
+	Refresh    time.Duration // Refresh token lifetime
+	SessionMax time.Duration // Absolute session lifetime from login; refresh rotation cannot extend past it
+}
+
+// OpeningHours is a daily time range in the shop's time zone, as offsets from midnight
+// A Close before Open spans midnight, e.g. 18:00-02:00
+type OpeningHours struct {
+	Open  time.Duration
+	Close time.Duration
+}
+
+// Contains reports whether the wall clock time of t falls within the opening hours
+func (h OpeningHours) Contains(t time.Time) bool {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if h.Open <= h.Close {
+		return clock >= h.Open && clock < h.Close
+	}
+	return clock >= h.Open || clock < h.Close
 }
 
 // Load reads configuration from environment variables
@@ -21,6 +108,55 @@ func Load() *Config {
 		DBUrl:     buildDBUrl(),
 		Port:      getEnv("PORT", "8080"),
 		Env:       getEnv("ENV", "development"),
+
+		JWTAlgorithm:           getEnv("JWT_ALGORITHM", "HS256"),
+		JWTKeyRotationInterval: getEnvAsDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+
+		TokenLifetimes: TokenLifetimes{
+			Access:     getEnvAsDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+			Refresh:    getEnvAsDuration("REFRESH_TOKEN_TTL", 24*time.Hour),
+			SessionMax: getEnvAsDuration("SESSION_MAX_LIFETIME", 7*24*time.Hour),
+		},
+		TokenLifetimeOverrides: getEnvAsTokenLifetimeOverrides("TOKEN_LIFETIME_OVERRIDES"),
+
+		LoginMaxAttempts:      getEnvAsInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginMaxAttemptsPerIP: getEnvAsInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20),
+		LoginAttemptWindow:    getEnvAsDuration("LOGIN_ATTEMPT_WINDOW", 15*time.Minute),
+		LoginLockoutDuration:  getEnvAsDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginDelayBase:        getEnvAsDuration("LOGIN_DELAY_BASE", time.Second),
+		LoginDelayMax:         getEnvAsDuration("LOGIN_DELAY_MAX", 30*time.Second),
+
+		PinHistorySize: getEnvAsInt("PIN_HISTORY_SIZE", 5),
+
+		OTPTTL:            getEnvAsDuration("OTP_TTL", 5*time.Minute),
+		OTPMaxAttempts:    getEnvAsInt("OTP_MAX_ATTEMPTS", 5),
+		OTPResendInterval: getEnvAsDuration("OTP_RESEND_INTERVAL", time.Minute),
+		OTPLoginEnabled:   getEnvAsBool("OTP_LOGIN_ENABLED", false),
+
+		RestrictLoginToShopDevices: getEnvAsBool("RESTRICT_LOGIN_TO_SHOP_DEVICES", false),
+		DeviceKeyChallengeTTL:      getEnvAsDuration("DEVICE_KEY_CHALLENGE_TTL", time.Minute),
+
+		PolicyCacheTTL: getEnvAsDuration("POLICY_CACHE_TTL", time.Minute),
+
+		SMSProvider: getEnv("SMS_PROVIDER", "log"),
+		SMSFilePath: getEnv("SMS_FILE_PATH", "sms_outbox.log"),
+
+		PhoneCountries: getEnvAsList("PHONE_COUNTRIES", nil),
+
+		LoginAlertRules:  getEnvAsList("LOGIN_ALERT_RULES", []string{"new_device", "new_ip", "outside_hours"}),
+		ShopOpeningHours: getEnvAsOpeningHours("SHOP_OPENING_HOURS"),
+		ShopTimezone:     getEnv("SHOP_TIMEZONE", "Asia/Bangkok"),
+
+		NotifierProvider: getEnv("NOTIFIER_PROVIDER", "log"),
+		NotifierFilePath: getEnv("NOTIFIER_FILE_PATH", "notifications.log"),
+
+		RefreshGracePeriod: getEnvAsDuration("REFRESH_GRACE_PERIOD", 10*time.Second),
+
+		UserStatusCacheTTL: getEnvAsDuration("USER_STATUS_CACHE_TTL", 5*time.Second),
+
+		BlacklistCacheTTL:       getEnvAsDuration("BLACKLIST_CACHE_TTL", 5*time.Second),
+		BlacklistPurgeInterval:  getEnvAsDuration("BLACKLIST_PURGE_INTERVAL", time.Hour),
+		BlacklistPurgeBatchSize: getEnvAsInt("BLACKLIST_PURGE_BATCH_SIZE", 1000),
 	}
 }
 
@@ -67,4 +203,136 @@ func getEnvAsBool(key string, fallback bool) bool {
 		}
 	}
 	return fallback
-}
\ No newline at end of file
+}
+
+// getEnvAsDuration gets an environment variable as time.Duration (e.g. "15m", "30s") with a fallback value
+func getEnvAsDuration(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return fallback
+}
+
+// getEnvAsList gets a comma-separated environment variable as a list with a fallback value
+// Surrounding spaces and empty items are dropped; "none" yields an empty list
+func getEnvAsList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" && item != "none" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvAsOpeningHours parses opening hours in the form "08:00-20:00" from an environment variable
+// Returns nil if the variable is unset or invalid; ValidateEnvironment reports invalid values
+func getEnvAsOpeningHours(key string) *OpeningHours {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	hours, err := ParseOpeningHours(value)
+	if err != nil {
+		return nil
+	}
+	return hours
+}
+
+// ParseOpeningHours parses the "HH:MM-HH:MM" format of SHOP_OPENING_HOURS
+func ParseOpeningHours(value string) (*OpeningHours, error) {
+	open, close, found := strings.Cut(value, "-")
+	if !found {
+		return nil, fmt.Errorf("must be in the form HH:MM-HH:MM")
+	}
+
+	openTime, err := time.Parse("15:04", strings.TrimSpace(open))
+	if err != nil {
+		return nil, fmt.Errorf("opening time must be in the form HH:MM")
+	}
+	closeTime, err := time.Parse("15:04", strings.TrimSpace(close))
+	if err != nil {
+		return nil, fmt.Errorf("closing time must be in the form HH:MM")
+	}
+
+	hours := &OpeningHours{
+		Open:  time.Duration(openTime.Hour())*time.Hour + time.Duration(openTime.Minute())*time.Minute,
+		Close: time.Duration(closeTime.Hour())*time.Hour + time.Duration(closeTime.Minute())*time.Minute,
+	}
+	if hours.Open == hours.Close {
+		return nil, fmt.Errorf("opening and closing time must differ")
+	}
+	return hours, nil
+}
+
+// getEnvAsTokenLifetimeOverrides parses token lifetime overrides from a JSON environment variable
+// Example: {"role:owner": {"refresh": "720h", "session_max": "2160h"}, "client:pos": {"access": "12h"}}
+// Returns nil if the variable is unset or invalid; ValidateEnvironment reports invalid values
+func getEnvAsTokenLifetimeOverrides(key string) map[string]TokenLifetimes {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	overrides, err := ParseTokenLifetimeOverrides(value)
+	if err != nil {
+		return nil
+	}
+	return overrides
+}
+
+// ParseTokenLifetimeOverrides parses the JSON format of TOKEN_LIFETIME_OVERRIDES
+func ParseTokenLifetimeOverrides(value string) (map[string]TokenLifetimes, error) {
+	var raw map[string]map[string]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("must be a JSON object: %w", err)
+	}
+
+	overrides := make(map[string]TokenLifetimes, len(raw))
+	for selector, fields := range raw {
+		if !isValidLifetimeSelector(selector) {
+			return nil, fmt.Errorf("%s is not a valid selector (expected role:<role>, client:<type> or role:<role>,client:<type>)", selector)
+		}
+
+		var lifetimes TokenLifetimes
+		for field, durationValue := range fields {
+			duration, err := time.ParseDuration(durationValue)
+			if err != nil || duration <= 0 {
+				return nil, fmt.Errorf("%s.%s must be a positive duration", selector, field)
+			}
+
+			switch field {
+			case "access":
+				lifetimes.Access = duration
+			case "refresh":
+				lifetimes.Refresh = duration
+			case "session_max":
+				lifetimes.SessionMax = duration
+			default:
+				return nil, fmt.Errorf("%s has unknown field %s (expected access, refresh or session_max)", selector, field)
+			}
+		}
+		overrides[selector] = lifetimes
+	}
+
+	return overrides, nil
+}
+
+// isValidLifetimeSelector checks a token lifetime override key
+func isValidLifetimeSelector(selector string) bool {
+	role, client, combined := strings.Cut(selector, ",")
+	if combined {
+		return strings.HasPrefix(role, "role:") && len(role) > len("role:") &&
+			strings.HasPrefix(client, "client:") && len(client) > len("client:")
+	}
+	return (strings.HasPrefix(selector, "role:") && len(selector) > len("role:")) ||
+		(strings.HasPrefix(selector, "client:") && len(selector) > len("client:"))
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ValidationError represents an environment validation error
@@ -86,6 +88,108 @@ func ValidateEnvironment() error {
 		}
 	}
 
+	// Validate JWT_ALGORITHM if provided
+	if algorithm := os.Getenv("JWT_ALGORITHM"); algorithm != "" {
+		if algorithm != "HS256" && algorithm != "RS256" && algorithm != "EdDSA" {
+			errors = append(errors, ValidationError{
+				Variable: "JWT_ALGORITHM",
+				Message:  "must be one of HS256, RS256 or EdDSA",
+			})
+		}
+	}
+
+	// Validate TOKEN_LIFETIME_OVERRIDES if provided
+	if overrides := os.Getenv("TOKEN_LIFETIME_OVERRIDES"); overrides != "" {
+		if _, err := ParseTokenLifetimeOverrides(overrides); err != nil {
+			errors = append(errors, ValidationError{
+				Variable: "TOKEN_LIFETIME_OVERRIDES",
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	// Validate PIN_HISTORY_SIZE if provided
+	if size := os.Getenv("PIN_HISTORY_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err != nil || n < 0 {
+			errors = append(errors, ValidationError{
+				Variable: "PIN_HISTORY_SIZE",
+				Message:  "must be a non-negative integer",
+			})
+		}
+	}
+
+	// Validate OTP_MAX_ATTEMPTS if provided
+	if attempts := os.Getenv("OTP_MAX_ATTEMPTS"); attempts != "" {
+		if n, err := strconv.Atoi(attempts); err != nil || n < 1 {
+			errors = append(errors, ValidationError{
+				Variable: "OTP_MAX_ATTEMPTS",
+				Message:  "must be a positive integer",
+			})
+		}
+	}
+
+	// Validate SMS_PROVIDER if provided
+	if provider := os.Getenv("SMS_PROVIDER"); provider != "" {
+		if provider != "log" && provider != "file" {
+			errors = append(errors, ValidationError{
+				Variable: "SMS_PROVIDER",
+				Message:  "must be one of log or file",
+			})
+		}
+	}
+
+	// Validate PHONE_COUNTRIES if provided
+	for _, country := range getEnvAsList("PHONE_COUNTRIES", nil) {
+		switch strings.ToUpper(country) {
+		case "TH", "LA", "MY":
+		default:
+			errors = append(errors, ValidationError{
+				Variable: "PHONE_COUNTRIES",
+				Message:  fmt.Sprintf("%s is not a supported country (expected LA, MY or none)", country),
+			})
+		}
+	}
+
+	// Validate LOGIN_ALERT_RULES if provided
+	for _, rule := range getEnvAsList("LOGIN_ALERT_RULES", nil) {
+		if rule != "new_device" && rule != "new_ip" && rule != "outside_hours" {
+			errors = append(errors, ValidationError{
+				Variable: "LOGIN_ALERT_RULES",
+				Message:  fmt.Sprintf("%s is not a rule (expected new_device, new_ip, outside_hours or none)", rule),
+			})
+		}
+	}
+
+	// Validate SHOP_OPENING_HOURS if provided
+	if hours := os.Getenv("SHOP_OPENING_HOURS"); hours != "" {
+		if _, err := ParseOpeningHours(hours); err != nil {
+			errors = append(errors, ValidationError{
+				Variable: "SHOP_OPENING_HOURS",
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	// Validate SHOP_TIMEZONE if provided
+	if timezone := os.Getenv("SHOP_TIMEZONE"); timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			errors = append(errors, ValidationError{
+				Variable: "SHOP_TIMEZONE",
+				Message:  "must be an IANA time zone name, e.g. Asia/Bangkok",
+			})
+		}
+	}
+
+	// Validate NOTIFIER_PROVIDER if provided
+	if provider := os.Getenv("NOTIFIER_PROVIDER"); provider != "" {
+		if provider != "log" && provider != "file" && provider != "sms" {
+			errors = append(errors, ValidationError{
+				Variable: "NOTIFIER_PROVIDER",
+				Message:  "must be one of log, file or sms",
+			})
+		}
+	}
+
 	if len(errors) > 0 {
 		return errors
 	}
@@ -40,8 +40,9 @@ func (db *DB) CreateTables() error {
 	usersTable := `
 	CREATE TABLE IF NOT EXISTS users (
 		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-		phone_number VARCHAR(10) UNIQUE NOT NULL,
+		phone_number VARCHAR(16) UNIQUE NOT NULL,
 		pin_hash VARCHAR(255) NOT NULL,
+		role VARCHAR(20) NOT NULL DEFAULT 'staff',
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 		last_login_at TIMESTAMP WITH TIME ZONE
@@ -51,39 +52,593 @@ func (db *DB) CreateTables() error {
 		return fmt.Errorf("failed to create users table: %w", err)
 	}
 
+	// Add role column to users tables created before roles existed
+	roleColumn := `ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'staff';`
+	if _, err := db.Exec(roleColumn); err != nil {
+		return fmt.Errorf("failed to add users role column: %w", err)
+	}
+
+	// Add optional birth_date column, used to reject PINs built from the birth year
+	birthDateColumn := `ALTER TABLE users ADD COLUMN IF NOT EXISTS birth_date DATE;`
+	if _, err := db.Exec(birthDateColumn); err != nil {
+		return fmt.Errorf("failed to add users birth_date column: %w", err)
+	}
+
+	// Add otp_login column, which lets a user log in with an SMS code instead of a PIN
+	otpLoginColumn := `ALTER TABLE users ADD COLUMN IF NOT EXISTS otp_login BOOLEAN NOT NULL DEFAULT FALSE;`
+	if _, err := db.Exec(otpLoginColumn); err != nil {
+		return fmt.Errorf("failed to add users otp_login column: %w", err)
+	}
+
+	// Add branch column, used by permission policies that are limited to the user's own branch
+	branchColumn := `ALTER TABLE users ADD COLUMN IF NOT EXISTS branch VARCHAR(50) NOT NULL DEFAULT '';`
+	if _, err := db.Exec(branchColumn); err != nil {
+		return fmt.Errorf("failed to add users branch column: %w", err)
+	}
+
+	// Add status columns; the status is checked at login, at refresh and on every request
+	statusColumns := `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'
+		CHECK (status IN ('active', 'suspended', 'locked', 'pending_pin_change'));
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP WITH TIME ZONE;`
+	if _, err := db.Exec(statusColumns); err != nil {
+		return fmt.Errorf("failed to add users status columns: %w", err)
+	}
+
+	// Add tokens_valid_after column; tokens issued up to this time are rejected, which logs the
+	// user out everywhere without blacklisting each token
+	tokensValidAfterColumn := `ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP WITH TIME ZONE;`
+	if _, err := db.Exec(tokensValidAfterColumn); err != nil {
+		return fmt.Errorf("failed to add users tokens_valid_after column: %w", err)
+	}
+
+	// Convert the must_change_pin and disabled_at columns of earlier versions into statuses
+	// Each column is checked on its own, as a database may have either. A suspension takes
+	// precedence over a pending PIN change, since a user has only one status.
+	statusMigration := `
+	DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'users' AND column_name = 'must_change_pin'
+		) THEN
+			UPDATE users SET status = 'pending_pin_change' WHERE must_change_pin AND status = 'active';
+			ALTER TABLE users DROP COLUMN IF EXISTS must_change_pin;
+		END IF;
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'users' AND column_name = 'disabled_at'
+		) THEN
+			UPDATE users SET status = 'suspended', status_changed_at = disabled_at WHERE disabled_at IS NOT NULL;
+			ALTER TABLE users DROP COLUMN IF EXISTS disabled_at;
+		END IF;
+	END $$;`
+	if _, err := db.Exec(statusMigration); err != nil {
+		return fmt.Errorf("failed to migrate users status: %w", err)
+	}
+
+	// Widen the phone_number column of earlier versions to fit E.164 numbers; checked first so
+	// the table is not locked on every startup
+	phoneNumberColumn := `
+	DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'users' AND column_name = 'phone_number'
+				AND character_maximum_length IS DISTINCT FROM 16
+		) THEN
+			ALTER TABLE users ALTER COLUMN phone_number TYPE VARCHAR(16);
+		END IF;
+	END $$;`
+	if _, err := db.Exec(phoneNumberColumn); err != nil {
+		return fmt.Errorf("failed to widen users phone_number column: %w", err)
+	}
+
+	// Create pin_history table so recently used PINs cannot be chosen again
+	pinHistoryTable := `
+	CREATE TABLE IF NOT EXISTS pin_history (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		pin_hash VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(pinHistoryTable); err != nil {
+		return fmt.Errorf("failed to create pin_history table: %w", err)
+	}
+
 	// Create token_blacklist table
 	tokenBlacklistTable := `
 	CREATE TABLE IF NOT EXISTS token_blacklist (
 		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-		token TEXT NOT NULL,
+		token_hash VARCHAR(64) NOT NULL,
 		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
 		token_type VARCHAR(10) NOT NULL CHECK (token_type IN ('access', 'refresh')),
 		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
-		blacklisted_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		blacklisted_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		successor BYTEA
 	);`
 
 	if _, err := db.Exec(tokenBlacklistTable); err != nil {
 		return fmt.Errorf("failed to create token_blacklist table: %w", err)
 	}
 
+	// Add successor column to token_blacklist tables created before refresh grace handling
+	successorColumn := `ALTER TABLE token_blacklist ADD COLUMN IF NOT EXISTS successor BYTEA;`
+	if _, err := db.Exec(successorColumn); err != nil {
+		return fmt.Errorf("failed to add token_blacklist successor column: %w", err)
+	}
+
+	// Replace plain-text tokens stored by older versions with their SHA-256 digests
+	hashTokens := `
+	DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'token_blacklist' AND column_name = 'token'
+		) THEN
+			UPDATE token_blacklist SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+			ALTER TABLE token_blacklist RENAME COLUMN token TO token_hash;
+			ALTER TABLE token_blacklist ALTER COLUMN token_hash TYPE VARCHAR(64);
+		END IF;
+	END $$;`
+	if _, err := db.Exec(hashTokens); err != nil {
+		return fmt.Errorf("failed to hash blacklisted tokens: %w", err)
+	}
+
+	// Create login_throttles table for failed PIN attempt tracking
+	loginThrottlesTable := `
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		throttle_key VARCHAR(100) PRIMARY KEY,
+		failed_count INTEGER NOT NULL DEFAULT 0,
+		last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		locked_until TIMESTAMP WITH TIME ZONE,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(loginThrottlesTable); err != nil {
+		return fmt.Errorf("failed to create login_throttles table: %w", err)
+	}
+
+	// Create token_families table for refresh token rotation chains
+	tokenFamiliesTable := `
+	CREATE TABLE IF NOT EXISTS token_families (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		revoked_reason VARCHAR(50)
+	);`
+
+	if _, err := db.Exec(tokenFamiliesTable); err != nil {
+		return fmt.Errorf("failed to create token_families table: %w", err)
+	}
+
+	// Create sessions table for the registry of logged-in devices, one per token family
+	sessionsTable := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id UUID PRIMARY KEY REFERENCES token_families(id) ON DELETE CASCADE,
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		jti VARCHAR(64) NOT NULL,
+		device_name VARCHAR(100) NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip_address VARCHAR(45) NOT NULL DEFAULT '',
+		client_type VARCHAR(20) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(sessionsTable); err != nil {
+		return fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	// Add client_type column to sessions tables created before per-client token lifetimes
+	clientTypeColumn := `ALTER TABLE sessions ADD COLUMN IF NOT EXISTS client_type VARCHAR(20) NOT NULL DEFAULT '';`
+	if _, err := db.Exec(clientTypeColumn); err != nil {
+		return fmt.Errorf("failed to add sessions client_type column: %w", err)
+	}
+
+	// Add device_id column to sessions tables created before device binding
+	sessionDeviceColumn := `ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_id VARCHAR(64) NOT NULL DEFAULT '';`
+	if _, err := db.Exec(sessionDeviceColumn); err != nil {
+		return fmt.Errorf("failed to add sessions device_id column: %w", err)
+	}
+
+	// Create devices table for the devices each user has logged in from
+	devicesTable := `
+	CREATE TABLE IF NOT EXISTS devices (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		device_id VARCHAR(64) NOT NULL,
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		last_ip VARCHAR(45) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		UNIQUE (user_id, device_id)
+	);`
+
+	if _, err := db.Exec(devicesTable); err != nil {
+		return fmt.Errorf("failed to create devices table: %w", err)
+	}
+
+	// Create shop_devices table for devices owners have registered as belonging to the shop
+	shopDevicesTable := `
+	CREATE TABLE IF NOT EXISTS shop_devices (
+		device_id VARCHAR(64) PRIMARY KEY,
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		registered_by UUID REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(shopDevicesTable); err != nil {
+		return fmt.Errorf("failed to create shop_devices table: %w", err)
+	}
+
+	// Create device_keys table for public keys devices use to log in without a PIN
+	deviceKeysTable := `
+	CREATE TABLE IF NOT EXISTS device_keys (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		device_id VARCHAR(64) NOT NULL,
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		algorithm VARCHAR(10) NOT NULL,
+		public_key BYTEA NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		revoked_at TIMESTAMP WITH TIME ZONE
+	);`
+
+	if _, err := db.Exec(deviceKeysTable); err != nil {
+		return fmt.Errorf("failed to create device_keys table: %w", err)
+	}
+
+	// Create device_key_challenges table for login nonces, one per device key
+	deviceKeyChallengesTable := `
+	CREATE TABLE IF NOT EXISTS device_key_challenges (
+		key_id UUID PRIMARY KEY REFERENCES device_keys(id) ON DELETE CASCADE,
+		nonce VARCHAR(64) NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(deviceKeyChallengesTable); err != nil {
+		return fmt.Errorf("failed to create device_key_challenges table: %w", err)
+	}
+
+	// Create service_accounts table for non-human API clients such as POS terminals
+	serviceAccountsTable := `
+	CREATE TABLE IF NOT EXISTS service_accounts (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		name VARCHAR(100) NOT NULL UNIQUE,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		branch VARCHAR(50) NOT NULL DEFAULT '',
+		created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(serviceAccountsTable); err != nil {
+		return fmt.Errorf("failed to create service_accounts table: %w", err)
+	}
+
+	// Create api_keys table for service account credentials; only a hash of each key is stored
+	apiKeysTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		service_account_id UUID NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		prefix VARCHAR(16) NOT NULL,
+		key_hash VARCHAR(64) NOT NULL UNIQUE,
+		permissions JSONB NOT NULL DEFAULT '[]',
+		expires_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		last_used_ip VARCHAR(45),
+		revoked_at TIMESTAMP WITH TIME ZONE
+	);`
+
+	if _, err := db.Exec(apiKeysTable); err != nil {
+		return fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+
+	// Create policy_rules table for permission rules granted to roles or, as overrides, to single users
+	policyRulesTable := `
+	CREATE TABLE IF NOT EXISTS policy_rules (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		permission VARCHAR(50) NOT NULL,
+		role VARCHAR(20),
+		user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+		effect VARCHAR(5) NOT NULL DEFAULT 'allow' CHECK (effect IN ('allow', 'deny')),
+		conditions JSONB NOT NULL DEFAULT '[]',
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		CHECK ((role IS NULL) <> (user_id IS NULL))
+	);`
+
+	if _, err := db.Exec(policyRulesTable); err != nil {
+		return fmt.Errorf("failed to create policy_rules table: %w", err)
+	}
+
+	// Create auth_events table, the append-only authentication audit log
+	// user_id has no foreign key so the history of deleted users is kept. Being append-only, its
+	// events are not converted to E.164: those recorded before keep local format phone numbers.
+	authEventsTable := `
+	CREATE TABLE IF NOT EXISTS auth_events (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		event_type VARCHAR(30) NOT NULL,
+		user_id UUID,
+		phone_number VARCHAR(20) NOT NULL DEFAULT '',
+		method VARCHAR(20) NOT NULL DEFAULT '',
+		reason VARCHAR(50) NOT NULL DEFAULT '',
+		session_id UUID,
+		ip_address VARCHAR(45) NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		device_id VARCHAR(64) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(authEventsTable); err != nil {
+		return fmt.Errorf("failed to create auth_events table: %w", err)
+	}
+
+	// Add actor_id column, the administrator who performed an event on another user's account
+	authEventsActorColumn := `ALTER TABLE auth_events ADD COLUMN IF NOT EXISTS actor_id UUID;`
+	if _, err := db.Exec(authEventsActorColumn); err != nil {
+		return fmt.Errorf("failed to add auth_events actor_id column: %w", err)
+	}
+
+	// Reject updates and deletes so audit entries cannot be altered after the fact
+	authEventsAppendOnly := `
+	CREATE OR REPLACE FUNCTION auth_events_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'auth_events is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS auth_events_append_only ON auth_events;
+	CREATE TRIGGER auth_events_append_only
+		BEFORE UPDATE OR DELETE ON auth_events
+		FOR EACH ROW EXECUTE FUNCTION auth_events_append_only();`
+
+	if _, err := db.Exec(authEventsAppendOnly); err != nil {
+		return fmt.Errorf("failed to create auth_events append-only trigger: %w", err)
+	}
+
+	// Create login_alerts table for unusual logins awaiting review
+	loginAlertsTable := `
+	CREATE TABLE IF NOT EXISTS login_alerts (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		phone_number VARCHAR(20) NOT NULL DEFAULT '',
+		reasons JSONB NOT NULL DEFAULT '[]',
+		session_id UUID,
+		ip_address VARCHAR(45) NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		device_id VARCHAR(64) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		reviewed_at TIMESTAMP WITH TIME ZONE,
+		reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL
+	);`
+
+	if _, err := db.Exec(loginAlertsTable); err != nil {
+		return fmt.Errorf("failed to create login_alerts table: %w", err)
+	}
+
+	// Store phone numbers in E.164; convert those saved in the local Thai format by older versions
+	if err := db.convertPhoneNumbersToE164(); err != nil {
+		return fmt.Errorf("failed to convert phone numbers to E.164: %w", err)
+	}
+
+	// Create signing_keys table for rotating RS256/EdDSA JWT signing keys
+	signingKeysTable := `
+	CREATE TABLE IF NOT EXISTS signing_keys (
+		kid VARCHAR(64) PRIMARY KEY,
+		algorithm VARCHAR(10) NOT NULL,
+		private_key BYTEA NOT NULL,
+		public_key BYTEA NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		rotate_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);`
+
+	if _, err := db.Exec(signingKeysTable); err != nil {
+		return fmt.Errorf("failed to create signing_keys table: %w", err)
+	}
+
+	// Create security_events table for suspicious activity such as refresh token reuse
+	securityEventsTable := `
+	CREATE TABLE IF NOT EXISTS security_events (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		event_type VARCHAR(50) NOT NULL,
+		details TEXT,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);`
+
+	if _, err := db.Exec(securityEventsTable); err != nil {
+		return fmt.Errorf("failed to create security_events table: %w", err)
+	}
+
+	// Create otp_codes table for one-time codes sent by SMS, one per user and purpose
+	otpCodesTable := `
+	CREATE TABLE IF NOT EXISTS otp_codes (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		purpose VARCHAR(20) NOT NULL,
+		code_hash VARCHAR(64) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		consumed_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		UNIQUE (user_id, purpose)
+	);`
+
+	if _, err := db.Exec(otpCodesTable); err != nil {
+		return fmt.Errorf("failed to create otp_codes table: %w", err)
+	}
+
 	// Create index on phone_number for faster lookups
 	phoneIndex := `CREATE INDEX IF NOT EXISTS idx_users_phone_number ON users(phone_number);`
 	if _, err := db.Exec(phoneIndex); err != nil {
 		return fmt.Errorf("failed to create phone number index: %w", err)
 	}
 
-	// Create index on token for faster blacklist lookups
-	tokenIndex := `CREATE INDEX IF NOT EXISTS idx_token_blacklist_token ON token_blacklist(token);`
+	// Remove duplicate blacklist entries left by older versions before enforcing uniqueness
+	dedupeTokens := `
+	DELETE FROM token_blacklist a
+	USING token_blacklist b
+	WHERE a.token_hash = b.token_hash AND a.ctid > b.ctid;`
+	if _, err := db.Exec(dedupeTokens); err != nil {
+		return fmt.Errorf("failed to remove duplicate blacklisted tokens: %w", err)
+	}
+
+	// Create unique index on token hash so a refresh token can only be consumed once
+	tokenIndex := `CREATE UNIQUE INDEX IF NOT EXISTS idx_token_blacklist_token_hash ON token_blacklist(token_hash);`
 	if _, err := db.Exec(tokenIndex); err != nil {
 		return fmt.Errorf("failed to create token index: %w", err)
 	}
 
+	// Drop the old token indexes, now covered by the token hash index
+	oldTokenIndex := `
+	DROP INDEX IF EXISTS idx_token_blacklist_token;
+	DROP INDEX IF EXISTS idx_token_blacklist_token_unique;`
+	if _, err := db.Exec(oldTokenIndex); err != nil {
+		return fmt.Errorf("failed to drop old token index: %w", err)
+	}
+
 	// Create index on user_id for faster user token lookups
 	userTokenIndex := `CREATE INDEX IF NOT EXISTS idx_token_blacklist_user_id ON token_blacklist(user_id);`
 	if _, err := db.Exec(userTokenIndex); err != nil {
 		return fmt.Errorf("failed to create user token index: %w", err)
 	}
 
+	// Create index on user_id for token family lookups
+	familyUserIndex := `CREATE INDEX IF NOT EXISTS idx_token_families_user_id ON token_families(user_id);`
+	if _, err := db.Exec(familyUserIndex); err != nil {
+		return fmt.Errorf("failed to create token family user index: %w", err)
+	}
+
+	// Create index on user_id for session listing
+	sessionUserIndex := `CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);`
+	if _, err := db.Exec(sessionUserIndex); err != nil {
+		return fmt.Errorf("failed to create session user index: %w", err)
+	}
+
+	// Create index on user_id for security event lookups
+	securityEventUserIndex := `CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON security_events(user_id);`
+	if _, err := db.Exec(securityEventUserIndex); err != nil {
+		return fmt.Errorf("failed to create security event user index: %w", err)
+	}
+
+	// Create index on user_id for PIN history lookups
+	pinHistoryUserIndex := `CREATE INDEX IF NOT EXISTS idx_pin_history_user_id ON pin_history(user_id, created_at DESC);`
+	if _, err := db.Exec(pinHistoryUserIndex); err != nil {
+		return fmt.Errorf("failed to create PIN history user index: %w", err)
+	}
+
+	// Create indexes for the audit log queries, which list newest first
+	authEventIndexes := `
+	CREATE INDEX IF NOT EXISTS idx_auth_events_created_at ON auth_events(created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_auth_events_user_id ON auth_events(user_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_auth_events_phone_number ON auth_events(phone_number, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_auth_events_actor_id ON auth_events(actor_id, created_at DESC);`
+	if _, err := db.Exec(authEventIndexes); err != nil {
+		return fmt.Errorf("failed to create auth event indexes: %w", err)
+	}
+
+	// Create index on user_id for listing login alerts, newest first
+	loginAlertUserIndex := `CREATE INDEX IF NOT EXISTS idx_login_alerts_user_id ON login_alerts(user_id, created_at DESC);`
+	if _, err := db.Exec(loginAlertUserIndex); err != nil {
+		return fmt.Errorf("failed to create login alert user index: %w", err)
+	}
+
+	// Create index on service_account_id for listing a service account's API keys
+	apiKeyServiceAccountIndex := `CREATE INDEX IF NOT EXISTS idx_api_keys_service_account_id ON api_keys(service_account_id);`
+	if _, err := db.Exec(apiKeyServiceAccountIndex); err != nil {
+		return fmt.Errorf("failed to create API key service account index: %w", err)
+	}
+
 	log.Println("Database tables created successfully")
 	return nil
+}
+
+// Phone number formats stored by older versions
+const (
+	// legacyPhoneNumberPattern matches any number stored in the local format
+	legacyPhoneNumberPattern = `^0[0-9]{8,9}$`
+
+	// thaiPhoneNumberPattern matches the local format numbers that are valid Thai numbers, as
+	// phone.Thailand defines them: mobile numbers (06, 08 and 09, 10 digits) and landline
+	// numbers (02 to 05 and 07, 9 digits)
+	thaiPhoneNumberPattern = `^0([689][0-9]{8}|[2-57][0-9]{7})$`
+)
+
+// convertPhoneNumbersToE164 converts the phone numbers that older versions stored in the local
+// Thai format, e.g. "0812345678", into E.164, in one transaction so a failure converts none.
+// Users whose number is not a valid Thai number, or whose number is already taken in E.164 by
+// another user, are left unchanged and logged so they can be corrected by hand. Failed attempts
+// counted under both formats of a number are merged.
+func (db *DB) convertPhoneNumbersToE164() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	unconvertibleUsers := `
+	SELECT u.id, u.phone_number, taken.id IS NOT NULL
+	FROM users u
+	LEFT JOIN users taken ON taken.phone_number = '+66' || substr(u.phone_number, 2)
+	WHERE u.phone_number ~ $1 AND (u.phone_number !~ $2 OR taken.id IS NOT NULL);`
+	rows, err := tx.Query(unconvertibleUsers, legacyPhoneNumberPattern, thaiPhoneNumberPattern)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, phoneNumber string
+		var taken bool
+		if err := rows.Scan(&id, &phoneNumber, &taken); err != nil {
+			rows.Close()
+			return err
+		}
+		if taken {
+			log.Printf("Cannot convert phone number %s of user %s to E.164: another user has it", phoneNumber, id)
+		} else {
+			log.Printf("Cannot convert phone number %s of user %s to E.164: not a valid Thai number", phoneNumber, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	conversions := []string{
+		`UPDATE users u SET phone_number = '+66' || substr(u.phone_number, 2)
+		WHERE u.phone_number ~ $1
+			AND NOT EXISTS (SELECT 1 FROM users taken WHERE taken.phone_number = '+66' || substr(u.phone_number, 2));`,
+
+		// Merge failed attempts counted under both formats into the E.164 key
+		`UPDATE login_throttles t SET
+			failed_count = GREATEST(t.failed_count, l.failed_count),
+			last_failed_at = GREATEST(t.last_failed_at, l.last_failed_at),
+			locked_until = GREATEST(t.locked_until, l.locked_until),
+			updated_at = NOW()
+		FROM login_throttles l
+		WHERE l.throttle_key LIKE 'phone:%' AND substr(l.throttle_key, 7) ~ $1
+			AND t.throttle_key = 'phone:+66' || substr(l.throttle_key, 8);`,
+		`DELETE FROM login_throttles l
+		WHERE l.throttle_key LIKE 'phone:%' AND substr(l.throttle_key, 7) ~ $1
+			AND EXISTS (SELECT 1 FROM login_throttles t WHERE t.throttle_key = 'phone:+66' || substr(l.throttle_key, 8));`,
+		`UPDATE login_throttles SET throttle_key = 'phone:+66' || substr(throttle_key, 8)
+		WHERE throttle_key LIKE 'phone:%' AND substr(throttle_key, 7) ~ $1;`,
+
+		`UPDATE login_alerts SET phone_number = '+66' || substr(phone_number, 2)
+		WHERE phone_number ~ $1;`,
+	}
+	for _, conversion := range conversions {
+		if _, err := tx.Exec(conversion, thaiPhoneNumberPattern); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
\ No newline at end of file
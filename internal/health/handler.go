@@ -13,6 +13,7 @@ import (
 type Handler struct {
 	db     *sql.DB
 	config *config.Config
+	jobs   map[string]func() interface{}
 }
 
 // NewHandler creates a new health check handler
@@ -20,17 +21,24 @@ func NewHandler(db *sql.DB, config *config.Config) *Handler {
 	return &Handler{
 		db:     db,
 		config: config,
+		jobs:   map[string]func() interface{}{},
 	}
 }
 
+// RegisterJob adds a background job whose status is reported by the health check
+func (h *Handler) RegisterJob(name string, status func() interface{}) {
+	h.jobs[name] = status
+}
+
 // HealthResponse represents the health check response
 type HealthResponse struct {
-	Status    string            `json:"status"`
-	Timestamp string            `json:"timestamp"`
-	Version   string            `json:"version"`
-	Uptime    string            `json:"uptime"`
-	Database  DatabaseHealth    `json:"database"`
-	System    SystemInfo        `json:"system"`
+	Status    string                 `json:"status"`
+	Timestamp string                 `json:"timestamp"`
+	Version   string                 `json:"version"`
+	Uptime    string                 `json:"uptime"`
+	Database  DatabaseHealth         `json:"database"`
+	System    SystemInfo             `json:"system"`
+	Jobs      map[string]interface{} `json:"jobs,omitempty"` // Status of background jobs
 }
 
 // DatabaseHealth represents database health information
@@ -73,6 +81,14 @@ func (h *Handler) Health(c *fiber.Ctx) error {
 		},
 	}
 
+	// Report the last run of each background job
+	if len(h.jobs) > 0 {
+		healthResponse.Jobs = make(map[string]interface{}, len(h.jobs))
+		for name, status := range h.jobs {
+			healthResponse.Jobs[name] = status()
+		}
+	}
+
 	return response.SendSuccess(c, healthResponse, "Health check completed")
 }
 
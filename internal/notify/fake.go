@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// logNotifier writes notifications to a logger instead of delivering them
+type logNotifier struct {
+	logger *log.Logger
+}
+
+// NewLogNotifier creates a notifier that writes every notification to the logger
+// A nil logger uses the standard logger
+func NewLogNotifier(logger *log.Logger) Notifier {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &logNotifier{
+		logger: logger,
+	}
+}
+
+// Notify logs the notification
+func (n *logNotifier) Notify(msg Message) error {
+	if msg.PhoneNumber == "" {
+		return errors.New("phone number cannot be empty")
+	}
+
+	n.logger.Printf("Notification to %s: %s: %s", msg.PhoneNumber, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileNotification is a notification recorded by the file notifier, one JSON object per line
+type FileNotification struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// fileNotifier appends notifications to a file instead of delivering them
+type fileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileNotifier creates a notifier that appends every notification to the file at path as a JSON line
+func NewFileNotifier(path string) Notifier {
+	return &fileNotifier{
+		path: path,
+	}
+}
+
+// Notify appends the notification to the file
+func (n *fileNotifier) Notify(msg Message) error {
+	if msg.PhoneNumber == "" {
+		return errors.New("phone number cannot be empty")
+	}
+
+	line, err := json.Marshal(FileNotification{To: msg.PhoneNumber, Subject: msg.Subject, Body: msg.Body, SentAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+
+	"tt-stock-api/internal/config"
+	"tt-stock-api/internal/sms"
+)
+
+// Supported notification providers
+const (
+	ProviderLog  = "log"
+	ProviderFile = "file"
+	ProviderSMS  = "sms"
+)
+
+// Message is a notification for one user
+type Message struct {
+	PhoneNumber string // Recipient's phone number
+	Subject     string // Short title, for channels that show one
+	Body        string
+}
+
+// Notifier delivers notifications to users
+// Implementations for other channels, e.g. push notifications, only need to provide Notify
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// NewNotifier creates the notifier for the configured provider
+// The sms provider delivers through sender; log and file never deliver anything and are
+// meant for local development and tests
+func NewNotifier(cfg *config.Config, sender sms.Sender) (Notifier, error) {
+	switch cfg.NotifierProvider {
+	case "", ProviderLog:
+		return NewLogNotifier(nil), nil
+	case ProviderFile:
+		return NewFileNotifier(cfg.NotifierFilePath), nil
+	case ProviderSMS:
+		return NewSMSNotifier(sender), nil
+	default:
+		return nil, fmt.Errorf("unsupported notifier provider: %s", cfg.NotifierProvider)
+	}
+}
+
+// smsNotifier sends notifications as text messages
+type smsNotifier struct {
+	sender sms.Sender
+}
+
+// NewSMSNotifier creates a notifier that sends the body of every notification by SMS
+func NewSMSNotifier(sender sms.Sender) Notifier {
+	return &smsNotifier{
+		sender: sender,
+	}
+}
+
+// Notify sends the notification body to the recipient's phone
+func (n *smsNotifier) Notify(msg Message) error {
+	if msg.PhoneNumber == "" {
+		return errors.New("phone number cannot be empty")
+	}
+
+	if err := n.sender.Send(msg.PhoneNumber, msg.Body); err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tt-stock-api/internal/config"
+	"tt-stock-api/internal/sms"
+)
+
+func TestNewNotifier(t *testing.T) {
+	for _, provider := range []string{"", ProviderLog, ProviderFile, ProviderSMS} {
+		notifier, err := NewNotifier(&config.Config{NotifierProvider: provider, NotifierFilePath: filepath.Join(t.TempDir(), "notifications.log")}, sms.NewLogSender(nil))
+		assert.NoError(t, err, provider)
+		assert.NotNil(t, notifier, provider)
+	}
+
+	notifier, err := NewNotifier(&config.Config{NotifierProvider: "carrier-pigeon"}, nil)
+	assert.Error(t, err)
+	assert.Nil(t, notifier)
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	notifier := NewLogNotifier(log.New(&buf, "", 0))
+
+	require.NoError(t, notifier.Notify(Message{PhoneNumber: "0812345678", Subject: "New login", Body: "Login from a new device"}))
+	assert.Equal(t, "Notification to 0812345678: New login: Login from a new device\n", buf.String())
+
+	assert.Error(t, notifier.Notify(Message{Body: "Login from a new device"}))
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.log")
+	notifier := NewFileNotifier(path)
+
+	require.NoError(t, notifier.Notify(Message{PhoneNumber: "0812345678", Subject: "New login", Body: "first"}))
+	require.NoError(t, notifier.Notify(Message{PhoneNumber: "0898765432", Subject: "New login", Body: "second"}))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var notifications []FileNotification
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var notification FileNotification
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &notification))
+		notifications = append(notifications, notification)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "0812345678", notifications[0].To)
+	assert.Equal(t, "New login", notifications[0].Subject)
+	assert.Equal(t, "first", notifications[0].Body)
+	assert.Equal(t, "0898765432", notifications[1].To)
+	assert.Equal(t, "second", notifications[1].Body)
+	assert.False(t, notifications[0].SentAt.IsZero())
+}
+
+func TestSMSNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	notifier := NewSMSNotifier(sms.NewFileSender(path))
+
+	require.NoError(t, notifier.Notify(Message{PhoneNumber: "0812345678", Subject: "New login", Body: "Login from a new device"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var message sms.FileMessage
+	require.NoError(t, json.Unmarshal(bytes.TrimSpace(data), &message))
+	assert.Equal(t, "0812345678", message.To)
+	assert.Equal(t, "Login from a new device", message.Message)
+
+	assert.Error(t, notifier.Notify(Message{Body: "Login from a new device"}))
+}
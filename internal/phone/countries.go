@@ -0,0 +1,50 @@
+package phone
+
+import "strings"
+
+// Thailand accepts Thai mobile numbers (06, 08 and 09, 10 digits with the trunk prefix) and
+// landline numbers (02 to 05 and 07, 9 digits with the trunk prefix)
+var Thailand = NumberingPlan{
+	CountryISO:  "TH",
+	CountryCode: "66",
+	Ranges: []NumberRange{
+		{Type: TypeMobile, Prefixes: []string{"6", "8", "9"}, Lengths: []int{9}},
+		{Type: TypeLandline, Prefixes: []string{"2", "3", "4", "5", "7"}, Lengths: []int{8}},
+	},
+}
+
+// Laos accepts Lao mobile numbers (020 with 8 more digits, 030 with 7) and landline numbers
+// (a provincial area code from 021 to 088 with 6 more digits)
+var Laos = NumberingPlan{
+	CountryISO:  "LA",
+	CountryCode: "856",
+	Ranges: []NumberRange{
+		{Type: TypeMobile, Prefixes: []string{"20"}, Lengths: []int{10}},
+		{Type: TypeMobile, Prefixes: []string{"30"}, Lengths: []int{9}},
+		{Type: TypeLandline, Prefixes: []string{"21", "23", "31", "34", "36", "38", "41", "51", "54", "61", "64", "71", "74", "81", "84", "86", "88"}, Lengths: []int{8}},
+	},
+}
+
+// Malaysia accepts Malaysian mobile numbers (01x, 10 or 11 digits with the trunk prefix) and
+// landline numbers (03 to 09, 9 or 10 digits with the trunk prefix)
+var Malaysia = NumberingPlan{
+	CountryISO:  "MY",
+	CountryCode: "60",
+	Ranges: []NumberRange{
+		{Type: TypeMobile, Prefixes: []string{"1"}, Lengths: []int{9, 10}},
+		{Type: TypeLandline, Prefixes: []string{"3", "4", "5", "6", "7", "8", "9"}, Lengths: []int{8, 9}},
+	},
+}
+
+// builtInCountries are the countries that can be enabled by their ISO code
+var builtInCountries = map[string]Validator{
+	Thailand.CountryISO: Thailand,
+	Laos.CountryISO:     Laos,
+	Malaysia.CountryISO: Malaysia,
+}
+
+// Lookup returns the built-in validator of a country by its ISO 3166-1 alpha-2 code
+func Lookup(country string) (Validator, bool) {
+	v, ok := builtInCountries[strings.ToUpper(country)]
+	return v, ok
+}
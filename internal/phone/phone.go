@@ -0,0 +1,87 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// trunkPrefix is the digit that starts national numbers dialled within the country
+const trunkPrefix = "0"
+
+// Number types
+const (
+	TypeMobile   = "mobile"
+	TypeLandline = "landline"
+)
+
+// Parse errors; their messages are shown to users
+var (
+	ErrInvalidFormat      = errors.New("invalid phone number format")
+	ErrUnsupportedCountry = errors.New("invalid phone number: numbers of this country are not accepted")
+	ErrInvalidPrefix      = errors.New("invalid phone number: not a mobile or landline number")
+	ErrInvalidLength      = errors.New("invalid phone number: wrong number of digits")
+)
+
+// Number is a parsed phone number
+type Number struct {
+	Country     string // ISO 3166-1 alpha-2 code of the country, e.g. "TH"
+	CountryCode string // Country calling code without the +, e.g. "66"
+	National    string // National significant number without the trunk prefix, e.g. "812345678"
+	Type        string // TypeMobile or TypeLandline
+}
+
+// E164 returns the number in E.164 format, e.g. "+66812345678", the format numbers are stored in
+func (n Number) E164() string {
+	return "+" + n.CountryCode + n.National
+}
+
+// Local returns the number the way it is written within its country, e.g. "0812345678"
+func (n Number) Local() string {
+	return trunkPrefix + n.National
+}
+
+// thaiNumbers accepts Thai numbers only; it backs the package-level functions
+var thaiNumbers = NewRegistry(Thailand)
+
+// Parse parses a Thai phone number in any of the formats Registry.Parse accepts
+// Use a Registry to accept numbers of other countries.
+func Parse(input string) (Number, error) {
+	return thaiNumbers.Parse(input)
+}
+
+// Normalize parses a Thai phone number and returns it in E.164 format
+func Normalize(input string) (string, error) {
+	return thaiNumbers.Normalize(input)
+}
+
+// FormatLocal returns a stored phone number for display: Thai numbers in the local format,
+// e.g. "0812345678", and foreign numbers unchanged in E.164 so their country stays visible.
+// Numbers that cannot be parsed are returned unchanged as well.
+func FormatLocal(phoneNumber string) string {
+	number, err := thaiNumbers.Parse(phoneNumber)
+	if err != nil {
+		return phoneNumber
+	}
+	return number.Local()
+}
+
+// stripSeparators removes the separators people type between digits and reports whether the
+// number starts with a +. It returns an empty string if anything else than digits remains.
+func stripSeparators(input string) (string, bool) {
+	input = strings.TrimSpace(input)
+	international := strings.HasPrefix(input, "+")
+	input = strings.TrimPrefix(input, "+")
+
+	var digits strings.Builder
+	for _, r := range input {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", international
+		}
+	}
+
+	return digits.String(), international
+}
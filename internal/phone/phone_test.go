@@ -0,0 +1,71 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		expectedE164 string
+		expectedType string
+		expectedErr  error
+	}{
+		{name: "local mobile", input: "0812345678", expectedE164: "+66812345678", expectedType: TypeMobile},
+		{name: "local mobile with spaces", input: "081 234 5678", expectedE164: "+66812345678", expectedType: TypeMobile},
+		{name: "local mobile with dashes", input: "081-234-5678", expectedE164: "+66812345678", expectedType: TypeMobile},
+		{name: "international mobile", input: "+66812345678", expectedE164: "+66812345678", expectedType: TypeMobile},
+		{name: "international mobile with separators", input: "+66 81-234-5678", expectedE164: "+66812345678", expectedType: TypeMobile},
+		{name: "international mobile with trunk prefix", input: "+66 (0)81 234 5678", expectedE164: "+66812345678", expectedType: TypeMobile},
+		{name: "country code without plus", input: "66812345678", expectedE164: "+66812345678", expectedType: TypeMobile},
+		{name: "06 mobile", input: "0612345678", expectedE164: "+66612345678", expectedType: TypeMobile},
+		{name: "09 mobile", input: " 095.123.4567 ", expectedE164: "+66951234567", expectedType: TypeMobile},
+		{name: "Bangkok landline", input: "02-123-4567", expectedE164: "+6621234567", expectedType: TypeLandline},
+		{name: "regional landline", input: "+66 53 123 456", expectedE164: "+6653123456", expectedType: TypeLandline},
+		{name: "empty", input: "", expectedErr: ErrInvalidFormat},
+		{name: "letters", input: "08123456ab", expectedErr: ErrInvalidFormat},
+		{name: "other country", input: "+14155552671", expectedErr: ErrUnsupportedCountry},
+		{name: "no prefix", input: "812345678", expectedErr: ErrInvalidPrefix},
+		{name: "unknown prefix", input: "0112345678", expectedErr: ErrInvalidPrefix},
+		{name: "mobile too short", input: "081234567", expectedErr: ErrInvalidLength},
+		{name: "mobile too long", input: "08123456789", expectedErr: ErrInvalidLength},
+		{name: "landline too long", input: "0212345678", expectedErr: ErrInvalidLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			number, err := Parse(tt.input)
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedE164, number.E164())
+			assert.Equal(t, tt.expectedType, number.Type)
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	normalized, err := Normalize("081 234 5678")
+	assert.NoError(t, err)
+	assert.Equal(t, "+66812345678", normalized)
+
+	_, err = Normalize("12345")
+	assert.Error(t, err)
+}
+
+func TestFormatLocal(t *testing.T) {
+	assert.Equal(t, "0812345678", FormatLocal("+66812345678"))
+	assert.Equal(t, "021234567", FormatLocal("+6621234567"))
+	// Numbers stored before normalization are already local
+	assert.Equal(t, "0812345678", FormatLocal("0812345678"))
+	// Anything else is shown as stored
+	assert.Equal(t, "+14155552671", FormatLocal("+14155552671"))
+	assert.Equal(t, "", FormatLocal(""))
+}
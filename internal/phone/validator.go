@@ -0,0 +1,175 @@
+package phone
+
+import (
+	"fmt"
+	"strings"
+
+	"tt-stock-api/internal/config"
+)
+
+// Validator checks the phone numbers of one country
+// Countries with a plain prefix-and-length numbering plan can use NumberingPlan; others
+// can implement Validate themselves.
+type Validator interface {
+	Country() string     // ISO 3166-1 alpha-2 code, e.g. "TH"
+	CallingCode() string // Country calling code without the +, e.g. "66"
+
+	// Validate checks a national significant number, without the trunk prefix, and
+	// returns its type (TypeMobile or TypeLandline)
+	Validate(national string) (string, error)
+}
+
+// NumberRange is a block of numbers of one type in a numbering plan
+type NumberRange struct {
+	Type     string
+	Prefixes []string // Leading digits of the national significant number
+	Lengths  []int    // Allowed lengths of the national significant number
+}
+
+// NumberingPlan is a Validator for a country whose numbers are told apart by their leading digits
+type NumberingPlan struct {
+	CountryISO  string
+	CountryCode string
+	Ranges      []NumberRange
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the plan's country
+func (p NumberingPlan) Country() string {
+	return p.CountryISO
+}
+
+// CallingCode returns the country calling code of the plan's country
+func (p NumberingPlan) CallingCode() string {
+	return p.CountryCode
+}
+
+// Validate returns the type of the first range whose prefix and length match the number
+// Numbers with a known prefix but a wrong length return ErrInvalidLength.
+func (p NumberingPlan) Validate(national string) (string, error) {
+	knownPrefix := false
+	for _, r := range p.Ranges {
+		if !hasAnyPrefix(national, r.Prefixes) {
+			continue
+		}
+		knownPrefix = true
+		for _, length := range r.Lengths {
+			if len(national) == length {
+				return r.Type, nil
+			}
+		}
+	}
+
+	if knownPrefix {
+		return "", ErrInvalidLength
+	}
+	return "", ErrInvalidPrefix
+}
+
+// hasAnyPrefix reports whether s starts with one of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry parses phone numbers of the countries it has a Validator for
+// Numbers of the default country may be typed in the local format, starting with the trunk
+// prefix 0; numbers of the other countries need their country calling code.
+type Registry struct {
+	defaultCountry Validator
+	byCallingCode  map[string]Validator
+}
+
+// NewRegistry creates a registry for the default country and any number of other countries
+// A later validator for the same calling code replaces an earlier one.
+func NewRegistry(defaultCountry Validator, others ...Validator) *Registry {
+	r := &Registry{
+		defaultCountry: defaultCountry,
+		byCallingCode:  make(map[string]Validator, len(others)+1),
+	}
+
+	for _, v := range others {
+		r.byCallingCode[v.CallingCode()] = v
+	}
+	r.byCallingCode[defaultCountry.CallingCode()] = defaultCountry
+
+	return r
+}
+
+// NewRegistryFromConfig creates the registry of the shop: Thailand by default, and the
+// built-in countries listed in PhoneCountries
+func NewRegistryFromConfig(cfg *config.Config) (*Registry, error) {
+	others := make([]Validator, 0, len(cfg.PhoneCountries))
+	for _, country := range cfg.PhoneCountries {
+		v, ok := Lookup(country)
+		if !ok {
+			return nil, fmt.Errorf("unsupported phone country: %s", country)
+		}
+		others = append(others, v)
+	}
+
+	return NewRegistry(Thailand, others...), nil
+}
+
+// Parse parses a phone number in any of the common input formats: local to the default
+// country ("0812345678"), or international with or without the + ("+66812345678",
+// "66812345678"), with spaces, dashes, dots and parentheses between the digits.
+// A trunk prefix after the calling code, as in "+66 (0)81 234 5678", is ignored.
+func (r *Registry) Parse(input string) (Number, error) {
+	digits, international := stripSeparators(input)
+	if digits == "" {
+		return Number{}, ErrInvalidFormat
+	}
+
+	var validator Validator
+	var national string
+	switch {
+	case !international && strings.HasPrefix(digits, trunkPrefix):
+		validator = r.defaultCountry
+		national = strings.TrimPrefix(digits, trunkPrefix)
+	default:
+		validator, national = r.splitCallingCode(digits)
+		if validator == nil {
+			if international {
+				return Number{}, ErrUnsupportedCountry
+			}
+			return Number{}, ErrInvalidPrefix
+		}
+		national = strings.TrimPrefix(national, trunkPrefix)
+	}
+
+	numberType, err := validator.Validate(national)
+	if err != nil {
+		return Number{}, err
+	}
+
+	return Number{
+		Country:     validator.Country(),
+		CountryCode: validator.CallingCode(),
+		National:    national,
+		Type:        numberType,
+	}, nil
+}
+
+// Normalize parses a phone number and returns it in E.164 format
+func (r *Registry) Normalize(input string) (string, error) {
+	number, err := r.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	return number.E164(), nil
+}
+
+// splitCallingCode finds the validator whose calling code starts the digits and returns it
+// with the rest of the digits. Calling codes are 1 to 3 digits long and none is a prefix of another.
+func (r *Registry) splitCallingCode(digits string) (Validator, string) {
+	for length := 1; length <= 3 && length < len(digits); length++ {
+		if v, ok := r.byCallingCode[digits[:length]]; ok {
+			return v, digits[length:]
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,73 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tt-stock-api/internal/config"
+)
+
+func TestRegistry_Parse(t *testing.T) {
+	registry := NewRegistry(Thailand, Laos, Malaysia)
+
+	tests := []struct {
+		name            string
+		input           string
+		expectedE164    string
+		expectedCountry string
+		expectedType    string
+		expectedErr     error
+	}{
+		{name: "Thai local number", input: "081-234-5678", expectedE164: "+66812345678", expectedCountry: "TH", expectedType: TypeMobile},
+		{name: "Thai international number", input: "+66 81 234 5678", expectedE164: "+66812345678", expectedCountry: "TH", expectedType: TypeMobile},
+		{name: "Lao mobile number", input: "+856 20 5555 1234", expectedE164: "+8562055551234", expectedCountry: "LA", expectedType: TypeMobile},
+		{name: "Lao mobile number without plus", input: "856 30 555 1234", expectedE164: "+856305551234", expectedCountry: "LA", expectedType: TypeMobile},
+		{name: "Lao landline number with trunk prefix", input: "+856 (0)21 212 345", expectedE164: "+85621212345", expectedCountry: "LA", expectedType: TypeLandline},
+		{name: "Malaysian mobile number", input: "+60 12-345 6789", expectedE164: "+60123456789", expectedCountry: "MY", expectedType: TypeMobile},
+		{name: "Malaysian 011 mobile number", input: "+60 11-2345 6789", expectedE164: "+601123456789", expectedCountry: "MY", expectedType: TypeMobile},
+		{name: "Malaysian landline number", input: "+60 3-2345 6789", expectedE164: "+60323456789", expectedCountry: "MY", expectedType: TypeLandline},
+		// Local numbers always belong to the default country
+		{name: "Lao number in local format", input: "020 5555 1234", expectedErr: ErrInvalidLength},
+		{name: "country not enabled", input: "+84 91 234 5678", expectedErr: ErrUnsupportedCountry},
+		{name: "unknown Lao prefix", input: "+856 99 555 1234", expectedErr: ErrInvalidPrefix},
+		{name: "Malaysian mobile number too short", input: "+60 12 345 67", expectedErr: ErrInvalidLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			number, err := registry.Parse(tt.input)
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedE164, number.E164())
+			assert.Equal(t, tt.expectedCountry, number.Country)
+			assert.Equal(t, tt.expectedType, number.Type)
+		})
+	}
+}
+
+func TestNewRegistryFromConfig(t *testing.T) {
+	registry, err := NewRegistryFromConfig(&config.Config{})
+	require.NoError(t, err)
+	_, err = registry.Normalize("+8562055551234")
+	assert.ErrorIs(t, err, ErrUnsupportedCountry)
+
+	registry, err = NewRegistryFromConfig(&config.Config{PhoneCountries: []string{"la"}})
+	require.NoError(t, err)
+	normalized, err := registry.Normalize("+8562055551234")
+	assert.NoError(t, err)
+	assert.Equal(t, "+8562055551234", normalized)
+	// Thailand stays the default country
+	normalized, err = registry.Normalize("0812345678")
+	assert.NoError(t, err)
+	assert.Equal(t, "+66812345678", normalized)
+
+	registry, err = NewRegistryFromConfig(&config.Config{PhoneCountries: []string{"XX"}})
+	assert.Error(t, err)
+	assert.Nil(t, registry)
+}
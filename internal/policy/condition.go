@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Condition operators
+const (
+	OperatorEq     = "eq"
+	OperatorNe     = "ne"
+	OperatorLt     = "lt"
+	OperatorLte    = "lte"
+	OperatorGt     = "gt"
+	OperatorGte    = "gte"
+	OperatorAbsLte = "abs_lte" // |attribute| <= value
+	OperatorIn     = "in"      // value is a list
+)
+
+// Condition restricts a rule to requests where an attribute compares to a value
+// Attributes are "subject.<name>" or "resource.<name>"; the right-hand side is either
+// the literal Value or, when Ref is set, another attribute. A missing attribute never
+// satisfies a condition.
+//
+// Example: {"attr": "resource.branch", "op": "eq", "ref": "subject.branch"}
+type Condition struct {
+	Attribute string `json:"attr"`
+	Operator  string `json:"op"`
+	Value     any    `json:"value,omitempty"`
+	Ref       string `json:"ref,omitempty"`
+}
+
+// evaluate reports whether the condition holds, and whether it could be evaluated at all
+// A condition with an unknown operator or attribute namespace is invalid.
+func (c Condition) evaluate(subject Subject, resource *Resource) (holds bool, valid bool) {
+	left, ok, valid := lookup(c.Attribute, subject, resource)
+	if !valid {
+		return false, false
+	}
+	if !ok {
+		return false, true
+	}
+
+	right := c.Value
+	if c.Ref != "" {
+		right, ok, valid = lookup(c.Ref, subject, resource)
+		if !valid {
+			return false, false
+		}
+		if !ok {
+			return false, true
+		}
+	}
+
+	switch c.Operator {
+	case OperatorEq:
+		return equal(left, right), true
+	case OperatorNe:
+		return !equal(left, right), true
+	case OperatorLt, OperatorLte, OperatorGt, OperatorGte, OperatorAbsLte:
+		l, lok := toNumber(left)
+		r, rok := toNumber(right)
+		if !lok || !rok {
+			return false, true
+		}
+		switch c.Operator {
+		case OperatorLt:
+			return l < r, true
+		case OperatorLte:
+			return l <= r, true
+		case OperatorGt:
+			return l > r, true
+		case OperatorGte:
+			return l >= r, true
+		default:
+			return math.Abs(l) <= r, true
+		}
+	case OperatorIn:
+		list, ok := right.([]any)
+		if !ok {
+			return false, false
+		}
+		for _, item := range list {
+			if equal(left, item) {
+				return true, true
+			}
+		}
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// lookup resolves an attribute path against the subject and resource
+// ok is false if the attribute is not set; valid is false for an unknown namespace
+func lookup(path string, subject Subject, resource *Resource) (value any, ok bool, valid bool) {
+	namespace, name, found := strings.Cut(path, ".")
+	if !found || name == "" {
+		return nil, false, false
+	}
+
+	switch namespace {
+	case "subject":
+		switch name {
+		case "user_id":
+			return subject.UserID.String(), true, true
+		case "role":
+			return subject.Role, subject.Role != "", true
+		case "branch":
+			return subject.Branch, subject.Branch != "", true
+		default:
+			return nil, false, true
+		}
+	case "resource":
+		if resource == nil {
+			return nil, false, true
+		}
+		if name == "branch" {
+			return resource.Branch, resource.Branch != "", true
+		}
+		value, ok := resource.Attributes[name]
+		return value, ok && value != nil, true
+	default:
+		return nil, false, false
+	}
+}
+
+// equal compares two attribute values, numerically if both are numbers
+func equal(a, b any) bool {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			return x == y
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// toNumber converts numeric values, including numeric strings, to float64
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"tt-stock-api/internal/user"
+)
+
+// staffStockAdjustLimit is the largest quantity change staff may make in one stock adjustment
+const staffStockAdjustLimit = 4
+
+// DefaultRules returns the built-in role rules that apply before any rules stored in the database
+// Stored rules can add to them or, with deny rules and user overrides, narrow them down.
+func DefaultRules() []Rule {
+	rules := []Rule{
+		// Staff may only adjust stock at their own branch, by a few units at a time
+		{
+			Permission: PermissionStockAdjust,
+			Role:       user.RoleStaff,
+			Effect:     EffectAllow,
+			Conditions: []Condition{
+				{Attribute: "resource.branch", Operator: OperatorEq, Ref: "subject.branch"},
+				{Attribute: "resource.quantity_delta", Operator: OperatorAbsLte, Value: staffStockAdjustLimit},
+			},
+		},
+		{Permission: PermissionStockAdjust, Role: user.RoleManager, Effect: EffectAllow},
+		{Permission: PermissionPriceOverride, Role: user.RoleManager, Effect: EffectAllow},
+		{Permission: PermissionReportViewCost, Role: user.RoleManager, Effect: EffectAllow},
+	}
+
+	for _, role := range []string{user.RoleOwner, user.RoleAdmin} {
+		for _, permission := range []string{
+			PermissionStockAdjust,
+			PermissionPriceOverride,
+			PermissionUserManage,
+			PermissionReportViewCost,
+			PermissionShopDeviceManage,
+			PermissionAuditView,
+			PermissionLoginAlertReview,
+			PermissionServiceAccountManage,
+			PermissionTokenIntrospect,
+			PermissionTokenRevoke,
+		} {
+			rules = append(rules, Rule{Permission: permission, Role: role, Effect: EffectAllow})
+		}
+	}
+
+	// Only admins may manage admin accounts, so owners cannot create or promote one
+	rules = append(rules, Rule{
+		Permission: PermissionUserManage,
+		Role:       user.RoleOwner,
+		Effect:     EffectDeny,
+		Conditions: []Condition{
+			{Attribute: "resource.role", Operator: OperatorEq, Value: user.RoleAdmin},
+		},
+	})
+
+	return rules
+}
@@ -0,0 +1,247 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Permissions checked by the API
+const (
+	PermissionStockAdjust          = "stock.adjust"
+	PermissionPriceOverride        = "price.override"
+	PermissionUserManage           = "user.manage"
+	PermissionReportViewCost       = "report.view_cost"
+	PermissionShopDeviceManage     = "shop_device.manage"
+	PermissionAuditView            = "audit.view"
+	PermissionLoginAlertReview     = "login_alert.review"
+	PermissionServiceAccountManage = "service_account.manage"
+	PermissionTokenIntrospect      = "token.introspect"
+	PermissionTokenRevoke          = "token.revoke"
+)
+
+// Permissions returns every permission checked by the API
+func Permissions() []string {
+	return []string{
+		PermissionStockAdjust,
+		PermissionPriceOverride,
+		PermissionUserManage,
+		PermissionReportViewCost,
+		PermissionShopDeviceManage,
+		PermissionAuditView,
+		PermissionLoginAlertReview,
+		PermissionServiceAccountManage,
+		PermissionTokenIntrospect,
+		PermissionTokenRevoke,
+	}
+}
+
+// IsValidPermission reports whether permission is checked by the API
+func IsValidPermission(permission string) bool {
+	for _, known := range Permissions() {
+		if permission == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule effects
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// ErrDenied is returned by Authorize when the subject may not perform the action
+var ErrDenied = errors.New("permission denied")
+
+// Subject is the user or service account an authorization decision is made for
+type Subject struct {
+	UserID uuid.UUID // ID of the user, or of the service account
+	Role   string
+	Branch string // Branch the user works at; empty if not assigned to one
+
+	// Service accounts hold exactly the permissions granted to the API key they authenticated
+	// with; the rules do not apply to them
+	ServiceAccount bool
+	Permissions    []string
+}
+
+// Resource describes what an action is performed on, for rules with conditions
+// Attributes hold action-specific values, e.g. "quantity_delta" for stock adjustments
+type Resource struct {
+	Branch     string
+	Attributes map[string]any
+}
+
+// Rule grants or denies a permission to a role, or to a single user as an override
+// Exactly one of Role and UserID is set. The rule only applies when all conditions hold.
+type Rule struct {
+	ID         uuid.UUID   `json:"id" db:"id"`
+	Permission string      `json:"permission" db:"permission"`
+	Role       string      `json:"role,omitempty" db:"role"`
+	UserID     uuid.UUID   `json:"user_id,omitempty" db:"user_id"`
+	Effect     string      `json:"effect" db:"effect"`
+	Conditions []Condition `json:"conditions" db:"conditions"`
+}
+
+// Authorizer decides whether a subject may perform an action
+type Authorizer interface {
+	// Authorize returns nil if the subject may perform the action on the resource and
+	// ErrDenied if not. A nil resource asks whether the subject holds the permission at all,
+	// e.g. to guard a route before the resource is known: conditional grants then count as
+	// held, and only unconditional denials apply.
+	Authorize(ctx context.Context, subject Subject, action string, resource *Resource) error
+}
+
+// engine evaluates the built-in rules together with the rules stored in the database
+type engine struct {
+	repo Repository
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	rules    []Rule
+	loadedAt time.Time
+}
+
+// NewEngine creates an authorizer for the built-in rules and the rules of repo
+// Stored rules are reloaded at most every ttl, so changes take up to ttl to apply;
+// a ttl of zero or less reloads them on every check.
+func NewEngine(repo Repository, ttl time.Duration) Authorizer {
+	return &engine{
+		repo: repo,
+		ttl:  ttl,
+	}
+}
+
+// Authorize evaluates the rules for the action
+// User rules override role rules: a matching user rule decides on its own, and only if none
+// matches are the role rules consulted. At each level a matching deny beats a matching allow,
+// and without any matching allow the action is denied.
+// Service accounts are checked against the permissions of their API key instead.
+func (e *engine) Authorize(ctx context.Context, subject Subject, action string, resource *Resource) error {
+	if subject.ServiceAccount {
+		if !evaluateServiceAccount(subject, action, resource) {
+			return ErrDenied
+		}
+		return nil
+	}
+
+	rules, err := e.load()
+	if err != nil {
+		return err
+	}
+
+	if !evaluate(rules, subject, action, resource) {
+		return ErrDenied
+	}
+
+	return nil
+}
+
+// load returns the built-in and stored rules, reloading the stored rules when the cache has expired
+func (e *engine) load() ([]Rule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.rules != nil && time.Since(e.loadedAt) < e.ttl {
+		return e.rules, nil
+	}
+
+	stored, err := e.repo.ListRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy rules: %w", err)
+	}
+
+	e.rules = append(DefaultRules(), stored...)
+	e.loadedAt = time.Now()
+	return e.rules, nil
+}
+
+// evaluate reports whether the rules allow the subject to perform the action
+func evaluate(rules []Rule, subject Subject, action string, resource *Resource) bool {
+	var userAllow, userDeny, roleAllow, roleDeny bool
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Permission != action {
+			continue
+		}
+
+		userRule := rule.UserID != uuid.Nil
+		if userRule && rule.UserID != subject.UserID {
+			continue
+		}
+		if !userRule && (rule.Role == "" || rule.Role != subject.Role) {
+			continue
+		}
+
+		if !rule.applies(subject, resource) {
+			continue
+		}
+
+		deny := rule.Effect != EffectAllow
+		switch {
+		case userRule && deny:
+			userDeny = true
+		case userRule:
+			userAllow = true
+		case deny:
+			roleDeny = true
+		default:
+			roleAllow = true
+		}
+	}
+
+	if userDeny || userAllow {
+		return !userDeny
+	}
+	return roleAllow && !roleDeny
+}
+
+// evaluateServiceAccount reports whether a service account may perform the action
+// The action must be one of the API key's permissions. A service account assigned to a branch
+// may only act on resources of that branch.
+func evaluateServiceAccount(subject Subject, action string, resource *Resource) bool {
+	granted := false
+	for _, permission := range subject.Permissions {
+		if permission == action {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return false
+	}
+
+	if resource != nil && subject.Branch != "" && resource.Branch != subject.Branch {
+		return false
+	}
+	return true
+}
+
+// applies reports whether the rule's conditions hold
+// Rules that cannot be evaluated fail closed: a malformed condition makes an allow rule
+// grant nothing and a deny rule deny everything it names.
+func (r *Rule) applies(subject Subject, resource *Resource) bool {
+	if resource == nil {
+		// Only the permission itself is being checked
+		return r.Effect == EffectAllow || len(r.Conditions) == 0
+	}
+
+	for _, condition := range r.Conditions {
+		holds, valid := condition.evaluate(subject, resource)
+		if !valid {
+			return r.Effect != EffectAllow
+		}
+		if !holds {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,299 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tt-stock-api/internal/user"
+)
+
+// countingRepository counts rule loads and can be switched to fail
+type countingRepository struct {
+	rules []Rule
+	loads int
+	err   error
+}
+
+func (r *countingRepository) ListRules() ([]Rule, error) {
+	r.loads++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.rules, nil
+}
+
+func stockAdjustment(branch string, delta any) *Resource {
+	return &Resource{Branch: branch, Attributes: map[string]any{"quantity_delta": delta}}
+}
+
+func TestEngine_DefaultRolePermissions(t *testing.T) {
+	engine := NewEngine(NewStaticRepository(), 0)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		role       string
+		permission string
+		allowed    bool
+	}{
+		{name: "Staff holds stock adjust", role: user.RoleStaff, permission: PermissionStockAdjust, allowed: true},
+		{name: "Staff cannot override prices", role: user.RoleStaff, permission: PermissionPriceOverride, allowed: false},
+		{name: "Staff cannot manage shop devices", role: user.RoleStaff, permission: PermissionShopDeviceManage, allowed: false},
+		{name: "Manager overrides prices", role: user.RoleManager, permission: PermissionPriceOverride, allowed: true},
+		{name: "Manager views cost reports", role: user.RoleManager, permission: PermissionReportViewCost, allowed: true},
+		{name: "Manager cannot manage users", role: user.RoleManager, permission: PermissionUserManage, allowed: false},
+		{name: "Owner manages users", role: user.RoleOwner, permission: PermissionUserManage, allowed: true},
+		{name: "Owner manages shop devices", role: user.RoleOwner, permission: PermissionShopDeviceManage, allowed: true},
+		{name: "Admin manages shop devices", role: user.RoleAdmin, permission: PermissionShopDeviceManage, allowed: true},
+		{name: "Owner manages service accounts", role: user.RoleOwner, permission: PermissionServiceAccountManage, allowed: true},
+		{name: "Manager cannot manage service accounts", role: user.RoleManager, permission: PermissionServiceAccountManage, allowed: false},
+		{name: "Admin may grant token introspection", role: user.RoleAdmin, permission: PermissionTokenIntrospect, allowed: true},
+		{name: "Manager cannot grant token revocation", role: user.RoleManager, permission: PermissionTokenRevoke, allowed: false},
+		{name: "Unknown role", role: "intern", permission: PermissionStockAdjust, allowed: false},
+		{name: "Unknown permission", role: user.RoleOwner, permission: "stock.delete", allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.Authorize(ctx, Subject{UserID: uuid.New(), Role: tt.role}, tt.permission, nil)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrDenied)
+			}
+		})
+	}
+}
+
+func TestEngine_StaffStockAdjustConditions(t *testing.T) {
+	engine := NewEngine(NewStaticRepository(), 0)
+	ctx := context.Background()
+	staff := Subject{UserID: uuid.New(), Role: user.RoleStaff, Branch: "silom"}
+
+	tests := []struct {
+		name     string
+		subject  Subject
+		resource *Resource
+		allowed  bool
+	}{
+		{name: "Own branch within limit", subject: staff, resource: stockAdjustment("silom", 3), allowed: true},
+		{name: "Own branch at limit", subject: staff, resource: stockAdjustment("silom", 4), allowed: true},
+		{name: "Own branch negative at limit", subject: staff, resource: stockAdjustment("silom", -4), allowed: true},
+		{name: "Own branch over limit", subject: staff, resource: stockAdjustment("silom", 5), allowed: false},
+		{name: "Own branch negative over limit", subject: staff, resource: stockAdjustment("silom", -5.5), allowed: false},
+		{name: "Numeric string delta", subject: staff, resource: stockAdjustment("silom", "2"), allowed: true},
+		{name: "JSON number delta", subject: staff, resource: stockAdjustment("silom", json.Number("2")), allowed: true},
+		{name: "Other branch", subject: staff, resource: stockAdjustment("sathorn", 1), allowed: false},
+		{name: "Missing delta", subject: staff, resource: &Resource{Branch: "silom"}, allowed: false},
+		{name: "Staff without branch", subject: Subject{UserID: uuid.New(), Role: user.RoleStaff}, resource: stockAdjustment("", 1), allowed: false},
+		{name: "Manager is not limited", subject: Subject{UserID: uuid.New(), Role: user.RoleManager, Branch: "silom"}, resource: stockAdjustment("sathorn", 100), allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.Authorize(ctx, tt.subject, PermissionStockAdjust, tt.resource)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrDenied)
+			}
+		})
+	}
+}
+
+func TestEngine_UserManageAdminAccounts(t *testing.T) {
+	engine := NewEngine(NewStaticRepository(), 0)
+	ctx := context.Background()
+	owner := Subject{UserID: uuid.New(), Role: user.RoleOwner}
+	admin := Subject{UserID: uuid.New(), Role: user.RoleAdmin}
+	account := func(role string) *Resource {
+		return &Resource{Attributes: map[string]any{"role": role}}
+	}
+
+	assert.NoError(t, engine.Authorize(ctx, owner, PermissionUserManage, account(user.RoleManager)))
+	assert.ErrorIs(t, engine.Authorize(ctx, owner, PermissionUserManage, account(user.RoleAdmin)), ErrDenied)
+	assert.NoError(t, engine.Authorize(ctx, admin, PermissionUserManage, account(user.RoleAdmin)))
+
+	// Without an account, only the permission itself is checked
+	assert.NoError(t, engine.Authorize(ctx, owner, PermissionUserManage, nil))
+}
+
+func TestEngine_UserOverrides(t *testing.T) {
+	ctx := context.Background()
+	trusted := Subject{UserID: uuid.New(), Role: user.RoleStaff, Branch: "silom"}
+	suspended := Subject{UserID: uuid.New(), Role: user.RoleManager, Branch: "silom"}
+	other := Subject{UserID: uuid.New(), Role: user.RoleManager, Branch: "silom"}
+
+	engine := NewEngine(NewStaticRepository(
+		// A trusted staff member may override prices and adjust stock by up to 20
+		Rule{Permission: PermissionPriceOverride, UserID: trusted.UserID, Effect: EffectAllow},
+		Rule{
+			Permission: PermissionStockAdjust,
+			UserID:     trusted.UserID,
+			Effect:     EffectAllow,
+			Conditions: []Condition{{Attribute: "resource.quantity_delta", Operator: OperatorAbsLte, Value: 20.0}},
+		},
+		// One manager loses price overrides
+		Rule{Permission: PermissionPriceOverride, UserID: suspended.UserID, Effect: EffectDeny},
+	), 0)
+
+	assert.NoError(t, engine.Authorize(ctx, trusted, PermissionPriceOverride, nil))
+	assert.NoError(t, engine.Authorize(ctx, trusted, PermissionStockAdjust, stockAdjustment("sathorn", 15)))
+	// Outside the override the role rules still apply
+	assert.NoError(t, engine.Authorize(ctx, trusted, PermissionStockAdjust, stockAdjustment("silom", 3)))
+	assert.ErrorIs(t, engine.Authorize(ctx, trusted, PermissionStockAdjust, stockAdjustment("silom", 25)), ErrDenied)
+	assert.ErrorIs(t, engine.Authorize(ctx, trusted, PermissionUserManage, nil), ErrDenied)
+
+	assert.ErrorIs(t, engine.Authorize(ctx, suspended, PermissionPriceOverride, nil), ErrDenied)
+	assert.NoError(t, engine.Authorize(ctx, suspended, PermissionStockAdjust, stockAdjustment("sathorn", 10)))
+	assert.NoError(t, engine.Authorize(ctx, other, PermissionPriceOverride, nil))
+}
+
+func TestEngine_DenyRules(t *testing.T) {
+	ctx := context.Background()
+	manager := Subject{UserID: uuid.New(), Role: user.RoleManager, Branch: "silom"}
+
+	engine := NewEngine(NewStaticRepository(
+		// Managers may not adjust stock at the warehouse
+		Rule{
+			Permission: PermissionStockAdjust,
+			Role:       user.RoleManager,
+			Effect:     EffectDeny,
+			Conditions: []Condition{{Attribute: "resource.branch", Operator: OperatorIn, Value: []any{"warehouse", "depot"}}},
+		},
+		// A user rule beats the role deny
+		Rule{Permission: PermissionStockAdjust, UserID: manager.UserID, Effect: EffectAllow,
+			Conditions: []Condition{{Attribute: "resource.branch", Operator: OperatorEq, Value: "depot"}}},
+	), 0)
+
+	assert.NoError(t, engine.Authorize(ctx, Subject{UserID: uuid.New(), Role: user.RoleManager}, PermissionStockAdjust, stockAdjustment("silom", 10)))
+	assert.ErrorIs(t, engine.Authorize(ctx, Subject{UserID: uuid.New(), Role: user.RoleManager}, PermissionStockAdjust, stockAdjustment("warehouse", 10)), ErrDenied)
+	assert.ErrorIs(t, engine.Authorize(ctx, manager, PermissionStockAdjust, stockAdjustment("warehouse", 10)), ErrDenied)
+	assert.NoError(t, engine.Authorize(ctx, manager, PermissionStockAdjust, stockAdjustment("depot", 10)))
+
+	// A conditional deny does not take the permission away when no resource is given
+	assert.NoError(t, engine.Authorize(ctx, Subject{UserID: uuid.New(), Role: user.RoleManager}, PermissionStockAdjust, nil))
+}
+
+func TestEngine_ServiceAccounts(t *testing.T) {
+	ctx := context.Background()
+	terminal := Subject{UserID: uuid.New(), Branch: "silom", ServiceAccount: true, Permissions: []string{PermissionStockAdjust}}
+	accounting := Subject{UserID: uuid.New(), ServiceAccount: true, Permissions: []string{PermissionReportViewCost}}
+
+	// Rules for the service account's ID or an empty role must not grant anything
+	repo := &countingRepository{rules: []Rule{
+		{Permission: PermissionPriceOverride, UserID: terminal.UserID, Effect: EffectAllow},
+		{Permission: PermissionUserManage, Role: "", Effect: EffectAllow},
+	}}
+	engine := NewEngine(repo, 0)
+
+	assert.NoError(t, engine.Authorize(ctx, terminal, PermissionStockAdjust, nil))
+	// Key permissions are not limited by the staff adjustment conditions, only by the branch
+	assert.NoError(t, engine.Authorize(ctx, terminal, PermissionStockAdjust, stockAdjustment("silom", 40)))
+	assert.ErrorIs(t, engine.Authorize(ctx, terminal, PermissionStockAdjust, stockAdjustment("sathorn", 1)), ErrDenied)
+	assert.ErrorIs(t, engine.Authorize(ctx, terminal, PermissionPriceOverride, nil), ErrDenied)
+	assert.ErrorIs(t, engine.Authorize(ctx, terminal, PermissionUserManage, nil), ErrDenied)
+
+	// Without a branch the service account acts on every branch
+	assert.NoError(t, engine.Authorize(ctx, accounting, PermissionReportViewCost, &Resource{Branch: "sathorn"}))
+	assert.ErrorIs(t, engine.Authorize(ctx, accounting, PermissionStockAdjust, nil), ErrDenied)
+
+	// The rules are not even loaded for service accounts
+	assert.Equal(t, 0, repo.loads)
+}
+
+func TestIsValidPermission(t *testing.T) {
+	for _, permission := range Permissions() {
+		assert.True(t, IsValidPermission(permission), permission)
+	}
+	assert.False(t, IsValidPermission("stock.delete"))
+	assert.False(t, IsValidPermission(""))
+}
+
+func TestEngine_MalformedConditionsFailClosed(t *testing.T) {
+	ctx := context.Background()
+	subject := Subject{UserID: uuid.New(), Role: user.RoleManager, Branch: "silom"}
+
+	engine := NewEngine(NewStaticRepository(
+		Rule{Permission: PermissionUserManage, Role: user.RoleManager, Effect: EffectAllow,
+			Conditions: []Condition{{Attribute: "resource.branch", Operator: "like", Value: "s%"}}},
+		Rule{Permission: PermissionPriceOverride, Role: user.RoleManager, Effect: EffectDeny,
+			Conditions: []Condition{{Attribute: "request.ip", Operator: OperatorEq, Value: "10.0.0.1"}}},
+	), 0)
+
+	assert.ErrorIs(t, engine.Authorize(ctx, subject, PermissionUserManage, &Resource{Branch: "silom"}), ErrDenied)
+	assert.ErrorIs(t, engine.Authorize(ctx, subject, PermissionPriceOverride, &Resource{Branch: "silom"}), ErrDenied)
+}
+
+func TestCondition_Operators(t *testing.T) {
+	subject := Subject{UserID: uuid.New(), Role: user.RoleStaff, Branch: "silom"}
+	resource := &Resource{Branch: "silom", Attributes: map[string]any{"amount": 10, "category": "tyres"}}
+
+	tests := []struct {
+		name      string
+		condition Condition
+		holds     bool
+	}{
+		{name: "eq", condition: Condition{Attribute: "resource.amount", Operator: OperatorEq, Value: 10.0}, holds: true},
+		{name: "ne", condition: Condition{Attribute: "resource.category", Operator: OperatorNe, Value: "oil"}, holds: true},
+		{name: "lt", condition: Condition{Attribute: "resource.amount", Operator: OperatorLt, Value: 10}, holds: false},
+		{name: "lte", condition: Condition{Attribute: "resource.amount", Operator: OperatorLte, Value: 10}, holds: true},
+		{name: "gt", condition: Condition{Attribute: "resource.amount", Operator: OperatorGt, Value: 5}, holds: true},
+		{name: "gte", condition: Condition{Attribute: "resource.amount", Operator: OperatorGte, Value: 11}, holds: false},
+		{name: "gt on text", condition: Condition{Attribute: "resource.category", Operator: OperatorGt, Value: 5}, holds: false},
+		{name: "in", condition: Condition{Attribute: "subject.role", Operator: OperatorIn, Value: []any{"staff", "manager"}}, holds: true},
+		{name: "ref", condition: Condition{Attribute: "subject.branch", Operator: OperatorEq, Ref: "resource.branch"}, holds: true},
+		{name: "missing attribute", condition: Condition{Attribute: "resource.size", Operator: OperatorNe, Value: "XL"}, holds: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			holds, valid := tt.condition.evaluate(subject, resource)
+			assert.True(t, valid)
+			assert.Equal(t, tt.holds, holds)
+		})
+	}
+}
+
+func TestEngine_CachesStoredRules(t *testing.T) {
+	ctx := context.Background()
+	subject := Subject{UserID: uuid.New(), Role: user.RoleStaff}
+	repo := &countingRepository{}
+	cached := NewEngine(repo, time.Minute).(*engine)
+
+	// Only the first check loads the rules
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, cached.Authorize(ctx, subject, PermissionReportViewCost, nil), ErrDenied)
+	}
+	assert.Equal(t, 1, repo.loads)
+
+	// A new rule applies once the cache expires
+	repo.rules = []Rule{{Permission: PermissionReportViewCost, UserID: subject.UserID, Effect: EffectAllow}}
+	cached.loadedAt = time.Now().Add(-2 * time.Minute)
+	require.NoError(t, cached.Authorize(ctx, subject, PermissionReportViewCost, nil))
+	assert.Equal(t, 2, repo.loads)
+
+	// Load errors are returned, not treated as a denial
+	repo.err = errors.New("db error")
+	cached.loadedAt = time.Now().Add(-2 * time.Minute)
+	err := cached.Authorize(ctx, subject, PermissionReportViewCost, nil)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrDenied)
+}
+
+func TestEngine_WithoutCacheReloadsEveryCheck(t *testing.T) {
+	repo := &countingRepository{}
+	engine := NewEngine(repo, 0)
+
+	for i := 0; i < 3; i++ {
+		_ = engine.Authorize(context.Background(), Subject{Role: user.RoleOwner}, PermissionUserManage, nil)
+	}
+	assert.Equal(t, 3, repo.loads)
+}
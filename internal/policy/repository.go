@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"tt-stock-api/internal/db"
+)
+
+// Repository defines the interface for loading stored policy rules
+type Repository interface {
+	ListRules() ([]Rule, error)
+}
+
+// repository implements the Repository interface
+type repository struct {
+	db *db.DB
+}
+
+// NewRepository creates a new policy rule repository instance
+func NewRepository(database *db.DB) Repository {
+	return &repository{
+		db: database,
+	}
+}
+
+// ListRules returns every stored policy rule
+func (r *repository) ListRules() ([]Rule, error) {
+	query := `
+		SELECT id, permission, role, user_id, effect, conditions
+		FROM policy_rules
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list policy rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		var rule Rule
+		var role sql.NullString
+		var userID uuid.NullUUID
+		var conditions []byte
+
+		if err := rows.Scan(&rule.ID, &rule.Permission, &role, &userID, &rule.Effect, &conditions); err != nil {
+			return nil, fmt.Errorf("failed to scan policy rule: %w", err)
+		}
+
+		rule.Role = role.String
+		rule.UserID = userID.UUID
+		if len(conditions) > 0 {
+			if err := json.Unmarshal(conditions, &rule.Conditions); err != nil {
+				return nil, fmt.Errorf("failed to decode conditions of policy rule %s: %w", rule.ID, err)
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list policy rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// staticRepository serves a fixed set of rules, for tests and deployments without stored rules
+type staticRepository struct {
+	rules []Rule
+}
+
+// NewStaticRepository creates a repository that always returns the given rules
+func NewStaticRepository(rules ...Rule) Repository {
+	return &staticRepository{
+		rules: rules,
+	}
+}
+
+// ListRules returns the fixed rules
+func (r *staticRepository) ListRules() ([]Rule, error) {
+	return r.rules, nil
+}
@@ -0,0 +1,98 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tt-stock-api/internal/db"
+)
+
+func TestRepository_ListRules(t *testing.T) {
+	roleRuleID := uuid.New()
+	userRuleID := uuid.New()
+	userID := uuid.New()
+
+	tests := []struct {
+		name        string
+		setupMock   func(mock sqlmock.Sqlmock)
+		expected    []Rule
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "role and user rules",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "permission", "role", "user_id", "effect", "conditions"}).
+					AddRow(roleRuleID, PermissionStockAdjust, "manager", nil, EffectDeny, []byte(`[{"attr":"resource.branch","op":"eq","value":"warehouse"}]`)).
+					AddRow(userRuleID, PermissionPriceOverride, nil, userID, EffectAllow, []byte(`[]`))
+				mock.ExpectQuery(`SELECT id, permission, role, user_id, effect, conditions\s+FROM policy_rules`).
+					WillReturnRows(rows)
+			},
+			expected: []Rule{
+				{
+					ID:         roleRuleID,
+					Permission: PermissionStockAdjust,
+					Role:       "manager",
+					Effect:     EffectDeny,
+					Conditions: []Condition{{Attribute: "resource.branch", Operator: OperatorEq, Value: "warehouse"}},
+				},
+				{
+					ID:         userRuleID,
+					Permission: PermissionPriceOverride,
+					UserID:     userID,
+					Effect:     EffectAllow,
+					Conditions: []Condition{},
+				},
+			},
+		},
+		{
+			name: "invalid conditions",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "permission", "role", "user_id", "effect", "conditions"}).
+					AddRow(roleRuleID, PermissionStockAdjust, "manager", nil, EffectAllow, []byte(`{"attr":`))
+				mock.ExpectQuery(`SELECT id, permission, role, user_id, effect, conditions\s+FROM policy_rules`).
+					WillReturnRows(rows)
+			},
+			expectError: true,
+			errorMsg:    "failed to decode conditions",
+		},
+		{
+			name: "database error",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, permission, role, user_id, effect, conditions\s+FROM policy_rules`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			expectError: true,
+			errorMsg:    "failed to list policy rules",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+
+			repo := NewRepository(&db.DB{DB: mockDB})
+			rules, err := repo.ListRules()
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				assert.Nil(t, rules)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, rules)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
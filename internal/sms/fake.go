@@ -0,0 +1,85 @@
+package sms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// logSender writes messages to a logger instead of sending them
+type logSender struct {
+	logger *log.Logger
+}
+
+// NewLogSender creates a sender that writes every message to the logger
+// A nil logger uses the standard logger
+func NewLogSender(logger *log.Logger) Sender {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &logSender{
+		logger: logger,
+	}
+}
+
+// Send logs the message
+func (s *logSender) Send(phoneNumber, message string) error {
+	if phoneNumber == "" {
+		return errors.New("phone number cannot be empty")
+	}
+
+	s.logger.Printf("SMS to %s: %s", phoneNumber, message)
+	return nil
+}
+
+// FileMessage is a message recorded by the file sender, one JSON object per line
+type FileMessage struct {
+	To      string    `json:"to"`
+	Message string    `json:"message"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// fileSender appends messages to a file instead of sending them
+type fileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSender creates a sender that appends every message to the file at path as a JSON line
+func NewFileSender(path string) Sender {
+	return &fileSender{
+		path: path,
+	}
+}
+
+// Send appends the message to the file
+func (s *fileSender) Send(phoneNumber, message string) error {
+	if phoneNumber == "" {
+		return errors.New("phone number cannot be empty")
+	}
+
+	line, err := json.Marshal(FileMessage{To: phoneNumber, Message: message, SentAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode SMS message: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open SMS file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write SMS message: %w", err)
+	}
+
+	return nil
+}
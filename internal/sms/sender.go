@@ -0,0 +1,32 @@
+package sms
+
+import (
+	"fmt"
+
+	"tt-stock-api/internal/config"
+)
+
+// Supported SMS providers
+const (
+	ProviderLog  = "log"
+	ProviderFile = "file"
+)
+
+// Sender delivers text messages to mobile phone numbers
+// Implementations for real SMS gateways only need to provide Send
+type Sender interface {
+	Send(phoneNumber, message string) error
+}
+
+// NewSender creates the sender for the configured SMS provider
+// The log and file providers never deliver anything and are meant for local development and tests
+func NewSender(cfg *config.Config) (Sender, error) {
+	switch cfg.SMSProvider {
+	case "", ProviderLog:
+		return NewLogSender(nil), nil
+	case ProviderFile:
+		return NewFileSender(cfg.SMSFilePath), nil
+	default:
+		return nil, fmt.Errorf("unsupported SMS provider: %s", cfg.SMSProvider)
+	}
+}
@@ -0,0 +1,65 @@
+package sms
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tt-stock-api/internal/config"
+)
+
+func TestNewSender(t *testing.T) {
+	for _, provider := range []string{"", ProviderLog, ProviderFile} {
+		sender, err := NewSender(&config.Config{SMSProvider: provider, SMSFilePath: filepath.Join(t.TempDir(), "sms.log")})
+		assert.NoError(t, err, provider)
+		assert.NotNil(t, sender, provider)
+	}
+
+	sender, err := NewSender(&config.Config{SMSProvider: "carrier-pigeon"})
+	assert.Error(t, err)
+	assert.Nil(t, sender)
+}
+
+func TestLogSender(t *testing.T) {
+	var buf bytes.Buffer
+	sender := NewLogSender(log.New(&buf, "", 0))
+
+	require.NoError(t, sender.Send("0812345678", "Your code is 123456"))
+	assert.Equal(t, "SMS to 0812345678: Your code is 123456\n", buf.String())
+
+	assert.Error(t, sender.Send("", "Your code is 123456"))
+}
+
+func TestFileSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	sender := NewFileSender(path)
+
+	require.NoError(t, sender.Send("0812345678", "first"))
+	require.NoError(t, sender.Send("0898765432", "second"))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var messages []FileMessage
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var message FileMessage
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &message))
+		messages = append(messages, message)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, messages, 2)
+	assert.Equal(t, "0812345678", messages[0].To)
+	assert.Equal(t, "first", messages[0].Message)
+	assert.Equal(t, "0898765432", messages[1].To)
+	assert.Equal(t, "second", messages[1].Message)
+	assert.False(t, messages[0].SentAt.IsZero())
+}
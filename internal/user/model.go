@@ -6,12 +6,80 @@ import (
 	"github.com/google/uuid"
 )
 
+// Roles a user can have
+const (
+	RoleStaff   = "staff"   // Shop employee; the default for new users
+	RoleManager = "manager" // Runs a shop on the owner's behalf
+	RoleOwner   = "owner"   // Owns the shop
+	RoleAdmin   = "admin"   // System administrator
+)
+
+// Roles lists the known roles
+var Roles = []string{RoleStaff, RoleManager, RoleOwner, RoleAdmin}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleStaff, RoleManager, RoleOwner, RoleAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// Account statuses
+const (
+	StatusActive           = "active"             // The user can log in and use the API
+	StatusSuspended        = "suspended"          // Suspended by an administrator, e.g. an employee who has left
+	StatusLocked           = "locked"             // Locked by an administrator, e.g. when the account may be compromised
+	StatusPendingPinChange = "pending_pin_change" // The user can log in but must change their PIN before anything else
+)
+
+// IsValidStatus reports whether status is one of the known account statuses
+func IsValidStatus(status string) bool {
+	switch status {
+	case StatusActive, StatusSuspended, StatusLocked, StatusPendingPinChange:
+		return true
+	default:
+		return false
+	}
+}
+
 // User represents a user in the system
 type User struct {
 	ID          uuid.UUID  `json:"id" db:"id"`
 	PhoneNumber string     `json:"phone_number" db:"phone_number"`
 	PinHash     string     `json:"-" db:"pin_hash"` // Hidden from JSON responses
+	Role        string     `json:"role" db:"role"`
 	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
 	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	BirthDate   *time.Time `json:"birth_date,omitempty" db:"birth_date"` // Optional; used to reject PINs built from the birth year
+	OTPLogin    bool       `json:"otp_login" db:"otp_login"`             // Whether the user may log in with an SMS code instead of a PIN
+	Branch      string     `json:"branch" db:"branch"`                   // Branch the user works at; empty if not assigned to one
+
+	Status          string     `json:"status" db:"status"`                                 // One of the Status constants
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty" db:"status_changed_at"` // When an administrator or a PIN change last changed the status
+}
+
+// CanLogin reports whether the account status lets the user log in
+func (u *User) CanLogin() bool {
+	return u.Status == StatusActive || u.Status == StatusPendingPinChange
+}
+
+// AccountState is what protected requests check about a user on every call
+type AccountState struct {
+	Status           string
+	TokensValidAfter *time.Time // Tokens issued up to this time are rejected; nil if the user was never logged out everywhere
+}
+
+// Filter selects users for List; zero fields do not filter
+type Filter struct {
+	Role         string
+	ExcludeRoles []string // Roles whose users are left out
+	Branch       string
+	PhoneNumber  string // E.164
+	Status       string
+	Limit        int
+	Offset       int
 }
\ No newline at end of file
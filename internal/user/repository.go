@@ -4,16 +4,38 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"tt-stock-api/internal/db"
 )
 
+// Repository errors; match them with errors.Is
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPhoneNumberTaken = errors.New("phone number is already registered")
+)
+
+// uniqueViolation is the PostgreSQL error code for a unique constraint violation
+const uniqueViolation = "23505"
+
 // Repository defines the interface for user data operations
 type Repository interface {
+	Create(u *User) error
 	FindByPhoneNumber(phoneNumber string) (*User, error)
+	FindByID(userID uuid.UUID) (*User, error)
+	FindByRole(role string) ([]User, error)
+	List(filter Filter) ([]User, int, error)
+	Update(u *User) error
+	FindAccountState(userID uuid.UUID) (*AccountState, error)
+	UpdateStatus(userID uuid.UUID, status string) error
+	RevokeTokens(userID uuid.UUID, issuedBefore time.Time) error
+	Delete(userID uuid.UUID) error
 	UpdateLastLogin(userID uuid.UUID) error
+	UpdatePin(userID uuid.UUID, pinHash string, historySize int) error
+	RecentPinHashes(userID uuid.UUID, limit int) ([]string, error)
 }
 
 // repository implements the Repository interface
@@ -28,6 +50,53 @@ func NewRepository(database *db.DB) Repository {
 	}
 }
 
+// Create inserts a new user, filling in its ID and timestamps
+// Returns ErrPhoneNumberTaken if another user has the same phone number
+func (r *repository) Create(u *User) error {
+	if u == nil || u.PhoneNumber == "" {
+		return errors.New("phone number cannot be empty")
+	}
+	if u.PinHash == "" {
+		return errors.New("PIN hash cannot be empty")
+	}
+
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	if u.Status == "" {
+		u.Status = StatusActive
+	}
+	now := time.Now()
+	u.CreatedAt = now
+	u.UpdatedAt = now
+
+	query := `
+		INSERT INTO users (id, phone_number, pin_hash, role, branch, birth_date, otp_login, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := r.db.Exec(query,
+		u.ID,
+		u.PhoneNumber,
+		u.PinHash,
+		u.Role,
+		u.Branch,
+		u.BirthDate,
+		u.OTPLogin,
+		u.Status,
+		now,
+		now,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrPhoneNumberTaken
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
+}
+
 // FindByPhoneNumber retrieves a user by their phone number
 func (r *repository) FindByPhoneNumber(phoneNumber string) (*User, error) {
 	if phoneNumber == "" {
@@ -35,34 +104,340 @@ func (r *repository) FindByPhoneNumber(phoneNumber string) (*User, error) {
 	}
 
 	query := `
-		SELECT id, phone_number, pin_hash, created_at, updated_at, last_login_at
+		SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at
 		FROM users 
 		WHERE phone_number = $1
 	`
 
+	user, err := scanUser(r.db.QueryRow(query, phoneNumber))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user with phone number %s %w", phoneNumber, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to query user by phone number: %w", err)
+	}
+
+	return user, nil
+}
+
+// FindByID retrieves a user by their ID
+func (r *repository) FindByID(userID uuid.UUID) (*User, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at
+		FROM users
+		WHERE id = $1
+	`
+
+	user, err := scanUser(r.db.QueryRow(query, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user with ID %s %w", userID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to query user by ID: %w", err)
+	}
+
+	return user, nil
+}
+
+// FindByRole retrieves all users with a role, oldest first
+func (r *repository) FindByRole(role string) ([]User, error) {
+	if role == "" {
+		return nil, errors.New("role cannot be empty")
+	}
+
+	query := `
+		SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at
+		FROM users
+		WHERE role = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users by role: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query users by role: %w", err)
+	}
+
+	return users, nil
+}
+
+// List returns the users matching the filter, newest first, together with the number of
+// matching users across all pages
+func (r *repository) List(filter Filter) ([]User, int, error) {
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Role != "" {
+		where("role = $%d", filter.Role)
+	}
+	if len(filter.ExcludeRoles) > 0 {
+		where("role <> ALL($%d)", pq.Array(filter.ExcludeRoles))
+	}
+	if filter.Branch != "" {
+		where("branch = $%d", filter.Branch)
+	}
+	if filter.PhoneNumber != "" {
+		where("phone_number = $%d", filter.PhoneNumber)
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM users " + whereClause
+	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at
+		FROM users
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, whereClause, len(args)+1, len(args)+2)
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// Update saves a user's profile: phone number, role, branch, birth date and OTP login
+// The PIN and account status have their own methods. Returns ErrPhoneNumberTaken if another
+// user has the new phone number.
+func (r *repository) Update(u *User) error {
+	if u == nil || u.ID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if u.PhoneNumber == "" {
+		return errors.New("phone number cannot be empty")
+	}
+
+	query := `
+		UPDATE users
+		SET phone_number = $1, role = $2, branch = $3, birth_date = $4, otp_login = $5, updated_at = $6
+		WHERE id = $7
+	`
+
+	now := time.Now()
+	result, err := r.db.Exec(query, u.PhoneNumber, u.Role, u.Branch, u.BirthDate, u.OTPLogin, now, u.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrPhoneNumberTaken
+		}
+		return fmt.Errorf("failed to update user %s: %w", u.ID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", u.ID, ErrNotFound)
+	}
+
+	u.UpdatedAt = now
+	return nil
+}
+
+// FindAccountState returns a user's account status and token cutoff without loading the rest
+// of the account
+func (r *repository) FindAccountState(userID uuid.UUID) (*AccountState, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+
+	var state AccountState
+	var tokensValidAfter sql.NullTime
+	err := r.db.QueryRow(`SELECT status, tokens_valid_after FROM users WHERE id = $1`, userID).Scan(&state.Status, &tokensValidAfter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user with ID %s %w", userID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to query account state of user %s: %w", userID, err)
+	}
+
+	if tokensValidAfter.Valid {
+		state.TokensValidAfter = &tokensValidAfter.Time
+	}
+
+	return &state, nil
+}
+
+// UpdateStatus changes a user's account status and records when it changed
+// Setting the status a user already has keeps the time it was first set
+func (r *repository) UpdateStatus(userID uuid.UUID, status string) error {
+	if userID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if !IsValidStatus(status) {
+		return fmt.Errorf("invalid account status: %s", status)
+	}
+
+	query := `
+		UPDATE users
+		SET status_changed_at = CASE WHEN status = $1 THEN status_changed_at ELSE $2 END,
+			status = $1, updated_at = $2
+		WHERE id = $3
+	`
+
+	result, err := r.db.Exec(query, status, time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update status of user %s: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+// RevokeTokens moves a user's token cutoff forward, so every token issued up to issuedBefore is
+// rejected. The cutoff never moves back.
+func (r *repository) RevokeTokens(userID uuid.UUID, issuedBefore time.Time) error {
+	if userID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+
+	query := `
+		UPDATE users
+		SET tokens_valid_after = GREATEST(tokens_valid_after, $1), updated_at = $2
+		WHERE id = $3
+	`
+
+	result, err := r.db.Exec(query, issuedBefore, time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke tokens of user %s: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+// Delete removes a user together with their sessions, devices and other per-user data
+// The authentication audit log keeps its entries about the user
+func (r *repository) Delete(userID uuid.UUID) error {
+	if userID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+
+	result, err := r.db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user %s: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// scanUser reads a user row selected with the columns used by the Find methods
+func scanUser(row interface{ Scan(dest ...any) error }) (*User, error) {
 	var user User
 	var lastLoginAt sql.NullTime
+	var birthDate sql.NullTime
+	var statusChangedAt sql.NullTime
 
-	err := r.db.QueryRow(query, phoneNumber).Scan(
+	err := row.Scan(
 		&user.ID,
 		&user.PhoneNumber,
 		&user.PinHash,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&lastLoginAt,
+		&birthDate,
+		&user.OTPLogin,
+		&user.Branch,
+		&user.Status,
+		&statusChangedAt,
 	)
-
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user with phone number %s not found", phoneNumber)
-		}
-		return nil, fmt.Errorf("failed to query user by phone number: %w", err)
+		return nil, err
 	}
 
-	// Handle nullable last_login_at field
+	// Handle nullable fields
 	if lastLoginAt.Valid {
 		user.LastLoginAt = &lastLoginAt.Time
 	}
+	if birthDate.Valid {
+		user.BirthDate = &birthDate.Time
+	}
+	if statusChangedAt.Valid {
+		user.StatusChangedAt = &statusChangedAt.Time
+	}
 
 	return &user, nil
 }
@@ -91,8 +466,116 @@ func (r *repository) UpdateLastLogin(userID uuid.UUID) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("user with ID %s not found", userID)
+		return fmt.Errorf("user with ID %s %w", userID, ErrNotFound)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// UpdatePin replaces a user's PIN hash and moves the previous hash into the PIN history,
+// keeping only the historySize most recent entries
+// A PIN the user chose themselves makes a pending_pin_change account active
+func (r *repository) UpdatePin(userID uuid.UUID, pinHash string, historySize int) error {
+	if userID == uuid.Nil {
+		return errors.New("user ID cannot be empty")
+	}
+	if pinHash == "" {
+		return errors.New("PIN hash cannot be empty")
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	historyQuery := `
+		INSERT INTO pin_history (user_id, pin_hash)
+		SELECT id, pin_hash FROM users WHERE id = $1
+	`
+
+	result, err := tx.Exec(historyQuery, userID)
+	if err != nil {
+		return fmt.Errorf("failed to record PIN history for user %s: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", userID, ErrNotFound)
+	}
+
+	updateQuery := `
+		UPDATE users
+		SET pin_hash = $1, updated_at = $2,
+			status = CASE WHEN status = 'pending_pin_change' THEN 'active' ELSE status END,
+			status_changed_at = CASE WHEN status = 'pending_pin_change' THEN $2 ELSE status_changed_at END
+		WHERE id = $3
+	`
+
+	if _, err := tx.Exec(updateQuery, pinHash, time.Now(), userID); err != nil {
+		return fmt.Errorf("failed to update PIN for user %s: %w", userID, err)
+	}
+
+	trimQuery := `
+		DELETE FROM pin_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM pin_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC
+			LIMIT $2
+		)
+	`
+
+	if _, err := tx.Exec(trimQuery, userID, historySize); err != nil {
+		return fmt.Errorf("failed to trim PIN history for user %s: %w", userID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RecentPinHashes returns the hashes of a user's previous PINs, newest first
+func (r *repository) RecentPinHashes(userID uuid.UUID, limit int) ([]string, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be empty")
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT pin_hash
+		FROM pin_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query PIN history: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan PIN history: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read PIN history: %w", err)
+	}
+
+	return hashes, nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -28,13 +29,14 @@ func TestRepository_FindByPhoneNumber(t *testing.T) {
 			name:        "successful user retrieval",
 			phoneNumber: "0812345678",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "phone_number", "pin_hash", "created_at", "updated_at", "last_login_at"}).
-					AddRow("123e4567-e89b-12d3-a456-426614174000", "0812345678", "$2a$12$hashedpin", 
+				rows := sqlmock.NewRows([]string{"id", "phone_number", "pin_hash", "role", "created_at", "updated_at", "last_login_at", "birth_date", "otp_login", "branch", "status", "status_changed_at"}).
+					AddRow("123e4567-e89b-12d3-a456-426614174000", "0812345678", "$2a$12$hashedpin", "staff",
 						time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 						time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-						time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC))
+						time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+						nil, false, "", "active", nil)
 				
-				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, created_at, updated_at, last_login_at FROM users WHERE phone_number = \$1`).
+				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at FROM users WHERE phone_number = \$1`).
 					WithArgs("0812345678").
 					WillReturnRows(rows)
 			},
@@ -42,9 +44,11 @@ func TestRepository_FindByPhoneNumber(t *testing.T) {
 				ID:          uuid.MustParse("123e4567-e89b-12d3-a456-426614174000"),
 				PhoneNumber: "0812345678",
 				PinHash:     "$2a$12$hashedpin",
+				Role:        "staff",
 				CreatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 				UpdatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 				LastLoginAt: func() *time.Time { t := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC); return &t }(),
+				Status:      StatusActive,
 			},
 			expectError: false,
 		},
@@ -52,13 +56,15 @@ func TestRepository_FindByPhoneNumber(t *testing.T) {
 			name:        "successful user retrieval with null last_login_at",
 			phoneNumber: "0812345679",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "phone_number", "pin_hash", "created_at", "updated_at", "last_login_at"}).
-					AddRow("123e4567-e89b-12d3-a456-426614174001", "0812345679", "$2a$12$hashedpin2", 
+				rows := sqlmock.NewRows([]string{"id", "phone_number", "pin_hash", "role", "created_at", "updated_at", "last_login_at", "birth_date", "otp_login", "branch", "status", "status_changed_at"}).
+					AddRow("123e4567-e89b-12d3-a456-426614174001", "0812345679", "$2a$12$hashedpin2", "owner",
 						time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 						time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-						nil)
+						nil,
+						time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC),
+						true, "silom", "pending_pin_change", nil)
 				
-				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, created_at, updated_at, last_login_at FROM users WHERE phone_number = \$1`).
+				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at FROM users WHERE phone_number = \$1`).
 					WithArgs("0812345679").
 					WillReturnRows(rows)
 			},
@@ -66,9 +72,15 @@ func TestRepository_FindByPhoneNumber(t *testing.T) {
 				ID:          uuid.MustParse("123e4567-e89b-12d3-a456-426614174001"),
 				PhoneNumber: "0812345679",
 				PinHash:     "$2a$12$hashedpin2",
+				Role:        "owner",
 				CreatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 				UpdatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 				LastLoginAt: nil,
+				BirthDate:   func() *time.Time { t := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC); return &t }(),
+				OTPLogin:    true,
+				Branch:      "silom",
+
+				Status: StatusPendingPinChange,
 			},
 			expectError: false,
 		},
@@ -76,7 +88,7 @@ func TestRepository_FindByPhoneNumber(t *testing.T) {
 			name:        "user not found",
 			phoneNumber: "0899999999",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, created_at, updated_at, last_login_at FROM users WHERE phone_number = \$1`).
+				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at FROM users WHERE phone_number = \$1`).
 					WithArgs("0899999999").
 					WillReturnError(sql.ErrNoRows)
 			},
@@ -98,7 +110,7 @@ func TestRepository_FindByPhoneNumber(t *testing.T) {
 			name:        "database error",
 			phoneNumber: "0812345678",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, created_at, updated_at, last_login_at FROM users WHERE phone_number = \$1`).
+				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at FROM users WHERE phone_number = \$1`).
 					WithArgs("0812345678").
 					WillReturnError(errors.New("database connection error"))
 			},
@@ -238,6 +250,527 @@ func TestRepository_UpdateLastLogin(t *testing.T) {
 	}
 }
 
+func TestRepository_FindByID(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+
+	tests := []struct {
+		name        string
+		userID      uuid.UUID
+		setupMock   func(mock sqlmock.Sqlmock)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:   "successful user retrieval",
+			userID: testUserID,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "phone_number", "pin_hash", "role", "created_at", "updated_at", "last_login_at", "birth_date", "otp_login", "branch", "status", "status_changed_at"}).
+					AddRow(testUserID.String(), "0812345678", "$2a$12$hashedpin", "staff",
+						time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						nil, nil, false, "", "active", nil)
+
+				mock.ExpectQuery(`SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at FROM users WHERE id = \$1`).
+					WithArgs(testUserID).
+					WillReturnRows(rows)
+			},
+		},
+		{
+			name:   "user not found",
+			userID: testUserID,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT (.+) FROM users WHERE id = \$1`).
+					WithArgs(testUserID).
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectError: true,
+			errorMsg:    "user with ID 123e4567-e89b-12d3-a456-426614174000 not found",
+		},
+		{
+			name:        "empty user ID",
+			userID:      uuid.Nil,
+			setupMock:   func(mock sqlmock.Sqlmock) {},
+			expectError: true,
+			errorMsg:    "user ID cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+			repo := NewRepository(&db.DB{DB: mockDB})
+
+			result, err := repo.FindByID(tt.userID)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				assert.Nil(t, result)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.userID, result.ID)
+				assert.Nil(t, result.BirthDate)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_FindByRole(t *testing.T) {
+	firstID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	secondID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174001")
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "phone_number", "pin_hash", "role", "created_at", "updated_at", "last_login_at", "birth_date", "otp_login", "branch", "status", "status_changed_at"}).
+		AddRow(firstID.String(), "0812345678", "$2a$12$hashedpin", "owner", createdAt, createdAt, nil, nil, false, "", "active", nil).
+		AddRow(secondID.String(), "0898765432", "$2a$12$hashedpin", "owner", createdAt, createdAt, createdAt, nil, false, "central", "suspended", createdAt)
+	mock.ExpectQuery(`SELECT id, phone_number, pin_hash, role, created_at, updated_at, last_login_at, birth_date, otp_login, branch, status, status_changed_at FROM users WHERE role = \$1 ORDER BY created_at`).
+		WithArgs("owner").
+		WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT (.+) FROM users WHERE role = \$1`).
+		WithArgs("manager").
+		WillReturnError(errors.New("database connection error"))
+
+	repo := NewRepository(&db.DB{DB: mockDB})
+
+	users, err := repo.FindByRole("owner")
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, firstID, users[0].ID)
+	assert.Equal(t, "0898765432", users[1].PhoneNumber)
+	assert.Equal(t, "central", users[1].Branch)
+	assert.NotNil(t, users[1].LastLoginAt)
+	assert.Equal(t, StatusSuspended, users[1].Status)
+	assert.Equal(t, createdAt, *users[1].StatusChangedAt)
+
+	users, err = repo.FindByRole("manager")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to query users by role")
+	assert.Nil(t, users)
+
+	// An empty role never queries the database
+	_, err = repo.FindByRole("")
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_UpdatePin(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+
+	tests := []struct {
+		name        string
+		setupMock   func(mock sqlmock.Sqlmock)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "moves the old hash into history and trims it",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO pin_history \(user_id, pin_hash\) SELECT id, pin_hash FROM users WHERE id = \$1`).
+					WithArgs(testUserID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE users SET pin_hash = \$1, updated_at = \$2, status = CASE WHEN status = 'pending_pin_change' THEN 'active' ELSE status END`).
+					WithArgs("$2a$12$newhash", sqlmock.AnyArg(), testUserID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`DELETE FROM pin_history WHERE user_id = \$1 AND id NOT IN`).
+					WithArgs(testUserID, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "user not found",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO pin_history`).
+					WithArgs(testUserID).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			expectError: true,
+			errorMsg:    "user with ID 123e4567-e89b-12d3-a456-426614174000 not found",
+		},
+		{
+			name: "database error on update",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO pin_history`).
+					WithArgs(testUserID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE users SET pin_hash`).
+					WillReturnError(errors.New("database connection error"))
+				mock.ExpectRollback()
+			},
+			expectError: true,
+			errorMsg:    "failed to update PIN for user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+			repo := NewRepository(&db.DB{DB: mockDB})
+
+			err = repo.UpdatePin(testUserID, "$2a$12$newhash", 3)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_RecentPinHashes(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	rows := sqlmock.NewRows([]string{"pin_hash"}).AddRow("$2a$12$newer").AddRow("$2a$12$older")
+	mock.ExpectQuery(`SELECT pin_hash FROM pin_history WHERE user_id = \$1 ORDER BY created_at DESC LIMIT \$2`).
+		WithArgs(testUserID, 3).
+		WillReturnRows(rows)
+
+	repo := NewRepository(&db.DB{DB: mockDB})
+
+	hashes, err := repo.RecentPinHashes(testUserID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"$2a$12$newer", "$2a$12$older"}, hashes)
+
+	// A zero history size never queries the database
+	hashes, err = repo.RecentPinHashes(testUserID, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, hashes)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_Create(t *testing.T) {
+	birthDate := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		user        *User
+		setupMock   func(mock sqlmock.Sqlmock)
+		expectedErr error
+		errorMsg    string
+	}{
+		{
+			name: "creates the user",
+			user: &User{PhoneNumber: "+66812345678", PinHash: "$2a$12$hashedpin", Role: RoleStaff, Branch: "silom", BirthDate: &birthDate, Status: StatusPendingPinChange},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO users \(id, phone_number, pin_hash, role, branch, birth_date, otp_login, status, created_at, updated_at\)`).
+					WithArgs(sqlmock.AnyArg(), "+66812345678", "$2a$12$hashedpin", RoleStaff, "silom", birthDate, false, StatusPendingPinChange, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "phone number taken",
+			user: &User{PhoneNumber: "+66812345678", PinHash: "$2a$12$hashedpin", Role: RoleStaff},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO users`).
+					WillReturnError(&pq.Error{Code: "23505"})
+			},
+			expectedErr: ErrPhoneNumberTaken,
+		},
+		{
+			name: "database error",
+			user: &User{PhoneNumber: "+66812345678", PinHash: "$2a$12$hashedpin", Role: RoleStaff},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO users`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			errorMsg: "failed to create user",
+		},
+		{
+			name:      "missing PIN hash",
+			user:      &User{PhoneNumber: "+66812345678"},
+			setupMock: func(mock sqlmock.Sqlmock) {},
+			errorMsg:  "PIN hash cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+			repo := NewRepository(&db.DB{DB: mockDB})
+
+			err = repo.Create(tt.user)
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+			case tt.errorMsg != "":
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			default:
+				assert.NoError(t, err)
+				assert.NotEqual(t, uuid.Nil, tt.user.ID)
+				assert.False(t, tt.user.CreatedAt.IsZero())
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_List(t *testing.T) {
+	userID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"id", "phone_number", "pin_hash", "role", "created_at", "updated_at", "last_login_at", "birth_date", "otp_login", "branch", "status", "status_changed_at"}
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users WHERE role = \$1 AND branch = \$2 AND status = \$3`).
+		WithArgs(RoleStaff, "silom", StatusPendingPinChange).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(21))
+	mock.ExpectQuery(`FROM users\s+WHERE role = \$1 AND branch = \$2 AND status = \$3\s+ORDER BY created_at DESC, id DESC\s+LIMIT \$4 OFFSET \$5`).
+		WithArgs(RoleStaff, "silom", StatusPendingPinChange, 20, 20).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(userID.String(), "+66812345678", "$2a$12$hashedpin", RoleStaff, createdAt, createdAt, nil, nil, false, "silom", "pending_pin_change", nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users WHERE role <> ALL\(\$1\)`).
+		WithArgs(pq.Array([]string{RoleAdmin})).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM users\s+WHERE role <> ALL\(\$1\)\s+ORDER BY created_at DESC, id DESC\s+LIMIT \$2 OFFSET \$3`).
+		WithArgs(pq.Array([]string{RoleAdmin}), 50, 0).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users\s*$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM users\s+ORDER BY created_at DESC, id DESC\s+LIMIT \$1 OFFSET \$2`).
+		WithArgs(50, 0).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	repo := NewRepository(&db.DB{DB: mockDB})
+
+	users, total, err := repo.List(Filter{Role: RoleStaff, Branch: "silom", Status: StatusPendingPinChange, Limit: 20, Offset: 20})
+	require.NoError(t, err)
+	assert.Equal(t, 21, total)
+	require.Len(t, users, 1)
+	assert.Equal(t, userID, users[0].ID)
+	assert.Equal(t, StatusPendingPinChange, users[0].Status)
+
+	users, total, err = repo.List(Filter{ExcludeRoles: []string{RoleAdmin}, Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+	assert.Empty(t, users)
+
+	users, total, err = repo.List(Filter{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+	assert.Empty(t, users)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_Update(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+
+	tests := []struct {
+		name        string
+		setupMock   func(mock sqlmock.Sqlmock)
+		expectedErr error
+		errorMsg    string
+	}{
+		{
+			name: "updates the profile",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE users SET phone_number = \$1, role = \$2, branch = \$3, birth_date = \$4, otp_login = \$5, updated_at = \$6 WHERE id = \$7`).
+					WithArgs("+66812345678", RoleManager, "silom", nil, true, sqlmock.AnyArg(), testUserID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "user not found",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE users SET phone_number`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedErr: ErrNotFound,
+		},
+		{
+			name: "phone number taken",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE users SET phone_number`).
+					WillReturnError(&pq.Error{Code: "23505"})
+			},
+			expectedErr: ErrPhoneNumberTaken,
+		},
+		{
+			name: "database error",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE users SET phone_number`).
+					WillReturnError(errors.New("database connection error"))
+			},
+			errorMsg: "failed to update user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			tt.setupMock(mock)
+			repo := NewRepository(&db.DB{DB: mockDB})
+
+			err = repo.Update(&User{ID: testUserID, PhoneNumber: "+66812345678", Role: RoleManager, Branch: "silom", OTPLogin: true})
+
+			switch {
+			case tt.expectedErr != nil:
+				assert.ErrorIs(t, err, tt.expectedErr)
+			case tt.errorMsg != "":
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			default:
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_FindAccountState(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	tokensValidAfter := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectQuery(`SELECT status, tokens_valid_after FROM users WHERE id = \$1`).
+		WithArgs(testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "tokens_valid_after"}).AddRow(StatusSuspended, tokensValidAfter))
+	mock.ExpectQuery(`SELECT status, tokens_valid_after FROM users WHERE id = \$1`).
+		WithArgs(testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "tokens_valid_after"}).AddRow(StatusActive, nil))
+	mock.ExpectQuery(`SELECT status, tokens_valid_after FROM users WHERE id = \$1`).
+		WithArgs(testUserID).
+		WillReturnError(sql.ErrNoRows)
+
+	repo := NewRepository(&db.DB{DB: mockDB})
+
+	state, err := repo.FindAccountState(testUserID)
+	require.NoError(t, err)
+	assert.Equal(t, &AccountState{Status: StatusSuspended, TokensValidAfter: &tokensValidAfter}, state)
+
+	state, err = repo.FindAccountState(testUserID)
+	require.NoError(t, err)
+	assert.Equal(t, &AccountState{Status: StatusActive}, state)
+
+	_, err = repo.FindAccountState(testUserID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_UpdateStatus(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectExec(`UPDATE users SET status_changed_at = CASE WHEN status = \$1 THEN status_changed_at ELSE \$2 END, status = \$1, updated_at = \$2 WHERE id = \$3`).
+		WithArgs(StatusSuspended, sqlmock.AnyArg(), testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET status_changed_at`).
+		WithArgs(StatusLocked, sqlmock.AnyArg(), testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := NewRepository(&db.DB{DB: mockDB})
+
+	assert.NoError(t, repo.UpdateStatus(testUserID, StatusSuspended))
+	assert.ErrorIs(t, repo.UpdateStatus(testUserID, StatusLocked), ErrNotFound)
+
+	// An empty ID or unknown status never queries the database
+	assert.Error(t, repo.UpdateStatus(uuid.Nil, StatusActive))
+	assert.Error(t, repo.UpdateStatus(testUserID, "retired"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_RevokeTokens(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	issuedBefore := time.Now()
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectExec(`UPDATE users SET tokens_valid_after = GREATEST\(tokens_valid_after, \$1\), updated_at = \$2 WHERE id = \$3`).
+		WithArgs(issuedBefore, sqlmock.AnyArg(), testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET tokens_valid_after`).
+		WithArgs(issuedBefore, sqlmock.AnyArg(), testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := NewRepository(&db.DB{DB: mockDB})
+
+	assert.NoError(t, repo.RevokeTokens(testUserID, issuedBefore))
+	assert.ErrorIs(t, repo.RevokeTokens(testUserID, issuedBefore), ErrNotFound)
+	assert.Error(t, repo.RevokeTokens(uuid.Nil, issuedBefore))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_Delete(t *testing.T) {
+	testUserID := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectExec(`DELETE FROM users WHERE id = \$1`).
+		WithArgs(testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM users WHERE id = \$1`).
+		WithArgs(testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM users WHERE id = \$1`).
+		WithArgs(testUserID).
+		WillReturnError(errors.New("database connection error"))
+
+	repo := NewRepository(&db.DB{DB: mockDB})
+
+	assert.NoError(t, repo.Delete(testUserID))
+	assert.ErrorIs(t, repo.Delete(testUserID), ErrNotFound)
+
+	err = repo.Delete(testUserID)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to delete user")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 // TestRepository_Interface verifies that repository implements the Repository interface
 func TestRepository_Interface(t *testing.T) {
 	mockDB, _, err := sqlmock.New()
@@ -1,6 +1,10 @@
 package response
 
 import (
+	"math"
+	"strconv"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -11,19 +15,54 @@ type LoginResponse struct {
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
 		ExpiresIn    int64  `json:"expires_in"` // Access token expiration in seconds
-		User         struct {
+		// Set when the user signed in with a PIN given by an administrator; until the PIN
+		// is changed, the access token only works for changing the PIN and logging out
+		PinChangeRequired bool `json:"pin_change_required,omitempty"`
+		User              struct {
 			ID          string `json:"id"`
 			PhoneNumber string `json:"phone_number"`
 		} `json:"user"`
 	} `json:"data"`
 }
 
+// Error codes returned in ErrorResponse
+// Clients switch on these instead of parsing messages, so existing codes must never change
+const (
+	CodeValidationError         = "VALIDATION_ERROR"
+	CodeAuthenticationError     = "AUTHENTICATION_ERROR"
+	CodeInvalidCredentials      = "INVALID_CREDENTIALS"
+	CodeInvalidToken            = "INVALID_TOKEN"
+	CodeTokenExpired            = "TOKEN_EXPIRED"
+	CodeTokenRevoked            = "TOKEN_REVOKED"
+	CodeWrongTokenType          = "WRONG_TOKEN_TYPE"
+	CodeRefreshTokenReused      = "REFRESH_TOKEN_REUSED"
+	CodeSessionExpired          = "SESSION_EXPIRED"
+	CodeNotFound                = "NOT_FOUND"
+	CodeAccountLocked           = "ACCOUNT_LOCKED"
+	CodeTooManyAttempts         = "TOO_MANY_ATTEMPTS"
+	CodeInvalidOTP              = "INVALID_OTP"
+	CodeOTPAttemptsExceeded     = "OTP_ATTEMPTS_EXCEEDED"
+	CodeDeviceMismatch          = "DEVICE_MISMATCH"
+	CodeDeviceNotAllowed        = "DEVICE_NOT_ALLOWED"
+	CodeInvalidDeviceKey        = "INVALID_DEVICE_KEY"
+	CodeForbidden               = "FORBIDDEN"
+	CodeAccountSuspended        = "ACCOUNT_SUSPENDED"
+	CodeAccountDisabled         = "ACCOUNT_DISABLED"
+	CodePinChangeRequired       = "PIN_CHANGE_REQUIRED"
+	CodePhoneNumberTaken        = "PHONE_NUMBER_TAKEN"
+	CodeInvalidAPIKey           = "INVALID_API_KEY"
+	CodeAPIKeyExpired           = "API_KEY_EXPIRED"
+	CodeServiceAccountNameTaken = "SERVICE_ACCOUNT_NAME_TAKEN"
+	CodeInternalServerError     = "INTERNAL_SERVER_ERROR"
+)
+
 // ErrorResponse represents the response structure for errors
 type ErrorResponse struct {
 	Success bool `json:"success"`
 	Error   struct {
 		Code    string `json:"code"`
 		Message string `json:"message"`
+		Field   string `json:"field,omitempty"` // Request field that failed validation, if any
 	} `json:"error"`
 }
 
@@ -35,13 +74,14 @@ type SuccessResponse struct {
 }
 
 // SendLoginSuccess sends a successful login response with tokens and user info
-func SendLoginSuccess(c *fiber.Ctx, accessToken, refreshToken string, expiresIn int64, userID, phoneNumber string) error {
+func SendLoginSuccess(c *fiber.Ctx, accessToken, refreshToken string, expiresIn int64, pinChangeRequired bool, userID, phoneNumber string) error {
 	response := LoginResponse{
 		Success: true,
 	}
 	response.Data.AccessToken = accessToken
 	response.Data.RefreshToken = refreshToken
 	response.Data.ExpiresIn = expiresIn
+	response.Data.PinChangeRequired = pinChangeRequired
 	response.Data.User.ID = userID
 	response.Data.User.PhoneNumber = phoneNumber
 
@@ -72,25 +112,76 @@ func SendSuccess(c *fiber.Ctx, data interface{}, message string) error {
 
 // SendValidationError sends a 400 Bad Request error for validation failures
 func SendValidationError(c *fiber.Ctx, message string) error {
-	return SendError(c, fiber.StatusBadRequest, "VALIDATION_ERROR", message)
+	return SendError(c, fiber.StatusBadRequest, CodeValidationError, message)
+}
+
+// SendFieldValidationError sends a 400 Bad Request error naming the request field that failed validation
+func SendFieldValidationError(c *fiber.Ctx, field, message string) error {
+	response := ErrorResponse{
+		Success: false,
+	}
+	response.Error.Code = CodeValidationError
+	response.Error.Message = message
+	response.Error.Field = field
+
+	return c.Status(fiber.StatusBadRequest).JSON(response)
 }
 
 // SendAuthenticationError sends a 401 Unauthorized error for authentication failures
 func SendAuthenticationError(c *fiber.Ctx, message string) error {
-	return SendError(c, fiber.StatusUnauthorized, "AUTHENTICATION_ERROR", message)
+	return SendError(c, fiber.StatusUnauthorized, CodeAuthenticationError, message)
+}
+
+// SendUnauthorizedError sends a 401 Unauthorized error with a specific error code,
+// e.g. CodeInvalidCredentials or CodeTokenRevoked
+func SendUnauthorizedError(c *fiber.Ctx, errorCode, message string) error {
+	return SendError(c, fiber.StatusUnauthorized, errorCode, message)
+}
+
+// SendForbiddenError sends a 403 Forbidden error with a specific error code,
+// e.g. CodeForbidden or CodeDeviceNotAllowed
+func SendForbiddenError(c *fiber.Ctx, errorCode, message string) error {
+	return SendError(c, fiber.StatusForbidden, errorCode, message)
+}
+
+// SendConflictError sends a 409 Conflict error with a specific error code,
+// e.g. CodePhoneNumberTaken
+func SendConflictError(c *fiber.Ctx, errorCode, message string) error {
+	return SendError(c, fiber.StatusConflict, errorCode, message)
 }
 
 // SendNotFoundError sends a 404 Not Found error
 func SendNotFoundError(c *fiber.Ctx, message string) error {
-	return SendError(c, fiber.StatusNotFound, "NOT_FOUND", message)
+	return SendError(c, fiber.StatusNotFound, CodeNotFound, message)
 }
 
 // SendInternalServerError sends a 500 Internal Server Error
 func SendInternalServerError(c *fiber.Ctx, message string) error {
-	return SendError(c, fiber.StatusInternalServerError, "INTERNAL_SERVER_ERROR", message)
+	return SendError(c, fiber.StatusInternalServerError, CodeInternalServerError, message)
 }
 
 // SendTokenExpiredError sends a 401 Unauthorized error specifically for expired tokens
 func SendTokenExpiredError(c *fiber.Ctx, message string) error {
-	return SendError(c, fiber.StatusUnauthorized, "TOKEN_EXPIRED", message)
-}
\ No newline at end of file
+	return SendError(c, fiber.StatusUnauthorized, CodeTokenExpired, message)
+}
+
+// SendAccountLockedError sends a 423 Locked error with a Retry-After header for locked accounts
+func SendAccountLockedError(c *fiber.Ctx, retryAfter time.Duration, message string) error {
+	setRetryAfter(c, retryAfter)
+	return SendError(c, fiber.StatusLocked, CodeAccountLocked, message)
+}
+
+// SendTooManyAttemptsError sends a 429 Too Many Requests error with a Retry-After header
+func SendTooManyAttemptsError(c *fiber.Ctx, retryAfter time.Duration, message string) error {
+	setRetryAfter(c, retryAfter)
+	return SendError(c, fiber.StatusTooManyRequests, CodeTooManyAttempts, message)
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounding up
+func setRetryAfter(c *fiber.Ctx, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(seconds, 10))
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// HashToken returns the hex-encoded SHA-256 digest of a token
+// Used to store and look up bearer tokens without keeping them in plain text
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashSecret returns the hex-encoded HMAC-SHA256 of a value under a key
+// Used for short secrets such as one-time codes, where a plain digest could be reversed by
+// trying every possible value
+func HashSecret(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateNumericCode returns a random code of the given number of decimal digits
+// Leading zeros are kept, so every code has exactly that many digits
+func GenerateNumericCode(digits int) (string, error) {
+	if digits <= 0 || digits > 18 {
+		return "", errors.New("code length must be between 1 and 18 digits")
+	}
+
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", digits, n.Int64()), nil
+}
+
+// DeriveKey derives a 32-byte AES-256 key from arbitrary secret material
+// The purpose string separates keys derived from the same secret for different uses
+func DeriveKey(purpose, secret string) []byte {
+	sum := sha256.Sum256([]byte(purpose + "\x00" + secret))
+	return sum[:]
+}
+
+// Encrypt encrypts plaintext with AES-GCM using a 32-byte key
+// The random nonce is prepended to the returned ciphertext
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts ciphertext produced by Encrypt with the same key
+// Returns an error if the key is wrong or the ciphertext has been tampered with
+func Decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+// newGCM creates an AES-GCM cipher for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := DeriveKey("test", "secret")
+	plaintext := []byte(`{"access_token":"a","refresh_token":"b"}`)
+
+	ciphertext, err := Encrypt(key, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() unexpected error: %v", err)
+	}
+
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Errorf("Encrypt() returned ciphertext containing the plaintext")
+	}
+
+	decrypted, err := Decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestEncrypt_UniqueNonces(t *testing.T) {
+	key := DeriveKey("test", "secret")
+	plaintext := []byte("same plaintext")
+
+	first, err1 := Encrypt(key, plaintext)
+	second, err2 := Encrypt(key, plaintext)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Encrypt() failed: %v, %v", err1, err2)
+	}
+
+	if bytes.Equal(first, second) {
+		t.Errorf("Encrypt() should produce different ciphertexts for the same plaintext")
+	}
+}
+
+func TestDecrypt_Failures(t *testing.T) {
+	key := DeriveKey("test", "secret")
+	ciphertext, err := Encrypt(key, []byte("payload"))
+	if err != nil {
+		t.Fatalf("Encrypt() unexpected error: %v", err)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext []byte
+	}{
+		{
+			name:       "Wrong key",
+			key:        DeriveKey("test", "other-secret"),
+			ciphertext: ciphertext,
+		},
+		{
+			name:       "Different purpose",
+			key:        DeriveKey("other", "secret"),
+			ciphertext: ciphertext,
+		},
+		{
+			name:       "Tampered ciphertext",
+			key:        key,
+			ciphertext: tampered,
+		},
+		{
+			name:       "Ciphertext too short",
+			key:        key,
+			ciphertext: []byte("short"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.key, tt.ciphertext); err == nil {
+				t.Errorf("Decrypt() expected error but got none")
+			}
+		})
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// SHA-256 of "abc"
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashToken("abc"); got != want {
+		t.Errorf("HashToken() = %q, want %q", got, want)
+	}
+
+	if HashToken("token-a") == HashToken("token-b") {
+		t.Errorf("HashToken() returned the same digest for different tokens")
+	}
+}
+
+func TestHashSecret(t *testing.T) {
+	key := DeriveKey("test", "secret")
+
+	hash := HashSecret(key, "123456")
+	if len(hash) != 64 {
+		t.Errorf("HashSecret() length = %d, want 64", len(hash))
+	}
+	if hash != HashSecret(key, "123456") {
+		t.Errorf("HashSecret() is not deterministic")
+	}
+	if hash == HashSecret(DeriveKey("test", "other-secret"), "123456") {
+		t.Errorf("HashSecret() gave the same hash under different keys")
+	}
+}
+
+func TestGenerateNumericCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := GenerateNumericCode(6)
+		if err != nil {
+			t.Fatalf("GenerateNumericCode() unexpected error: %v", err)
+		}
+		if len(code) != 6 {
+			t.Fatalf("GenerateNumericCode() = %q, want 6 digits", code)
+		}
+		for _, c := range code {
+			if c < '0' || c > '9' {
+				t.Fatalf("GenerateNumericCode() = %q, want only digits", code)
+			}
+		}
+	}
+
+	if _, err := GenerateNumericCode(0); err == nil {
+		t.Errorf("GenerateNumericCode(0) expected an error")
+	}
+}